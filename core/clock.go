@@ -0,0 +1,113 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时间来源 各章中和时间有关的逻辑（投票截止、会话时间戳、计数器时间片、日志轮换、信号量超时、延迟任务等）都通过它获取时间
+// 测试时换成 FakeClock 就可以直接快进一周
+type Clock interface {
+	Now() time.Time
+	// After 经过d之后向返回的通道发送当时的时间
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock 系统时钟
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Since 相当于 time.Since 但使用clock的时间
+func Since(clock Clock, t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+// Sleep 等待d 或者直到done被关闭 被提前唤醒时返回false
+func Sleep(clock Clock, done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return false
+	case <-clock.After(d):
+		return true
+	}
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// FakeClock 只有调用 Advance 或 Set 时才会前进的时钟
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// NewFakeClock 创建一个停在now的时钟 now为零值时使用当前时间
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance 让时钟前进d 唤醒所有到期的After
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set 把时钟设置为t 时钟不会倒退
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	fired := 0
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			break
+		}
+		w.ch <- c.now
+		fired++
+	}
+	c.waiters = c.waiters[fired:]
+}
+
+// Waiters 正在等待的After数量 测试中用来确认后台任务已经进入等待
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil 等到至少有n个After在等待
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	short := c.After(time.Second)
+	long := c.After(time.Hour)
+	if c.Waiters() != 2 {
+		t.Fatalf("waiters = %d, want 2", c.Waiters())
+	}
+
+	c.Advance(time.Minute)
+	select {
+	case now := <-short:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Fatalf("fired at %v", now)
+		}
+	default:
+		t.Fatal("After(1s) did not fire after advancing a minute")
+	}
+	select {
+	case <-long:
+		t.Fatal("After(1h) fired too early")
+	default:
+	}
+
+	// 时钟不会倒退
+	c.Set(start)
+	if !c.Now().Equal(start.Add(time.Minute)) {
+		t.Fatalf("clock went backwards to %v", c.Now())
+	}
+	c.Set(start.Add(2 * time.Hour))
+	<-long
+	if Since(c, start) != 2*time.Hour {
+		t.Fatalf("Since() = %v", Since(c, start))
+	}
+}
+
+func TestSleep(t *testing.T) {
+	c := NewFakeClock(time.Time{})
+	done := make(chan struct{})
+	woke := make(chan bool)
+	go func() { woke <- Sleep(c, done, time.Hour) }()
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	if !<-woke {
+		t.Fatal("Sleep() = false after the clock advanced")
+	}
+
+	go func() { woke <- Sleep(c, done, time.Hour) }()
+	c.BlockUntil(1)
+	close(done)
+	if <-woke {
+		t.Fatal("Sleep() = true after done was closed")
+	}
+}
@@ -0,0 +1,443 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// 连接模式
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+// Config redis连接配置 可以从配置文件(yaml/json)、环境变量和命令行参数中加载
+// 优先级: 命令行参数 > 环境变量 > 配置文件 > 默认值
+type Config struct {
+	Mode       string   // single/sentinel/cluster
+	Network    string   // tcp/unix 仅单节点模式有效 为空时以/开头的地址视为unix socket
+	Addrs      []string // 单节点取第一个地址 哨兵模式为哨兵地址 集群模式为种子节点
+	MasterName string   // 哨兵模式下的主节点名
+	Username   string   // ACL用户名
+	Password   string
+	DB         int
+	TestDB     bool // 连接后将库标记为测试库 允许示例程序用 ResetKeys 清空
+
+	SentinelPassword string
+
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+	IdleTimeout  time.Duration
+
+	ConnectTimeout  time.Duration // 首次连接的总超时时间 ctx没有设置截止时间时生效
+	RetryBackoff    time.Duration // 首次重连的等待时间 之后每次翻倍
+	RetryMaxBackoff time.Duration // 重连等待时间的上限
+
+	TLS                   bool
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSCAFile             string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+
+	MetricsAddr string // 不为空时在这个地址上提供 /metrics 接口
+	TraceFile   string // 不为空时把每条命令的span以JSON行的形式追加到这个文件
+}
+
+// 默认配置 指向本机的redis
+func DefaultConfig() *Config {
+	return &Config{
+		Mode:        ModeSingle,
+		Addrs:       []string{"127.0.0.1:6379"},
+		DialTimeout: 5 * time.Second,
+
+		ConnectTimeout:  30 * time.Second,
+		RetryBackoff:    100 * time.Millisecond,
+		RetryMaxBackoff: 5 * time.Second,
+	}
+}
+
+// 配置项 同一个名字同时用于配置文件的键和命令行参数 环境变量为 REDIS_ 加上大写的名字
+type configField struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var configFields = []configField{
+	{"mode", "connection mode: single, sentinel or cluster", func(c *Config, v string) error {
+		switch v {
+		case ModeSingle, ModeSentinel, ModeCluster:
+			c.Mode = v
+			return nil
+		}
+		return errors.Errorf("unknown mode %q", v)
+	}},
+	{"network", "network for single mode: tcp or unix", setString(func(c *Config) *string { return &c.Network })},
+	{"addrs", "comma separated server addresses (or a unix socket path)", func(c *Config, v string) error {
+		c.Addrs = splitList(v)
+		return nil
+	}},
+	{"master-name", "sentinel master name", setString(func(c *Config) *string { return &c.MasterName })},
+	{"username", "ACL username", setString(func(c *Config) *string { return &c.Username })},
+	{"password", "password", setString(func(c *Config) *string { return &c.Password })},
+	{"db", "database number", setInt(func(c *Config) *int { return &c.DB })},
+	{"test-db", "mark the db as a test db so the examples may reset it", setBool(func(c *Config) *bool { return &c.TestDB })},
+	{"sentinel-password", "sentinel password", setString(func(c *Config) *string { return &c.SentinelPassword })},
+	{"pool-size", "max connections per node", setInt(func(c *Config) *int { return &c.PoolSize })},
+	{"min-idle-conns", "min idle connections per node", setInt(func(c *Config) *int { return &c.MinIdleConns })},
+	{"max-retries", "max command retries", setInt(func(c *Config) *int { return &c.MaxRetries })},
+	{"dial-timeout", "dial timeout", setDuration(func(c *Config) *time.Duration { return &c.DialTimeout })},
+	{"read-timeout", "read timeout", setDuration(func(c *Config) *time.Duration { return &c.ReadTimeout })},
+	{"write-timeout", "write timeout", setDuration(func(c *Config) *time.Duration { return &c.WriteTimeout })},
+	{"pool-timeout", "wait time for a free connection", setDuration(func(c *Config) *time.Duration { return &c.PoolTimeout })},
+	{"idle-timeout", "close idle connections after this duration", setDuration(func(c *Config) *time.Duration { return &c.IdleTimeout })},
+	{"connect-timeout", "give up connecting after this duration", setDuration(func(c *Config) *time.Duration { return &c.ConnectTimeout })},
+	{"retry-backoff", "initial wait between connection attempts", setDuration(func(c *Config) *time.Duration { return &c.RetryBackoff })},
+	{"retry-max-backoff", "max wait between connection attempts", setDuration(func(c *Config) *time.Duration { return &c.RetryMaxBackoff })},
+	{"tls", "enable TLS", setBool(func(c *Config) *bool { return &c.TLS })},
+	{"tls-cert-file", "client certificate file", setString(func(c *Config) *string { return &c.TLSCertFile })},
+	{"tls-key-file", "client key file", setString(func(c *Config) *string { return &c.TLSKeyFile })},
+	{"tls-ca-file", "CA certificate file", setString(func(c *Config) *string { return &c.TLSCAFile })},
+	{"tls-server-name", "server name used to verify the certificate", setString(func(c *Config) *string { return &c.TLSServerName })},
+	{"tls-insecure-skip-verify", "skip certificate verification", setBool(func(c *Config) *bool { return &c.TLSInsecureSkipVerify })},
+	{"metrics-addr", "serve prometheus metrics on this address", setString(func(c *Config) *string { return &c.MetricsAddr })},
+	{"trace-file", "append command spans to this file", setString(func(c *Config) *string { return &c.TraceFile })},
+}
+
+// 布尔类型的配置项 命令行中可以只写参数名
+var boolConfigs = map[string]bool{"test-db": true, "tls": true, "tls-insecure-skip-verify": true}
+
+func setString(field func(c *Config) *string) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// 时长既可以写成 5s 这种格式 也可以直接写秒数
+func setDuration(field func(c *Config) *time.Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			*field(c) = time.Duration(secs * float64(time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	ret := make([]string, 0)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func envName(name string) string {
+	return "REDIS_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// 设置某个配置项
+func (c *Config) Set(name string, value string) error {
+	for _, f := range configFields {
+		if f.name == name {
+			if err := f.set(c, value); err != nil {
+				return errors.Wrapf(err, "config %s", name)
+			}
+			return nil
+		}
+	}
+	return errors.Errorf("unknown config %q", name)
+}
+
+// 从配置文件中加载 根据后缀名判断是yaml还是json
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", path)
+	}
+	for name, v := range values {
+		if err := c.Set(name, fileValue(v)); err != nil {
+			return errors.Wrapf(err, "load %s", path)
+		}
+	}
+	return nil
+}
+
+// 配置文件中的列表转换成逗号分隔的字符串
+func fileValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// 从环境变量中加载 例如 REDIS_ADDRS REDIS_PASSWORD REDIS_TLS
+func (c *Config) LoadEnv() error {
+	for _, f := range configFields {
+		if v, ok := os.LookupEnv(envName(f.name)); ok {
+			if err := c.Set(f.name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type flagValue struct {
+	isBool bool
+	value  *string
+}
+
+func (v *flagValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return *v.value
+}
+
+func (v *flagValue) Set(s string) error {
+	*v.value = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+// 从命令行参数中加载配置 参数名为 -redis- 加上配置名 例如 -redis-addrs
+// -redis-config 或者环境变量 REDIS_CONFIG 指定配置文件
+func LoadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	values := make(map[string]*string)
+	for _, f := range configFields {
+		v := new(string)
+		values[f.name] = v
+		fs.Var(&flagValue{isBool: boolConfigs[f.name], value: v},
+			"redis-"+f.name, f.usage+" (env "+envName(f.name)+")")
+	}
+	file := fs.String("redis-config", os.Getenv("REDIS_CONFIG"), "yaml or json config file (env REDIS_CONFIG)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if *file != "" {
+		if err := cfg.LoadFile(*file); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[strings.TrimPrefix(f.Name, "redis-")]; ok && err == nil && f.Name != "redis-config" {
+			err = cfg.Set(strings.TrimPrefix(f.Name, "redis-"), *v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 根据配置生成tls配置 未开启tls时返回nil
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tls key pair")
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	if c.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tls ca")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", c.TLSCAFile)
+		}
+		tc.RootCAs = pool
+	}
+	return tc, nil
+}
+
+// 同一个文件只打开一次 重建客户端时共用
+var traceFiles = struct {
+	sync.Mutex
+	m map[string]*Tracer
+}{m: make(map[string]*Tracer)}
+
+func traceFileTracer(path string) (*Tracer, error) {
+	traceFiles.Lock()
+	defer traceFiles.Unlock()
+	if t, ok := traceFiles.m[path]; ok {
+		return t, nil
+	}
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open trace file")
+	}
+	t := NewTracer(exporter)
+	traceFiles.m[path] = t
+	return t, nil
+}
+
+// 根据配置创建客户端 但不检查连通性
+// 客户端会安装钩子把命令记录到 DefaultMetrics 配置了TraceFile时同时导出span
+// 指标服务（MetricsAddr）由 Connect 启动
+func NewClient(cfg *Config) (redis.UniversalClient, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var tracer *Tracer
+	if cfg.TraceFile != "" {
+		if tracer, err = traceFileTracer(cfg.TraceFile); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	Instrument(client, DefaultMetrics, tracer)
+	return client, nil
+}
+
+func newClient(cfg *Config) (redis.UniversalClient, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("no redis address configured")
+	}
+	tc, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Mode {
+	case ModeSingle, "":
+		return redis.NewClient(&redis.Options{
+			Network:      cfg.Network,
+			Addr:         cfg.Addrs[0],
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+			TLSConfig:    tc,
+		}), nil
+	case ModeSentinel:
+		if cfg.MasterName == "" {
+			return nil, errors.New("sentinel mode needs a master name")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			MaxRetries:       cfg.MaxRetries,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			PoolTimeout:      cfg.PoolTimeout,
+			IdleTimeout:      cfg.IdleTimeout,
+			TLSConfig:        tc,
+		}), nil
+	case ModeCluster:
+		if cfg.DB != 0 {
+			return nil, errors.New("cluster mode only supports db 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			IdleTimeout:  cfg.IdleTimeout,
+			TLSConfig:    tc,
+		}), nil
+	}
+	return nil, errors.Errorf("unknown mode %q", cfg.Mode)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设置环境变量 测试结束时恢复 同时清掉其他 REDIS_ 开头的变量
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if name := kv[:strings.Index(kv, "=")]; strings.HasPrefix(name, "REDIS_") {
+			if _, ok := env[name]; !ok {
+				env[name] = ""
+			}
+		}
+	}
+	for name, v := range env {
+		old, had := os.LookupEnv(name)
+		if v == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, v)
+		}
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadConfig(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return LoadConfig(fs, args)
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setenv(t, map[string]string{})
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Fatalf("LoadConfig() = %+v, want the defaults", cfg)
+	}
+}
+
+// 命令行参数 > 环境变量 > 配置文件 > 默认值
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, "redis.yaml", `
+addrs: [10.0.0.1:6379, 10.0.0.2:6379]
+db: 1
+password: file
+username: file
+read-timeout: 2
+tls: true
+`)
+	setenv(t, map[string]string{"REDIS_CONFIG": path, "REDIS_DB": "2", "REDIS_PASSWORD": "env"})
+	cfg, err := loadConfig("-redis-db", "3", "-redis-test-db", "-redis-write-timeout", "1.5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.Addrs = []string{"10.0.0.1:6379", "10.0.0.2:6379"}
+	want.DB = 3
+	want.Password = "env"
+	want.Username = "file"
+	want.ReadTimeout = 2 * time.Second
+	want.WriteTimeout = 1500 * time.Millisecond
+	want.TLS = true
+	want.TestDB = true
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("LoadConfig() = %+v\nwant %+v", cfg, want)
+	}
+
+	// -redis-config 优先于 REDIS_CONFIG json文件中的列表也可以写成逗号分隔的字符串
+	json := writeConfig(t, "redis.json", `{"addrs": "10.0.0.3:6379, 10.0.0.4:6379", "mode": "cluster", "pool-size": 20}`)
+	cfg, err = loadConfig("-redis-config", json)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Mode != ModeCluster || cfg.PoolSize != 20 || !reflect.DeepEqual(cfg.Addrs, []string{"10.0.0.3:6379", "10.0.0.4:6379"}) || cfg.DB != 2 {
+		t.Fatalf("LoadConfig(json) = %+v", cfg)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, c := range map[string]struct {
+		file string
+		env  map[string]string
+		args []string
+	}{
+		"unknown file key": {file: "colour: red\n"},
+		"bad file int":     {file: "db: one\n"},
+		"bad yaml":         {file: "addrs: [\n"},
+		"missing file":     {args: []string{"-redis-config", "/nonexistent/redis.yaml"}},
+		"bad env bool":     {env: map[string]string{"REDIS_TLS": "maybe"}},
+		"bad env duration": {env: map[string]string{"REDIS_DIAL_TIMEOUT": "soon"}},
+		"bad mode flag":    {args: []string{"-redis-mode", "ring"}},
+		"bad int flag":     {args: []string{"-redis-pool-size", "ten"}},
+		"unknown flag":     {args: []string{"-redis-colour", "red"}},
+	} {
+		env := c.env
+		if env == nil {
+			env = map[string]string{}
+		}
+		if c.file != "" {
+			env["REDIS_CONFIG"] = writeConfig(t, "redis.yaml", c.file)
+		}
+		setenv(t, env)
+		if cfg, err := loadConfig(c.args...); err == nil {
+			t.Errorf("%s: LoadConfig() = %+v, want an error", name, cfg)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package core
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 连接失败的原因
+const (
+	CauseUnknown  = "unknown"
+	CauseAuth     = "auth failed"
+	CauseWrongDB  = "wrong db"
+	CauseRefused  = "connection refused"
+	CauseTimeout  = "timeout"
+	CauseDNS      = "dns lookup failed"
+	CauseLoading  = "server loading"
+	CauseCanceled = "canceled"
+)
+
+// ConnectError 连接失败时返回的错误 Cause 为失败的原因 Err 为最后一次尝试的错误
+type ConnectError struct {
+	Addrs    []string
+	Cause    string
+	Attempts int
+	Err      error
+}
+
+func (e *ConnectError) Error() string {
+	return "connect " + strings.Join(e.Addrs, ",") + ": " + e.Cause + ": " + e.Err.Error()
+}
+
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// 认证失败和库号错误重试也不会成功 其他原因可以重试
+func (e *ConnectError) Temporary() bool {
+	switch e.Cause {
+	case CauseAuth, CauseWrongDB, CauseCanceled:
+		return false
+	}
+	return true
+}
+
+// 根据错误信息判断连接失败的原因
+func ConnectCause(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CauseCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CauseTimeout
+	case strings.HasPrefix(msg, "WRONGPASS"), strings.HasPrefix(msg, "NOAUTH"),
+		strings.Contains(msg, "invalid password"), strings.Contains(msg, "without any password configured"),
+		strings.Contains(msg, "invalid username-password pair"):
+		return CauseAuth
+	case strings.Contains(msg, "DB index is out of range"), strings.Contains(msg, "invalid DB index"):
+		return CauseWrongDB
+	case strings.HasPrefix(msg, "LOADING"):
+		return CauseLoading
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return CauseRefused
+	case errors.As(err, &dnsErr):
+		return CauseDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return CauseTimeout
+	}
+	return CauseUnknown
+}
+
+// 计算第attempt次重试前的等待时间 指数退避并加上随机抖动（等待时间在[d/2, d)之间）
+func backoff(attempt int, min time.Duration, max time.Duration) time.Duration {
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 根据配置创建客户端并检查是否可以连通 连不上时按指数退避重试
+// 直到ctx结束或者超过cfg.ConnectTimeout 认证失败和库号错误会直接返回
+// 连接成功并且配置了MetricsAddr时启动指标服务 HealthChecker 重建客户端时不会再次启动
+func Connect(ctx context.Context, cfg *Config) (redis.UniversalClient, error) {
+	client, err := connect(ctx, cfg)
+	if err == nil && cfg.MetricsAddr != "" {
+		ServeMetrics(cfg.MetricsAddr)
+	}
+	return client, err
+}
+
+func connect(ctx context.Context, cfg *Config) (redis.UniversalClient, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok && cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+		defer cancel()
+	}
+	last := ""
+	for attempt := 1; ; attempt++ {
+		err := client.Ping(ctx).Err()
+		if err == nil && cfg.TestDB {
+			err = MarkTestDB(ctx, client)
+		}
+		if err == nil {
+			return client, nil
+		}
+		connErr := &ConnectError{Addrs: cfg.Addrs, Cause: ConnectCause(err), Attempts: attempt, Err: err}
+		if ctx.Err() == nil {
+			last = connErr.Cause
+		} else if last != "" {
+			// 这次尝试被ctx打断 保留上一次连接失败的原因
+			connErr.Cause = last
+		}
+		if !connErr.Temporary() {
+			_ = client.Close()
+			return nil, connErr
+		}
+		select {
+		case <-time.After(backoff(attempt, cfg.RetryBackoff, cfg.RetryMaxBackoff)):
+		case <-ctx.Done():
+			_ = client.Close()
+			// 超时的时候保留最后一次连接失败的原因 方便排查
+			connErr.Err = errors.Wrap(err, ctx.Err().Error())
+			return nil, connErr
+		}
+	}
+}
+
+// 连接的健康状态
+type HealthState int32
+
+const (
+	StateConnecting HealthState = iota
+	StateUp
+	StateDown
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case StateUp:
+		return "up"
+	case StateDown:
+		return "down"
+	}
+	return "connecting"
+}
+
+// 某一时刻的健康检查结果
+type HealthStatus struct {
+	State      HealthState
+	LastError  error
+	LastCheck  time.Time
+	Since      time.Time // 进入当前状态的时间
+	Reconnects int
+}
+
+// HealthChecker 定时PING redis 连续失败达到阈值之后重新创建客户端
+// 使用者每次都通过 Client() 获取当前的客户端 不要长期持有旧的客户端
+type HealthChecker struct {
+	cfg      *Config
+	Interval time.Duration // 检查间隔
+	Failures int           // 连续失败多少次后重建客户端
+
+	mu     sync.RWMutex
+	client redis.UniversalClient
+	status HealthStatus
+	failed int
+}
+
+func NewHealthChecker(cfg *Config, client redis.UniversalClient) *HealthChecker {
+	state := StateConnecting
+	if client != nil {
+		state = StateUp
+	}
+	return &HealthChecker{
+		cfg:      cfg,
+		Interval: time.Second,
+		Failures: 3,
+		client:   client,
+		status:   HealthStatus{State: state, Since: time.Now()},
+	}
+}
+
+// 当前使用的客户端 从未连接成功时为nil
+func (h *HealthChecker) Client() redis.UniversalClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.client
+}
+
+func (h *HealthChecker) State() HealthState {
+	return h.Status().State
+}
+
+func (h *HealthChecker) Status() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+func (h *HealthChecker) setState(state HealthState, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if h.status.State != state {
+		h.status.Since = now
+	}
+	h.status.State = state
+	h.status.LastError = err
+	h.status.LastCheck = now
+}
+
+// n<0时失败次数加一 否则重置为n 返回新的失败次数
+func (h *HealthChecker) setFailed(n int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n < 0 {
+		h.failed++
+	} else {
+		h.failed = n
+	}
+	return h.failed
+}
+
+// 执行一次检查 失败次数达到阈值时重建客户端
+func (h *HealthChecker) Check(ctx context.Context) error {
+	client := h.Client()
+	var err error
+	if client == nil {
+		err = errors.New("not connected")
+	} else {
+		err = client.Ping(ctx).Err()
+	}
+	if err == nil {
+		h.setFailed(0)
+		h.setState(StateUp, nil)
+		return nil
+	}
+	failed := h.setFailed(-1)
+	h.setState(StateDown, &ConnectError{Addrs: h.cfg.Addrs, Cause: ConnectCause(err), Attempts: failed, Err: err})
+	if client != nil && failed < h.Failures {
+		return h.Status().LastError
+	}
+
+	// 重建客户端 只尝试一个检查间隔的时间 下一轮检查再继续
+	h.setState(StateConnecting, h.Status().LastError)
+	rctx, cancel := context.WithTimeout(ctx, h.Interval)
+	defer cancel()
+	newClient, err := connect(rctx, h.cfg)
+	if err != nil {
+		h.setState(StateDown, err)
+		return err
+	}
+	h.mu.Lock()
+	old := h.client
+	h.client = newClient
+	h.status.Reconnects++
+	h.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	h.setFailed(0)
+	h.setState(StateUp, nil)
+	return nil
+}
+
+// 按间隔执行检查 直到ctx结束
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+	for {
+		_ = h.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
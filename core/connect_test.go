@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+)
+
+func testConfig(addr string) *Config {
+	cfg := DefaultConfig()
+	cfg.Addrs = []string{addr}
+	cfg.ConnectTimeout = time.Second
+	cfg.RetryBackoff = 10 * time.Millisecond
+	cfg.RetryMaxBackoff = 20 * time.Millisecond
+	return cfg
+}
+
+func connectError(t *testing.T, err error) *ConnectError {
+	t.Helper()
+	var connErr *ConnectError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Connect() = %v, want a *ConnectError", err)
+	}
+	return connErr
+}
+
+func TestConnectAuth(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireAuth("secret")
+	for _, password := range []string{"", "wrong"} {
+		cfg := testConfig(m.Addr())
+		cfg.Password = password
+		start := time.Now()
+		_, err := Connect(context.Background(), cfg)
+		// 认证失败重试也不会成功 直接返回
+		if connErr := connectError(t, err); connErr.Cause != CauseAuth || connErr.Attempts != 1 || connErr.Temporary() {
+			t.Fatalf("Connect(password %q) = %+v", password, connErr)
+		}
+		if elapsed := time.Since(start); elapsed > cfg.ConnectTimeout/2 {
+			t.Fatalf("auth failure took %v", elapsed)
+		}
+	}
+
+	cfg := testConfig(m.Addr())
+	cfg.Password = "secret"
+	client, err := Connect(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
+
+// 一个只会拒绝SELECT的redis服务器 其他命令都返回OK
+func rejectSelect(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					// 命令是RESP数组 *<n> 后面跟着n个 $<len> <参数>
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					var args []string
+					for i := 0; i < n; i++ {
+						r.ReadString('\n')
+						arg, _ := r.ReadString('\n')
+						args = append(args, strings.TrimSpace(arg))
+					}
+					if len(args) > 0 && strings.EqualFold(args[0], "select") {
+						conn.Write([]byte("-ERR DB index is out of range\r\n"))
+					} else {
+						conn.Write([]byte("+OK\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestConnectWrongDB(t *testing.T) {
+	cfg := testConfig(rejectSelect(t))
+	cfg.DB = 99
+	_, err := Connect(context.Background(), cfg)
+	if connErr := connectError(t, err); connErr.Cause != CauseWrongDB || connErr.Attempts != 1 || connErr.Temporary() {
+		t.Fatalf("Connect(db 99) = %+v", connErr)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	// 找一个没有被监听的端口
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := testConfig(addr)
+	cfg.ConnectTimeout = 200 * time.Millisecond
+	_, err = Connect(context.Background(), cfg)
+	connErr := connectError(t, err)
+	// 连接被拒绝可以重试 一直重试到ConnectTimeout 保留最后一次失败的原因
+	if connErr.Cause != CauseRefused || !connErr.Temporary() || connErr.Attempts < 2 {
+		t.Fatalf("Connect(refused) = %+v", connErr)
+	}
+
+	// ctx被取消时停止重试
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.ConnectTimeout = time.Minute
+	if _, err := Connect(ctx, cfg); connectError(t, err).Temporary() {
+		t.Fatalf("Connect(canceled) = %+v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	min, max := 100*time.Millisecond, 400*time.Millisecond
+	for attempt, d := range map[int]time.Duration{1: min, 2: 2 * min, 3: max, 10: max} {
+		for i := 0; i < 20; i++ {
+			if got := backoff(attempt, min, max); got < d/2 || got > d {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", attempt, got, d/2, d)
+			}
+		}
+	}
+	if got := backoff(1, 0, 0); got < 50*time.Millisecond || got > 100*time.Millisecond {
+		t.Fatalf("backoff() with defaults = %v", got)
+	}
+}
+
+// 指标服务只在 Connect 成功时启动 重建客户端不会再次启动
+func TestConnectServesMetricsOnce(t *testing.T) {
+	m := miniredis.RunT(t)
+	cfg := testConfig(m.Addr())
+	cfg.MetricsAddr = "127.0.0.1:0"
+	defer metricsServers.Delete(cfg.MetricsAddr)
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if _, ok := metricsServers.Load(cfg.MetricsAddr); ok {
+		t.Fatal("NewClient() started the metrics server")
+	}
+	client, err = Connect(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if _, ok := metricsServers.Load(cfg.MetricsAddr); !ok {
+		t.Fatal("Connect() did not start the metrics server")
+	}
+
+	metricsServers.Delete(cfg.MetricsAddr)
+	h := NewHealthChecker(cfg, nil)
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Client().Close()
+	if h.Status().Reconnects != 1 {
+		t.Fatalf("status = %+v", h.Status())
+	}
+	if _, ok := metricsServers.Load(cfg.MetricsAddr); ok {
+		t.Fatal("reconnecting started the metrics server again")
+	}
+}
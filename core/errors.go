@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 各章函数返回的错误分类 用 errors.Is 判断
+var (
+	// 键或成员不存在（redis.Nil）
+	ErrNotFound = errors.New("not found")
+	// 被监视的键发生了变化（redis.TxFailedErr） 或者重试多次仍然没有完成
+	ErrConflict = errors.New("conflict")
+	// 与redis之间的网络错误 连接被关闭或超时
+	ErrTransport = errors.New("transport error")
+)
+
+// OpError 操作失败时返回的错误 Op 为操作名 Err 为原始错误
+// errors.Is 既可以匹配上面的分类 也可以匹配原始错误（如 redis.Nil、context.Canceled）
+type OpError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+func (e *OpError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Wrap 给err加上操作名并归类 err为nil时返回nil 已经归类过的错误不会重复包装
+func Wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	return &OpError{Op: op, Kind: ErrorKind(err), Err: err}
+}
+
+// NotFound 构造一个不存在错误 用于没有对应redis错误的场景（比如集合中没有某个成员）
+func NotFound(op string, what string) error {
+	return &OpError{Op: op, Kind: ErrNotFound, Err: errors.New(what + " not found")}
+}
+
+// Conflict 构造一个冲突错误 用于事务重试超时等场景
+func Conflict(op string, why string) error {
+	return &OpError{Op: op, Kind: ErrConflict, Err: errors.New(why)}
+}
+
+// ErrorKind 判断err属于哪一类 无法归类（比如服务器返回的命令错误、ctx被取消）时返回nil
+func ErrorKind(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotFound), errors.Is(err, redis.Nil):
+		return ErrNotFound
+	case errors.Is(err, ErrConflict), errors.Is(err, redis.TxFailedErr):
+		return ErrConflict
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil
+	case errors.Is(err, ErrTransport), errors.Is(err, redis.ErrClosed),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return ErrTransport
+	}
+	return nil
+}
+
+// IgnoreNotFound 把不存在错误当作成功 用于读取可选的键
+func IgnoreNotFound(err error) error {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// StopErr 守护任务退出时的返回值 ctx已经结束时返回nil 否则返回归类后的err
+func StopErr(ctx context.Context, op string, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return Wrap(op, err)
+}
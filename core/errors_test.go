@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+func TestErrorKind(t *testing.T) {
+	_, dialErr := net.Dial("tcp", "127.0.0.1:1")
+	for _, c := range []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"nil", nil, nil},
+		{"redis.Nil", redis.Nil, ErrNotFound},
+		{"NotFound", NotFound("op", "member"), ErrNotFound},
+		{"TxFailedErr", redis.TxFailedErr, ErrConflict},
+		{"wrapped TxFailedErr", errors.Wrap(redis.TxFailedErr, "watch"), ErrConflict},
+		{"Conflict", Conflict("op", "retries"), ErrConflict},
+		{"io.EOF", io.EOF, ErrTransport},
+		{"io.ErrUnexpectedEOF", io.ErrUnexpectedEOF, ErrTransport},
+		{"redis.ErrClosed", redis.ErrClosed, ErrTransport},
+		{"connection refused", dialErr, ErrTransport},
+		{"canceled", context.Canceled, nil},
+		{"deadline", errors.Wrap(context.DeadlineExceeded, "get"), nil},
+		{"command error", errors.New("ERR wrong number of arguments"), nil},
+	} {
+		if kind := ErrorKind(c.err); kind != c.kind {
+			t.Errorf("ErrorKind(%s) = %v, want %v", c.name, kind, c.kind)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if Wrap("op", nil) != nil {
+		t.Fatal("Wrap(nil) != nil")
+	}
+	err := Wrap("articles.vote", redis.Nil)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, redis.Nil) || errors.Is(err, ErrConflict) {
+		t.Fatalf("Wrap(redis.Nil) = %v", err)
+	}
+	if err.Error() != "articles.vote: redis: nil" {
+		t.Fatalf("Error() = %q", err.Error())
+	}
+	// 已经归类过的错误保留最里层的操作名
+	again := Wrap("outer", errors.Wrap(err, "context"))
+	var opErr *OpError
+	if !errors.Is(again, ErrNotFound) || !errors.As(again, &opErr) || opErr.Op != "articles.vote" {
+		t.Fatalf("Wrap(OpError) = %v", again)
+	}
+	if err := Wrap("op", errors.New("ERR")); errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrTransport) {
+		t.Fatalf("Wrap(command error) classified as %v", ErrorKind(err))
+	}
+	if IgnoreNotFound(Wrap("op", redis.Nil)) != nil || IgnoreNotFound(Conflict("op", "x")) == nil {
+		t.Fatal("IgnoreNotFound() did not only drop not found errors")
+	}
+}
+
+// 真实的客户端返回的错误也能被正确归类
+func TestErrorKindFromClient(t *testing.T) {
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	conn := redis.NewClient(&redis.Options{Addr: m.Addr(), MaxRetries: -1})
+	defer conn.Close()
+
+	if err := Wrap("get", conn.Get(ctx, "missing").Err()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GET missing = %v", err)
+	}
+	err := conn.Watch(ctx, func(tx *redis.Tx) error {
+		conn.Set(ctx, "watched", "changed", 0)
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "watched", "mine", 0)
+			return nil
+		})
+		return err
+	}, "watched")
+	if err := Wrap("watch", err); !errors.Is(err, ErrConflict) {
+		t.Fatalf("WATCH conflict = %v", err)
+	}
+
+	m.Close()
+	if err := Wrap("ping", conn.Ping(ctx).Err()); !errors.Is(err, ErrTransport) {
+		t.Fatalf("PING after server closed = %v (kind %v)", err, ErrorKind(err))
+	}
+	conn.Close()
+	if err := Wrap("ping", conn.Ping(ctx).Err()); !errors.Is(err, ErrTransport) || !errors.Is(err, redis.ErrClosed) {
+		t.Fatalf("PING on closed client = %v", err)
+	}
+}
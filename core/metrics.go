@@ -0,0 +1,291 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type opKey struct{}
+
+// WithOp 给ctx加上逻辑操作名（如 articles.vote） 通过ctx执行的redis命令的指标和span都会带上这个名字
+// 外层已经设置过操作名时保留外层的 这样内部调用的其他函数（比如加锁）会计入外层操作
+func WithOp(ctx context.Context, op string) context.Context {
+	if OpName(ctx) != "" {
+		return ctx
+	}
+	return context.WithValue(ctx, opKey{}, op)
+}
+
+// OpName 取出ctx中的操作名 没有设置时返回空字符串
+func OpName(ctx context.Context) string {
+	op, _ := ctx.Value(opKey{}).(string)
+	return op
+}
+
+// 延迟的分桶（秒）
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// 数据大小的分桶（字节）
+var sizeBuckets = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+type histogram struct {
+	counts []uint64 // 每个桶的计数 最后一个为+Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets)+1)
+	}
+	i := sort.SearchFloat64s(buckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// 指标的标签
+type metricKey struct {
+	op      string
+	command string
+	kind    string // 只用于错误计数
+}
+
+// Metrics 按操作名和命令名统计redis命令的延迟、错误数量和请求/响应大小
+// 实现了http.Handler 以Prometheus文本格式输出
+type Metrics struct {
+	mu        sync.Mutex
+	commands  map[metricKey]*histogram
+	pipelines map[metricKey]*histogram
+	requests  map[metricKey]*histogram
+	responses map[metricKey]*histogram
+	errors    map[metricKey]uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		commands:  make(map[metricKey]*histogram),
+		pipelines: make(map[metricKey]*histogram),
+		requests:  make(map[metricKey]*histogram),
+		responses: make(map[metricKey]*histogram),
+		errors:    make(map[metricKey]uint64),
+	}
+}
+
+// 默认的指标 NewClient创建的客户端都会记录到这里
+var DefaultMetrics = NewMetrics()
+
+func observe(m map[metricKey]*histogram, key metricKey, buckets []float64, v float64) {
+	h, ok := m[key]
+	if !ok {
+		h = &histogram{}
+		m[key] = h
+	}
+	h.observe(buckets, v)
+}
+
+// 记录一条命令的大小和错误 pipeline为true时命令的延迟记在流水线上
+func (m *Metrics) recordCmd(op string, cmd redis.Cmder, elapsed time.Duration, pipeline bool) {
+	key := metricKey{op: op, command: cmd.Name()}
+	if !pipeline {
+		observe(m.commands, key, latencyBuckets, elapsed.Seconds())
+	}
+	observe(m.requests, key, sizeBuckets, float64(requestSize(cmd)))
+	if err := cmd.Err(); err != nil {
+		m.errors[metricKey{op: op, command: key.command, kind: errorLabel(err)}]++
+	} else {
+		observe(m.responses, key, sizeBuckets, float64(responseSize(cmd)))
+	}
+}
+
+func (m *Metrics) observeCmd(op string, cmd redis.Cmder, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recordCmd(op, cmd, elapsed, false)
+}
+
+func (m *Metrics) observePipeline(op string, cmds []redis.Cmder, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.pipelines, metricKey{op: op}, latencyBuckets, elapsed.Seconds())
+	for _, cmd := range cmds {
+		m.recordCmd(op, cmd, elapsed, true)
+	}
+}
+
+// 错误计数的kind标签
+func errorLabel(err error) string {
+	switch ErrorKind(err) {
+	case ErrNotFound:
+		return "not_found"
+	case ErrConflict:
+		return "conflict"
+	case ErrTransport:
+		return "transport"
+	}
+	return "other"
+}
+
+// 请求的大小 所有参数按字符串计算
+func requestSize(cmd redis.Cmder) int {
+	n := 0
+	for _, arg := range cmd.Args() {
+		n += valueSize(arg)
+	}
+	return n
+}
+
+// 响应的大小 只统计常见的回复类型
+func responseSize(cmd redis.Cmder) int {
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		return len(c.Val())
+	case *redis.StringSliceCmd:
+		return valueSize(c.Val())
+	case *redis.StringStringMapCmd:
+		n := 0
+		for k, v := range c.Val() {
+			n += len(k) + len(v)
+		}
+		return n
+	case *redis.SliceCmd:
+		return valueSize(c.Val())
+	case *redis.ZSliceCmd:
+		n := 0
+		for _, z := range c.Val() {
+			n += valueSize(z.Member) + 8
+		}
+		return n
+	case *redis.Cmd:
+		return valueSize(c.Val())
+	case *redis.IntCmd, *redis.FloatCmd, *redis.BoolCmd:
+		return 8
+	}
+	return 0
+}
+
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case []string:
+		n := 0
+		for _, s := range v {
+			n += len(s)
+		}
+		return n
+	case []interface{}:
+		n := 0
+		for _, item := range v {
+			n += valueSize(item)
+		}
+		return n
+	}
+	return len(fmt.Sprint(v))
+}
+
+func labelValue(v string) string {
+	if v == "" {
+		return "none"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
+
+func (k metricKey) labels(pipeline bool) string {
+	if pipeline {
+		return `op="` + labelValue(k.op) + `"`
+	}
+	s := `op="` + labelValue(k.op) + `",command="` + labelValue(k.command) + `"`
+	if k.kind != "" {
+		s += `,kind="` + k.kind + `"`
+	}
+	return s
+}
+
+func sortedKeys(m map[metricKey]*histogram) []metricKey {
+	keys := make([]metricKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].command < keys[j].command
+	})
+	return keys
+}
+
+func writeHistogram(w io.Writer, name string, help string, buckets []float64, m map[metricKey]*histogram, pipeline bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, k := range sortedKeys(m) {
+		h := m[k]
+		labels := k.labels(pipeline)
+		var cum uint64
+		for i, le := range buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, cum)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// WritePrometheus 以Prometheus文本格式输出所有指标
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeHistogram(w, "redis_command_duration_seconds", "Latency of single redis commands.", latencyBuckets, m.commands, false)
+	writeHistogram(w, "redis_pipeline_duration_seconds", "Latency of redis pipelines and transactions.", latencyBuckets, m.pipelines, true)
+	writeHistogram(w, "redis_request_bytes", "Size of redis command arguments.", sizeBuckets, m.requests, false)
+	writeHistogram(w, "redis_response_bytes", "Size of successful redis replies.", sizeBuckets, m.responses, false)
+
+	name := "redis_command_errors_total"
+	fmt.Fprintf(w, "# HELP %s Failed redis commands.\n# TYPE %s counter\n", name, name)
+	keys := make([]metricKey, 0, len(m.errors))
+	for k := range m.errors {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].labels(false) < keys[j].labels(false) })
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, k.labels(false), m.errors[k])
+	}
+}
+
+// ServeHTTP 作为 /metrics 接口
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// 已经启动的指标服务 每个地址只启动一次
+var metricsServers sync.Map
+
+// ServeMetrics 在addr上启动一个只提供 /metrics 的http服务 输出 DefaultMetrics
+func ServeMetrics(addr string) {
+	if _, loaded := metricsServers.LoadOrStore(addr, true); loaded {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", DefaultMetrics)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			metricsServers.Delete(addr)
+			fmt.Println("metrics server:", err)
+		}
+	}()
+}
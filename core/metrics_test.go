@@ -0,0 +1,109 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*core.Span
+}
+
+func (e *memExporter) ExportSpan(span *core.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func TestMetricsHook(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	metrics := core.NewMetrics()
+	core.Instrument(conn, metrics, nil)
+
+	ctx := core.WithOp(context.Background(), "test.op")
+	// 内层的操作名不会覆盖外层的
+	ctx = core.WithOp(ctx, "test.inner")
+	conn.Set(ctx, "hello", "world", 0)
+	conn.Get(ctx, "missing")
+	pipe := conn.TxPipeline()
+	pipe.Incr(ctx, "counter")
+	pipe.Get(ctx, "hello")
+	pipe.Exec(ctx)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		`redis_command_duration_seconds_count{op="test.op",command="set"} 1`,
+		`redis_command_errors_total{op="test.op",command="get",kind="not_found"} 1`,
+		`redis_pipeline_duration_seconds_count{op="test.op"} 1`,
+		`redis_request_bytes_sum{op="test.op",command="set"} 13`,
+		`redis_response_bytes_sum{op="test.op",command="get"} 5`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "test.inner") {
+		t.Error("inner op name replaced the outer one")
+	}
+}
+
+func TestTracerHook(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	exporter := &memExporter{}
+	tracer := core.NewTracer(exporter)
+	core.Instrument(conn, nil, tracer)
+
+	ctx, span := tracer.Start(context.Background(), "test.op")
+	conn.Set(ctx, "hello", "world", 0)
+	conn.HGet(ctx, "hello", "field")
+	span.End(nil)
+
+	if len(exporter.spans) != 3 {
+		t.Fatalf("spans = %d, want 3", len(exporter.spans))
+	}
+	set, hget, root := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+	if set.TraceID != root.TraceID || set.ParentSpanID != root.SpanID {
+		t.Fatalf("command span is not a child of the operation span: %+v %+v", set, root)
+	}
+	if set.Name != "set" || set.Kind != "client" || set.Attributes["app.operation"] != "test.op" || set.StatusCode != "OK" {
+		t.Fatalf("set span = %+v", set)
+	}
+	// 类型错误
+	if hget.StatusCode != "ERROR" || hget.StatusMsg == "" {
+		t.Fatalf("hget span = %+v", hget)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := t.TempDir() + "/spans.json"
+	exporter, err := core.NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := core.NewTracer(exporter)
+	_, span := tracer.Start(context.Background(), "test.op")
+	span.End(nil)
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"name":"test.op"`)) || !bytes.HasSuffix(data, []byte("\n")) {
+		t.Fatalf("trace file = %q", data)
+	}
+}
@@ -3,26 +3,18 @@ package core
 import (
 	"context"
 	"github.com/go-redis/redis/v8"
-	"github.com/pkg/errors"
 )
 
-func InitRedis(ctx context.Context, ip string, pw string, db int) (qr *redis.Client) {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     ip,
-		Password: pw, // no password set
-		DB:       db, // use default DB
-	})
-	pong, err := redisClient.Ping(ctx).Result()
-	if pong != "PONG" || err != nil {
-		panic(errors.New("Redis is close!!!"))
-	}
-	return redisClient
-}
-
-//清除所有的key值 避免影响下次测试
-func ClearAllKeys(ctx context.Context, redisCli *redis.Client) {
-	{
-		ret, _ := redisCli.Keys(ctx, "*").Result()
-		redisCli.Del(ctx, ret...)
+//旧的初始化方式 新代码请使用 Config 和 Connect
+//连接失败时按照默认配置进行重试 最终失败则返回 *ConnectError
+func InitRedis(ctx context.Context, ip string, pw string, db int) (*redis.Client, error) {
+	cfg := DefaultConfig()
+	cfg.Addrs = []string{ip}
+	cfg.Password = pw // no password set
+	cfg.DB = db       // use default DB
+	redisClient, err := Connect(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
+	return redisClient.(*redis.Client), nil
 }
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 标记测试库的键 只有存在这个键的库才允许被 ResetKeys 清空
+const TestDBMarker = "myredis:test-db"
+
+var ErrNotTestDB = errors.New("refuse to reset keys: db is not marked as a test db")
+
+// 将当前库标记为测试库
+func MarkTestDB(ctx context.Context, conn redis.Cmdable) error {
+	return conn.Set(ctx, TestDBMarker, 1, 0).Err()
+}
+
+// 判断当前库是否被标记为测试库
+func IsTestDB(ctx context.Context, conn redis.Cmdable) (bool, error) {
+	n, err := conn.Exists(ctx, TestDBMarker).Result()
+	return n > 0, err
+}
+
+type ResetOptions struct {
+	Prefix    string // 只删除以该前缀开头的键 为空时删除所有键（标记键除外）
+	BatchSize int64  // 每次SCAN和UNLINK的数量 默认500
+	DryRun    bool   // 只列出要删除的键 不真正删除
+}
+
+type ResetResult struct {
+	Matched int64    // 匹配到的键数量 非DryRun时即删除的数量
+	Keys    []string // DryRun时匹配到的键
+}
+
+// 转义SCAN MATCH的通配符
+func escapePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(s)
+}
+
+// 使用SCAN遍历键空间 分批UNLINK 不会像KEYS那样阻塞服务器
+// 只有被 MarkTestDB 标记过的库才允许执行 否则返回 ErrNotTestDB
+func ResetKeys(ctx context.Context, conn redis.UniversalClient, opt ResetOptions) (*ResetResult, error) {
+	ok, err := IsTestDB(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotTestDB
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = 500
+	}
+	ret := &ResetResult{}
+	// 集群模式下需要在每个主节点上分别扫描
+	if cluster, ok := conn.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			part := &ResetResult{}
+			if err := resetNode(ctx, node, opt, part, false); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			ret.Matched += part.Matched
+			ret.Keys = append(ret.Keys, part.Keys...)
+			return nil
+		})
+		return ret, err
+	}
+	return ret, resetNode(ctx, conn, opt, ret, true)
+}
+
+// multiKey为false时说明节点上的键可能属于不同的槽 不能一次UNLINK多个键
+func resetNode(ctx context.Context, conn redis.Cmdable, opt ResetOptions, ret *ResetResult, multiKey bool) error {
+	pattern := escapePattern(opt.Prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := conn.Scan(ctx, cursor, pattern, opt.BatchSize).Result()
+		if err != nil {
+			return err
+		}
+		batch := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key != TestDBMarker {
+				batch = append(batch, key)
+			}
+		}
+		if len(batch) > 0 {
+			ret.Matched += int64(len(batch))
+			if opt.DryRun {
+				ret.Keys = append(ret.Keys, batch...)
+			} else if err := unlink(ctx, conn, batch, multiKey); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// 单节点一次UNLINK多个键 集群节点上用流水线逐个UNLINK
+func unlink(ctx context.Context, conn redis.Cmdable, keys []string, multiKey bool) error {
+	if multiKey {
+		return conn.Unlink(ctx, keys...).Err()
+	}
+	_, err := conn.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
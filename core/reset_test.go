@@ -0,0 +1,120 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 记录SCAN命令使用的MATCH参数
+type scanHook struct {
+	patterns *[]string
+}
+
+func (h scanHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if args := cmd.Args(); cmd.Name() == "scan" && len(args) > 3 {
+		*h.patterns = append(*h.patterns, args[3].(string))
+	}
+	return ctx, nil
+}
+func (scanHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (scanHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (scanHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func newResetClient(t *testing.T, keys ...string) *redis.Client {
+	t.Helper()
+	m := miniredis.RunT(t)
+	for _, key := range keys {
+		m.Set(key, "1")
+	}
+	conn := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestResetKeysUnmarked(t *testing.T) {
+	ctx := context.Background()
+	conn := newResetClient(t, "a", "b")
+	if _, err := ResetKeys(ctx, conn, ResetOptions{}); !errors.Is(err, ErrNotTestDB) {
+		t.Fatalf("ResetKeys() on an unmarked db = %v, want ErrNotTestDB", err)
+	}
+	if n := conn.Exists(ctx, "a", "b").Val(); n != 2 {
+		t.Fatalf("%d keys left, want 2", n)
+	}
+	if ok, err := IsTestDB(ctx, conn); ok || err != nil {
+		t.Fatalf("IsTestDB() = %v, %v before MarkTestDB", ok, err)
+	}
+	MarkTestDB(ctx, conn)
+	if ok, err := IsTestDB(ctx, conn); !ok || err != nil {
+		t.Fatalf("IsTestDB() = %v, %v after MarkTestDB", ok, err)
+	}
+}
+
+func TestResetKeysDryRun(t *testing.T) {
+	ctx := context.Background()
+	conn := newResetClient(t, "a", "b", "c")
+	if err := MarkTestDB(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := ResetKeys(ctx, conn, ResetOptions{DryRun: true, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ret.Keys)
+	if ret.Matched != 3 || !reflect.DeepEqual(ret.Keys, []string{"a", "b", "c"}) {
+		t.Fatalf("ResetKeys(DryRun) = %+v", ret)
+	}
+	if n := conn.DBSize(ctx).Val(); n != 4 {
+		t.Fatalf("DryRun deleted keys: %d left, want 4", n)
+	}
+}
+
+func TestResetKeysPrefix(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"test*:1", "test*:2", "test*x", "testab:1", "other"}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, "test*:n"+strconv.Itoa(i))
+	}
+	conn := newResetClient(t, keys...)
+	var patterns []string
+	conn.AddHook(scanHook{patterns: &patterns})
+	if err := MarkTestDB(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前缀中的通配符按字面匹配
+	ret, err := ResetKeys(ctx, conn, ResetOptions{Prefix: "test*:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Matched != 12 || len(ret.Keys) != 0 {
+		t.Fatalf("ResetKeys(prefix) = %+v", ret)
+	}
+	if len(patterns) == 0 || patterns[0] != `test\*:*` {
+		t.Fatalf("SCAN patterns = %q, want test\\*:*", patterns)
+	}
+	left, _ := conn.Keys(ctx, "*").Result()
+	sort.Strings(left)
+	if want := []string{TestDBMarker, "other", "test*x", "testab:1"}; !reflect.DeepEqual(left, want) {
+		t.Fatalf("keys left = %v, want %v", left, want)
+	}
+	if escapePattern(`a\b?[c]`) != `a\\b\?\[c\]` {
+		t.Fatalf("escapePattern() = %q", escapePattern(`a\b?[c]`))
+	}
+
+	// 不带前缀时删除标记以外的所有键
+	if _, err := ResetKeys(ctx, conn, ResetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := conn.Keys(ctx, "*").Result(); !reflect.DeepEqual(left, []string{TestDBMarker}) {
+		t.Fatalf("keys left = %v", left)
+	}
+}
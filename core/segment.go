@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 每个序列的统计信息
+type SegmentStats struct {
+	SegmentsLeased int64 // 从redis租用的号段数量
+	IDsIssued      int64 // 已经分配出去的id数量
+	IDsWasted      int64 // 租用了但没有用到就被丢弃的id数量（进程退出时剩余的号段）
+	FetchErrors    int64 // 后台预取失败的次数
+}
+
+// 一个号段 [start, end]
+type segment struct {
+	next int64
+	end  int64
+}
+
+func (s *segment) remaining() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.end - s.next + 1
+}
+
+type sequence struct {
+	mu       sync.Mutex
+	cur      *segment
+	prefetch *segment
+	fetching chan struct{} // 后台预取进行中时不为nil 预取结束后关闭
+	stats    SegmentStats
+}
+
+// SegmentAllocator 号段式id分配器 每次用INCRBY从redis租用Step个id 在内存中逐个分配
+// 当前号段剩余不足 Step*PrefetchRatio 时在后台预取下一个号段
+// 同一个进程内分配的id单调递增 多个进程之间不会重复 但不保证全局递增
+// 进程重启后从redis继续租用新的号段 之前没有用完的id会被跳过（允许有空洞）
+type SegmentAllocator struct {
+	Step          int64
+	PrefetchRatio float64
+	FetchTimeout  time.Duration
+
+	conn redis.Cmdable
+	mu   sync.Mutex
+	seqs map[string]*sequence
+}
+
+func NewSegmentAllocator(conn redis.Cmdable, step int64) *SegmentAllocator {
+	if step <= 0 {
+		step = 1000
+	}
+	return &SegmentAllocator{
+		Step:          step,
+		PrefetchRatio: 0.2,
+		FetchTimeout:  5 * time.Second,
+		conn:          conn,
+		seqs:          make(map[string]*sequence),
+	}
+}
+
+func (a *SegmentAllocator) sequence(key string) *sequence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seq, ok := a.seqs[key]
+	if !ok {
+		seq = &sequence{}
+		a.seqs[key] = seq
+	}
+	return seq
+}
+
+// 从redis租用一个号段 兼容原来用INCR生成id的键
+func (a *SegmentAllocator) lease(ctx context.Context, key string) (*segment, error) {
+	end, err := a.conn.IncrBy(ctx, key, a.Step).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "lease segment of %s", key)
+	}
+	return &segment{next: end - a.Step + 1, end: end}, nil
+}
+
+// 分配序列key的下一个id
+func (a *SegmentAllocator) Next(ctx context.Context, key string) (int64, error) {
+	seq := a.sequence(key)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	for seq.cur.remaining() == 0 {
+		switch {
+		case seq.prefetch != nil:
+			seq.cur, seq.prefetch = seq.prefetch, nil
+		case seq.fetching != nil:
+			// 等待后台预取完成
+			done := seq.fetching
+			seq.mu.Unlock()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				seq.mu.Lock()
+				return 0, ctx.Err()
+			}
+			seq.mu.Lock()
+			if seq.prefetch == nil && seq.cur.remaining() == 0 {
+				// 预取失败 同步租用
+				if err := a.leaseSync(ctx, key, seq); err != nil {
+					return 0, err
+				}
+			}
+		default:
+			if err := a.leaseSync(ctx, key, seq); err != nil {
+				return 0, err
+			}
+		}
+	}
+	id := seq.cur.next
+	seq.cur.next++
+	seq.stats.IDsIssued++
+	if seq.prefetch == nil && seq.fetching == nil &&
+		float64(seq.cur.remaining()) <= float64(a.Step)*a.PrefetchRatio {
+		a.startPrefetch(key, seq)
+	}
+	return id, nil
+}
+
+// 调用时需要持有seq.mu
+func (a *SegmentAllocator) leaseSync(ctx context.Context, key string, seq *sequence) error {
+	s, err := a.lease(ctx, key)
+	if err != nil {
+		return err
+	}
+	seq.cur = s
+	seq.stats.SegmentsLeased++
+	return nil
+}
+
+// 调用时需要持有seq.mu
+func (a *SegmentAllocator) startPrefetch(key string, seq *sequence) {
+	done := make(chan struct{})
+	seq.fetching = done
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), a.FetchTimeout)
+		defer cancel()
+		s, err := a.lease(ctx, key)
+		seq.mu.Lock()
+		defer seq.mu.Unlock()
+		if err != nil {
+			seq.stats.FetchErrors++
+		} else {
+			seq.prefetch = s
+			seq.stats.SegmentsLeased++
+		}
+		seq.fetching = nil
+		close(done)
+	}()
+}
+
+// 获取序列key的统计信息
+func (a *SegmentAllocator) Stats(key string) SegmentStats {
+	seq := a.sequence(key)
+	seq.mu.Lock()
+	defer seq.mu.Unlock()
+	return seq.stats
+}
+
+// 丢弃所有号段中剩余的id并计入统计 之后再调用Next会重新租用号段
+func (a *SegmentAllocator) Close() {
+	a.mu.Lock()
+	seqs := make([]*sequence, 0, len(a.seqs))
+	for _, seq := range a.seqs {
+		seqs = append(seqs, seq)
+	}
+	a.mu.Unlock()
+	for _, seq := range seqs {
+		seq.mu.Lock()
+		for seq.fetching != nil {
+			done := seq.fetching
+			seq.mu.Unlock()
+			<-done
+			seq.mu.Lock()
+		}
+		seq.stats.IDsWasted += seq.cur.remaining() + seq.prefetch.remaining()
+		seq.cur, seq.prefetch = nil, nil
+		seq.mu.Unlock()
+	}
+}
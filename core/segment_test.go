@@ -0,0 +1,152 @@
+package core_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// 只实现INCRBY的内存计数器 fail不为nil时INCRBY返回它
+type counterConn struct {
+	redis.Cmdable
+	mu     sync.Mutex
+	values map[string]int64
+	fail   error
+}
+
+func newCounterConn() *counterConn {
+	return &counterConn{values: map[string]int64{}}
+}
+
+func (c *counterConn) IncrBy(ctx context.Context, key string, n int64) *redis.IntCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != nil {
+		return redis.NewIntResult(0, c.fail)
+	}
+	c.values[key] += n
+	return redis.NewIntResult(c.values[key], nil)
+}
+
+func (c *counterConn) get(key string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *counterConn) setFail(err error) {
+	c.mu.Lock()
+	c.fail = err
+	c.mu.Unlock()
+}
+
+// 等到序列的统计信息满足ok
+func waitStats(t *testing.T, a *core.SegmentAllocator, key string, ok func(core.SegmentStats) bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !ok(a.Stats(key)); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats() = %+v", a.Stats(key))
+		}
+	}
+}
+
+func TestSegmentAllocator(t *testing.T) {
+	ctx := context.Background()
+	conn := newCounterConn()
+	// 原来用INCR生成到了100
+	conn.values["article:"] = 100
+	a := core.NewSegmentAllocator(conn, 10)
+
+	// 跨过号段边界时id仍然连续递增
+	last := int64(100)
+	for i := 0; i < 25; i++ {
+		id, err := a.Next(ctx, "article:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != last+1 {
+			t.Fatalf("Next() #%d = %d, want %d", i, id, last+1)
+		}
+		last = id
+		// 当前号段只剩2个时已经在后台预取下一个号段
+		if i == 7 {
+			waitStats(t, a, "article:", func(s core.SegmentStats) bool { return s.SegmentsLeased == 2 })
+			if v := conn.get("article:"); v != 120 {
+				t.Fatalf("counter after prefetch = %d, want 120", v)
+			}
+		}
+	}
+	waitStats(t, a, "article:", func(s core.SegmentStats) bool { return s.SegmentsLeased == 3 })
+	if s := a.Stats("article:"); s.IDsIssued != 25 || s.FetchErrors != 0 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+
+	// 关闭时剩余的id计入浪费 之后重新租用的号段跳过它们
+	a.Close()
+	if s := a.Stats("article:"); s.IDsWasted != 5 {
+		t.Fatalf("IDsWasted = %d, want 5", s.IDsWasted)
+	}
+	if id, _ := a.Next(ctx, "article:"); id != 131 {
+		t.Fatalf("Next() after Close = %d, want 131", id)
+	}
+}
+
+// 后台预取失败时计入FetchErrors 用完当前号段时同步租用
+func TestSegmentAllocatorFetchError(t *testing.T) {
+	ctx := context.Background()
+	conn := newCounterConn()
+	a := core.NewSegmentAllocator(conn, 5)
+	a.Next(ctx, "seq:")
+	conn.setFail(errors.New("connection refused"))
+	for i := 0; i < 4; i++ {
+		a.Next(ctx, "seq:")
+	}
+	waitStats(t, a, "seq:", func(s core.SegmentStats) bool { return s.FetchErrors >= 1 })
+	if _, err := a.Next(ctx, "seq:"); err == nil {
+		t.Fatal("Next() succeeded without a segment")
+	}
+	conn.setFail(nil)
+	if id, err := a.Next(ctx, "seq:"); err != nil || id != 6 {
+		t.Fatalf("Next() after recovery = %d, %v, want 6", id, err)
+	}
+}
+
+// 多个分配器（进程）同时分配 id不会重复
+func TestSegmentAllocatorConcurrent(t *testing.T) {
+	ctx := context.Background()
+	conn := newCounterConn()
+	var mu sync.Mutex
+	seen := map[int64]bool{}
+	var wg sync.WaitGroup
+	for p := 0; p < 3; p++ {
+		a := core.NewSegmentAllocator(conn, 7)
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					id, err := a.Next(ctx, "seq:")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					if seen[id] {
+						t.Errorf("id %d issued twice", id)
+					}
+					seen[id] = true
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if len(seen) != 600 {
+		t.Fatalf("issued %d ids, want 600", len(seen))
+	}
+}
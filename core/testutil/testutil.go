@@ -0,0 +1,134 @@
+// Package testutil 测试用的redis服务器
+// 本地安装了redis-server时在随机端口上启动一个真正的redis 否则使用进程内的miniredis
+// 环境变量 REDIS_TEST_SERVER=miniredis 强制使用miniredis 设置为可执行文件路径时使用指定的redis-server
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// Server 测试用的redis服务器 测试结束时自动关闭
+type Server struct {
+	Addr   string
+	Client *redis.Client
+
+	mini *miniredis.Miniredis
+	cmd  *exec.Cmd
+}
+
+// NewServer 启动一个空的redis服务器并连接到它 连接的库已经被标记为测试库
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{}
+	if path := redisServerPath(); path != "" {
+		if err := s.startBinary(path); err != nil {
+			tb.Fatalf("start %s: %v", path, err)
+		}
+	} else {
+		m, err := miniredis.Run()
+		if err != nil {
+			tb.Fatalf("start miniredis: %v", err)
+		}
+		s.mini = m
+		s.Addr = m.Addr()
+	}
+	tb.Cleanup(s.Close)
+
+	cfg := core.DefaultConfig()
+	cfg.Addrs = []string{s.Addr}
+	cfg.TestDB = true
+	cfg.ConnectTimeout = 5 * time.Second
+	client, err := core.Connect(context.Background(), cfg)
+	if err != nil {
+		tb.Fatalf("connect test server: %v", err)
+	}
+	s.Client = client.(*redis.Client)
+	return s
+}
+
+// Real 是否为真正的redis-server 一些miniredis不支持的命令需要据此跳过
+func (s *Server) Real() bool {
+	return s.cmd != nil
+}
+
+// Mini 返回进程内的miniredis 使用redis-server时返回nil
+func (s *Server) Mini() *miniredis.Miniredis {
+	return s.mini
+}
+
+// FastForward 让键的过期时间前进d miniredis不会自己让键过期 使用redis-server时直接等待d
+func (s *Server) FastForward(d time.Duration) {
+	if s.mini != nil {
+		s.mini.FastForward(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// Close 关闭客户端和服务器 可以重复调用
+func (s *Server) Close() {
+	if s.Client != nil {
+		s.Client.Close()
+		s.Client = nil
+	}
+	if s.mini != nil {
+		s.mini.Close()
+	}
+	if s.cmd != nil && s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+		s.cmd = nil
+	}
+}
+
+// 找出要使用的redis-server 返回空字符串时使用miniredis
+func redisServerPath() string {
+	switch env := os.Getenv("REDIS_TEST_SERVER"); env {
+	case "miniredis":
+		return ""
+	case "":
+		path, _ := exec.LookPath("redis-server")
+		return path
+	default:
+		return env
+	}
+}
+
+// 在随机端口上启动redis-server 不做持久化 等到可以连接之后才返回
+func (s *Server) startBinary(path string) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s.Addr = "127.0.0.1:" + strconv.Itoa(port)
+	s.cmd = exec.Command(path, "--port", strconv.Itoa(port), "--bind", "127.0.0.1",
+		"--save", "", "--appendonly", "no", "--daemonize", "no")
+	if err := s.cmd.Start(); err != nil {
+		s.cmd = nil
+		return err
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		conn, err := net.DialTimeout("tcp", s.Addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.Close()
+	return fmt.Errorf("redis-server did not listen on %s", s.Addr)
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Span 一次操作或一条redis命令的执行记录 字段与OpenTelemetry的span对应
+type Span struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"` // internal/client
+	StartTime    time.Time         `json:"start_time"`
+	EndTime      time.Time         `json:"end_time"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	StatusCode   string            `json:"status_code"` // OK/ERROR
+	StatusMsg    string            `json:"status_message,omitempty"`
+
+	tracer *Tracer
+}
+
+// End 结束span并导出 err不为nil时状态为ERROR
+func (s *Span) End(err error) {
+	s.EndTime = time.Now()
+	s.StatusCode = "OK"
+	if err != nil {
+		s.StatusCode = "ERROR"
+		s.StatusMsg = err.Error()
+	}
+	if s.tracer != nil {
+		s.tracer.export(s)
+	}
+}
+
+// SpanExporter 导出结束的span
+type SpanExporter interface {
+	ExportSpan(span *Span) error
+}
+
+// FileExporter 把span以JSON行的形式追加到文件中
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: f}, nil
+}
+
+func (e *FileExporter) ExportSpan(span *Span) error {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(data, '\n'))
+	return err
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// Tracer 创建span 并在span结束时交给Exporter
+type Tracer struct {
+	Exporter SpanExporter
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{Exporter: exporter}
+}
+
+type spanKey struct{}
+
+// SpanFromContext 取出ctx中当前的span 没有时返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start 开始一个span ctx中已经有span时作为它的子span 返回的ctx带有新的span
+// name一般为操作名 操作名同时会通过WithOp写入ctx
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := t.newSpan(ctx, name, "internal")
+	ctx = WithOp(ctx, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) newSpan(ctx context.Context, name string, kind string) *Span {
+	span := &Span{
+		SpanID:     randomID(8),
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]string),
+		tracer:     t,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+	if op := OpName(ctx); op != "" {
+		span.Attributes["app.operation"] = op
+	}
+	return span
+}
+
+func (t *Tracer) export(span *Span) {
+	if t.Exporter != nil {
+		_ = t.Exporter.ExportSpan(span)
+	}
+}
+
+// Hook go-redis的钩子 把命令和流水线的执行情况记录到Metrics 并为每次调用创建一个client span
+// Metrics和Tracer都可以为nil
+type Hook struct {
+	Metrics *Metrics
+	Tracer  *Tracer
+}
+
+type hookStart struct {
+	start time.Time
+	span  *Span
+}
+
+// 同一个客户端上可能有多个钩子 它们的After*拿到的是同一个ctx 所以用钩子本身区分
+type hookKey struct{ h *Hook }
+
+// Instrument 给客户端安装钩子
+func Instrument(client redis.UniversalClient, metrics *Metrics, tracer *Tracer) {
+	client.AddHook(&Hook{Metrics: metrics, Tracer: tracer})
+}
+
+func (h *Hook) before(ctx context.Context, name string, statement string) context.Context {
+	hs := &hookStart{start: time.Now()}
+	if h.Tracer != nil {
+		hs.span = h.Tracer.newSpan(ctx, name, "client")
+		hs.span.Attributes["db.system"] = "redis"
+		hs.span.Attributes["db.operation"] = statement
+	}
+	return context.WithValue(ctx, hookKey{h}, hs)
+}
+
+func (h *Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx, cmd.Name(), cmd.Name()), nil
+}
+
+func (h *Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	hs, ok := ctx.Value(hookKey{h}).(*hookStart)
+	if !ok {
+		return nil
+	}
+	if h.Metrics != nil {
+		h.Metrics.observeCmd(OpName(ctx), cmd, time.Since(hs.start))
+	}
+	if hs.span != nil {
+		hs.span.End(IgnoreNotFound(cmd.Err()))
+	}
+	return nil
+}
+
+func (h *Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	return h.before(ctx, "pipeline", strings.Join(names, " ")), nil
+}
+
+func (h *Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	hs, ok := ctx.Value(hookKey{h}).(*hookStart)
+	if !ok {
+		return nil
+	}
+	if h.Metrics != nil {
+		h.Metrics.observePipeline(OpName(ctx), cmds, time.Since(hs.start))
+	}
+	if hs.span != nil {
+		var err error
+		for _, cmd := range cmds {
+			if err = IgnoreNotFound(cmd.Err()); err != nil {
+				break
+			}
+		}
+		hs.span.End(err)
+	}
+	return nil
+}
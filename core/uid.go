@@ -1,28 +1,253 @@
 package core
 
 import (
-	"fmt"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 雪花算法的id结构: 1位符号位 41位毫秒时间戳 10位机器号 12位序列号
+const (
+	workerBits   = 10
+	sequenceBits = 12
+	MaxWorkerID  = 1<<workerBits - 1
+	maxSequence  = 1<<sequenceBits - 1
 )
 
-var count int64
-var oldUseTimeStamp = time.Now().Unix()
-var mux sync.Mutex
+// 时间戳从这个时间开始计算 41位毫秒大约可以用69年
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	ErrClockBackwards = errors.New("clock moved backwards")
+	ErrLeaseLost      = errors.New("worker id lease lost")
+	// 还没有调用 InitIDGenerator 租用机器号
+	ErrNoWorkerID = errors.New("no worker id leased")
+)
+
+// Snowflake 雪花算法id生成器 同一时刻每个机器号只能被一个进程使用
+// 机器号可以通过 LeaseWorkerID 从redis租用 保证多个进程之间不会冲突
+type Snowflake struct {
+	// 时钟回拨不超过该值时等待时钟追上来 超过时返回 ErrClockBackwards
+	MaxBackwards time.Duration
+
+	mu       sync.Mutex
+	workerID int64
+	lastMs   int64
+	sequence int64
+	lease    *WorkerLease
+	clock    Clock
+}
+
+func NewSnowflake(workerID int64) (*Snowflake, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, errors.Errorf("worker id %d out of range [0, %d]", workerID, MaxWorkerID)
+	}
+	return &Snowflake{MaxBackwards: 10 * time.Millisecond, workerID: workerID, clock: RealClock{}}, nil
+}
+
+// 使用从redis租来的机器号 租约丢失后不再生成id
+func NewSnowflakeWithLease(lease *WorkerLease) *Snowflake {
+	s, _ := NewSnowflake(lease.ID())
+	s.lease = lease
+	return s
+}
+
+func (s *Snowflake) WorkerID() int64 { return s.workerID }
+
+func (s *Snowflake) millis() int64 {
+	return s.clock.Now().Sub(Epoch).Nanoseconds() / int64(time.Millisecond)
+}
+
+// 生成下一个id 等待时钟追上来或者等待下一毫秒时通过 Clock 等待
+func (s *Snowflake) NextID() (int64, error) {
+	if s.lease != nil && s.lease.Lost() {
+		return 0, ErrLeaseLost
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := s.millis()
+	if ms < s.lastMs {
+		// 时钟回拨 回拨得不多就等一等 否则报错 避免生成重复的id
+		behind := time.Duration(s.lastMs-ms) * time.Millisecond
+		if behind > s.MaxBackwards {
+			return 0, errors.Wrapf(ErrClockBackwards, "by %v", behind)
+		}
+		for ; ms < s.lastMs; ms = s.millis() {
+			Sleep(s.clock, nil, time.Duration(s.lastMs-ms)*time.Millisecond)
+		}
+	}
+	if ms == s.lastMs {
+		s.sequence = (s.sequence + 1) & maxSequence
+		if s.sequence == 0 {
+			// 这一毫秒的序列号用完了 等到下一毫秒
+			for ms <= s.lastMs {
+				Sleep(s.clock, nil, 100*time.Microsecond)
+				ms = s.millis()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastMs = ms
+	return ms<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence, nil
+}
+
+// 从id中解析出生成时间、机器号和序列号
+func ParseID(id int64) (time.Time, int64, int64) {
+	ms := id >> (workerBits + sequenceBits)
+	worker := (id >> sequenceBits) & MaxWorkerID
+	seq := id & maxSequence
+	return Epoch.Add(time.Duration(ms) * time.Millisecond), worker, seq
+}
+
+// 租约的续期脚本 只有持有者才能续期
+var renewLeaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
 
-const PerSecondMaxValue int64 = 10000
+// 释放租约的脚本 只有持有者才能释放
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// WorkerLease 从redis租用的机器号 后台定时续期 续期失败则认为租约丢失
+type WorkerLease struct {
+	id     int64
+	key    string
+	token  string
+	ttl    time.Duration
+	conn   redis.Cmdable
+	lost   int32
+	cancel context.CancelFunc
+	done   chan struct{}
+	now    func() time.Time
+	// 键在redis中最晚的过期时间（UnixNano） 由发出续期命令之前的时间加上ttl得到
+	deadline int64
+}
+
+// 从redis中租用一个空闲的机器号 租约的有效期为ttl 每ttl/3续期一次
+func LeaseWorkerID(ctx context.Context, conn redis.Cmdable, ttl time.Duration) (*WorkerLease, error) {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	token := NewToken()
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	// 从随机位置开始找 减少多个进程同时启动时的争抢
+	start := int64(binary.BigEndian.Uint16(b[:])) % (MaxWorkerID + 1)
+	for i := int64(0); i <= MaxWorkerID; i++ {
+		id := (start + i) % (MaxWorkerID + 1)
+		key := "idgen:worker:" + strconv.FormatInt(id, 10)
+		sent := time.Now()
+		ok, err := conn.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hctx, cancel := context.WithCancel(context.Background())
+			l := &WorkerLease{id: id, key: key, token: token, ttl: ttl, conn: conn, cancel: cancel, done: make(chan struct{}),
+				now: time.Now, deadline: sent.Add(ttl).UnixNano()}
+			go l.heartbeat(hctx)
+			return l, nil
+		}
+	}
+	return nil, errors.New("no free worker id")
+}
+
+func (l *WorkerLease) ID() int64 { return l.id }
+
+// 租约是否已经丢失（过期后被其他进程占用或者已经释放）
+// 为了容忍进程之间的时钟误差和暂停 距离过期还剩 ttl/3 时就认为租约已经丢失 此时续期已经失败过至少一次
+func (l *WorkerLease) Lost() bool {
+	if atomic.LoadInt32(&l.lost) == 1 {
+		return true
+	}
+	margin := l.ttl / 3
+	return l.now().After(time.Unix(0, atomic.LoadInt64(&l.deadline)).Add(-margin))
+}
+
+func (l *WorkerLease) heartbeat(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sent := l.now()
+		ok, err := renewLeaseScript.Run(ctx, l.conn, []string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+		switch {
+		case err == nil && ok == 1 && !l.Lost():
+			atomic.StoreInt64(&l.deadline, sent.Add(l.ttl).UnixNano())
+		case err == nil || l.Lost():
+			// 键已经不属于自己 或者一直续期失败到了安全时间之内
+			// 即使之后续期成功 这期间其他进程也可能已经用过这个机器号 所以租约一旦丢失就不再恢复
+			atomic.StoreInt32(&l.lost, 1)
+			return
+		}
+	}
+}
+
+// 停止续期并释放机器号
+func (l *WorkerLease) Release(ctx context.Context) error {
+	l.cancel()
+	<-l.done
+	atomic.StoreInt32(&l.lost, 1)
+	return releaseLeaseScript.Run(ctx, l.conn, []string{l.key}, l.token).Err()
+}
+
+// 128位的随机令牌 用作锁和信号量的标识符
+func NewToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// GenID 使用的生成器 调用 InitIDGenerator 之前为nil
+var defaultGenerator atomic.Value
 
 func init() {
-	count = 0
+	defaultGenerator.Store((*Snowflake)(nil))
+}
+
+// 从redis租用机器号并用于 GenID 返回的租约需要在退出时释放
+func InitIDGenerator(ctx context.Context, conn redis.Cmdable, ttl time.Duration) (*WorkerLease, error) {
+	lease, err := LeaseWorkerID(ctx, conn, ttl)
+	if err != nil {
+		return nil, err
+	}
+	defaultGenerator.Store(NewSnowflakeWithLease(lease))
+	return lease, nil
 }
 
-func GenID() string {
-	defer mux.Unlock()
-	mux.Lock()
-	if count > PerSecondMaxValue {
-		oldUseTimeStamp = time.Now().Unix()
-		count = 0
+// 生成全局唯一的id 还没有租用机器号时返回 ErrNoWorkerID 租约丢失后返回 ErrLeaseLost
+func GenID() (string, error) {
+	s := defaultGenerator.Load().(*Snowflake)
+	if s == nil {
+		return "", ErrNoWorkerID
+	}
+	id, err := s.NextID()
+	if err != nil {
+		return "", err
 	}
-	count++
-	return fmt.Sprintf("%v", oldUseTimeStamp*PerSecondMaxValue+count)
+	return strconv.FormatInt(id, 10), nil
 }
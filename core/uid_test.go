@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// 可以回拨的时钟 等待仍然由 FakeClock 的 Advance 唤醒
+type skewClock struct {
+	*FakeClock
+	skew time.Duration
+}
+
+func (c *skewClock) Now() time.Time { return c.FakeClock.Now().Add(c.skew) }
+
+func TestSnowflakeClockBackwards(t *testing.T) {
+	s, err := NewSnowflake(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &skewClock{FakeClock: NewFakeClock(Epoch.Add(time.Hour))}
+	s.clock = clock
+	first, _ := s.NextID()
+	second, _ := s.NextID()
+	if second <= first {
+		t.Fatalf("NextID() = %d after %d", second, first)
+	}
+	if _, worker, seq := ParseID(second); worker != 7 || seq != 1 {
+		t.Fatalf("ParseID() = worker %d seq %d", worker, seq)
+	}
+
+	// 回拨超过MaxBackwards 报错而不是生成可能重复的id
+	clock.skew = -time.Second
+	if _, err := s.NextID(); !errors.Is(err, ErrClockBackwards) {
+		t.Fatalf("NextID() after going back 1s = %v", err)
+	}
+
+	// 回拨不多时等到时钟追上来
+	clock.skew = -5 * time.Millisecond
+	done := make(chan int64, 1)
+	go func() {
+		id, _ := s.NextID()
+		done <- id
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Millisecond)
+	if third := <-done; third <= second {
+		t.Fatalf("NextID() = %d after %d", third, second)
+	}
+}
+
+// 一毫秒内的序列号用完之后 等时钟走到下一毫秒
+func TestSnowflakeSequenceExhausted(t *testing.T) {
+	s, _ := NewSnowflake(1)
+	clock := NewFakeClock(Epoch.Add(time.Hour))
+	s.clock = clock
+	var last int64
+	for i := 0; i <= maxSequence; i++ {
+		last, _ = s.NextID()
+	}
+	done := make(chan int64, 1)
+	go func() {
+		id, _ := s.NextID()
+		done <- id
+	}()
+	clock.BlockUntil(1)
+	select {
+	case id := <-done:
+		t.Fatalf("NextID() = %d before the clock moved", id)
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	next := <-done
+	if at, _, seq := ParseID(next); next <= last || seq != 0 || !at.Equal(clock.Now()) {
+		t.Fatalf("NextID() = %d (%v seq %d) after %d", next, at, seq, last)
+	}
+}
+
+func TestWorkerLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	conn := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	defer conn.Close()
+
+	defer func(g interface{}) { defaultGenerator.Store(g) }(defaultGenerator.Load())
+	defaultGenerator.Store((*Snowflake)(nil))
+	if _, err := GenID(); !errors.Is(err, ErrNoWorkerID) {
+		t.Fatalf("GenID() before InitIDGenerator = %v, want ErrNoWorkerID", err)
+	}
+	lease, err := InitIDGenerator(ctx, conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lease.Release(ctx)
+	s := defaultGenerator.Load().(*Snowflake)
+	if s.WorkerID() != lease.ID() || lease.Lost() {
+		t.Fatalf("worker id %d, lease %d lost %v", s.WorkerID(), lease.ID(), lease.Lost())
+	}
+	if id, err := GenID(); err != nil || id == "" {
+		t.Fatalf("GenID() = %q, %v", id, err)
+	}
+
+	// 机器号被其他进程占用之后 下一次续期发现租约丢失
+	conn.Set(ctx, lease.key, "other", 0)
+	for end := time.Now().Add(2 * time.Second); !lease.Lost(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatal("lease not lost after the key was taken")
+		}
+	}
+	if _, err := s.NextID(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("NextID() = %v, want ErrLeaseLost", err)
+	}
+	if id, err := GenID(); id != "" || !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("GenID() = %q, %v, want ErrLeaseLost", id, err)
+	}
+}
+
+// 续期一直失败时 在过期之前的安全时间内就认为租约已经丢失
+func TestWorkerLeaseMargin(t *testing.T) {
+	deadline := time.Unix(1600000000, 0)
+	now := deadline.Add(-time.Minute)
+	lease := &WorkerLease{ttl: 3 * time.Minute, deadline: deadline.UnixNano(), now: func() time.Time { return now }}
+	s := NewSnowflakeWithLease(lease)
+	if _, err := s.NextID(); err != nil || lease.Lost() {
+		t.Fatalf("NextID() = %v with a minute left, lost %v", err, lease.Lost())
+	}
+	now = now.Add(time.Second)
+	if !lease.Lost() {
+		t.Fatal("lease not lost within ttl/3 of its deadline")
+	}
+	if _, err := s.NextID(); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("NextID() = %v, want ErrLeaseLost", err)
+	}
+}
@@ -0,0 +1,204 @@
+package articles
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/locks"
+)
+
+// ArchiveOptions 归档的参数
+type ArchiveOptions struct {
+	// 导出文件所在的目录 每天一个 articles-YYYY-MM-DD.ndjson 文件 每行一篇文章
+	Dir string
+	// 每批归档的文章数量 默认100
+	Batch int
+	// 为true时保留文章散列 GET /articles/{id} 仍然可以读到归档的文章 默认导出之后删除
+	KeepHashes bool
+}
+
+// 归档进行中的批次 {file: 导出文件名, offset: 写入这一批之前的文件长度}
+// 与归档这一批的redis修改在同一个事务中删除 进程崩溃后据此截断导出文件并重做这一批
+const archivePending = "archive:pending"
+
+// 归档锁的过期时间 每批之前续期
+const archiveLockTimeout = 5 * time.Minute
+
+var errArchiveLockLost = errors.New("archive lock lost")
+
+// 测试用的钩子 在导出文件写入之后、redis事务执行之前调用 返回错误时模拟崩溃
+var archiveHook = func() error { return nil }
+
+// ArchiveArticles 归档所有已经超过投票期限的文章 返回归档的文章数量
+// 文章散列导出到opt.Dir下的NDJSON文件之后 在一个事务中：把score:中的最终评分写入archive:有序集合、
+// 从time:和各排名算法的有序集合中移除文章、删除voted:和oppose_voted:集合和文章散列（opt.KeepHashes为true时保留散列）
+// 文章不在score:中时返回错误 不导出这一批 归档停在这篇文章处 需要先修复它的评分
+// 同时只允许一个归档进程运行 拿不到锁时直接返回0 每批之前给锁续期 锁丢失时停止归档
+func ArchiveArticles(ctx context.Context, conn redis.UniversalClient, opt ArchiveOptions) (int, error) {
+	ctx = core.WithOp(ctx, "articles.archive")
+	if opt.Batch <= 0 {
+		opt.Batch = 100
+	}
+	if err := os.MkdirAll(opt.Dir, 0755); err != nil {
+		return 0, core.Wrap("articles.archive", err)
+	}
+	lock, err := locks.AcquireLockWithTimeout(ctx, conn, "articles:archive", time.Millisecond, archiveLockTimeout)
+	if lock == "" {
+		return 0, err
+	}
+	defer locks.ReleaseLock(ctx, conn, "articles:archive", lock)
+
+	if err := recoverArchive(ctx, conn, opt.Dir); err != nil {
+		return 0, core.Wrap("articles.archive", err)
+	}
+	cutoff := Clock.Now().Unix() - ONE_WEEK_IN_SECONDS
+	total := 0
+	for {
+		if ok, err := locks.RefreshLock(ctx, conn, "articles:archive", lock, archiveLockTimeout); err != nil || !ok {
+			if err == nil {
+				err = errArchiveLockLost
+			}
+			return total, core.Wrap("articles.archive", err)
+		}
+		n, err := archiveBatch(ctx, conn, opt, cutoff)
+		total += n
+		if err != nil {
+			return total, core.Wrap("articles.archive", err)
+		}
+		if n < opt.Batch {
+			return total, nil
+		}
+	}
+}
+
+// 上次归档在导出之后、事务执行之前中断 把导出文件截断到这一批之前的长度
+func recoverArchive(ctx context.Context, conn redis.Cmdable, dir string) error {
+	pending, err := conn.HGetAll(ctx, archivePending).Result()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	offset, err := strconv.ParseInt(pending["offset"], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "bad %s", archivePending)
+	}
+	path := filepath.Join(dir, filepath.Base(pending["file"]))
+	if info, err := os.Stat(path); err == nil && info.Size() > offset {
+		if err := os.Truncate(path, offset); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return conn.Del(ctx, archivePending).Err()
+}
+
+// 归档发布时间早于cutoff的最旧的一批文章
+func archiveBatch(ctx context.Context, conn redis.Cmdable, opt ArchiveOptions, cutoff int64) (int, error) {
+	ids, err := conn.ZRangeByScore(ctx, "time:", &redis.ZRangeBy{
+		Min: "-inf", Max: "(" + strconv.FormatInt(cutoff, 10), Count: int64(opt.Batch)}).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	pipe := conn.Pipeline()
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	scores := make([]*redis.FloatCmd, len(ids))
+	for i, id := range ids {
+		hashes[i] = pipe.HGetAll(ctx, id)
+		scores[i] = pipe.ZScore(ctx, "score:", id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if scores[i].Err() == redis.Nil {
+			return 0, errors.Errorf("%s is not in score:", id)
+		}
+	}
+
+	now := Clock.Now()
+	name := "articles-" + now.UTC().Format("2006-01-02") + ".ndjson"
+	if err := exportArticles(ctx, conn, filepath.Join(opt.Dir, name), name, ids, hashes, scores, now); err != nil {
+		return 0, err
+	}
+	if err := archiveHook(); err != nil {
+		return 0, err
+	}
+
+	tx := conn.TxPipeline()
+	for i, id := range ids {
+		article_id := strings.TrimPrefix(id, "article:")
+		// 冻结最终评分
+		tx.ZAdd(ctx, "archive:", &redis.Z{Score: scores[i].Val(), Member: id})
+		tx.ZRem(ctx, "time:", id)
+		for _, r := range Rankers {
+			tx.ZRem(ctx, r.Name()+":", id)
+		}
+		tx.Del(ctx, "voted:"+article_id, "oppose_voted:"+article_id)
+		if !opt.KeepHashes {
+			tx.Del(ctx, id)
+		}
+	}
+	tx.Del(ctx, archivePending)
+	if _, err := tx.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// 把一批文章追加到导出文件 写入之前先在redis中记录文件原来的长度
+func exportArticles(ctx context.Context, conn redis.Cmdable, path string, name string, ids []string,
+	hashes []*redis.StringStringMapCmd, scores []*redis.FloatCmd, now time.Time) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := conn.HSet(ctx, archivePending, "file", name, "offset", offset).Err(); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i, id := range ids {
+		article := make(map[string]interface{}, len(hashes[i].Val())+2)
+		for k, v := range hashes[i].Val() {
+			article[k] = v
+		}
+		article["id"] = id
+		article["score"] = scores[i].Val()
+		article["archived_at"] = now.Unix()
+		if err := enc.Encode(article); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// 事务执行之前确保导出的内容已经落盘
+	return f.Sync()
+}
+
+// ArchiveLoop 守护任务 每隔interval调用一次 ArchiveArticles 直到ctx结束或出错
+func ArchiveLoop(ctx context.Context, conn redis.UniversalClient, opt ArchiveOptions, interval time.Duration) error {
+	ctx = core.WithOp(ctx, "articles.archive")
+	for ctx.Err() == nil {
+		if _, err := ArchiveArticles(ctx, conn, opt); err != nil {
+			return core.StopErr(ctx, "articles.archive", err)
+		}
+		core.Sleep(Clock, ctx.Done(), interval)
+	}
+	return nil
+}
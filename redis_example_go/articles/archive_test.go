@@ -0,0 +1,228 @@
+package articles
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// 读出导出目录中所有文章 按id统计出现的次数
+func readArchive(t *testing.T, dir string) map[string][]map[string]interface{} {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	articles := make(map[string][]map[string]interface{})
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var a map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				t.Fatalf("%s: %v in %q", name, err, scanner.Text())
+			}
+			id, _ := a["id"].(string)
+			articles[id] = append(articles[id], a)
+		}
+		f.Close()
+	}
+	return articles
+}
+
+func TestArchiveArticles(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+	dir := tempDir(t)
+
+	var old []string
+	for i := 0; i < 3; i++ {
+		id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+		old = append(old, "article:"+id)
+	}
+	if _, err := ArticleOpposeVote(ctx, conn, "other_user", old[0]); err != nil {
+		t.Fatal(err)
+	}
+	final := conn.ZScore(ctx, "score:", old[0]).Val()
+	clock.Advance(8 * 24 * time.Hour)
+	fresh, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+
+	n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: dir, Batch: 2})
+	if err != nil || n != 3 {
+		t.Fatalf("ArchiveArticles() = %d, %v, want 3", n, err)
+	}
+	if score := conn.ZScore(ctx, "archive:", old[0]).Val(); score != final {
+		t.Fatalf("archived score = %v, want %v", score, final)
+	}
+	for _, key := range []string{"time:", "score:", "hn:", "hot:", "wilson:"} {
+		if members := conn.ZRange(ctx, key, 0, -1).Val(); len(members) != 1 || members[0] != "article:"+fresh {
+			t.Errorf("%s = %v, want only article:%s", key, members, fresh)
+		}
+	}
+	if n := conn.Exists(ctx, "voted:1", "oppose_voted:1", "archive:pending").Val(); n != 0 {
+		t.Errorf("%d archived keys left", n)
+	}
+	// 默认删除文章散列
+	if _, err := GetArticle(ctx, conn, old[0]); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("GetArticle(archived) = %v, want ErrNotFound", err)
+	}
+
+	exported := readArchive(t, dir)
+	if len(exported) != 3 {
+		t.Fatalf("exported %d articles, want 3", len(exported))
+	}
+	if a := exported[old[0]][0]; a["title"] != "A title" || a["score"] != final || a["votes"] != "0" {
+		t.Fatalf("exported article = %v", a)
+	}
+
+	// 归档之后不能再投票 再次归档没有新的文章
+	if _, err := ArticleVote(ctx, conn, "other_user", old[1]); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("ArticleVote(archived) = %v, want ErrVotingClosed", err)
+	}
+	if n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: dir}); n != 0 || err != nil {
+		t.Fatalf("ArchiveArticles() again = %d, %v", n, err)
+	}
+}
+
+func TestArchiveResume(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+	dir := tempDir(t)
+
+	for i := 0; i < 5; i++ {
+		PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	}
+	clock.Advance(8 * 24 * time.Hour)
+
+	// 第二批写入文件之后、事务执行之前崩溃
+	batches := 0
+	archiveHook = func() error {
+		if batches++; batches == 2 {
+			return errors.New("crash")
+		}
+		return nil
+	}
+	defer func() { archiveHook = func() error { return nil } }()
+	n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: dir, Batch: 2})
+	if err == nil || n != 2 {
+		t.Fatalf("ArchiveArticles() = %d, %v, want 2 and an error", n, err)
+	}
+	if conn.Exists(ctx, "archive:pending").Val() != 1 {
+		t.Fatal("no pending batch recorded")
+	}
+	if exported := readArchive(t, dir); len(exported) != 4 {
+		t.Fatalf("exported %d articles before resuming, want 4", len(exported))
+	}
+
+	n, err = ArchiveArticles(ctx, conn, ArchiveOptions{Dir: dir, Batch: 2})
+	if err != nil || n != 3 {
+		t.Fatalf("resumed ArchiveArticles() = %d, %v, want 3", n, err)
+	}
+	exported := readArchive(t, dir)
+	if len(exported) != 5 {
+		t.Fatalf("exported %d articles, want 5", len(exported))
+	}
+	for id, copies := range exported {
+		if len(copies) != 1 {
+			t.Errorf("%s exported %d times", id, len(copies))
+		}
+	}
+	if n := conn.ZCard(ctx, "archive:").Val(); n != 5 {
+		t.Fatalf("archive: has %d articles, want 5", n)
+	}
+	if conn.Exists(ctx, "article:1").Val() != 0 {
+		t.Fatal("archived article hash kept")
+	}
+}
+
+// KeepHashes 保留归档文章的散列
+func TestArchiveKeepHashes(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	clock.Advance(8 * 24 * time.Hour)
+	if n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: tempDir(t), KeepHashes: true}); n != 1 || err != nil {
+		t.Fatalf("ArchiveArticles() = %d, %v", n, err)
+	}
+	if a, _ := GetArticle(ctx, conn, "article:"+id); a["title"] != "A title" {
+		t.Fatalf("GetArticle(archived) = %v", a)
+	}
+}
+
+// 不在score:中的文章不会以0分归档
+func TestArchiveMissingScore(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+	dir := tempDir(t)
+
+	for i := 0; i < 2; i++ {
+		PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	}
+	conn.ZRem(ctx, "score:", "article:1")
+	clock.Advance(8 * 24 * time.Hour)
+	if n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: dir}); n != 0 || err == nil {
+		t.Fatalf("ArchiveArticles() = %d, %v, want an error", n, err)
+	}
+	if n := conn.ZCard(ctx, "archive:").Val(); n != 0 || len(readArchive(t, dir)) != 0 {
+		t.Fatalf("archived %d articles with a missing score", n)
+	}
+	if n := conn.ZCard(ctx, "time:").Val(); n != 2 {
+		t.Fatalf("%d articles left in time:, want 2", n)
+	}
+}
+
+// 归档锁在两批之间丢失时停止归档
+func TestArchiveLockLost(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	for i := 0; i < 3; i++ {
+		PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	}
+	clock.Advance(8 * 24 * time.Hour)
+	archiveHook = func() error {
+		return conn.Set(ctx, "lock:articles:archive", "other", time.Minute).Err()
+	}
+	defer func() { archiveHook = func() error { return nil } }()
+	n, err := ArchiveArticles(ctx, conn, ArchiveOptions{Dir: tempDir(t), Batch: 2})
+	if !errors.Is(err, errArchiveLockLost) || n != 2 {
+		t.Fatalf("ArchiveArticles() = %d, %v, want 2 and errArchiveLockLost", n, err)
+	}
+	if n := conn.ZCard(ctx, "time:").Val(); n != 1 {
+		t.Fatalf("%d articles left, want 1", n)
+	}
+}
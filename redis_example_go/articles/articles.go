@@ -0,0 +1,331 @@
+// Package articles 第一章的文章投票网站：发布文章、投票、按评分或时间获取文章以及文章分组
+package articles
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Article struct {
+	Title  string
+	Link   string
+	Poster string
+	Time   time.Time
+	Votes  int
+}
+
+//	准备好需要用到的常量
+const ONE_WEEK_IN_SECONDS = 7 * 86400
+const VOTE_SCORE = 432
+const ARTICLES_PER_PAGE = 25
+
+// 文章id分配器 为nil时每篇文章用INCR article: 生成id
+var IDs *core.SegmentAllocator
+
+// 发布时间和投票截止时间使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// VoteState 用户对一篇文章的投票状态
+type VoteState int
+
+const (
+	VoteDown VoteState = -1
+	VoteNone VoteState = 0
+	VoteUp   VoteState = 1
+)
+
+// 超过投票期限后再投票（包括取消投票）返回的错误
+var ErrVotingClosed = core.Conflict("articles.vote", "voting window closed")
+
+// 投赞成票的用户记录在voted:id集合中 投反对票的用户记录在oppose_voted:id集合中
+// 一个用户最多出现在其中一个集合里 状态变化时按前后状态的差值调整score:、文章散列的votes和score
+// 以及赞成票数upvotes和反对票数downvotes（其他排名算法根据这两个字段计算评分）
+// KEYS: time: score: article:id voted:id oppose_voted:id archive:
+// ARGV: 用户 新的状态 投票截止时间 VOTE_SCORE 投票集合的过期时间（秒）
+// 文章不存在时返回nil 否则返回 {是否已截止, 文章评分, 发布时间, 赞成票数, 反对票数}
+var voteScript = redis.NewScript(`
+local posted = redis.call("zscore", KEYS[1], KEYS[3])
+if not posted then
+	-- 已经归档的文章
+	local archived = redis.call("zscore", KEYS[6], KEYS[3])
+	if archived then
+		return {1, archived, 0, 0, 0}
+	end
+	return false
+end
+-- 不在score:中的文章（比如数据不完整）评分按0返回
+if tonumber(posted) < tonumber(ARGV[3]) then
+	return {1, redis.call("zscore", KEYS[2], KEYS[3]) or "0", posted, 0, 0}
+end
+-- 旧文章没有分开记录赞成票和反对票
+if redis.call("hexists", KEYS[3], "upvotes") == 0 then
+	redis.call("hset", KEYS[3], "upvotes", redis.call("scard", KEYS[4]), "downvotes", redis.call("scard", KEYS[5]))
+end
+local old = 0
+if redis.call("sismember", KEYS[4], ARGV[1]) == 1 then
+	old = 1
+elseif redis.call("sismember", KEYS[5], ARGV[1]) == 1 then
+	old = -1
+end
+local new = tonumber(ARGV[2])
+if old ~= new then
+	if old == 1 then
+		redis.call("srem", KEYS[4], ARGV[1])
+		redis.call("hincrby", KEYS[3], "upvotes", -1)
+	elseif old == -1 then
+		redis.call("srem", KEYS[5], ARGV[1])
+		redis.call("hincrby", KEYS[3], "downvotes", -1)
+	end
+	if new == 1 then
+		redis.call("sadd", KEYS[4], ARGV[1])
+		redis.call("hincrby", KEYS[3], "upvotes", 1)
+	elseif new == -1 then
+		redis.call("sadd", KEYS[5], ARGV[1])
+		redis.call("hincrby", KEYS[3], "downvotes", 1)
+	end
+	local delta = new - old
+	redis.call("zincrby", KEYS[2], delta * tonumber(ARGV[4]), KEYS[3])
+	redis.call("hincrby", KEYS[3], "votes", delta)
+	redis.call("hincrby", KEYS[3], "score", delta * tonumber(ARGV[4]))
+	for i = 4, 5 do
+		if redis.call("exists", KEYS[i]) == 1 then
+			redis.call("expireat", KEYS[i], math.floor(tonumber(posted)) + tonumber(ARGV[5]))
+		end
+	end
+end
+local counts = redis.call("hmget", KEYS[3], "upvotes", "downvotes")
+return {0, redis.call("zscore", KEYS[2], KEYS[3]) or "0", posted, counts[1], counts[2]}`)
+
+// Vote 把用户对文章的投票设置为state 返回投票之后的文章评分（score:中的评分） article为article:id形式的键
+// 赞成票可以直接改成反对票 VoteNone 表示取消投票 状态没有变化时不做任何修改
+// 投票之后同时更新 Rankers 中其他排名算法的评分
+// 文章不存在时返回 core.ErrNotFound 超过投票期限（包括已经归档）时返回 ErrVotingClosed
+// 超过 VoteLimiter 的限制时返回 *ratelimit.LimitError 被 RingDetector 判定为可疑时返回 ErrVoteHeld
+func Vote(ctx context.Context, conn redis.Cmdable, user string, article string, state VoteState) (float64, error) {
+	ctx = core.WithOp(ctx, "articles.vote")
+	if state < VoteDown || state > VoteUp {
+		return 0, core.Wrap("articles.vote", fmt.Errorf("invalid vote state %d", state))
+	}
+	if err := VoteLimiter.Check(ctx, conn, user); err != nil {
+		return 0, err
+	}
+	if RingDetector != nil {
+		held, err := RingDetector.hold(ctx, conn, user, article, state)
+		if err != nil {
+			return 0, core.Wrap("articles.vote", err)
+		}
+		if held {
+			return 0, ErrVoteHeld
+		}
+	}
+	return applyVote(ctx, conn, user, article, state)
+}
+
+// 执行投票 不做限流和团伙检测
+func applyVote(ctx context.Context, conn redis.Cmdable, user string, article string, state VoteState) (float64, error) {
+	//计算文章的投票截止时间。
+	now := Clock.Now()
+	cutoff := now.Unix() - ONE_WEEK_IN_SECONDS
+	// 从article:id标识符（identifier）里面取出文章的ID。
+	article_id := strings.TrimPrefix(article, "article:")
+
+	keys := []string{"time:", "score:", article, "voted:" + article_id, "oppose_voted:" + article_id, "archive:"}
+	reply, err := voteScript.Run(ctx, conn, keys, user, int(state), cutoff, VOTE_SCORE, ONE_WEEK_IN_SECONDS).Result()
+	if err != nil {
+		return 0, core.Wrap("articles.vote", err)
+	}
+	res, ok := reply.([]interface{})
+	if !ok || len(res) != 5 {
+		return 0, core.Wrap("articles.vote", fmt.Errorf("unexpected vote script reply %v", reply))
+	}
+	scoreStr, _ := res[1].(string)
+	score, err := strconv.ParseFloat(scoreStr, 64)
+	if err != nil {
+		return 0, core.Wrap("articles.vote", err)
+	}
+	if closed, _ := res[0].(int64); closed == 1 {
+		return score, ErrVotingClosed
+	}
+	postedStr, _ := res[2].(string)
+	posted, _ := strconv.ParseFloat(postedStr, 64)
+	v := ArticleVotes{Posted: time.Unix(int64(posted), 0), Up: parseCount(res[3]), Down: parseCount(res[4])}
+	if err := setRanks(ctx, conn, article, v, now); err != nil {
+		return score, core.Wrap("articles.vote", err)
+	}
+	return score, nil
+}
+
+// ArticleVote 为文章投赞成票 返回投票之后的文章评分（代码清单1-6）
+func ArticleVote(ctx context.Context, conn redis.Cmdable, user string, article string) (float64, error) {
+	return Vote(ctx, conn, user, article, VoteUp)
+}
+
+// ArticleOpposeVote 为文章投反对票 已经投过赞成票时改为反对票（代码清单1-11 额外练习）
+func ArticleOpposeVote(ctx context.Context, conn redis.Cmdable, user string, article string) (float64, error) {
+	return Vote(ctx, conn, user, article, VoteDown)
+}
+
+// GetVote 获取用户对文章的投票状态
+func GetVote(ctx context.Context, conn redis.Cmdable, user string, article string) (VoteState, error) {
+	ctx = core.WithOp(ctx, "articles.get_vote")
+	article_id := strings.TrimPrefix(article, "article:")
+	pipe := conn.Pipeline()
+	up := pipe.SIsMember(ctx, "voted:"+article_id, user)
+	down := pipe.SIsMember(ctx, "oppose_voted:"+article_id, user)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return VoteNone, core.Wrap("articles.get_vote", err)
+	}
+	switch {
+	case up.Val():
+		return VoteUp, nil
+	case down.Val():
+		return VoteDown, nil
+	}
+	return VoteNone, nil
+}
+
+// PostArticle 发布新的文章 返回文章id（代码清单1-7）
+func PostArticle(ctx context.Context, conn redis.Cmdable, user string, title string, link string) (string, error) {
+	ctx = core.WithOp(ctx, "articles.post")
+	if err := PostLimiter.Check(ctx, conn, user); err != nil {
+		return "", err
+	}
+	// 生成一个新的文章ID。
+	var id int64
+	var err error
+	if IDs != nil {
+		id, err = IDs.Next(ctx, "article:")
+	} else {
+		id, err = conn.Incr(ctx, "article:").Result()
+	}
+	if err != nil {
+		return "", core.Wrap("articles.post", err)
+	}
+	article_id := fmt.Sprintf("%v", id)
+
+	voted := "voted:" + article_id
+	posted := Clock.Now()
+	now := posted.Unix()
+	article := "article:" + article_id
+	score := float64(now + VOTE_SCORE)
+
+	pipe := conn.TxPipeline()
+	// 将发布文章的用户添加到文章的已投票用户名单里面，
+	// 然后将这个名单的过期时间设置为一周（第3章将对过期时间作更详细的介绍）。
+	pipe.SAdd(ctx, voted, user)
+	pipe.Expire(ctx, voted, ONE_WEEK_IN_SECONDS*time.Second)
+	// 将文章信息存储到一个散列里面。
+	pipe.HSet(ctx, article, "title", title, "link", link, "poster", user, "time", now,
+		"votes", 1, "upvotes", 1, "downvotes", 0, "score", score)
+	// 将文章添加到根据发布时间排序的有序集合和各排名算法的有序集合里面。
+	pipe.ZAdd(ctx, "time:", &redis.Z{Score: float64(now), Member: article})
+	addRanks(ctx, pipe, article, ArticleVotes{Posted: time.Unix(now, 0), Up: 1}, posted, true)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", core.Wrap("articles.post", err)
+	}
+	return article_id, nil
+}
+
+// GetArticles 按排名算法分页获取文章 page从1开始（代码清单1-8）
+// order为 Rankers 中排名算法的名字（默认score） 或者time表示按发布时间排序 未知的名字返回 core.ErrNotFound
+// 每篇文章为文章散列的内容 外加id字段
+func GetArticles(ctx context.Context, conn redis.Cmdable, page int, order string) ([]map[string]string, error) {
+	ctx = core.WithOp(ctx, "articles.get")
+	key, err := orderKey(order)
+	if err != nil {
+		return nil, err
+	}
+	return getArticles(ctx, conn, page, key)
+}
+
+// GetArticle 获取一篇文章 内容与GetArticles返回的相同 文章不存在时返回 core.ErrNotFound
+func GetArticle(ctx context.Context, conn redis.Cmdable, article string) (map[string]string, error) {
+	ctx = core.WithOp(ctx, "articles.get")
+	article_data, err := conn.HGetAll(ctx, article).Result()
+	if err != nil {
+		return nil, core.Wrap("articles.get", err)
+	}
+	if len(article_data) == 0 {
+		return nil, core.NotFound("articles.get", article)
+	}
+	article_data["id"] = article
+	return article_data, nil
+}
+
+// 从有序集合order中分页获取文章
+func getArticles(ctx context.Context, conn redis.Cmdable, page int, order string) ([]map[string]string, error) {
+	if page < 1 {
+		page = 1
+	}
+	// 设置获取文章的起始索引和结束索引。
+	start := int64((page - 1) * ARTICLES_PER_PAGE)
+	end := start + ARTICLES_PER_PAGE - 1
+
+	// 获取多个文章ID。
+	ids, err := conn.ZRevRange(ctx, order, start, end).Result()
+	if err != nil {
+		return nil, core.Wrap("articles.get", err)
+	}
+	return loadArticles(ctx, conn, ids)
+}
+
+// 获取多篇文章的详细信息
+func loadArticles(ctx context.Context, conn redis.Cmdable, ids []string) ([]map[string]string, error) {
+	// 使用流水线一次性获取所有文章的详细信息（第4章）。
+	pipe := conn.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, id)
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, core.Wrap("articles.get", err)
+		}
+	}
+	articles := make([]map[string]string, 0, len(ids))
+	for i, cmd := range cmds {
+		article_data := cmd.Val()
+		article_data["id"] = ids[i]
+		articles = append(articles, article_data)
+	}
+	return articles, nil
+}
+
+// AddRemoveGroups 将文章添加到分组 或者将文章从某些分组中删除（代码清单1-9）
+func AddRemoveGroups(ctx context.Context, conn redis.Cmdable, article_id int, to_add []string, to_remove []string) error {
+	ctx = core.WithOp(ctx, "articles.groups")
+	// 构建存储文章信息的键名。
+	article := "article:" + strconv.Itoa(article_id)
+	pipe := conn.TxPipeline()
+	for _, group := range to_add {
+		// 将文章添加到它所属的群组里面。
+		pipe.SAdd(ctx, "group:"+group, article)
+	}
+	for _, group := range to_remove {
+		// 从群组里面移除文章。
+		pipe.SRem(ctx, "group:"+group, article)
+	}
+	if len(to_add)+len(to_remove) == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("articles.groups", err)
+}
+
+// GetGroupArticles 获取分组内的文章 order与GetArticles相同 排序结果缓存GroupCacheTTL（代码清单1-10）
+// 按页码分页 需要稳定翻页时使用 QueryGroupArticles
+func GetGroupArticles(ctx context.Context, conn redis.Cmdable, group string, page int, order string) ([]map[string]string, error) {
+	ctx = core.WithOp(ctx, "articles.group_get")
+	// 为每个群组的每种排列顺序都创建一个键。
+	key, err := groupCache(ctx, conn, GroupQuery{Groups: []string{group}, Order: order})
+	if err != nil {
+		return nil, err
+	}
+	// 调用之前定义的get_articles()函数来进行分页并获取文章数据。
+	return getArticles(ctx, conn, page, key)
+}
@@ -0,0 +1,171 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh01.test_article_functionality
+func TestArticleFunctionality(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	article_id, err := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	if err != nil || article_id == "" {
+		t.Fatalf("PostArticle() = %q, %v", article_id, err)
+	}
+	r := conn.HGetAll(ctx, "article:"+article_id).Val()
+	if r["title"] != "A title" || r["poster"] != "username" {
+		t.Fatalf("article hash = %v", r)
+	}
+
+	if _, err := ArticleVote(ctx, conn, "other_user", "article:"+article_id); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := conn.HGet(ctx, "article:"+article_id, "votes").Int(); v <= 1 {
+		t.Fatalf("votes = %d, want > 1", v)
+	}
+
+	list, err := GetArticles(ctx, conn, 1, "")
+	if err != nil || len(list) < 1 {
+		t.Fatalf("GetArticles() = %v, %v", list, err)
+	}
+	if list[0]["id"] != "article:"+article_id {
+		t.Fatalf("first article id = %q", list[0]["id"])
+	}
+
+	aid, _ := strconv.Atoi(article_id)
+	if err := AddRemoveGroups(ctx, conn, aid, []string{"new-group"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	list, err = GetGroupArticles(ctx, conn, "new-group", 1, "")
+	if err != nil || len(list) < 1 {
+		t.Fatalf("GetGroupArticles() = %v, %v", list, err)
+	}
+}
+
+func TestArticleVoteTwice(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	article_id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	for i := 0; i < 2; i++ {
+		if _, err := ArticleVote(ctx, conn, "other_user", "article:"+article_id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := conn.HGet(ctx, "article:"+article_id, "votes").Val(); v != "2" {
+		t.Fatalf("votes = %s, want 2", v)
+	}
+}
+
+func TestArticleVoteNotFound(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if _, err := ArticleVote(ctx, conn, "other_user", "article:404"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("ArticleVote() = %v, want ErrNotFound", err)
+	}
+}
+
+func TestArticleVotingWindow(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	article_id, err := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	article := "article:" + article_id
+	clock.Advance(6 * 24 * time.Hour)
+	if _, err := ArticleVote(ctx, conn, "user1", article); err != nil {
+		t.Fatal(err)
+	}
+	// 超过一周之后投票被拒绝
+	clock.Advance(2 * 24 * time.Hour)
+	if _, err := ArticleVote(ctx, conn, "user2", article); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("ArticleVote() = %v, want ErrVotingClosed", err)
+	}
+	if _, err := Vote(ctx, conn, "user1", article, VoteNone); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("Vote(VoteNone) = %v, want ErrVotingClosed", err)
+	}
+	if v, _ := conn.HGet(ctx, article, "votes").Int(); v != 2 {
+		t.Fatalf("votes = %d, want 2", v)
+	}
+	if conn.SIsMember(ctx, "voted:"+article_id, "user2").Val() {
+		t.Fatal("late vote was recorded")
+	}
+}
+
+// 不在score:中的文章 投票期限结束之后投票返回ErrVotingClosed和0分 而不是panic
+func TestVoteClosedArticleWithoutScore(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	article_id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	article := "article:" + article_id
+	conn.ZRem(ctx, "score:", article)
+	clock.Advance(8 * 24 * time.Hour)
+	if score, err := ArticleVote(ctx, conn, "user1", article); !errors.Is(err, ErrVotingClosed) || score != 0 {
+		t.Fatalf("ArticleVote() = %v, %v, want 0, ErrVotingClosed", score, err)
+	}
+}
+
+func TestVoteChanges(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	article_id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	article := "article:" + article_id
+	posted := conn.ZScore(ctx, "score:", article).Val()
+
+	steps := []struct {
+		state VoteState
+		votes int
+		delta float64
+	}{
+		{VoteUp, 2, VOTE_SCORE},
+		{VoteUp, 2, VOTE_SCORE},
+		// 赞成票直接改为反对票
+		{VoteDown, 0, -VOTE_SCORE},
+		{VoteNone, 1, 0},
+		{VoteDown, 0, -VOTE_SCORE},
+		{VoteUp, 2, VOTE_SCORE},
+	}
+	for i, step := range steps {
+		score, err := Vote(ctx, conn, "other_user", article, step.state)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if score != posted+step.delta {
+			t.Fatalf("step %d: score = %v, want %v", i, score, posted+step.delta)
+		}
+		if zscore := conn.ZScore(ctx, "score:", article).Val(); zscore != score {
+			t.Fatalf("step %d: score: = %v, want %v", i, zscore, score)
+		}
+		if v, _ := conn.HGet(ctx, article, "votes").Int(); v != step.votes {
+			t.Fatalf("step %d: votes = %d, want %d", i, v, step.votes)
+		}
+		if state, _ := GetVote(ctx, conn, "other_user", article); state != step.state {
+			t.Fatalf("step %d: GetVote() = %d, want %d", i, state, step.state)
+		}
+		// 反对票集合和赞成票集合一样在投票期限结束后过期
+		if step.state == VoteDown {
+			if ttl := conn.TTL(ctx, "oppose_voted:"+article_id).Val(); ttl <= 0 {
+				t.Fatalf("step %d: oppose_voted: ttl = %v", i, ttl)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package articles
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// CommentSort 评论的排序方式
+type CommentSort string
+
+const (
+	// 赞成票比例的Wilson置信区间下界
+	CommentsBest CommentSort = "best"
+	// 最新的在前
+	CommentsNew CommentSort = "new"
+	// 净票数最多的在前
+	CommentsTop CommentSort = "top"
+)
+
+// 删除别人的评论时返回的错误
+var ErrNotAuthor = core.Conflict("articles.delete_comment", "only the author can delete a comment")
+
+// Comment 一条评论 被删除的评论保留在评论树中 Deleted为true 作者和内容为空
+type Comment struct {
+	ID         string
+	Article    string
+	Parent     string
+	Author     string
+	Body       string
+	Time       int64
+	Votes      int64
+	Upvotes    int64
+	Downvotes  int64
+	Deleted    bool
+	ReplyCount int64
+	// 已经加载的回复 以及获取其余回复的游标
+	Replies     []*Comment
+	MoreReplies string
+}
+
+// CommentPage 一页评论 Next为下一页的游标
+type CommentPage struct {
+	Comments []*Comment
+	Next     string
+}
+
+// 每条评论的回复按三种排序方式各保存在一个有序集合中 parent为0时是文章下的顶层评论
+// 例如 comments:12:0:best comments:12:34:new
+func commentsKey(article_id string, parent string, sort CommentSort) string {
+	if parent == "" {
+		parent = "0"
+	}
+	return "comments:" + article_id + ":" + parent + ":" + string(sort)
+}
+
+// 按时间排序时精确到毫秒
+func commentTime(t time.Time) float64 {
+	return float64(t.UnixNano()/1e6) / 1e3
+}
+
+// PostComment 发表评论 parent为空时评论文章 否则回复parent这条评论 返回评论id
+// 与发布文章一样 作者自动为自己的评论投一票赞成票
+// 文章或者被回复的评论不存在时返回 core.ErrNotFound
+func PostComment(ctx context.Context, conn redis.Cmdable, user string, article string, parent string, body string) (string, error) {
+	ctx = core.WithOp(ctx, "articles.post_comment")
+	if err := PostLimiter.Check(ctx, conn, user); err != nil {
+		return "", err
+	}
+	article_id := strings.TrimPrefix(article, "article:")
+	pipe := conn.Pipeline()
+	articleCmd := pipe.Exists(ctx, article)
+	var parentCmd *redis.StringCmd
+	if parent != "" {
+		parentCmd = pipe.HGet(ctx, "comment:"+parent, "article")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", core.Wrap("articles.post_comment", err)
+	}
+	if articleCmd.Val() == 0 {
+		return "", core.NotFound("articles.post_comment", article)
+	}
+	if parentCmd != nil && parentCmd.Val() != article {
+		return "", core.NotFound("articles.post_comment", "comment:"+parent)
+	}
+
+	var id int64
+	var err error
+	if IDs != nil {
+		id, err = IDs.Next(ctx, "comment:")
+	} else {
+		id, err = conn.Incr(ctx, "comment:").Result()
+	}
+	if err != nil {
+		return "", core.Wrap("articles.post_comment", err)
+	}
+	comment_id := strconv.FormatInt(id, 10)
+	comment := "comment:" + comment_id
+	now := Clock.Now()
+	voted := "comment_voted:" + comment_id
+
+	tx := conn.TxPipeline()
+	tx.HSet(ctx, comment, "article", article, "parent", parent, "author", user, "body", body,
+		"time", now.Unix(), "votes", 1, "upvotes", 1, "downvotes", 0, "deleted", 0, "replies", 0)
+	tx.SAdd(ctx, voted, user)
+	tx.Expire(ctx, voted, ONE_WEEK_IN_SECONDS*time.Second)
+	tx.ZAdd(ctx, commentsKey(article_id, parent, CommentsNew), &redis.Z{Score: commentTime(now), Member: comment_id})
+	tx.ZAdd(ctx, commentsKey(article_id, parent, CommentsTop), &redis.Z{Score: 1, Member: comment_id})
+	tx.ZAdd(ctx, commentsKey(article_id, parent, CommentsBest), &redis.Z{Score: Wilson{}.Score(ArticleVotes{Up: 1}, now), Member: comment_id})
+	if parent != "" {
+		tx.HIncrBy(ctx, "comment:"+parent, "replies", 1)
+	}
+	if _, err := tx.Exec(ctx); err != nil {
+		return "", core.Wrap("articles.post_comment", err)
+	}
+	return comment_id, nil
+}
+
+// 与文章的投票脚本相同的状态转换 评论的净票数保存在按top排序的有序集合中
+// 按best排序的评分（Wilson置信区间下界）也在脚本中计算 并发的投票不会让旧的评分覆盖新的评分
+// KEYS: comment:id comment_voted:id comment_oppose_voted:id 按top排序的有序集合 按best排序的有序集合
+// ARGV: 用户 新的状态 投票截止时间 投票集合的过期时间（秒） Wilson算法的z
+// 评论不存在或已删除时返回nil 否则返回 {是否已截止, 净票数, 赞成票数, 反对票数}
+var commentVoteScript = redis.NewScript(`
+local c = redis.call("hmget", KEYS[1], "time", "deleted")
+if not c[1] or c[2] == "1" then
+	return false
+end
+if tonumber(c[1]) < tonumber(ARGV[3]) then
+	return {1, 0, 0, 0}
+end
+local old = 0
+if redis.call("sismember", KEYS[2], ARGV[1]) == 1 then
+	old = 1
+elseif redis.call("sismember", KEYS[3], ARGV[1]) == 1 then
+	old = -1
+end
+local new = tonumber(ARGV[2])
+if old ~= new then
+	if old == 1 then
+		redis.call("srem", KEYS[2], ARGV[1])
+		redis.call("hincrby", KEYS[1], "upvotes", -1)
+	elseif old == -1 then
+		redis.call("srem", KEYS[3], ARGV[1])
+		redis.call("hincrby", KEYS[1], "downvotes", -1)
+	end
+	if new == 1 then
+		redis.call("sadd", KEYS[2], ARGV[1])
+		redis.call("hincrby", KEYS[1], "upvotes", 1)
+	elseif new == -1 then
+		redis.call("sadd", KEYS[3], ARGV[1])
+		redis.call("hincrby", KEYS[1], "downvotes", 1)
+	end
+	redis.call("hincrby", KEYS[1], "votes", new - old)
+	redis.call("zincrby", KEYS[4], new - old, string.sub(KEYS[1], 9))
+	local counts = redis.call("hmget", KEYS[1], "upvotes", "downvotes")
+	local up, n = tonumber(counts[1]), tonumber(counts[1]) + tonumber(counts[2])
+	local best = 0
+	if n > 0 then
+		local z, phat = tonumber(ARGV[5]), up / n
+		best = (phat + z * z / (2 * n) - z * math.sqrt((phat * (1 - phat) + z * z / (4 * n)) / n)) / (1 + z * z / n)
+	end
+	redis.call("zadd", KEYS[5], string.format("%.17g", best), string.sub(KEYS[1], 9))
+	for i = 2, 3 do
+		if redis.call("exists", KEYS[i]) == 1 then
+			redis.call("expireat", KEYS[i], tonumber(c[1]) + tonumber(ARGV[4]))
+		end
+	end
+end
+local v = redis.call("hmget", KEYS[1], "votes", "upvotes", "downvotes")
+return {0, tonumber(v[1]), tonumber(v[2]), tonumber(v[3])}`)
+
+// VoteComment 把用户对评论的投票设置为state 返回评论的净票数
+// 与文章一样 评论发表一周之后不能再投票（返回 ErrVotingClosed） 评论不存在或已删除时返回 core.ErrNotFound
+func VoteComment(ctx context.Context, conn redis.Cmdable, user string, comment_id string, state VoteState) (int64, error) {
+	ctx = core.WithOp(ctx, "articles.vote_comment")
+	if state < VoteDown || state > VoteUp {
+		return 0, core.Wrap("articles.vote_comment", errors.Errorf("invalid vote state %d", state))
+	}
+	if err := VoteLimiter.Check(ctx, conn, user); err != nil {
+		return 0, err
+	}
+	comment := "comment:" + comment_id
+	fields, err := conn.HMGet(ctx, comment, "article", "parent").Result()
+	if err != nil {
+		return 0, core.Wrap("articles.vote_comment", err)
+	}
+	article, _ := fields[0].(string)
+	parent, _ := fields[1].(string)
+	if article == "" {
+		return 0, core.NotFound("articles.vote_comment", comment)
+	}
+	article_id := strings.TrimPrefix(article, "article:")
+
+	now := Clock.Now()
+	keys := []string{comment, "comment_voted:" + comment_id, "comment_oppose_voted:" + comment_id,
+		commentsKey(article_id, parent, CommentsTop), commentsKey(article_id, parent, CommentsBest)}
+	reply, err := commentVoteScript.Run(ctx, conn, keys, user, int(state), now.Unix()-ONE_WEEK_IN_SECONDS, ONE_WEEK_IN_SECONDS, Wilson{}.z()).Result()
+	if err != nil {
+		return 0, core.Wrap("articles.vote_comment", err)
+	}
+	res := reply.([]interface{})
+	if res[0].(int64) == 1 {
+		return 0, ErrVotingClosed
+	}
+	return res[1].(int64), nil
+}
+
+// DeleteComment 删除评论 评论只是被标记为已删除并清空作者和内容 它的回复仍然保留在评论树中
+// 只有作者可以删除 否则返回 ErrNotAuthor 评论不存在时返回 core.ErrNotFound
+func DeleteComment(ctx context.Context, conn redis.Cmdable, user string, comment_id string) error {
+	ctx = core.WithOp(ctx, "articles.delete_comment")
+	comment := "comment:" + comment_id
+	author, err := conn.HGet(ctx, comment, "author").Result()
+	if err != nil {
+		return core.Wrap("articles.delete_comment", err)
+	}
+	if author != user {
+		return ErrNotAuthor
+	}
+	tx := conn.TxPipeline()
+	tx.HSet(ctx, comment, "deleted", 1, "author", "", "body", "")
+	tx.Del(ctx, "comment_voted:"+comment_id, "comment_oppose_voted:"+comment_id)
+	_, err = tx.Exec(ctx)
+	return core.Wrap("articles.delete_comment", err)
+}
+
+func parseComment(id string, m map[string]string) *Comment {
+	c := &Comment{
+		ID:      id,
+		Article: m["article"],
+		Parent:  m["parent"],
+		Author:  m["author"],
+		Body:    m["body"],
+		Deleted: m["deleted"] == "1",
+	}
+	c.Time, _ = strconv.ParseInt(m["time"], 10, 64)
+	c.Votes, _ = strconv.ParseInt(m["votes"], 10, 64)
+	c.Upvotes, _ = strconv.ParseInt(m["upvotes"], 10, 64)
+	c.Downvotes, _ = strconv.ParseInt(m["downvotes"], 10, 64)
+	c.ReplyCount, _ = strconv.ParseInt(m["replies"], 10, 64)
+	return c
+}
+
+// GetComments 按sort分页获取parent的回复（parent为空时获取文章的顶层评论） cursor为空时从第一条开始
+// depth>0时同时加载depth层回复 每条评论最多加载count条回复 其余回复用MoreReplies游标继续获取
+// sort为空时为best 未知的排序方式返回 core.ErrNotFound 游标无法解析时返回 ErrInvalidCursor
+func GetComments(ctx context.Context, conn redis.Cmdable, article string, parent string, sort CommentSort,
+	cursor string, count int, depth int) (*CommentPage, error) {
+	ctx = core.WithOp(ctx, "articles.get_comments")
+	if sort == "" {
+		sort = CommentsBest
+	}
+	if sort != CommentsBest && sort != CommentsNew && sort != CommentsTop {
+		return nil, core.NotFound("articles.get_comments", "comment sort "+string(sort))
+	}
+	if count <= 0 {
+		count = ARTICLES_PER_PAGE
+	}
+	article_id := strings.TrimPrefix(article, "article:")
+
+	var items []redis.Z
+	var err error
+	key := commentsKey(article_id, parent, sort)
+	if cursor == "" {
+		items, err = conn.ZRevRangeWithScores(ctx, key, 0, int64(count)).Result()
+	} else {
+		items, err = rangeAfter(ctx, conn, key, cursor, count+1)
+	}
+	if err != nil {
+		return nil, core.Wrap("articles.get_comments", err)
+	}
+	page := &CommentPage{}
+	page.Comments, page.Next = trimPage(items, count)
+	if err := loadComments(ctx, conn, page.Comments); err != nil {
+		return nil, err
+	}
+
+	// 逐层加载回复 每层只需要两次通信往返
+	level := page.Comments
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var parents []*Comment
+		for _, c := range level {
+			if c.ReplyCount > 0 {
+				parents = append(parents, c)
+			}
+		}
+		pipe := conn.Pipeline()
+		cmds := make([]*redis.ZSliceCmd, len(parents))
+		for i, c := range parents {
+			cmds[i] = pipe.ZRevRangeWithScores(ctx, commentsKey(article_id, c.ID, sort), 0, int64(count))
+		}
+		if len(parents) > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return nil, core.Wrap("articles.get_comments", err)
+			}
+		}
+		var next []*Comment
+		for i, c := range parents {
+			c.Replies, c.MoreReplies = trimPage(cmds[i].Val(), count)
+			next = append(next, c.Replies...)
+		}
+		if err := loadComments(ctx, conn, next); err != nil {
+			return nil, err
+		}
+		level = next
+	}
+	return page, nil
+}
+
+// 多取的一条用来判断是否还有下一页
+func trimPage(items []redis.Z, count int) ([]*Comment, string) {
+	next := ""
+	if len(items) > count {
+		items = items[:count]
+		last := items[count-1]
+		next = encodeCursor(last.Score, last.Member.(string))
+	}
+	comments := make([]*Comment, len(items))
+	for i, z := range items {
+		comments[i] = &Comment{ID: z.Member.(string)}
+	}
+	return comments, next
+}
+
+// 用流水线一次性加载评论的内容
+func loadComments(ctx context.Context, conn redis.Cmdable, comments []*Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	pipe := conn.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(comments))
+	for i, c := range comments {
+		cmds[i] = pipe.HGetAll(ctx, "comment:"+c.ID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return core.Wrap("articles.get_comments", err)
+	}
+	for i, c := range comments {
+		*c = *parseComment(c.ID, cmds[i].Val())
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func commentIDs(comments []*Comment) []string {
+	ids := make([]string, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func TestCommentTree(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	article_id, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	article := "article:" + article_id
+	post := func(user string, parent string) string {
+		t.Helper()
+		clock.Advance(time.Second)
+		id, err := PostComment(ctx, conn, user, article, parent, "text by "+user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// 1 ─ 2 ─ 4
+	//   └ 3
+	// 5
+	c1 := post("alice", "")
+	c2 := post("bob", c1)
+	c3 := post("carol", c1)
+	c4 := post("alice", c2)
+	c5 := post("dave", "")
+
+	if _, err := PostComment(ctx, conn, "bob", "article:404", "", "x"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("PostComment(missing article) = %v", err)
+	}
+	other, _ := PostArticle(ctx, conn, "username", "B title", "http://www.google.com")
+	if _, err := PostComment(ctx, conn, "bob", "article:"+other, c1, "x"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("PostComment(parent on another article) = %v", err)
+	}
+
+	// 投票：c5 +2 c1 -1（作者自己的一票之外）
+	for _, user := range []string{"x", "y"} {
+		if _, err := VoteComment(ctx, conn, user, c5, VoteUp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, user := range []string{"x", "y"} {
+		VoteComment(ctx, conn, user, c1, VoteDown)
+	}
+	if votes, err := VoteComment(ctx, conn, "y", c1, VoteNone); votes != 0 || err != nil {
+		t.Fatalf("VoteComment(none) = %d, %v, want 0", votes, err)
+	}
+	VoteComment(ctx, conn, "y", c3, VoteUp)
+	// best的评分由投票脚本计算 与 Wilson 排名相同
+	for id, v := range map[string]ArticleVotes{c1: {Up: 1, Down: 1}, c5: {Up: 3}} {
+		got := conn.ZScore(ctx, commentsKey(strings.TrimPrefix(article, "article:"), "", CommentsBest), id).Val()
+		if want := (Wilson{}).Score(v, time.Time{}); math.Abs(got-want) > 1e-12 {
+			t.Errorf("best score of %s = %v, want %v", id, got, want)
+		}
+	}
+
+	for sort, want := range map[CommentSort][]string{
+		CommentsNew:  {c5, c1},
+		CommentsTop:  {c5, c1},
+		CommentsBest: {c5, c1},
+	} {
+		page, err := GetComments(ctx, conn, article, "", sort, "", 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := commentIDs(page.Comments); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("GetComments(%s) = %v, want %v", sort, got, want)
+		}
+	}
+
+	// 带回复的树 每层最多1条回复
+	page, err := GetComments(ctx, conn, article, "", CommentsTop, "", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].ID != c5 || page.Next == "" {
+		t.Fatalf("first page = %v next %q", commentIDs(page.Comments), page.Next)
+	}
+	page, err = GetComments(ctx, conn, article, "", CommentsTop, page.Next, 1, 2)
+	if err != nil || len(page.Comments) != 1 || page.Next != "" {
+		t.Fatalf("second page = %v, %v", page, err)
+	}
+	root := page.Comments[0]
+	if root.ID != c1 || root.Votes != 0 || root.Author != "alice" || root.ReplyCount != 2 {
+		t.Fatalf("root = %+v", root)
+	}
+	// c3有两票 排在c2前面 c2的回复c4在第二层
+	if got := commentIDs(root.Replies); len(got) != 1 || got[0] != c3 || root.MoreReplies == "" {
+		t.Fatalf("replies = %v more %q", got, root.MoreReplies)
+	}
+	more, err := GetComments(ctx, conn, article, c1, CommentsTop, root.MoreReplies, 1, 1)
+	if err != nil || len(more.Comments) != 1 || more.Comments[0].ID != c2 {
+		t.Fatalf("more replies = %v, %v", more, err)
+	}
+	if got := commentIDs(more.Comments[0].Replies); len(got) != 1 || got[0] != c4 {
+		t.Fatalf("replies of %s = %v", c2, got)
+	}
+
+	// 删除之后保留回复
+	if err := DeleteComment(ctx, conn, "bob", c1); !errors.Is(err, ErrNotAuthor) {
+		t.Fatalf("DeleteComment(not author) = %v", err)
+	}
+	if err := DeleteComment(ctx, conn, "alice", c1); err != nil {
+		t.Fatal(err)
+	}
+	page, _ = GetComments(ctx, conn, article, "", CommentsNew, "", 10, 1)
+	deleted := page.Comments[1]
+	if deleted.ID != c1 || !deleted.Deleted || deleted.Author != "" || deleted.Body != "" || len(deleted.Replies) != 2 {
+		t.Fatalf("deleted comment = %+v", deleted)
+	}
+	if _, err := VoteComment(ctx, conn, "x", c1, VoteUp); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("VoteComment(deleted) = %v", err)
+	}
+	if _, err := PostComment(ctx, conn, "x", article, c1, "still replying"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 评论发表一周之后不能再投票
+	clock.Advance(8 * 24 * time.Hour)
+	if _, err := VoteComment(ctx, conn, "z", c5, VoteUp); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("VoteComment(old) = %v, want ErrVotingClosed", err)
+	}
+	if _, err := GetComments(ctx, conn, article, "", "controversial", "", 0, 0); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("GetComments(unknown sort) = %v", err)
+	}
+}
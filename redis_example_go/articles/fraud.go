@@ -0,0 +1,203 @@
+package articles
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/ratelimit"
+)
+
+// 投票（文章和评论）和发布（文章和评论）的限流器 为nil时不限流
+// 超过限制时返回的错误满足 errors.Is(err, ratelimit.ErrRateLimited)
+var VoteLimiter, PostLimiter *ratelimit.Limiter
+
+// 投票团伙检测 为nil时不检测
+var RingDetector *VoteRingDetector
+
+// 投票被判定为可疑 暂时没有计入评分 等待 ReviewVote 处理
+var ErrVoteHeld = core.Conflict("articles.vote", "vote held for review")
+
+// VoteRingDetector 投票团伙检测：Window时间内有Threshold个新账号（第一次出现不到NewAccountAge）给同一篇文章投票时
+// 把文章标记为可疑（记录在review:有序集合中） 之后新账号对这篇文章的投票都放进 review:votes:<文章id> 散列等待审核
+// 标记时窗口内已经计入的新账号投票也会被撤回并放进审核散列
+// 账号创建（登录系统创建会话）时用 RecordUser 把时间记录在users:first_seen有序集合中 没有记录的用户不算新账号
+type VoteRingDetector struct {
+	NewAccountAge time.Duration
+	Window        time.Duration
+	Threshold     int64
+}
+
+// KEYS: users:first_seen votes:new:id review:votes:id review: time:
+// ARGV: 用户 当前时间 新账号期限 窗口 阈值 投票状态 文章 投票截止时间（时间都以秒为单位）
+// 投票不需要审核时返回空列表 需要审核时返回 {用户} 文章刚刚被标记时返回窗口内投票的所有新账号
+var ringScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local posted = redis.call("zscore", KEYS[5], ARGV[7])
+if not posted or tonumber(posted) < tonumber(ARGV[8]) then
+	return {}
+end
+local first = redis.call("zscore", KEYS[1], ARGV[1])
+if not first or now - tonumber(first) >= tonumber(ARGV[3]) then
+	return {}
+end
+redis.call("zadd", KEYS[2], now, ARGV[1])
+redis.call("zremrangebyscore", KEYS[2], "-inf", now - tonumber(ARGV[4]))
+redis.call("expire", KEYS[2], ARGV[4])
+if redis.call("zcard", KEYS[2]) >= tonumber(ARGV[5]) or redis.call("zscore", KEYS[4], ARGV[7]) then
+	redis.call("hset", KEYS[3], ARGV[1], ARGV[6])
+	if redis.call("zadd", KEYS[4], "nx", now, ARGV[7]) == 1 then
+		return redis.call("zrange", KEYS[2], 0, -1)
+	end
+	return {ARGV[1]}
+end
+return {}`)
+
+// RecordUser 记录用户第一次出现的时间 已经记录过时不做修改 投票团伙检测据此判断新账号
+// 应该在创建账号或者为用户创建会话时调用 第一次投票时才记录会让所有老用户在之后的NewAccountAge内都像新账号
+func RecordUser(ctx context.Context, conn redis.Cmdable, user string) error {
+	ctx = core.WithOp(ctx, "articles.record_user")
+	err := conn.ZAddNX(ctx, "users:first_seen", &redis.Z{Score: float64(Clock.Now().Unix()), Member: user}).Err()
+	return core.Wrap("articles.record_user", err)
+}
+
+// 检查投票是否需要审核 需要时已经放进了审核散列 出错时返回false和错误
+func (d *VoteRingDetector) hold(ctx context.Context, conn redis.Cmdable, user string, article string, state VoteState) (bool, error) {
+	article_id := strings.TrimPrefix(article, "article:")
+	if state == VoteNone {
+		// 取消投票时同时撤回等待审核的投票
+		return false, conn.HDel(ctx, "review:votes:"+article_id, user).Err()
+	}
+	newAge, window, threshold := d.NewAccountAge, d.Window, d.Threshold
+	if newAge <= 0 {
+		newAge = 24 * time.Hour
+	}
+	if window <= 0 {
+		window = 10 * time.Minute
+	}
+	if threshold <= 0 {
+		threshold = 5
+	}
+	now := Clock.Now().Unix()
+	keys := []string{"users:first_seen", "votes:new:" + article_id, "review:votes:" + article_id, "review:", "time:"}
+	reply, err := ringScript.Run(ctx, conn, keys, user, now, int64(newAge.Seconds()), int64(window.Seconds()),
+		threshold, int(state), article, now-ONE_WEEK_IN_SECONDS).Result()
+	if err != nil {
+		return false, err
+	}
+	ring := reply.([]interface{})
+	for _, member := range ring {
+		if member.(string) == user {
+			continue
+		}
+		if err := holdCounted(ctx, conn, member.(string), article); err != nil {
+			return false, err
+		}
+	}
+	return len(ring) > 0, nil
+}
+
+// 把已经计入评分的投票撤回 放进审核散列
+func holdCounted(ctx context.Context, conn redis.Cmdable, user string, article string) error {
+	state, err := GetVote(ctx, conn, user, article)
+	if err != nil || state == VoteNone {
+		return err
+	}
+	article_id := strings.TrimPrefix(article, "article:")
+	// 先放进审核散列再撤回 中途失败时审核通过也只是重复投一次相同的票
+	ok, err := conn.HSetNX(ctx, "review:votes:"+article_id, user, int(state)).Result()
+	if err != nil || !ok {
+		return err
+	}
+	_, err = applyVote(ctx, conn, user, article, VoteNone)
+	return err
+}
+
+// HeldVotes 获取文章等待审核的投票 用户 -> 投票状态
+func HeldVotes(ctx context.Context, conn redis.Cmdable, article string) (map[string]VoteState, error) {
+	ctx = core.WithOp(ctx, "articles.held_votes")
+	article_id := strings.TrimPrefix(article, "article:")
+	held, err := conn.HGetAll(ctx, "review:votes:"+article_id).Result()
+	if err != nil {
+		return nil, core.Wrap("articles.held_votes", err)
+	}
+	votes := make(map[string]VoteState, len(held))
+	for user, v := range held {
+		state, _ := strconv.Atoi(v)
+		votes[user] = VoteState(state)
+	}
+	return votes, nil
+}
+
+// ReviewVote 审核一张等待审核的投票 approve为true时计入评分 否则丢弃
+// 文章没有剩余等待审核的投票之后从review:中移除 没有这张投票时返回 core.ErrNotFound
+// 投票期限已经结束时投票同样被丢弃 返回 ErrVotingClosed
+func ReviewVote(ctx context.Context, conn redis.Cmdable, article string, user string, approve bool) error {
+	ctx = core.WithOp(ctx, "articles.review_vote")
+	article_id := strings.TrimPrefix(article, "article:")
+	key := "review:votes:" + article_id
+	v, err := conn.HGet(ctx, key, user).Result()
+	if err != nil {
+		return core.Wrap("articles.review_vote", err)
+	}
+	var voteErr error
+	if approve {
+		state, _ := strconv.Atoi(v)
+		_, voteErr = applyVote(ctx, conn, user, article, VoteState(state))
+		if voteErr != nil && !errors.Is(voteErr, ErrVotingClosed) {
+			return voteErr
+		}
+	}
+	if err := conn.HDel(ctx, key, user).Err(); err != nil {
+		return core.Wrap("articles.review_vote", err)
+	}
+	left, err := conn.Exists(ctx, key).Result()
+	if err != nil {
+		return core.Wrap("articles.review_vote", err)
+	}
+	if left == 0 {
+		if err := conn.ZRem(ctx, "review:", article).Err(); err != nil {
+			return core.Wrap("articles.review_vote", err)
+		}
+	}
+	return voteErr
+}
+
+// ExpireHeldVotes 丢弃投票期限已经结束（或者已经删除）的文章的所有等待审核的投票 返回处理的文章数量
+// 这些投票已经不能再计入评分
+func ExpireHeldVotes(ctx context.Context, conn redis.Cmdable) (int, error) {
+	ctx = core.WithOp(ctx, "articles.expire_held_votes")
+	flagged, err := conn.ZRange(ctx, "review:", 0, -1).Result()
+	if err != nil {
+		return 0, core.Wrap("articles.expire_held_votes", err)
+	}
+	pipe := conn.Pipeline()
+	posted := make([]*redis.FloatCmd, len(flagged))
+	for i, article := range flagged {
+		posted[i] = pipe.ZScore(ctx, "time:", article)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, core.Wrap("articles.expire_held_votes", err)
+	}
+	cutoff := float64(Clock.Now().Unix() - ONE_WEEK_IN_SECONDS)
+	expired := 0
+	for i, article := range flagged {
+		if t, err := posted[i].Result(); err == nil && t >= cutoff {
+			continue
+		}
+		pipe.Del(ctx, "review:votes:"+strings.TrimPrefix(article, "article:"))
+		pipe.ZRem(ctx, "review:", article)
+		expired++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, core.Wrap("articles.expire_held_votes", err)
+	}
+	return expired, nil
+}
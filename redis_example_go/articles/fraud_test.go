@@ -0,0 +1,182 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+	"redis-learn/redis_example_go/ratelimit"
+)
+
+func TestVoteLimits(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	VoteLimiter = &ratelimit.Limiter{Name: "vote", User: ratelimit.Limit{Rate: 2, Period: time.Minute}}
+	PostLimiter = &ratelimit.Limiter{Name: "post", User: ratelimit.Limit{Rate: 1, Period: time.Minute}}
+	defer func() { VoteLimiter, PostLimiter = nil, nil }()
+
+	a, err := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PostArticle(ctx, conn, "username", "B title", "http://www.google.com"); !errors.Is(err, ratelimit.ErrRateLimited) {
+		t.Fatalf("second PostArticle() = %v, want ErrRateLimited", err)
+	}
+	ArticleVote(ctx, conn, "other_user", "article:"+a)
+	ArticleOpposeVote(ctx, conn, "other_user", "article:"+a)
+	if _, err := ArticleVote(ctx, conn, "other_user", "article:"+a); !errors.Is(err, ratelimit.ErrRateLimited) {
+		t.Fatalf("third vote = %v, want ErrRateLimited", err)
+	}
+	// 限流的票没有计入
+	if state, _ := GetVote(ctx, conn, "other_user", "article:"+a); state != VoteDown {
+		t.Fatalf("GetVote() = %d, want VoteDown", state)
+	}
+}
+
+func TestVoteRing(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	RingDetector = &VoteRingDetector{NewAccountAge: 24 * time.Hour, Window: 10 * time.Minute, Threshold: 3}
+	defer func() { Clock, RingDetector = core.RealClock{}, nil }()
+
+	// 老账号不受影响
+	RecordUser(ctx, conn, "old_user")
+	clock.Advance(48 * time.Hour)
+	a, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	article := "article:" + a
+	if _, err := ArticleVote(ctx, conn, "old_user", article); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第三个新账号投票时文章被标记 之后新账号的票都等待审核
+	before, _ := conn.ZScore(ctx, "score:", article).Result()
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Minute)
+		RecordUser(ctx, conn, fmt.Sprintf("new%d", i))
+		_, err := ArticleVote(ctx, conn, fmt.Sprintf("new%d", i), article)
+		if i < 2 && err != nil || i >= 2 && !errors.Is(err, ErrVoteHeld) {
+			t.Fatalf("vote #%d = %v", i, err)
+		}
+	}
+	// 标记之前已经计入的两张新账号的票被撤回
+	if after, _ := conn.ZScore(ctx, "score:", article).Result(); after != before {
+		t.Fatalf("score = %v, want %v", after, before)
+	}
+	if state, _ := GetVote(ctx, conn, "new0", article); state != VoteNone {
+		t.Fatalf("ring vote still counted: %d", state)
+	}
+	// 没有记录第一次出现时间的用户不算新账号 标记之后新账号的票仍然等待审核
+	if _, err := ArticleVote(ctx, conn, "unseen", article); err != nil {
+		t.Fatalf("vote by an unseen user = %v", err)
+	}
+	before += VOTE_SCORE
+	RecordUser(ctx, conn, "new4")
+	if _, err := ArticleVote(ctx, conn, "new4", article); !errors.Is(err, ErrVoteHeld) {
+		t.Fatalf("vote by new4 = %v, want ErrVoteHeld", err)
+	}
+	held, err := HeldVotes(ctx, conn, article)
+	if err != nil || len(held) != 5 || held["new0"] != VoteUp || held["new2"] != VoteUp || held["new4"] != VoteUp {
+		t.Fatalf("HeldVotes() = %v, %v", held, err)
+	}
+	if n, _ := conn.ZScore(ctx, "review:", article).Result(); n == 0 {
+		t.Fatal("article not flagged for review")
+	}
+
+	// 取消投票同时撤回等待审核的票
+	if _, err := Vote(ctx, conn, "new4", article, VoteNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReviewVote(ctx, conn, article, "new2", true); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := conn.ZScore(ctx, "score:", article).Result(); after != before+VOTE_SCORE {
+		t.Fatalf("score after approval = %v, want %v", after, before+VOTE_SCORE)
+	}
+	if err := ReviewVote(ctx, conn, article, "new3", false); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := GetVote(ctx, conn, "new3", article); state != VoteNone {
+		t.Fatalf("rejected vote counted: %d", state)
+	}
+	if err := ReviewVote(ctx, conn, article, "new3", true); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("ReviewVote(reviewed) = %v, want ErrNotFound", err)
+	}
+	if err := ReviewVote(ctx, conn, article, "new0", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// 投票期限结束之后通过审核的票也被丢弃
+	clock.Advance(ONE_WEEK_IN_SECONDS * time.Second)
+	if err := ReviewVote(ctx, conn, article, "new1", true); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("ReviewVote() after the window = %v, want ErrVotingClosed", err)
+	}
+	if conn.Exists(ctx, "review:votes:"+a).Val() != 0 || conn.ZCard(ctx, "review:").Val() != 0 {
+		t.Fatal("review state not cleaned up")
+	}
+}
+
+// 老用户（包括没有记录第一次出现时间的用户）一起投票时不会被当成投票团伙
+func TestVoteRingEstablishedUsers(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	RingDetector = &VoteRingDetector{NewAccountAge: 24 * time.Hour, Window: 10 * time.Minute, Threshold: 3}
+	defer func() { Clock, RingDetector = core.RealClock{}, nil }()
+
+	for i := 0; i < 3; i++ {
+		RecordUser(ctx, conn, fmt.Sprint("old", i))
+	}
+	clock.Advance(48 * time.Hour)
+	a, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	for _, user := range []string{"old0", "legacy0", "old1", "legacy1", "old2", "legacy2"} {
+		if _, err := ArticleVote(ctx, conn, user, "article:"+a); err != nil {
+			t.Fatalf("vote by %s = %v", user, err)
+		}
+	}
+	if held, _ := HeldVotes(ctx, conn, "article:"+a); len(held) != 0 || conn.ZCard(ctx, "review:").Val() != 0 {
+		t.Fatalf("established users held: %v", held)
+	}
+	if n := conn.ZCard(ctx, "users:first_seen").Val(); n != 3 {
+		t.Fatalf("voting recorded first_seen: %d users", n)
+	}
+}
+
+func TestExpireHeldVotes(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	RingDetector = &VoteRingDetector{Threshold: 1}
+	defer func() { Clock, RingDetector = core.RealClock{}, nil }()
+
+	a, _ := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	clock.Advance(3 * 24 * time.Hour)
+	RecordUser(ctx, conn, "new")
+	b, _ := PostArticle(ctx, conn, "username", "B title", "http://www.google.com")
+	for _, id := range []string{a, b} {
+		if _, err := ArticleVote(ctx, conn, "new", "article:"+id); !errors.Is(err, ErrVoteHeld) {
+			t.Fatalf("ArticleVote() = %v, want ErrVoteHeld", err)
+		}
+	}
+	// 只有a的投票期限已经结束
+	clock.Advance(5 * 24 * time.Hour)
+	if n, err := ExpireHeldVotes(ctx, conn); n != 1 || err != nil {
+		t.Fatalf("ExpireHeldVotes() = %d, %v", n, err)
+	}
+	if held, _ := HeldVotes(ctx, conn, "article:"+a); len(held) != 0 {
+		t.Fatalf("HeldVotes(a) = %v", held)
+	}
+	if held, _ := HeldVotes(ctx, conn, "article:"+b); len(held) != 1 {
+		t.Fatalf("HeldVotes(b) = %v", held)
+	}
+	if n := conn.ZCard(ctx, "review:").Val(); n != 1 {
+		t.Fatalf("%d articles under review, want 1", n)
+	}
+}
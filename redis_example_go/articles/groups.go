@@ -0,0 +1,266 @@
+package articles
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// 分组查询结果（有序集合的交集）的缓存时间
+var GroupCacheTTL = time.Minute
+
+// 分页游标无法解析
+var ErrInvalidCursor = errors.New("articles: invalid cursor")
+
+// GroupOp 多个分组之间的组合方式
+type GroupOp string
+
+const (
+	// 文章同时属于所有分组
+	GroupAnd GroupOp = "and"
+	// 文章属于任意一个分组
+	GroupOr GroupOp = "or"
+)
+
+// GroupQuery 分组查询 Order与GetArticles相同
+type GroupQuery struct {
+	Groups []string
+	Op     GroupOp
+	Order  string
+}
+
+// ArticlePage 一页文章 Next为下一页的游标 没有更多文章时为空
+type ArticlePage struct {
+	Articles []map[string]string
+	Next     string
+}
+
+// 游标中保存上一页最后一篇文章的评分和id 对调用方是不透明的字符串
+// 翻页期间缓存过期重建或者有新文章加入都不会导致重复或遗漏（评分变化的文章除外）
+func encodeCursor(score float64, id string) string {
+	raw := strconv.FormatFloat(score, 'g', -1, 64) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (float64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidCursor
+	}
+	score, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return score, parts[1], nil
+}
+
+// 缓存键 单个分组时与原来的 order+group 相同 多个分组时为 order+op:排序后的分组
+func groupCacheKey(order string, op GroupOp, groups []string) string {
+	if len(groups) == 1 {
+		return order + groups[0]
+	}
+	return order + string(op) + ":" + strings.Join(groups, ",")
+}
+
+// 同一个进程内同时请求同一个缓存时只有一个请求去redis计算交集 其他请求等待它的结果
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+var flights = struct {
+	sync.Mutex
+	m map[string]*flight
+}{m: make(map[string]*flight)}
+
+// 共享的计算使用的ctx 保留发起者ctx中的值 但不继承它的取消和截止时间
+// 否则发起请求的客户端断开时 所有等待同一个结果的请求都会失败
+type detachedCtx struct{ context.Context }
+
+func (detachedCtx) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedCtx) Done() <-chan struct{}       { return nil }
+func (detachedCtx) Err() error                  { return nil }
+
+// 在后台执行fn 每个调用者在自己的ctx结束时停止等待并返回ctx的错误 计算仍然继续
+func singleFlight(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	flights.Lock()
+	f, ok := flights.m[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		flights.m[key] = f
+		go func() {
+			f.err = fn(detachedCtx{ctx})
+			flights.Lock()
+			delete(flights.m, key)
+			flights.Unlock()
+			close(f.done)
+		}()
+	}
+	flights.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 确保分组查询的结果已经缓存在redis中 返回缓存的键
+func groupCache(ctx context.Context, conn redis.Cmdable, q GroupQuery) (string, error) {
+	order, err := orderKey(q.Order)
+	if err != nil {
+		return "", err
+	}
+	op := q.Op
+	if op == "" {
+		op = GroupAnd
+	}
+	if op != GroupAnd && op != GroupOr {
+		return "", core.Wrap("articles.group_get", errors.Errorf("unknown group op %q", op))
+	}
+	// 去重并排序 使同一组分组不论顺序都共用一个缓存
+	seen := make(map[string]bool)
+	groups := make([]string, 0, len(q.Groups))
+	for _, g := range q.Groups {
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		return "", core.Wrap("articles.group_get", errors.New("no groups"))
+	}
+	sort.Strings(groups)
+	key := groupCacheKey(order, op, groups)
+
+	// 检查是否有已缓存的排序结果，如果没有的话就现在进行排序。
+	exists, err := conn.Exists(ctx, key).Result()
+	if err != nil {
+		return "", core.Wrap("articles.group_get", err)
+	}
+	if exists == 1 {
+		return key, nil
+	}
+	err = singleFlight(ctx, key, func(ctx context.Context) error {
+		// 等待期间其他进程可能已经算好了
+		if exists, err := conn.Exists(ctx, key).Result(); err != nil || exists == 1 {
+			return err
+		}
+		setKeys := make([]string, len(groups))
+		for i, g := range groups {
+			setKeys[i] = "group:" + g
+		}
+		pipe := conn.TxPipeline()
+		// 集合没有分数 默认分数为1 把集合的权重设为0后求和 结果就是排名算法的评分
+		if op == GroupAnd {
+			weights := make([]float64, len(setKeys)+1)
+			weights[len(setKeys)] = 1
+			pipe.ZInterStore(ctx, key, &redis.ZStore{Keys: append(setKeys, order), Weights: weights})
+		} else {
+			// 先求分组的并集 再与排名的有序集合求交集
+			tmp := key + ":tmp"
+			pipe.ZUnionStore(ctx, tmp, &redis.ZStore{Keys: setKeys})
+			pipe.ZInterStore(ctx, key, &redis.ZStore{Keys: []string{tmp, order}, Weights: []float64{0, 1}})
+			pipe.Del(ctx, tmp)
+		}
+		// 让Redis在GroupCacheTTL之后自动删除这个有序集合。
+		pipe.Expire(ctx, key, GroupCacheTTL)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	return key, core.Wrap("articles.group_get", err)
+}
+
+// QueryGroupArticles 按游标分页获取分组查询的文章 cursor为空时从第一篇开始 count<=0时为ARTICLES_PER_PAGE
+// 游标无法解析时返回 ErrInvalidCursor
+func QueryGroupArticles(ctx context.Context, conn redis.Cmdable, q GroupQuery, cursor string, count int) (*ArticlePage, error) {
+	ctx = core.WithOp(ctx, "articles.group_get")
+	if count <= 0 {
+		count = ARTICLES_PER_PAGE
+	}
+	key, err := groupCache(ctx, conn, q)
+	if err != nil {
+		return nil, err
+	}
+	return pageAfter(ctx, conn, "articles.group_get", key, cursor, count)
+}
+
+// ListArticles 按游标分页获取所有文章 order与GetArticles相同 其余参数与QueryGroupArticles相同
+func ListArticles(ctx context.Context, conn redis.Cmdable, order string, cursor string, count int) (*ArticlePage, error) {
+	ctx = core.WithOp(ctx, "articles.get")
+	if count <= 0 {
+		count = ARTICLES_PER_PAGE
+	}
+	key, err := orderKey(order)
+	if err != nil {
+		return nil, err
+	}
+	return pageAfter(ctx, conn, "articles.get", key, cursor, count)
+}
+
+// 从有序集合key中获取游标之后的一页文章
+func pageAfter(ctx context.Context, conn redis.Cmdable, op string, key string, cursor string, count int) (*ArticlePage, error) {
+	var items []redis.Z
+	var err error
+	if cursor == "" {
+		items, err = conn.ZRevRangeWithScores(ctx, key, 0, int64(count)).Result()
+	} else {
+		items, err = rangeAfter(ctx, conn, key, cursor, count+1)
+	}
+	if err != nil {
+		return nil, core.Wrap(op, err)
+	}
+	// 多取一篇用来判断是否还有下一页
+	page := &ArticlePage{}
+	if len(items) > count {
+		items = items[:count]
+		last := items[count-1]
+		page.Next = encodeCursor(last.Score, last.Member.(string))
+	}
+	ids := make([]string, len(items))
+	for i, z := range items {
+		ids[i] = z.Member.(string)
+	}
+	page.Articles, err = loadArticles(ctx, conn, ids)
+	return page, err
+}
+
+// 按评分从高到低排在游标之后的count个成员 评分相同时与ZREVRANGE一样按成员倒序
+func rangeAfter(ctx context.Context, conn redis.Cmdable, key string, cursor string, count int) ([]redis.Z, error) {
+	score, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	s := strconv.FormatFloat(score, 'g', -1, 64)
+	pipe := conn.Pipeline()
+	// 与游标评分相同的成员 以及评分更低的成员
+	tiesCmd := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Max: s, Min: s})
+	lowerCmd := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Max: "(" + s, Min: "-inf", Count: int64(count)})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	items := make([]redis.Z, 0, count)
+	for _, z := range tiesCmd.Val() {
+		if z.Member.(string) < id {
+			items = append(items, z)
+		}
+	}
+	items = append(items, lowerCmd.Val()...)
+	if len(items) > count {
+		items = items[:count]
+	}
+	return items, nil
+}
@@ -0,0 +1,185 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 发布count篇文章并加入groups 返回文章键
+func postGroupArticles(t *testing.T, ctx context.Context, conn redis.Cmdable, count int, groups ...string) []string {
+	t.Helper()
+	articles := make([]string, count)
+	for i := range articles {
+		id, err := PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := AddRemoveGroups(ctx, conn, atoi(t, id), groups, nil); err != nil {
+			t.Fatal(err)
+		}
+		articles[i] = "article:" + id
+	}
+	return articles
+}
+
+func TestQueryGroupArticlesCursor(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	// 时钟不动 所有文章的评分相同 翻页只能靠id区分
+	Clock = core.NewFakeClock(time.Time{})
+	defer func() { Clock = core.RealClock{} }()
+
+	posted := postGroupArticles(t, ctx, conn, 7, "g")
+	q := GroupQuery{Groups: []string{"g"}}
+	seen := make(map[string]bool)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, err := QueryGroupArticles(ctx, conn, q, cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range page.Articles {
+			if seen[a["id"]] {
+				t.Fatalf("article %s returned twice", a["id"])
+			}
+			seen[a["id"]] = true
+		}
+		if pages == 0 {
+			// 翻页期间缓存被重建 新发布的文章排在最前面 不影响后面的页
+			conn.Del(ctx, "score:g")
+			postGroupArticles(t, ctx, conn, 1, "g")
+		}
+		if page.Next == "" {
+			if pages != 2 || len(page.Articles) != 1 {
+				t.Fatalf("last page %d has %d articles", pages, len(page.Articles))
+			}
+			break
+		}
+		cursor = page.Next
+	}
+	for _, a := range posted {
+		if !seen[a] {
+			t.Errorf("article %s missing", a)
+		}
+	}
+
+	if _, err := QueryGroupArticles(ctx, conn, q, "not a cursor", 3); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("QueryGroupArticles(bad cursor) = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestQueryGroupArticlesAndOr(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	postGroupArticles(t, ctx, conn, 2, "a")
+	postGroupArticles(t, ctx, conn, 3, "a", "b")
+	postGroupArticles(t, ctx, conn, 4, "b")
+
+	for _, tc := range []struct {
+		q    GroupQuery
+		want int
+	}{
+		{GroupQuery{Groups: []string{"a", "b"}}, 3},
+		{GroupQuery{Groups: []string{"b", "a"}, Op: GroupAnd, Order: "time"}, 3},
+		{GroupQuery{Groups: []string{"a", "b"}, Op: GroupOr}, 9},
+		{GroupQuery{Groups: []string{"b", "b"}, Op: GroupOr, Order: "wilson"}, 7},
+	} {
+		page, err := QueryGroupArticles(ctx, conn, tc.q, "", 0)
+		if err != nil || len(page.Articles) != tc.want || page.Next != "" {
+			t.Fatalf("QueryGroupArticles(%+v) = %d articles, %v", tc.q, len(page.Articles), err)
+		}
+	}
+	// 分组的顺序不影响缓存键
+	if n := conn.Exists(ctx, "score:and:a,b", "time:and:a,b", "score:or:a,b", "wilson:b").Val(); n != 4 {
+		t.Fatalf("cached intersections = %d, want 4", n)
+	}
+	if conn.Exists(ctx, "score:or:a,b:tmp").Val() != 0 {
+		t.Fatal("temporary union left behind")
+	}
+
+	GroupCacheTTL = 5 * time.Second
+	defer func() { GroupCacheTTL = time.Minute }()
+	QueryGroupArticles(ctx, conn, GroupQuery{Groups: []string{"a"}}, "", 0)
+	if ttl := conn.TTL(ctx, "score:a").Val(); ttl <= 0 || ttl > 5*time.Second {
+		t.Fatalf("cache ttl = %v", ttl)
+	}
+}
+
+func TestSingleFlight(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			singleFlight(ctx, "key", func(context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return nil
+			})
+		}()
+	}
+	// 等到第一个调用开始执行
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+// 发起者的ctx被取消时 共享的计算继续执行 其他等待者拿到它的结果
+func TestSingleFlightCancel(t *testing.T) {
+	leader, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var loadErr error
+	leaderErr := make(chan error, 1)
+	go func() {
+		leaderErr <- singleFlight(leader, "key", func(ctx context.Context) error {
+			close(started)
+			<-release
+			loadErr = ctx.Err()
+			return nil
+		})
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		waiter <- singleFlight(context.Background(), "key", func(context.Context) error {
+			t.Error("second load started")
+			return nil
+		})
+	}()
+	cancel()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Fatalf("singleFlight() after cancel = %v", err)
+	}
+
+	// 等待者也可以在自己的ctx结束时放弃
+	short, stop := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer stop()
+	if err := singleFlight(short, "key", nil); err != context.DeadlineExceeded {
+		t.Fatalf("singleFlight() after timeout = %v", err)
+	}
+
+	close(release)
+	if err := <-waiter; err != nil || loadErr != nil {
+		t.Fatalf("waiter = %v, load ctx err = %v", err, loadErr)
+	}
+}
@@ -0,0 +1,265 @@
+package articles
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// ArticleVotes 计算排名需要的文章数据
+type ArticleVotes struct {
+	Posted time.Time
+	Up     int64
+	Down   int64
+}
+
+// Ranker 文章排名算法 每个算法把评分保存在自己的有序集合 Name()+":" 中
+// GetArticles 和 GetGroupArticles 的order参数就是算法的名字
+// TimeDependent 为true时评分随当前时间变化 需要 ReRankLoop 定期重新计算
+type Ranker interface {
+	Name() string
+	Score(v ArticleVotes, now time.Time) float64
+	TimeDependent() bool
+}
+
+// 参与排名的算法 发布文章和投票时会更新所有算法的有序集合
+// 随时间衰减的算法（如HackerNews）需要 ReRankLoop 在后台定期重新计算 其余的只在投票时更新
+var Rankers = []Ranker{Linear{}, HackerNews{}, RedditHot{}, Wilson{}}
+
+// 按名字查找排名算法
+func ranker(name string) (Ranker, bool) {
+	for _, r := range Rankers {
+		if r.Name() == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// 排名使用的有序集合 除了排名算法之外还可以按发布时间（time）排序
+func orderKey(order string) (string, error) {
+	if order == "" {
+		order = Linear{}.Name()
+	}
+	if order == "time" {
+		return "time:", nil
+	}
+	if _, ok := ranker(order); !ok {
+		return "", core.NotFound("articles.get", "ranker "+order)
+	}
+	return order + ":", nil
+}
+
+// Linear 第一章的评分：发布时间 + 净票数 * VOTE_SCORE 保存在score:中
+// 评分与时间无关 投票时由投票脚本原子地增减 不会被并发的投票覆盖
+type Linear struct{}
+
+func (Linear) Name() string { return "score" }
+
+func (Linear) TimeDependent() bool { return false }
+
+func (Linear) Score(v ArticleVotes, now time.Time) float64 {
+	return float64(v.Posted.Unix() + (v.Up-v.Down)*VOTE_SCORE)
+}
+
+// HackerNews Hacker News的重力衰减排名 (P-1) / (T+2)^G
+// P为净票数（包含发布者自己的一票） T为发布后经过的小时数 Gravity默认为1.8
+type HackerNews struct {
+	Gravity float64
+}
+
+func (HackerNews) Name() string { return "hn" }
+
+func (HackerNews) TimeDependent() bool { return true }
+
+func (r HackerNews) Score(v ArticleVotes, now time.Time) float64 {
+	gravity := r.Gravity
+	if gravity <= 0 {
+		gravity = 1.8
+	}
+	hours := now.Sub(v.Posted).Hours()
+	if hours < 0 {
+		hours = 0
+	}
+	return float64(v.Up-v.Down-1) / math.Pow(hours+2, gravity)
+}
+
+// Reddit算法使用的时间起点（2005-12-08 07:46:43 UTC）
+const redditEpoch = 1134028003
+
+// RedditHot Reddit的hot排名 净票数取对数 每45000秒（12.5小时）相当于票数增加10倍
+// 评分只与发布时间有关 新文章自然排在前面 不需要重新计算
+type RedditHot struct{}
+
+func (RedditHot) Name() string { return "hot" }
+
+func (RedditHot) TimeDependent() bool { return false }
+
+func (RedditHot) Score(v ArticleVotes, now time.Time) float64 {
+	s := v.Up - v.Down
+	order := math.Log10(math.Max(math.Abs(float64(s)), 1))
+	sign := 0.0
+	if s > 0 {
+		sign = 1
+	} else if s < 0 {
+		sign = -1
+	}
+	seconds := float64(v.Posted.Unix() - redditEpoch)
+	return math.Round((sign*order+seconds/45000)*1e7) / 1e7
+}
+
+// Wilson 赞成票比例的Wilson置信区间下界 与时间无关 Z默认为1.96（95%置信度）
+type Wilson struct {
+	Z float64
+}
+
+func (Wilson) Name() string { return "wilson" }
+
+func (Wilson) TimeDependent() bool { return false }
+
+func (r Wilson) z() float64 {
+	if r.Z <= 0 {
+		return 1.96
+	}
+	return r.Z
+}
+
+func (r Wilson) Score(v ArticleVotes, now time.Time) float64 {
+	n := float64(v.Up + v.Down)
+	if n == 0 {
+		return 0
+	}
+	z := r.z()
+	phat := float64(v.Up) / n
+	return (phat + z*z/(2*n) - z*math.Sqrt((phat*(1-phat)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// 把文章在各排名算法中的评分写入流水线 Linear的评分由投票脚本维护 withLinear为false时跳过
+func addRanks(ctx context.Context, pipe redis.Pipeliner, article string, v ArticleVotes, now time.Time, withLinear bool) {
+	for _, r := range Rankers {
+		if _, ok := r.(Linear); ok && !withLinear {
+			continue
+		}
+		pipe.ZAdd(ctx, r.Name()+":", &redis.Z{Score: r.Score(v, now), Member: article})
+	}
+}
+
+// KEYS: 文章散列 排名算法的有序集合...  ARGV: 赞成票数 反对票数 文章 评分...
+// 只有文章的票数仍然是计算评分时的票数才写入 否则说明有更新的投票 由它写入评分
+var setRanksScript = redis.NewScript(`
+local counts = redis.call("hmget", KEYS[1], "upvotes", "downvotes")
+if counts[1] ~= ARGV[1] or counts[2] ~= ARGV[2] then
+	return 0
+end
+for i = 2, #KEYS do
+	redis.call("zadd", KEYS[i], ARGV[i + 2], ARGV[3])
+end
+return 1`)
+
+// 投票之后写入文章在Linear以外的排名算法中的评分 并发的投票不会让旧的评分覆盖新的评分
+func setRanks(ctx context.Context, conn redis.Cmdable, article string, v ArticleVotes, now time.Time) error {
+	keys := []string{article}
+	args := []interface{}{v.Up, v.Down, article}
+	for _, r := range Rankers {
+		if _, ok := r.(Linear); ok {
+			continue
+		}
+		keys = append(keys, r.Name()+":")
+		args = append(args, r.Score(v, now))
+	}
+	return setRanksScript.Run(ctx, conn, keys, args...).Err()
+}
+
+// KEYS: 排名算法的有序集合 文章散列...  ARGV: 每篇文章4个参数 votes upvotes downvotes 评分
+// 和 setRanksScript 一样 只有文章的票数仍然是读取时的票数才写入评分 返回写入的文章数量
+var reRankScript = redis.NewScript(`
+local written = 0
+for i = 2, #KEYS do
+	local j = (i - 2) * 4
+	local counts = redis.call("hmget", KEYS[i], "votes", "upvotes", "downvotes")
+	if (counts[1] or "") == ARGV[j + 1] and (counts[2] or "") == ARGV[j + 2] and (counts[3] or "") == ARGV[j + 3] then
+		redis.call("zadd", KEYS[1], ARGV[j + 4], KEYS[i])
+		written = written + 1
+	end
+end
+return written`)
+
+// 每次重新计算的文章数量
+const reRankBatch = 100
+
+// ReRank 重新计算所有文章在ranker中的评分
+// 赞成票和反对票的数量来自文章散列的upvotes和downvotes字段 旧文章没有这两个字段时把votes当作赞成票数
+// 读取票数之后有新投票的文章跳过 评分由投票写入 与时间无关的算法不重新计算 直接返回nil
+func ReRank(ctx context.Context, conn redis.Cmdable, r Ranker) error {
+	ctx = core.WithOp(ctx, "articles.rerank")
+	if !r.TimeDependent() {
+		return nil
+	}
+	now := Clock.Now()
+	for start := int64(0); ; start += reRankBatch {
+		// 按发布时间遍历所有文章
+		posted, err := conn.ZRangeWithScores(ctx, "time:", start, start+reRankBatch-1).Result()
+		if err != nil {
+			return core.Wrap("articles.rerank", err)
+		}
+		if len(posted) == 0 {
+			return nil
+		}
+		pipe := conn.Pipeline()
+		cmds := make([]*redis.SliceCmd, len(posted))
+		for i, z := range posted {
+			cmds[i] = pipe.HMGet(ctx, z.Member.(string), "votes", "upvotes", "downvotes")
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return core.Wrap("articles.rerank", err)
+		}
+		keys := []string{r.Name() + ":"}
+		args := make([]interface{}, 0, 4*len(posted))
+		for i, z := range posted {
+			v := ArticleVotes{Posted: time.Unix(int64(z.Score), 0)}
+			fields := cmds[i].Val()
+			if fields[1] == nil {
+				v.Up = parseCount(fields[0])
+			} else {
+				v.Up, v.Down = parseCount(fields[1]), parseCount(fields[2])
+			}
+			keys = append(keys, z.Member.(string))
+			args = append(args, rawCount(fields[0]), rawCount(fields[1]), rawCount(fields[2]), r.Score(v, now))
+		}
+		if err := reRankScript.Run(ctx, conn, keys, args...).Err(); err != nil {
+			return core.Wrap("articles.rerank", err)
+		}
+	}
+}
+
+func parseCount(v interface{}) int64 {
+	n, _ := strconv.ParseInt(rawCount(v), 10, 64)
+	return n
+}
+
+// HMGET返回的字段值 字段不存在时为空字符串
+func rawCount(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// ReRankLoop 守护任务 每隔interval用 ReRank 重新计算 Rankers 中随时间衰减的算法的评分 直到ctx结束或出错
+func ReRankLoop(ctx context.Context, conn redis.Cmdable, interval time.Duration) error {
+	ctx = core.WithOp(ctx, "articles.rerank")
+	for ctx.Err() == nil {
+		for _, r := range Rankers {
+			if !r.TimeDependent() {
+				continue
+			}
+			if err := ReRank(ctx, conn, r); err != nil {
+				return core.StopErr(ctx, "articles.rerank", err)
+			}
+		}
+		core.Sleep(Clock, ctx.Done(), interval)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package articles
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func TestRankerScores(t *testing.T) {
+	posted := time.Unix(1600000000, 0)
+	v := ArticleVotes{Posted: posted, Up: 10, Down: 2}
+
+	if got, want := (Linear{}).Score(v, posted), float64(1600000000+8*VOTE_SCORE); got != want {
+		t.Errorf("Linear = %v, want %v", got, want)
+	}
+	if got, want := (HackerNews{}).Score(v, posted.Add(2*time.Hour)), 7/math.Pow(4, 1.8); math.Abs(got-want) > 1e-9 {
+		t.Errorf("HackerNews = %v, want %v", got, want)
+	}
+	if got, want := (RedditHot{}).Score(v, posted), math.Round((math.Log10(8)+float64(1600000000-redditEpoch)/45000)*1e7)/1e7; got != want {
+		t.Errorf("RedditHot = %v, want %v", got, want)
+	}
+	// 票数越多 同样比例的赞成票置信下界越高
+	few := (Wilson{}).Score(ArticleVotes{Up: 4, Down: 1}, posted)
+	many := (Wilson{}).Score(ArticleVotes{Up: 400, Down: 100}, posted)
+	if !(0 < few && few < many && many < 0.8) {
+		t.Errorf("Wilson = %v, %v", few, many)
+	}
+	if got := (Wilson{}).Score(ArticleVotes{}, posted); got != 0 {
+		t.Errorf("Wilson without votes = %v", got)
+	}
+}
+
+func TestGetArticlesByRanker(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	// 旧文章票数多 新文章票数少
+	old, _ := PostArticle(ctx, conn, "username", "old", "http://www.google.com")
+	for _, user := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := ArticleVote(ctx, conn, user, "article:"+old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ArticleOpposeVote(ctx, conn, "f", "article:"+old); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := PostArticle(ctx, conn, "username", "new", "http://www.google.com")
+
+	for _, order := range []string{"", "score", "hn", "hot", "wilson"} {
+		list, err := GetArticles(ctx, conn, 1, order)
+		if err != nil || len(list) != 2 {
+			t.Fatalf("GetArticles(%q) = %v, %v", order, list, err)
+		}
+		if list[0]["id"] != "article:"+old {
+			t.Errorf("GetArticles(%q) first = %s, want article:%s", order, list[0]["id"], old)
+		}
+	}
+	if list, _ := GetArticles(ctx, conn, 1, "time"); len(list) != 2 {
+		t.Errorf("GetArticles(time) = %v", list)
+	}
+	if r := conn.HMGet(ctx, "article:"+old, "votes", "upvotes", "downvotes").Val(); r[0] != "5" || r[1] != "6" || r[2] != "1" {
+		t.Errorf("votes, upvotes, downvotes = %v", r)
+	}
+
+	if _, err := GetArticles(ctx, conn, 1, "nope"); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("GetArticles(nope) = %v, want ErrNotFound", err)
+	}
+
+	// 评分小于1的排名算法也能用于分组
+	if err := AddRemoveGroups(ctx, conn, atoi(t, fresh), []string{"g"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddRemoveGroups(ctx, conn, atoi(t, old), []string{"g"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	group, err := GetGroupArticles(ctx, conn, "g", 1, "wilson")
+	if err != nil || len(group) != 2 || group[0]["id"] != "article:"+old {
+		t.Fatalf("GetGroupArticles(wilson) = %v, %v", group, err)
+	}
+}
+
+func TestReRank(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	old, _ := PostArticle(ctx, conn, "username", "old", "http://www.google.com")
+	for _, user := range []string{"a", "b", "c"} {
+		if _, err := ArticleVote(ctx, conn, user, "article:"+old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(24 * time.Hour)
+	fresh, _ := PostArticle(ctx, conn, "username", "new", "http://www.google.com")
+	if _, err := ArticleVote(ctx, conn, "a", "article:"+fresh); err != nil {
+		t.Fatal(err)
+	}
+	// 投票时计算的评分还没有衰减
+	if list, _ := GetArticles(ctx, conn, 1, "hn"); list[0]["id"] != "article:"+old {
+		t.Fatalf("before rerank first = %s", list[0]["id"])
+	}
+
+	// 重新计算期间投票脚本对score:的修改不会被覆盖
+	conn.ZIncrBy(ctx, "score:", 432, "article:"+old)
+	before := conn.ZScore(ctx, "score:", "article:"+old).Val()
+
+	rctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- ReRankLoop(rctx, conn, time.Hour) }()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// 旧文章的评分随时间衰减 排到了新文章后面
+	if list, _ := GetArticles(ctx, conn, 1, "hn"); list[0]["id"] != "article:"+fresh {
+		t.Fatalf("after rerank first = %s, want article:%s", list[0]["id"], fresh)
+	}
+	// 与时间无关的评分不重新计算
+	if after := conn.ZScore(ctx, "score:", "article:"+old).Val(); after != before {
+		t.Fatalf("ReRankLoop changed linear score from %v to %v", before, after)
+	}
+	if err := ReRank(ctx, conn, Linear{}); err != nil {
+		t.Fatal(err)
+	}
+	if after := conn.ZScore(ctx, "score:", "article:"+old).Val(); after != before {
+		t.Fatalf("linear score changed from %v to %v", before, after)
+	}
+	// hot只与发布时间有关 也不重新计算
+	conn.ZIncrBy(ctx, "hot:", 1, "article:"+old)
+	hot := conn.ZScore(ctx, "hot:", "article:"+old).Val()
+	if err := ReRank(ctx, conn, RedditHot{}); err != nil {
+		t.Fatal(err)
+	}
+	if after := conn.ZScore(ctx, "hot:", "article:"+old).Val(); after != hot {
+		t.Fatalf("hot score changed from %v to %v", hot, after)
+	}
+}
+
+// 在重新计算的脚本执行之前用另一个连接投票
+type voteBeforeReRank struct {
+	other *redis.Client
+	voted bool
+}
+
+func (h *voteBeforeReRank) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if args := cmd.Args(); !h.voted && cmd.Name() == "evalsha" && len(args) > 1 && args[1] == reRankScript.Hash() {
+		h.voted = true
+		_, err := ArticleVote(ctx, h.other, "late", "article:1")
+		return ctx, err
+	}
+	return ctx, nil
+}
+func (*voteBeforeReRank) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (*voteBeforeReRank) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (*voteBeforeReRank) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestReRankConcurrentVote(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	id, _ := PostArticle(ctx, conn, "username", "title", "http://www.google.com")
+	if id != "1" {
+		t.Fatalf("PostArticle() = %s", id)
+	}
+	clock.Advance(time.Hour)
+	other := redis.NewClient(conn.Options())
+	defer other.Close()
+	hook := &voteBeforeReRank{other: other}
+	conn.AddHook(hook)
+
+	// 读取票数之后的投票写入的评分不会被重新计算的旧评分覆盖
+	if err := ReRank(ctx, conn, HackerNews{}); err != nil {
+		t.Fatal(err)
+	}
+	if !hook.voted {
+		t.Fatal("rerank script did not run")
+	}
+	v := ArticleVotes{Posted: time.Unix(1600000000, 0), Up: 2}
+	if got, want := conn.ZScore(ctx, "hn:", "article:1").Val(), (HackerNews{}).Score(v, clock.Now()); got != want {
+		t.Fatalf("hn score = %v, want %v from the late vote", got, want)
+	}
+}
+
+func atoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
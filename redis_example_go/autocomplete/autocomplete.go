@@ -0,0 +1,159 @@
+// Package autocomplete 第六章的自动补全：最近联系人列表和公会成员前缀查找
+package autocomplete
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"sort"
+	"strings"
+)
+
+// AddUpdateContact 将联系人添加到用户的最近联系人列表的最前面 最多保留100个（代码清单6-1）
+func AddUpdateContact(ctx context.Context, conn redis.Cmdable, user string, contact string) error {
+	ctx = core.WithOp(ctx, "autocomplete.add_contact")
+	ac_list := "recent:" + user
+	// 准备执行原子操作。
+	pipeline := conn.TxPipeline()
+	// 如果联系人已经存在，那么移除他。
+	pipeline.LRem(ctx, ac_list, 1, contact)
+	// 将联系人推入到列表的最前端。
+	pipeline.LPush(ctx, ac_list, contact)
+	// 只保留列表里面的前100个联系人。
+	pipeline.LTrim(ctx, ac_list, 0, 99)
+	// 实际地执行以上操作。
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("autocomplete.add_contact", err)
+}
+
+// RemoveContact 将联系人从用户的最近联系人列表中删除
+func RemoveContact(ctx context.Context, conn redis.Cmdable, user string, contact string) error {
+	ctx = core.WithOp(ctx, "autocomplete.remove_contact")
+	return core.Wrap("autocomplete.remove_contact", conn.LRem(ctx, "recent:"+user, 1, contact).Err())
+}
+
+// FetchAutocompleteList 返回最近联系人中带有prefix前缀的联系人（代码清单6-2）
+func FetchAutocompleteList(ctx context.Context, conn redis.Cmdable, user string, prefix string) ([]string, error) {
+	ctx = core.WithOp(ctx, "autocomplete.fetch")
+	// 获取自动补完列表。
+	candidates, err := conn.LRange(ctx, "recent:"+user, 0, -1).Result()
+	if err != nil {
+		return nil, core.Wrap("autocomplete.fetch", err)
+	}
+	matches := make([]string, 0)
+	// 检查每个候选联系人。
+	for _, v := range candidates {
+		// 发现一个匹配的联系人。
+		if strings.HasPrefix(strings.ToLower(v), prefix) {
+			matches = append(matches, v)
+		}
+	}
+	// 返回所有匹配的联系人。
+	return matches, nil
+}
+
+// 准备一个由已知字符组成的列表。
+const valid_characters = "`abcdefghijklmnopqrstuvwxyz{"
+
+// FindPrefixRange 获取前缀的首尾标识 用于在有序集合中获取区间范围（代码清单6-3）
+// 例如prefix为abc时返回abb{和abc{
+func FindPrefixRange(prefix string) (string, string) {
+	// 在字符列表中查找前缀字符所处的位置。
+	last := ""
+	if prefix != "" {
+		last = prefix[len(prefix)-1:]
+	}
+	posn := sort.Search(len(valid_characters), func(i int) bool {
+		return valid_characters[i:i+1] >= last
+	})
+	// 找到前驱字符。
+	if posn == 0 {
+		posn = 1
+	}
+	suffix := valid_characters[posn-1 : posn]
+	// 返回范围。
+	head := ""
+	if prefix != "" {
+		head = prefix[:len(prefix)-1]
+	}
+	return head + suffix + "{", prefix + "{"
+}
+
+// AutocompleteOnPrefix 在公会成员中查找带有prefix前缀的成员 最多返回10个（代码清单6-4）
+// 添加标识的起始和结尾点 用于在有序集合中获取到前缀匹配的区间范围
+func AutocompleteOnPrefix(ctx context.Context, conn redis.UniversalClient, guild string, prefix string) ([]string, error) {
+	ctx = core.WithOp(ctx, "autocomplete.prefix")
+	// 根据给定的前缀计算出查找范围的起点和终点。
+	start, end := FindPrefixRange(prefix)
+	//考虑多个成员对同一工会成员进行发生消息时 避免重复添加相同的起始和结束元素
+	identifier := core.NewToken()
+	start += identifier
+	end += identifier
+	zset_name := "members:" + guild
+
+	// 将范围的起始元素和结束元素添加到有序集合里面。
+	if err := conn.ZAdd(ctx, zset_name, &redis.Z{Score: 0, Member: start}, &redis.Z{Score: 0, Member: end}).Err(); err != nil {
+		return nil, core.Wrap("autocomplete.prefix", err)
+	}
+	var items []string
+	for {
+		txf := func(tx *redis.Tx) error {
+			// 找到两个被插入元素在有序集合中的排名。
+			sindex, err := tx.ZRank(ctx, zset_name, start).Result()
+			if err != nil {
+				return err
+			}
+			eindex, err := tx.ZRank(ctx, zset_name, end).Result()
+			if err != nil {
+				return err
+			}
+			erange := sindex + 9
+			if eindex-2 < erange {
+				erange = eindex - 2
+			}
+			var cmd *redis.StringSliceCmd
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				// 获取范围内的值，然后删除之前插入的起始元素和结束元素。
+				pipe.ZRem(ctx, zset_name, start, end)
+				cmd = pipe.ZRange(ctx, zset_name, sindex, erange)
+				return nil
+			})
+			if err == nil {
+				items = cmd.Val()
+			}
+			return err
+		}
+		err := conn.Watch(ctx, txf, zset_name)
+		// 如果自动补完有序集合已经被其他客户端修改过了，那么进行重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			conn.ZRem(ctx, zset_name, start, end)
+			return nil, core.Wrap("autocomplete.prefix", err)
+		}
+		break
+	}
+	// 如果有其他自动补完操作正在执行，
+	// 那么从获取到的元素里面移除起始元素和终结元素。
+	retItems := make([]string, 0, len(items))
+	for _, v := range items {
+		if !strings.Contains(v, "{") {
+			retItems = append(retItems, v)
+		}
+	}
+	return retItems, nil
+}
+
+// JoinGuild 加入公会（代码清单6-5）
+func JoinGuild(ctx context.Context, conn redis.Cmdable, guild string, user string) error {
+	ctx = core.WithOp(ctx, "autocomplete.join_guild")
+	return core.Wrap("autocomplete.join_guild", conn.ZAdd(ctx, "members:"+guild, &redis.Z{Score: 0, Member: user}).Err())
+}
+
+// LeaveGuild 离开公会（代码清单6-5）
+func LeaveGuild(ctx context.Context, conn redis.Cmdable, guild string, user string) error {
+	ctx = core.WithOp(ctx, "autocomplete.leave_guild")
+	return core.Wrap("autocomplete.leave_guild", conn.ZRem(ctx, "members:"+guild, user).Err())
+}
@@ -0,0 +1,88 @@
+package autocomplete
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh06.test_add_update_contact
+func TestAddUpdateContact(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for i := 0; i < 10; i++ {
+		if err := AddUpdateContact(ctx, conn, "user", "contact-"+strconv.Itoa(i/3)+"-"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.LLen(ctx, "recent:user").Val(); n != 10 {
+		t.Fatalf("contacts = %d, want 10", n)
+	}
+	if err := AddUpdateContact(ctx, conn, "user", "contact-1-4"); err != nil {
+		t.Fatal(err)
+	}
+	if first := conn.LIndex(ctx, "recent:user", 0).Val(); first != "contact-1-4" {
+		t.Fatalf("first contact = %q, want contact-1-4", first)
+	}
+	if n := conn.LLen(ctx, "recent:user").Val(); n != 10 {
+		t.Fatalf("contacts after update = %d, want 10", n)
+	}
+
+	all, err := FetchAutocompleteList(ctx, conn, "user", "c")
+	if err != nil || len(all) != 10 {
+		t.Fatalf("FetchAutocompleteList(c) = %v, %v", all, err)
+	}
+	matches, _ := FetchAutocompleteList(ctx, conn, "user", "contact-2-")
+	if len(matches) != 3 {
+		t.Fatalf("FetchAutocompleteList(contact-2-) = %v, want 3", matches)
+	}
+	if err := RemoveContact(ctx, conn, "user", "contact-2-6"); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ = FetchAutocompleteList(ctx, conn, "user", "contact-2-")
+	if len(matches) != 2 {
+		t.Fatalf("FetchAutocompleteList(contact-2-) after remove = %v, want 2", matches)
+	}
+}
+
+// 对应TestCh06.test_address_book_autocomplete
+func TestAddressBookAutocomplete(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for _, name := range []string{"jeff", "jenny", "jack", "jennifer"} {
+		if err := JoinGuild(ctx, conn, "test", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := AutocompleteOnPrefix(ctx, conn, "test", "je")
+	if err != nil || len(r) != 3 {
+		t.Fatalf("AutocompleteOnPrefix(je) = %v, %v", r, err)
+	}
+	if err := LeaveGuild(ctx, conn, "test", "jeff"); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = AutocompleteOnPrefix(ctx, conn, "test", "je")
+	if !reflect.DeepEqual(r, []string{"jennifer", "jenny"}) {
+		t.Fatalf("AutocompleteOnPrefix(je) = %v, want [jennifer jenny]", r)
+	}
+	if n := conn.ZCard(ctx, "members:test").Val(); n != 3 {
+		t.Fatalf("range markers left behind: %d members", n)
+	}
+}
+
+func TestFindPrefixRange(t *testing.T) {
+	for prefix, want := range map[string][2]string{
+		"abc": {"abb{", "abc{"},
+		"a":   {"`{", "a{"},
+	} {
+		start, end := FindPrefixRange(prefix)
+		if start != want[0] || end != want[1] {
+			t.Errorf("FindPrefixRange(%q) = %q, %q, want %q, %q", prefix, start, end, want[0], want[1])
+		}
+	}
+}
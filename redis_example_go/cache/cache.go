@@ -0,0 +1,145 @@
+// Package cache 第二章的网页缓存和数据行缓存
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-redis/redis/v8"
+	"net/url"
+	"redis-learn/core"
+	"time"
+)
+
+// 调度时间使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+type Cach_Request struct {
+	Content string
+}
+
+type Delay struct {
+	RowIdDelay map[string]float64 `sorted:"1"`
+}
+
+type Schedule struct {
+	RowIdTimestamp map[string]float64 `sorted:"1"`
+}
+
+type InvRowId struct {
+	Row string
+}
+
+// CacheRequest 缓存页面 不能缓存的请求直接调用callback生成（代码清单2-6）
+func CacheRequest(ctx context.Context, conn redis.Cmdable, request string, callback func(string) string) (string, error) {
+	ctx = core.WithOp(ctx, "cache.request")
+	// 对于不能被缓存的请求，直接调用回调函数。
+	can_cache, err := CanCache(ctx, conn, request)
+	if err != nil {
+		return "", err
+	}
+	if !can_cache {
+		return callback(request), nil
+	}
+	// 将请求转换成一个简单的字符串键，方便之后进行查找。
+	page_key := "cache:" + hash_request(request)
+	// 尝试查找被缓存的页面。
+	content, err := conn.Get(ctx, page_key).Result()
+	if err == redis.Nil {
+		// 如果页面还没有被缓存，那么生成页面。
+		content = callback(request)
+		// 将新生成的页面放到缓存里面。
+		err = conn.SetNX(ctx, page_key, content, 300*time.Second).Err()
+	}
+	if err != nil {
+		return "", core.Wrap("cache.request", err)
+	}
+	// 返回页面。
+	return content, nil
+}
+
+// ScheduleRowCache 设置数据行的缓存间隔 并立即调度一次缓存 delay<=0表示不再缓存（代码清单2-7）
+func ScheduleRowCache(ctx context.Context, conn redis.Cmdable, row_id string, delay float64) error {
+	ctx = core.WithOp(ctx, "cache.schedule_row")
+	pipe := conn.TxPipeline()
+	// 先设置数据行的延迟值。
+	pipe.ZAdd(ctx, "delay:", &redis.Z{Score: delay, Member: row_id})
+	// 立即缓存数据行。
+	pipe.ZAdd(ctx, "schedule:", &redis.Z{Score: float64(Clock.Now().Unix()), Member: row_id})
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("cache.schedule_row", err)
+}
+
+// CacheRows 守护任务 按调度时间把数据行缓存到inv:<row_id> 直到ctx结束或出错（代码清单2-8）
+// 数据行来自 StubLoader 其他数据源使用 NewRowCacher
+func CacheRows(ctx context.Context, conn redis.Cmdable) error {
+	return NewRowCacher(conn, StubLoader).Run(ctx)
+}
+
+// InventoryGet 获取数据行内容
+func InventoryGet(rowId string) string {
+	return "{\"testData\":\"123\",\"name\":\"xiaoming\",\"row_id\":\"" + rowId + "\"}"
+}
+
+// RescaleViewed 守护任务 每5分钟删除排名20000之后的商品 并将浏览次数减半（代码清单2-10）
+func RescaleViewed(ctx context.Context, conn redis.Cmdable) error {
+	ctx = core.WithOp(ctx, "cache.rescale_viewed")
+	for ctx.Err() == nil {
+		pipe := conn.Pipeline()
+		// 删除所有排名在20 000名之后的商品。
+		pipe.ZRemRangeByRank(ctx, "viewed:", 20000, -1)
+		// 将浏览次数降低为原来的一半
+		pipe.ZInterStore(ctx, "viewed:", &redis.ZStore{Weights: []float64{0.5}, Keys: []string{"viewed:"}})
+		if _, err := pipe.Exec(ctx); err != nil {
+			return core.StopErr(ctx, "cache.rescale_viewed", err)
+		}
+		// 5分钟之后再执行这个操作。
+		core.Sleep(Clock, ctx.Done(), 300*time.Second)
+	}
+	return nil
+}
+
+// CanCache 判断页面是否可以被缓存 只有浏览次数排名前10000的商品页面才会被缓存（代码清单2-11）
+func CanCache(ctx context.Context, conn redis.Cmdable, request string) (bool, error) {
+	ctx = core.WithOp(ctx, "cache.can_cache")
+	// 尝试从页面里面取出商品ID。
+	item_id := extract_item_id(request)
+	// 检查这个页面能否被缓存以及这个页面是否为商品页面。
+	if item_id == "" || is_dynamic(request) {
+		return false, nil
+	}
+	// 取得商品的浏览次数排名。
+	rank, err := conn.ZRank(ctx, "viewed:", item_id).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, core.Wrap("cache.can_cache", err)
+	}
+	// 根据商品的浏览次数排名来判断是否需要缓存这个页面。
+	return rank < 10000, nil
+}
+
+//--------------- 以下是辅助函数 --------------------------------
+
+func extract_item_id(request string) string {
+	parsed, _ := url.Parse(request)
+	query, _ := url.ParseQuery(parsed.RawQuery)
+	val, ok := query["item"]
+	if ok {
+		return val[0]
+	}
+	return ""
+}
+
+// 带有_参数（一般是防止浏览器缓存的时间戳）的请求是动态的
+func is_dynamic(request string) bool {
+	parsed, _ := url.Parse(request)
+	query, _ := url.ParseQuery(parsed.RawQuery)
+	_, ok := query["_"]
+	return ok
+}
+
+func hash_request(request string) string {
+	sum := sha256.Sum256([]byte(request))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+	"redis-learn/redis_example_go/sessions"
+)
+
+// 对应TestCh02.test_cache_request
+func TestCacheRequest(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	token := core.NewToken()
+
+	callback := func(request string) string {
+		return "content for " + request
+	}
+	sessions.UpdateToken(ctx, conn, token, "username", "itemX")
+	url := "http://test.com/?item=itemX"
+	result, err := CacheRequest(ctx, conn, url, callback)
+	if err != nil || result != "content for "+url {
+		t.Fatalf("CacheRequest() = %q, %v", result, err)
+	}
+	// 已经缓存的请求不会调用回调函数
+	result2, err := CacheRequest(ctx, conn, url, nil)
+	if err != nil || result2 != result {
+		t.Fatalf("cached CacheRequest() = %q, %v", result2, err)
+	}
+
+	for _, request := range []string{"http://test.com/", "http://test.com/?item=itemX&_=1234536"} {
+		if ok, err := CanCache(ctx, conn, request); ok || err != nil {
+			t.Errorf("CanCache(%q) = %v, %v", request, ok, err)
+		}
+	}
+}
+
+// 对应TestCh02.test_cache_rows
+func TestCacheRows(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if err := ScheduleRowCache(ctx, conn, "itemX", 1); err != nil {
+		t.Fatal(err)
+	}
+	if s := conn.ZRangeWithScores(ctx, "schedule:", 0, -1).Val(); len(s) != 1 {
+		t.Fatalf("schedule = %v", s)
+	}
+
+	cacheCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- CacheRows(cacheCtx, conn) }()
+
+	time.Sleep(500 * time.Millisecond)
+	if r := conn.Get(ctx, "inv:itemX").Val(); r != InventoryGet("itemX") {
+		t.Fatalf("cached row = %q", r)
+	}
+	next := conn.ZScore(ctx, "schedule:", "itemX").Val()
+	if next <= float64(time.Now().Unix()-1) {
+		t.Fatalf("row was not rescheduled: %v", next)
+	}
+
+	ScheduleRowCache(ctx, conn, "itemX", -1)
+	time.Sleep(500 * time.Millisecond)
+	if n := conn.Exists(ctx, "inv:itemX").Val(); n != 0 {
+		t.Fatal("cache was not cleared")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRescaleViewed(t *testing.T) {
+	ctx := context.Background()
+	srv := testutil.NewServer(t)
+	if !srv.Real() {
+		t.Skip("miniredis clears the destination of ZINTERSTORE before reading it")
+	}
+	conn := srv.Client
+	conn.ZIncrBy(ctx, "viewed:", -4, "itemX")
+
+	rescaleCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := RescaleViewed(rescaleCtx, conn); err != nil {
+		t.Fatal(err)
+	}
+	if s := conn.ZScore(ctx, "viewed:", "itemX").Val(); s != -2 {
+		t.Fatalf("viewed score = %v, want -2", s)
+	}
+}
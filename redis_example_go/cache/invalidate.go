@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// 网页缓存的响应用这个响应头声明标签（逗号分隔） 标签不会发送给客户端
+const TagHeader = "Cache-Tag"
+
+// Invalidation 一条失效消息 Keys中包含标签对应的所有键
+// All为true表示订阅刚刚（重新）建立 之前的消息可能丢失 本地副本应该全部清除
+type Invalidation struct {
+	Keys []string `json:"keys,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	All  bool     `json:"-"`
+}
+
+// KEYS: tag:<标签>...  ARGV: 键 缓存时间（毫秒 0表示不过期）
+// 把键加入每个标签的集合 标签集合的过期时间不短于键的过期时间
+var tagScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+for _, tag in ipairs(KEYS) do
+	local existed = redis.call("exists", tag) == 1
+	redis.call("sadd", tag, ARGV[1])
+	local current = redis.call("pttl", tag)
+	if ttl <= 0 then
+		redis.call("persist", tag)
+	elseif not existed or (current >= 0 and current < ttl) then
+		redis.call("pexpire", tag, ttl)
+	end
+end
+return #KEYS`)
+
+// KEYS: tag:<标签>... 键...  ARGV: 频道 标签数量 标签...
+// 键中必须包含标签集合里的所有键 否则说明读取标签之后又有键加上了标签 返回-1
+// 删除标签和所有键 然后发布失效消息 返回删除的键的数量
+var invalidateScript = redis.NewScript(`
+local ntags = tonumber(ARGV[2])
+local keys, declared = {}, {}
+for i = ntags + 1, #KEYS do
+	declared[KEYS[i]] = true
+	table.insert(keys, KEYS[i])
+end
+for i = 1, ntags do
+	for _, key in ipairs(redis.call("smembers", KEYS[i])) do
+		if not declared[key] then
+			return -1
+		end
+	end
+end
+for i = 1, ntags do
+	redis.call("del", KEYS[i])
+end
+local deleted = 0
+for _, key in ipairs(keys) do
+	deleted = deleted + redis.call("del", key)
+end
+local msg = {}
+if #keys > 0 then
+	msg.keys = keys
+end
+if ntags > 0 then
+	msg.tags = {unpack(ARGV, 3, 2 + ntags)}
+end
+redis.call("publish", ARGV[1], cjson.encode(msg))
+return deleted`)
+
+// TagKey 给缓存的键加上标签 按标签失效时会删除这个键 ttl是键的缓存时间
+func TagKey(ctx context.Context, conn redis.Cmdable, key string, ttl time.Duration, tags ...string) error {
+	ctx = core.WithOp(ctx, "cache.tag")
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = "tag:" + tag
+	}
+	err := tagScript.Run(ctx, conn, keys, key, ttl.Milliseconds()).Err()
+	return core.Wrap("cache.tag", err)
+}
+
+// Bus 跨进程的缓存失效总线 写入者用 Invalidate/InvalidateTags 删除redis中的缓存并发布失效消息
+// 每个进程运行 Run 订阅消息 再交给 OnInvalidate 注册的本地缓存
+type Bus struct {
+	Channel string
+
+	conn     redis.UniversalClient
+	mu       sync.Mutex
+	handlers []func(Invalidation)
+}
+
+func NewBus(conn redis.UniversalClient) *Bus {
+	return &Bus{Channel: "invalidate:", conn: conn}
+}
+
+// OnInvalidate 注册收到失效消息时调用的函数 在 Run 的goroutine中调用
+func (b *Bus) OnInvalidate(fn func(Invalidation)) {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, fn)
+	b.mu.Unlock()
+}
+
+func (b *Bus) dispatch(msg Invalidation) {
+	b.mu.Lock()
+	handlers := append([]func(Invalidation){}, b.handlers...)
+	b.mu.Unlock()
+	for _, fn := range handlers {
+		fn(msg)
+	}
+}
+
+// Invalidate 删除缓存的键并通知所有进程 返回删除的键的数量
+func (b *Bus) Invalidate(ctx context.Context, keys ...string) (int64, error) {
+	ctx = core.WithOp(ctx, "cache.invalidate")
+	return b.invalidate(ctx, keys, nil)
+}
+
+// InvalidateTags 删除带有任意一个标签的缓存并通知所有进程 返回删除的键的数量
+func (b *Bus) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	ctx = core.WithOp(ctx, "cache.invalidate")
+	return b.invalidate(ctx, nil, tags)
+}
+
+// Notify 只通知所有进程清除本地副本 不删除redis中的键 用于写入新值之后
+func (b *Bus) Notify(ctx context.Context, keys ...string) error {
+	ctx = core.WithOp(ctx, "cache.invalidate")
+	if len(keys) == 0 {
+		return nil
+	}
+	msg, _ := json.Marshal(Invalidation{Keys: keys})
+	return core.Wrap("cache.invalidate", b.conn.Publish(ctx, b.Channel, msg).Err())
+}
+
+// 标签集合一直在变化 重试多次仍然失败时返回 core.ErrConflict
+func (b *Bus) invalidate(ctx context.Context, keys []string, tags []string) (int64, error) {
+	if len(keys) == 0 && len(tags) == 0 {
+		return 0, nil
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		// 先读出标签对应的键 脚本用到的键都通过KEYS传入 脚本中会检查标签集合没有增加新的键
+		script_keys := make([]string, 0, len(tags)+len(keys))
+		args := []interface{}{b.Channel, len(tags)}
+		pipe := b.conn.Pipeline()
+		members := make([]*redis.StringSliceCmd, len(tags))
+		for i, tag := range tags {
+			script_keys = append(script_keys, "tag:"+tag)
+			args = append(args, tag)
+			members[i] = pipe.SMembers(ctx, "tag:"+tag)
+		}
+		if len(tags) > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return 0, core.Wrap("cache.invalidate", err)
+			}
+		}
+		var tagged []string
+		for _, cmd := range members {
+			tagged = append(tagged, cmd.Val()...)
+		}
+		sort.Strings(tagged)
+		seen := map[string]bool{}
+		for _, key := range append(tagged, keys...) {
+			if !seen[key] {
+				seen[key] = true
+				script_keys = append(script_keys, key)
+			}
+		}
+		deleted, err := invalidateScript.Run(ctx, b.conn, script_keys, args...).Int64()
+		if err != nil {
+			return 0, core.Wrap("cache.invalidate", err)
+		}
+		if deleted >= 0 {
+			return deleted, nil
+		}
+	}
+	return 0, core.Conflict("cache.invalidate", "tags kept changing")
+}
+
+// InvalidateRows 删除缓存的数据行并通知所有进程 仍在调度中的行会被立即重新缓存
+func (b *Bus) InvalidateRows(ctx context.Context, row_ids ...string) error {
+	ctx = core.WithOp(ctx, "cache.invalidate")
+	keys := make([]string, len(row_ids))
+	for i, row_id := range row_ids {
+		keys[i] = "inv:" + row_id
+	}
+	if _, err := b.invalidate(ctx, keys, nil); err != nil {
+		return err
+	}
+	pipe := b.conn.Pipeline()
+	now := unixSeconds(Clock.Now())
+	for _, row_id := range row_ids {
+		pipe.ZAddXX(ctx, "schedule:", &redis.Z{Score: now, Member: row_id})
+	}
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("cache.invalidate", err)
+}
+
+// Run 订阅失效消息直到ctx结束 每次订阅（重新）建立时先发送一条All消息
+func (b *Bus) Run(ctx context.Context) error {
+	ctx = core.WithOp(ctx, "cache.invalidate")
+	pubsub := b.conn.Subscribe(ctx, b.Channel)
+	defer pubsub.Close()
+	// 等待订阅成功 这样连接错误可以直接返回
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return core.StopErr(ctx, "cache.invalidate", err)
+	}
+	b.dispatch(Invalidation{All: true})
+	ch := pubsub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch m := m.(type) {
+			case *redis.Subscription:
+				if m.Kind == "subscribe" {
+					b.dispatch(Invalidation{All: true})
+				}
+			case *redis.Message:
+				var msg Invalidation
+				if err := json.Unmarshal([]byte(m.Payload), &msg); err == nil {
+					b.dispatch(msg)
+				}
+			}
+		}
+	}
+}
+
+// 解析响应中的 Cache-Tag
+func responseTags(header []string) []string {
+	var tags []string
+	for _, v := range header {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
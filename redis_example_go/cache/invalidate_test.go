@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 启动总线 返回收到的失效消息
+func runBus(t *testing.T, ctx context.Context, conn redis.UniversalClient) <-chan Invalidation {
+	t.Helper()
+	msgs := make(chan Invalidation, 10)
+	bus := NewBus(conn)
+	bus.OnInvalidate(func(msg Invalidation) { msgs <- msg })
+	go bus.Run(ctx)
+	select {
+	case msg := <-msgs:
+		if !msg.All {
+			t.Fatalf("first message = %+v, want All", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bus did not subscribe")
+	}
+	return msgs
+}
+
+func receive(t *testing.T, msgs <-chan Invalidation) Invalidation {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		sort.Strings(msg.Keys)
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no invalidation received")
+	}
+	return Invalidation{}
+}
+
+func TestInvalidateTags(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := testutil.NewServer(t).Client
+	// 两个进程各自订阅
+	first, second := runBus(t, ctx, conn), runBus(t, ctx, conn)
+
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Beta = 0
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a", "/b":
+			w.Header().Set(TagHeader, "item:itemX, seller:17")
+		case "/c":
+			w.Header().Set(TagHeader, "item:itemY")
+		}
+		fmt.Fprint(w, r.URL.Path)
+	})))
+	defer srv.Close()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if tags := resp.Header.Get(TagHeader); tags != "" {
+			t.Fatalf("%s sent Cache-Tag %q to the client", path, tags)
+		}
+	}
+	key := func(path string) string {
+		return pc.PageKey(httptest.NewRequest("GET", srv.URL+path, nil))
+	}
+
+	deleted, err := NewBus(conn).InvalidateTags(ctx, "item:itemX")
+	if err != nil || deleted != 2 {
+		t.Fatalf("InvalidateTags() = %d, %v", deleted, err)
+	}
+	want := []string{key("/a"), key("/b")}
+	sort.Strings(want)
+	for _, msgs := range []<-chan Invalidation{first, second} {
+		msg := receive(t, msgs)
+		if fmt.Sprint(msg.Keys) != fmt.Sprint(want) || fmt.Sprint(msg.Tags) != "[item:itemX]" {
+			t.Fatalf("message = %+v, want keys %v", msg, want)
+		}
+	}
+	for path, status := range map[string]string{"/a": "MISS", "/b": "MISS", "/c": "HIT"} {
+		if got, _ := fetch(t, srv.URL+path); got != status {
+			t.Fatalf("%s after invalidation = %s, want %s", path, got, status)
+		}
+	}
+	// 标签集合跟随页面过期
+	if ttl := conn.PTTL(ctx, "tag:seller:17").Val(); ttl <= 0 || ttl > pc.TTL {
+		t.Fatalf("tag ttl = %v", ttl)
+	}
+
+	// 按键失效
+	if deleted, err := NewBus(conn).Invalidate(ctx, key("/c"), "cache:missing"); err != nil || deleted != 1 {
+		t.Fatalf("Invalidate() = %d, %v", deleted, err)
+	}
+	if msg := receive(t, first); len(msg.Keys) != 2 || msg.Tags != nil {
+		t.Fatalf("message = %+v", msg)
+	}
+}
+
+func TestInvalidateRows(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+	msgs := runBus(t, ctx, conn)
+
+	c := NewRowCacher(conn, RowLoaderFunc(func(ctx context.Context, row_id string) (string, error) {
+		return "row " + row_id, nil
+	}))
+	ScheduleRowCache(ctx, conn, "itemX", 60)
+	c.CacheOne(ctx)
+	clock.Advance(10 * time.Second)
+
+	if err := NewBus(conn).InvalidateRows(ctx, "itemX", "itemY"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, msgs); fmt.Sprint(msg.Keys) != "[inv:itemX inv:itemY]" {
+		t.Fatalf("message = %+v", msg)
+	}
+	if conn.Exists(ctx, "inv:itemX").Val() != 0 {
+		t.Fatal("row still cached")
+	}
+	// 仍在调度中的行立即重新缓存 没有调度的行不会加入调度
+	if next := conn.ZScore(ctx, "schedule:", "itemX").Val(); next != 1600000010 {
+		t.Fatalf("next schedule = %v", next)
+	}
+	if conn.ZScore(ctx, "schedule:", "itemY").Err() != redis.Nil {
+		t.Fatal("unscheduled row was scheduled")
+	}
+	c.CacheOne(ctx)
+	if conn.Get(ctx, "inv:itemX").Val() != "row itemX" {
+		t.Fatal("row not cached again")
+	}
+}
@@ -0,0 +1,381 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/locks"
+)
+
+// PageCacheStats 网页缓存的统计信息
+type PageCacheStats struct {
+	Hits      int64 // 直接使用缓存的请求
+	Misses    int64 // 缓存中没有 重新生成的请求
+	Bypassed  int64 // 不能缓存的请求
+	Refreshes int64 // 缓存过期之前提前重新生成的次数
+	LockWaits int64 // 等待其他请求生成页面的次数
+	Stored    int64 // 写入缓存的页面数量
+}
+
+// PageCache net/http的网页缓存中间件 页面压缩之后保存在 cache:<哈希> 中
+// 键是请求方法、规范化的URL和Vary中的请求头的SHA-256 只缓存GET和HEAD请求的200响应
+// 缓存时间取响应的 Cache-Control: s-maxage/max-age 没有时为TTL 响应带有no-store、private或者Set-Cookie时不缓存
+// 响应的Vary为*或者包含不在Vary中的请求头时 同一个键对应不同的页面 也不缓存
+// 请求带有 Cache-Control: no-cache 时跳过缓存重新生成 no-store 时既不读也不写缓存
+// 请求带有不在Vary中的Authorization或Cookie时 页面可能是为这个用户生成的 不读缓存
+// 只有响应带有public或者s-maxage时才写入缓存
+//
+// 响应可以用 Cache-Tag 响应头给页面加上标签（比如页面中出现的商品） Bus.InvalidateTags 会删除带有标签的所有页面
+//
+// 防止缓存击穿：缓存过期之前按XFetch算法随机地提前重新生成（Beta越大越早） 缓存中没有页面时
+// 只有拿到 lock:cache:<哈希> 锁的请求生成页面 其他请求最多等待LockTimeout
+type PageCache struct {
+	TTL         time.Duration
+	Vary        []string
+	LockTimeout time.Duration
+	Beta        float64
+	// 判断请求是否可以缓存 默认使用 CanCache（浏览次数排名前10000的商品页面）
+	CanCache func(ctx context.Context, conn redis.Cmdable, r *http.Request) (bool, error)
+
+	conn  redis.UniversalClient
+	mu    sync.Mutex
+	stats PageCacheStats
+}
+
+func NewPageCache(conn redis.UniversalClient) *PageCache {
+	return &PageCache{
+		TTL:         300 * time.Second,
+		LockTimeout: 5 * time.Second,
+		Beta:        1,
+		CanCache: func(ctx context.Context, conn redis.Cmdable, r *http.Request) (bool, error) {
+			return CanCache(ctx, conn, r.URL.String())
+		},
+		conn: conn,
+	}
+}
+
+// 缓存的页面
+type pageEntry struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Expires int64       `json:"expires"` // 过期时间（unix毫秒）
+	Delta   int64       `json:"delta"`   // 生成页面用了多少毫秒
+}
+
+// Stats 返回统计信息
+func (c *PageCache) Stats() PageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *PageCache) count(field *int64) {
+	c.mu.Lock()
+	*field++
+	c.mu.Unlock()
+}
+
+// PageKey 返回请求在缓存中的键
+func (c *PageCache) PageKey(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + "\n" + normalizeURL(r.Host, r.URL) + "\n"))
+	for _, name := range c.Vary {
+		name = http.CanonicalHeaderKey(name)
+		h.Write([]byte(name + ":" + strings.Join(r.Header[name], ",") + "\n"))
+	}
+	return "cache:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// 规范化URL：主机名小写 查询参数按名字排序 去掉协议和片段
+func normalizeURL(host string, u *url.URL) string {
+	if host == "" {
+		host = u.Host
+	}
+	n := url.URL{Host: strings.ToLower(host), Path: u.EscapedPath()}
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	n.RawQuery = u.Query().Encode()
+	return n.String()
+}
+
+// 解析Cache-Control中的指令
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, v := range header["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(strings.ToLower(d))
+			if d == "" {
+				continue
+			}
+			kv := strings.SplitN(d, "=", 2)
+			if len(kv) == 2 {
+				directives[kv[0]] = strings.Trim(kv[1], `"`)
+			} else {
+				directives[kv[0]] = ""
+			}
+		}
+	}
+	return directives
+}
+
+// Middleware 返回带缓存的handler
+func (c *PageCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := core.WithOp(r.Context(), "cache.page")
+		cc := cacheControl(r.Header)
+		_, noStore := cc["no-store"]
+		_, noCache := cc["no-cache"]
+		if (r.Method != "GET" && r.Method != "HEAD") || noStore {
+			c.count(&c.stats.Bypassed)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, err := c.CanCache(ctx, c.conn, r); err != nil || !ok {
+			c.count(&c.stats.Bypassed)
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.PageKey(r)
+		credentialed := c.credentialed(r)
+		if !noCache && !credentialed {
+			entry, err := c.load(ctx, key)
+			if err == nil && entry != nil {
+				// 没有提前刷新或者其他请求正在刷新时直接返回缓存
+				var locked string
+				if c.refreshEarly(entry) {
+					if locked, err = c.lock(ctx, key); err != nil {
+						c.count(&c.stats.Bypassed)
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+				if locked == "" {
+					c.count(&c.stats.Hits)
+					c.serve(w, entry, "HIT")
+					return
+				}
+				c.count(&c.stats.Refreshes)
+				defer c.unlock(key, locked)
+				c.generate(w, r, next, key, "REFRESH", false)
+				return
+			}
+			// 缓存中没有页面 只让一个请求生成 其他请求等待它写入缓存
+			// 拿锁出错时多半是redis不可用 不再等待 直接由handler生成
+			locked, err := c.lock(ctx, key)
+			if err != nil {
+				c.count(&c.stats.Bypassed)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if locked != "" {
+				defer c.unlock(key, locked)
+			} else if entry := c.wait(ctx, key); entry != nil {
+				c.count(&c.stats.Hits)
+				c.serve(w, entry, "HIT")
+				return
+			}
+		}
+		c.count(&c.stats.Misses)
+		c.generate(w, r, next, key, "MISS", credentialed)
+	})
+}
+
+// 读取缓存的页面 不存在时返回nil
+func (c *PageCache) load(ctx context.Context, key string) (*pageEntry, error) {
+	data, err := c.conn.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, core.Wrap("cache.page", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var entry pageEntry
+	if err := json.NewDecoder(zr).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// XFetch：当 now - delta*beta*ln(rand) >= 过期时间 时提前重新生成
+func (c *PageCache) refreshEarly(entry *pageEntry) bool {
+	if c.Beta <= 0 {
+		return false
+	}
+	now := Clock.Now().UnixNano() / 1e6
+	return float64(now)-float64(entry.Delta)*c.Beta*math.Log(rand.Float64()) >= float64(entry.Expires)
+}
+
+// 尝试获取生成页面的锁 只尝试一次 被其他请求持有时返回空字符串
+func (c *PageCache) lock(ctx context.Context, key string) (string, error) {
+	return locks.AcquireLockWithTimeout(ctx, c.conn, key, time.Millisecond, c.LockTimeout)
+}
+
+func (c *PageCache) unlock(key string, locked string) {
+	locks.ReleaseLock(context.Background(), c.conn, key, locked)
+}
+
+// 等待持有锁的请求把页面写入缓存 锁被释放或者超时后返回缓存中的页面（可能为nil）
+func (c *PageCache) wait(ctx context.Context, key string) *pageEntry {
+	c.count(&c.stats.LockWaits)
+	deadline := Clock.Now().Add(c.LockTimeout)
+	for Clock.Now().Before(deadline) && ctx.Err() == nil {
+		core.Sleep(Clock, ctx.Done(), 20*time.Millisecond)
+		if entry, err := c.load(ctx, key); err == nil && entry != nil {
+			return entry
+		}
+		if c.conn.Exists(ctx, "lock:"+key).Val() == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *PageCache) serve(w http.ResponseWriter, entry *pageEntry, status string) {
+	for k, v := range entry.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", status)
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// 记录handler的响应
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// 请求是否带有不在Vary中（没有包含在键里）的身份信息
+func (c *PageCache) credentialed(r *http.Request) bool {
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if len(r.Header[name]) > 0 && !c.varies(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 生成页面 可以缓存时写入缓存 然后返回给客户端 credentialed见 PageCache.credentialed
+func (c *PageCache) generate(w http.ResponseWriter, r *http.Request, next http.Handler, key string, status string, credentialed bool) {
+	start := Clock.Now()
+	rec := &recorder{header: http.Header{}}
+	next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	tags := responseTags(rec.header[TagHeader])
+	delete(rec.header, TagHeader)
+	entry := &pageEntry{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+	if ttl, ok := c.responseTTL(rec, credentialed); ok {
+		now := Clock.Now()
+		entry.Delta = int64(now.Sub(start) / time.Millisecond)
+		entry.Expires = now.Add(ttl).UnixNano() / 1e6
+		if err := c.store(r.Context(), key, entry, ttl); err == nil {
+			c.count(&c.stats.Stored)
+			TagKey(r.Context(), c.conn, key, ttl, tags...)
+		}
+	}
+	c.serve(w, entry, status)
+}
+
+// 响应的缓存时间 不能缓存时返回false
+func (c *PageCache) responseTTL(rec *recorder, credentialed bool) (time.Duration, bool) {
+	if rec.status != http.StatusOK || len(rec.header["Set-Cookie"]) > 0 {
+		return 0, false
+	}
+	if !c.covers(rec.header["Vary"]) {
+		return 0, false
+	}
+	cc := cacheControl(rec.header)
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := cc[d]; ok {
+			return 0, false
+		}
+	}
+	// 带有身份信息的请求的响应只有明确允许共享时才缓存
+	_, public := cc["public"]
+	_, shared := cc["s-maxage"]
+	if credentialed && !public && !shared {
+		return 0, false
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, false
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	return c.TTL, c.TTL > 0
+}
+
+// 响应的Vary中的请求头是否都已经包含在键里
+func (c *PageCache) covers(vary []string) bool {
+	for _, v := range vary {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if name == "*" || !c.varies(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *PageCache) varies(name string) bool {
+	for _, v := range c.Vary {
+		if http.CanonicalHeaderKey(v) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *PageCache) store(ctx context.Context, key string, entry *pageEntry, ttl time.Duration) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(entry); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return core.Wrap("cache.page", c.conn.Set(ctx, key, buf.Bytes(), ttl).Err())
+}
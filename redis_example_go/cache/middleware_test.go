@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+	"redis-learn/redis_example_go/sessions"
+)
+
+func alwaysCache(ctx context.Context, conn redis.Cmdable, r *http.Request) (bool, error) {
+	return true, nil
+}
+
+// 发送GET请求 返回X-Cache和响应体
+func fetch(t *testing.T, url string, header ...string) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.Header.Get("X-Cache"), string(body)
+}
+
+func TestPageCache(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	var calls int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&calls, 1)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "page %d %s", n, r.Header.Get("Accept-Language"))
+	})
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Vary = []string{"Accept-Language"}
+	pc.Beta = 0
+	srv := httptest.NewServer(pc.Middleware(handler))
+	defer srv.Close()
+
+	if status, body := fetch(t, srv.URL+"/item?a=1&b=2"); status != "MISS" || body != "page 1 " {
+		t.Fatalf("first request = %s %q", status, body)
+	}
+	// 查询参数的顺序不影响缓存
+	if status, body := fetch(t, srv.URL+"/item?b=2&a=1"); status != "HIT" || body != "page 1 " {
+		t.Fatalf("second request = %s %q", status, body)
+	}
+	// Vary中的请求头不同时分别缓存
+	if status, _ := fetch(t, srv.URL+"/item?a=1&b=2", "Accept-Language", "zh"); status != "MISS" {
+		t.Fatalf("request with another language = %s", status)
+	}
+	// 请求要求重新生成
+	if status, body := fetch(t, srv.URL+"/item?a=1&b=2", "Cache-Control", "no-cache"); status != "MISS" || body != "page 3 " {
+		t.Fatalf("no-cache request = %s %q", status, body)
+	}
+	if _, body := fetch(t, srv.URL+"/item?a=1&b=2"); body != "page 3 " {
+		t.Fatalf("cache after no-cache request = %q", body)
+	}
+	// 响应不允许缓存
+	fetch(t, srv.URL+"/private")
+	if status, _ := fetch(t, srv.URL+"/private"); status != "MISS" {
+		t.Fatalf("private response cached: %s", status)
+	}
+	s := pc.Stats()
+	if s.Hits != 2 || s.Misses != 5 || s.Stored != 3 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+	// 页面压缩保存
+	data := conn.Get(ctx, pc.PageKey(httptest.NewRequest("GET", srv.URL+"/item?a=1&b=2", nil))).Val()
+	if !strings.HasPrefix(data, "\x1f\x8b") {
+		t.Fatalf("cached page is not gzip compressed: %q", data)
+	}
+}
+
+func TestPageCacheVary(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", strings.TrimPrefix(r.URL.Path, "/"))
+		fmt.Fprint(w, "page")
+	})
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Vary = []string{"Accept-Language"}
+	pc.Beta = 0
+	srv := httptest.NewServer(pc.Middleware(handler))
+	defer srv.Close()
+
+	// 只有Vary中的请求头都在键里时才缓存
+	for path, want := range map[string]string{"/accept-language": "HIT", "/*": "MISS", "/cookie": "MISS", "/accept-language,user-agent": "MISS"} {
+		fetch(t, srv.URL+path)
+		if status, _ := fetch(t, srv.URL+path); status != want {
+			t.Errorf("second request with Vary: %s = %s, want %s", path[1:], status, want)
+		}
+	}
+}
+
+func TestPageCacheDefaultPolicy(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	pc := NewPageCache(conn)
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "item page")
+	})))
+	defer srv.Close()
+
+	sessions.UpdateToken(ctx, conn, core.NewToken(), "username", "itemX")
+	for _, path := range []string{"/?item=itemX", "/?item=itemX"} {
+		fetch(t, srv.URL+path)
+	}
+	fetch(t, srv.URL+"/?item=itemY")
+	fetch(t, srv.URL+"/")
+	if s := pc.Stats(); s.Hits != 1 || s.Misses != 1 || s.Bypassed != 2 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+}
+
+func TestPageCacheStampede(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	var calls int64
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Beta = 0
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "slow page")
+	})))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, body := fetch(t, srv.URL+"/slow"); body != "slow page" {
+				t.Errorf("body = %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("page generated %d times, want 1", calls)
+	}
+	if s := pc.Stats(); s.LockWaits != 9 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+}
+
+func TestPageCacheEarlyRefresh(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Now())
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	var calls int64
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Beta = 1e6
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		// 生成页面用了100毫秒
+		clock.Advance(100 * time.Millisecond)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "page")
+	})))
+	defer srv.Close()
+
+	fetch(t, srv.URL+"/")
+	// 快要过期时提前重新生成
+	clock.Advance(59 * time.Second)
+	if status, _ := fetch(t, srv.URL+"/"); status != "REFRESH" || calls != 2 {
+		t.Fatalf("request near expiry = %s, %d calls", status, calls)
+	}
+	if s := pc.Stats(); s.Refreshes != 1 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+}
+
+// 带有身份信息的请求不读缓存 它们的响应只有明确允许共享时才写入缓存
+func TestPageCacheCredentials(t *testing.T) {
+	conn := testutil.NewServer(t).Client
+	var calls int64
+	pc := NewPageCache(conn)
+	pc.CanCache = alwaysCache
+	pc.Beta = 0
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&calls, 1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		fmt.Fprintf(w, "page %d", n)
+	})))
+	defer srv.Close()
+
+	fetch(t, srv.URL+"/plain")
+	if status, body := fetch(t, srv.URL+"/plain", "Cookie", "session=abc"); status != "MISS" || body != "page 2" {
+		t.Fatalf("request with a cookie = %s %q", status, body)
+	}
+	if status, body := fetch(t, srv.URL+"/plain"); status != "HIT" || body != "page 1" {
+		t.Fatalf("anonymous request = %s %q", status, body)
+	}
+	fetch(t, srv.URL+"/other", "Authorization", "Bearer abc")
+	if status, _ := fetch(t, srv.URL+"/other"); status != "MISS" {
+		t.Fatalf("response to an authorized request was cached: %s", status)
+	}
+	fetch(t, srv.URL+"/public", "Authorization", "Bearer abc")
+	if status, _ := fetch(t, srv.URL+"/public"); status != "HIT" {
+		t.Fatalf("public response to an authorized request = %s, want HIT", status)
+	}
+
+	// Cookie在Vary中时每个Cookie分别缓存
+	pc.Vary = []string{"Cookie"}
+	fetch(t, srv.URL+"/plain", "Cookie", "session=abc")
+	if status, _ := fetch(t, srv.URL+"/plain", "Cookie", "session=abc"); status != "HIT" {
+		t.Fatalf("request with a cookie in Vary = %s, want HIT", status)
+	}
+}
+
+// redis不可用时不等待锁 直接由handler生成页面
+func TestPageCacheRedisDown(t *testing.T) {
+	s := testutil.NewServer(t)
+	pc := NewPageCache(s.Client)
+	pc.CanCache = alwaysCache
+	pc.LockTimeout = 10 * time.Second
+	srv := httptest.NewServer(pc.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page")
+	})))
+	defer srv.Close()
+	s.Close()
+
+	start := time.Now()
+	if _, body := fetch(t, srv.URL+"/"); body != "page" {
+		t.Fatalf("body = %q", body)
+	}
+	if d := time.Since(start); d > pc.LockTimeout/2 {
+		t.Fatalf("request took %v", d)
+	}
+	if st := pc.Stats(); st.Bypassed != 1 || st.LockWaits != 0 {
+		t.Fatalf("Stats() = %+v", st)
+	}
+}
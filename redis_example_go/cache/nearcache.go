@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// NearCacheStats 本地缓存的统计信息
+type NearCacheStats struct {
+	Hits          int64 // 本地命中
+	Misses        int64 // 从redis读取
+	Evictions     int64 // 超过MaxEntries被淘汰的条目
+	Invalidations int64 // 收到失效通知被清除的条目
+	Flushes       int64 // 清空本地缓存的次数（订阅建立或断开、FLUSHDB之后）
+	Entries       int   // 当前的条目数量
+}
+
+// NearCache 在redis前面的进程内LRU缓存 适合 inv:<row_id>、cache:<哈希> 这样读多写少的键
+//
+// Run 优先使用 CLIENT TRACKING 的广播模式：一条订阅了 __redis__:invalidate 的连接接收通知，
+// 另一条专用连接打开 CLIENT TRACKING on REDIRECT <订阅连接id> BCAST PREFIX <Prefixes>，
+// 任何客户端修改或删除这些前缀的键时redis都会通知。服务器不支持时退回到 Bus 的pub/sub，
+// 此时写入者必须通过 Set 或者 Bus 修改键才能让其他进程的本地副本失效。
+//
+// 只有在订阅成功之后读取的值才会保存在本地 读取期间收到失效通知的值不会保存
+// 订阅断开时清空本地缓存 重新订阅成功之前不保存新的值
+// FLUSHDB/FLUSHALL 之后redis发送内容为空的失效通知 此时同样清空本地缓存
+type NearCache struct {
+	MaxEntries int
+	TTL        time.Duration
+	Prefixes   []string
+	// 不支持CLIENT TRACKING时使用的失效总线
+	Bus *Bus
+
+	conn     *redis.Client
+	tracking int32
+	ready    int32
+
+	mu          sync.Mutex
+	lru         *list.List
+	items       map[string]*list.Element
+	epoch       uint64
+	flushed     uint64
+	inflight    map[string]int
+	invalidated map[string]uint64
+	stats       NearCacheStats
+}
+
+type nearEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+func NewNearCache(conn *redis.Client) *NearCache {
+	c := &NearCache{
+		MaxEntries:  10000,
+		TTL:         time.Minute,
+		Prefixes:    []string{"inv:", "cache:"},
+		Bus:         NewBus(conn),
+		conn:        conn,
+		lru:         list.New(),
+		items:       map[string]*list.Element{},
+		inflight:    map[string]int{},
+		invalidated: map[string]uint64{},
+	}
+	c.Bus.OnInvalidate(c.handle)
+	return c
+}
+
+// Tracking 是否在使用CLIENT TRACKING接收失效通知
+func (c *NearCache) Tracking() bool {
+	return atomic.LoadInt32(&c.tracking) == 1
+}
+
+// Stats 返回统计信息
+func (c *NearCache) Stats() NearCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// Get 读取键 本地有未过期的副本时直接返回 键不存在时返回 core.ErrNotFound
+func (c *NearCache) Get(ctx context.Context, key string) (string, error) {
+	ctx = core.WithOp(ctx, "cache.near_get")
+	now := Clock.Now()
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*nearEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return entry.value, nil
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	c.inflight[key]++
+	start := c.epoch
+	c.mu.Unlock()
+
+	value, err := c.conn.Get(ctx, key).Result()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stale := c.flushed > start || c.invalidated[key] > start
+	if c.inflight[key]--; c.inflight[key] == 0 {
+		delete(c.inflight, key)
+		delete(c.invalidated, key)
+	}
+	if err == redis.Nil {
+		return "", core.NotFound("cache.near_get", "key "+key)
+	} else if err != nil {
+		return "", core.Wrap("cache.near_get", err)
+	}
+	if !stale && atomic.LoadInt32(&c.ready) == 1 && c.tracked(key) {
+		c.store(key, value, now.Add(c.TTL))
+	}
+	return value, nil
+}
+
+// Set 写入redis并让所有进程的本地副本失效 ttl为0时不过期
+func (c *NearCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	ctx = core.WithOp(ctx, "cache.near_set")
+	if err := c.conn.Set(ctx, key, value, ttl).Err(); err != nil {
+		return core.Wrap("cache.near_set", err)
+	}
+	c.handle(Invalidation{Keys: []string{key}})
+	if c.Tracking() {
+		return nil
+	}
+	return c.Bus.Notify(ctx, key)
+}
+
+// 只缓存Prefixes中的键 CLIENT TRACKING只会通知这些键
+func (c *NearCache) tracked(key string) bool {
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *NearCache) store(key string, value string, expires time.Time) {
+	if c.MaxEntries <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = &nearEntry{key, value, expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&nearEntry{key, value, expires})
+	for c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *NearCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*nearEntry).key)
+}
+
+// 处理失效通知
+func (c *NearCache) handle(msg Invalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	if msg.All {
+		c.flush(true)
+		return
+	}
+	for _, key := range msg.Keys {
+		if c.inflight[key] > 0 {
+			c.invalidated[key] = c.epoch
+		}
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// 清空本地缓存 ready表示之后读取的值是否可以保存在本地 调用时必须持有c.mu
+func (c *NearCache) flush(ready bool) {
+	c.flushed = c.epoch
+	c.lru.Init()
+	c.items = map[string]*list.Element{}
+	c.stats.Flushes++
+	if ready {
+		atomic.StoreInt32(&c.ready, 1)
+	} else {
+		atomic.StoreInt32(&c.ready, 0)
+	}
+}
+
+// Run 接收失效通知直到ctx结束 在此之前Get不会在本地保存任何值
+func (c *NearCache) Run(ctx context.Context) error {
+	ctx = core.WithOp(ctx, "cache.near")
+	defer atomic.StoreInt32(&c.ready, 0)
+	err := c.track(ctx)
+	if err != errNoTracking {
+		return err
+	}
+	return c.Bus.Run(ctx)
+}
+
+var errNoTracking = errors.New("cache: client tracking not supported")
+
+// 使用CLIENT TRACKING接收通知 服务器不支持时返回errNoTracking
+func (c *NearCache) track(ctx context.Context) error {
+	// 订阅连接只用来接收通知 建立连接时记下它的id
+	var client_id int64
+	opts := *c.conn.Options()
+	opts.PoolSize = 1
+	onConnect := opts.OnConnect
+	opts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		if onConnect != nil {
+			if err := onConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		id, _ := cn.ClientID(ctx).Result()
+		atomic.StoreInt64(&client_id, id)
+		return nil
+	}
+	sub := redis.NewClient(&opts)
+	defer sub.Close()
+	pubsub := sub.Subscribe(ctx, "__redis__:invalidate")
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return core.StopErr(ctx, "cache.near", err)
+	}
+
+	var tracker *redis.Conn
+	defer func() {
+		if tracker != nil {
+			tracker.Close()
+		}
+	}()
+	// 每次订阅（重新）建立时打开跟踪 并清空可能错过通知的本地缓存
+	enable := func() error {
+		if tracker != nil {
+			tracker.Close()
+		}
+		tracker = c.conn.Conn(ctx)
+		id := atomic.LoadInt64(&client_id)
+		if id == 0 {
+			return errNoTracking
+		}
+		args := []interface{}{"client", "tracking", "on", "redirect", id, "bcast"}
+		for _, prefix := range c.Prefixes {
+			args = append(args, "prefix", prefix)
+		}
+		if err := tracker.Process(ctx, redis.NewCmd(ctx, args...)); err != nil {
+			if strings.HasPrefix(err.Error(), "ERR") {
+				return errNoTracking
+			}
+			return core.StopErr(ctx, "cache.near", err)
+		}
+		atomic.StoreInt32(&c.tracking, 1)
+		c.handle(Invalidation{All: true})
+		return nil
+	}
+	if err := enable(); err != nil {
+		return err
+	}
+	defer atomic.StoreInt32(&c.tracking, 0)
+
+	// 不使用ChannelWithSubscriptions：它会丢掉接收出错的消息 包括FLUSHDB之后的空通知
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, time.Minute)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// 一分钟没有通知时检查订阅连接 连接断开时下一次接收会重新订阅
+			pubsub.Ping(ctx)
+			continue
+		}
+		if c.receive(msg, err) {
+			if err := enable(); err != nil {
+				return err
+			}
+		} else if err != nil && !isFlushNotice(err) {
+			core.Sleep(Clock, ctx.Done(), 100*time.Millisecond)
+		}
+	}
+}
+
+// 处理订阅连接收到的一条消息 返回订阅是否刚刚（重新）建立 此时需要重新打开跟踪
+func (c *NearCache) receive(msg interface{}, err error) bool {
+	if err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.epoch++
+		// 空通知表示所有键都失效了 其他错误说明连接可能已经断开 期间的通知可能丢失
+		c.flush(isFlushNotice(err))
+		return false
+	}
+	switch msg := msg.(type) {
+	case *redis.Subscription:
+		if msg.Kind == "subscribe" {
+			atomic.StoreInt32(&c.ready, 0)
+			return true
+		}
+	case *redis.Message:
+		c.handle(Invalidation{Keys: msg.PayloadSlice})
+	}
+	return false
+}
+
+// FLUSHDB/FLUSHALL 之后的失效通知内容为空 go-redis把它当作错误返回
+func isFlushNotice(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "redis: unsupported pubsub message payload: <nil>")
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 启动本地缓存 等到订阅成功
+func runNearCache(t *testing.T, ctx context.Context, conn *redis.Client) *NearCache {
+	t.Helper()
+	c := NewNearCache(conn)
+	go c.Run(ctx)
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt32(&c.ready) == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("near cache did not subscribe")
+		}
+	}
+	return c
+}
+
+// 等到读到want 返回之前读到旧值的次数
+func waitFor(t *testing.T, ctx context.Context, c *NearCache, key string, want string) int {
+	t.Helper()
+	stale := 0
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		got, err := c.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == want {
+			return stale
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get(%s) still returns %q, want %q", key, got, want)
+		}
+		stale++
+	}
+}
+
+func TestNearCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := testutil.NewServer(t)
+	conn := s.Client
+	// 两个进程各自的本地缓存
+	a, b := runNearCache(t, ctx, conn), runNearCache(t, ctx, conn)
+	if a.Tracking() != s.Real() {
+		t.Fatalf("Tracking() = %v with a real server = %v", a.Tracking(), s.Real())
+	}
+
+	conn.Set(ctx, "inv:itemX", "v1", 0)
+	for _, c := range []*NearCache{a, a, b} {
+		if v, err := c.Get(ctx, "inv:itemX"); v != "v1" || err != nil {
+			t.Fatalf("Get() = %q, %v", v, err)
+		}
+	}
+	if s := a.Stats(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+
+	// 写入之后 写入者立即读到新值 其他进程很快停止读到旧值
+	if err := a.Set(ctx, "inv:itemX", "v2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := a.Get(ctx, "inv:itemX"); v != "v2" {
+		t.Fatalf("writer read %q after Set", v)
+	}
+	waitFor(t, ctx, b, "inv:itemX", "v2")
+	for i := 0; i < 10; i++ {
+		if v, _ := b.Get(ctx, "inv:itemX"); v != "v2" {
+			t.Fatalf("stale read %q after invalidation", v)
+		}
+	}
+
+	// 通过总线删除
+	if _, err := NewBus(conn).Invalidate(ctx, "inv:itemX"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, err := b.Get(ctx, "inv:itemX"); errors.Is(err, core.ErrNotFound) {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("deleted key still cached")
+		}
+	}
+
+	// CLIENT TRACKING能发现其他客户端直接写入的键
+	if s.Real() {
+		conn.Set(ctx, "cache:page", "old", 0)
+		b.Get(ctx, "cache:page")
+		conn.Set(ctx, "cache:page", "new", 0)
+		waitFor(t, ctx, b, "cache:page", "new")
+	}
+}
+
+func TestNearCacheLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	for _, key := range []string{"inv:a", "inv:b", "inv:c", "other"} {
+		conn.Set(ctx, key, key, 0)
+	}
+	// 订阅之前不保存本地副本
+	c := NewNearCache(conn)
+	c.Get(ctx, "inv:a")
+	if c.Stats().Entries != 0 {
+		t.Fatal("value cached before subscribing")
+	}
+	go c.Run(ctx)
+	for atomic.LoadInt32(&c.ready) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.MaxEntries = 2
+	c.TTL = 10 * time.Second
+
+	for _, key := range []string{"inv:a", "inv:b", "inv:a", "inv:c", "other"} {
+		c.Get(ctx, key)
+	}
+	// inv:b最久没有使用 被淘汰 other不在Prefixes中
+	if s := c.Stats(); s.Entries != 2 || s.Evictions != 1 || s.Hits != 1 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+	c.Get(ctx, "inv:a")
+	c.Get(ctx, "inv:c")
+	if s := c.Stats(); s.Hits != 3 {
+		t.Fatalf("Stats() = %+v", s)
+	}
+	// 本地副本过期
+	clock.Advance(10 * time.Second)
+	c.Get(ctx, "inv:a")
+	if s := c.Stats(); s.Hits != 3 || s.Misses != 6 {
+		t.Fatalf("Stats() after expiry = %+v", s)
+	}
+}
+
+// 在读取命令完成之后调用after
+type afterHook struct{ after func(redis.Cmder) }
+
+func (h afterHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h afterHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(cmd)
+	return nil
+}
+
+func (h afterHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h afterHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// 读取期间收到的失效通知 读到的值不会保存在本地
+func TestNearCacheInvalidatedDuringRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := testutil.NewServer(t)
+	conn := redis.NewClient(s.Client.Options())
+	defer conn.Close()
+	c := runNearCache(t, ctx, conn)
+	conn.AddHook(afterHook{func(cmd redis.Cmder) {
+		if cmd.Name() == "get" {
+			c.handle(Invalidation{Keys: []string{"inv:itemX"}})
+		}
+	}})
+
+	conn.Set(ctx, "inv:itemX", "v1", 0)
+	if v, _ := c.Get(ctx, "inv:itemX"); v != "v1" {
+		t.Fatalf("Get() = %q", v)
+	}
+	if s := c.Stats(); s.Entries != 0 {
+		t.Fatalf("value read during invalidation was cached: %+v", s)
+	}
+	if len(c.inflight) != 0 || len(c.invalidated) != 0 {
+		t.Fatalf("leaked read state: %v %v", c.inflight, c.invalidated)
+	}
+}
+
+// FLUSHDB之后的空通知和连接错误都会清空本地缓存 连接错误之后直到重新订阅都不保存新的值
+func TestNearCacheReceive(t *testing.T) {
+	c := NewNearCache(testutil.NewServer(t).Client)
+	fill := func() {
+		c.handle(Invalidation{All: true})
+		c.mu.Lock()
+		c.store("inv:a", "1", time.Now().Add(time.Minute))
+		c.store("inv:b", "2", time.Now().Add(time.Minute))
+		c.mu.Unlock()
+	}
+
+	fill()
+	if c.receive(&redis.Message{Channel: "__redis__:invalidate", PayloadSlice: []string{"inv:a"}}, nil) {
+		t.Fatal("invalidation reported as a new subscription")
+	}
+	if s := c.Stats(); s.Entries != 1 || s.Invalidations != 1 {
+		t.Fatalf("Stats() after invalidating a key = %+v", s)
+	}
+
+	fill()
+	c.receive(nil, errors.New("redis: unsupported pubsub message payload: <nil>"))
+	if s := c.Stats(); s.Entries != 0 || atomic.LoadInt32(&c.ready) != 1 {
+		t.Fatalf("Stats() after FLUSHDB = %+v, ready %d", s, c.ready)
+	}
+
+	fill()
+	c.receive(nil, errors.New("read tcp: connection reset by peer"))
+	if s := c.Stats(); s.Entries != 0 || atomic.LoadInt32(&c.ready) != 0 {
+		t.Fatalf("Stats() after a connection error = %+v, ready %d", s, c.ready)
+	}
+	if !c.receive(&redis.Subscription{Kind: "subscribe", Channel: "__redis__:invalidate", Count: 1}, nil) {
+		t.Fatal("resubscription not reported")
+	}
+}
+
+// 真正的redis-server在FLUSHALL之后发送空的失效通知
+func TestNearCacheFlushAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := testutil.NewServer(t)
+	if !s.Real() {
+		t.Skip("miniredis does not support CLIENT TRACKING")
+	}
+	// 订阅连接同样执行调用者的OnConnect
+	opts := *s.Client.Options()
+	opts.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		return cn.ClientSetName(ctx, "near-test").Err()
+	}
+	conn := redis.NewClient(&opts)
+	defer conn.Close()
+	c := runNearCache(t, ctx, conn)
+	named := false
+	for _, line := range strings.Split(conn.ClientList(ctx).Val(), "\n") {
+		if strings.Contains(line, " sub=1 ") {
+			named = strings.Contains(line, " name=near-test ")
+		}
+	}
+	if !named {
+		t.Fatal("caller's OnConnect was not run on the subscription connection")
+	}
+	conn.Set(ctx, "inv:itemX", "v1", 0)
+	c.Get(ctx, "inv:itemX")
+	conn.FlushAll(ctx)
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, err := c.Get(ctx, "inv:itemX"); errors.Is(err, core.ErrNotFound) {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("flushed key still cached")
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// RowLoader 从数据源读取需要缓存的数据行 返回缓存在inv:<row_id>中的内容
+// 数据行已经不存在时返回 core.ErrNotFound 对应的缓存会被清除
+type RowLoader interface {
+	LoadRow(ctx context.Context, row_id string) (string, error)
+}
+
+// RowLoaderFunc 把函数适配成RowLoader
+type RowLoaderFunc func(ctx context.Context, row_id string) (string, error)
+
+func (f RowLoaderFunc) LoadRow(ctx context.Context, row_id string) (string, error) {
+	return f(ctx, row_id)
+}
+
+// 使用 InventoryGet 的示例数据源 CacheRows 使用它
+var StubLoader RowLoader = RowLoaderFunc(func(ctx context.Context, row_id string) (string, error) {
+	return InventoryGet(row_id), nil
+})
+
+// SQLLoader 用database/sql读取数据行 Query只有一个参数（row_id） 结果的第一行按列名转换成JSON对象
+type SQLLoader struct {
+	DB    *sql.DB
+	Query string
+}
+
+func (l *SQLLoader) LoadRow(ctx context.Context, row_id string) (string, error) {
+	rows, err := l.DB.QueryContext(ctx, l.Query, row_id)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", core.NotFound("cache.load_row", "row "+row_id)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	if err := rows.Scan(values...); err != nil {
+		return "", err
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, name := range columns {
+		v := *(values[i].(*interface{}))
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		row[name] = v
+	}
+	data, err := json.Marshal(row)
+	return string(data), err
+}
+
+// RescheduleRow 修改数据行的缓存间隔 下一次缓存在delay秒之后 delay<=0时与 EvictRow 相同
+func RescheduleRow(ctx context.Context, conn redis.Cmdable, row_id string, delay float64) error {
+	ctx = core.WithOp(ctx, "cache.schedule_row")
+	if delay <= 0 {
+		return EvictRow(ctx, conn, row_id)
+	}
+	pipe := conn.TxPipeline()
+	pipe.ZAdd(ctx, "delay:", &redis.Z{Score: delay, Member: row_id})
+	pipe.ZAdd(ctx, "schedule:", &redis.Z{Score: unixSeconds(Clock.Now()) + delay, Member: row_id})
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("cache.schedule_row", err)
+}
+
+// EvictRow 立即停止缓存数据行 并删除已经缓存的内容
+func EvictRow(ctx context.Context, conn redis.Cmdable, row_id string) error {
+	ctx = core.WithOp(ctx, "cache.evict_row")
+	pipe := conn.TxPipeline()
+	pipe.ZRem(ctx, "delay:", row_id)
+	pipe.ZRem(ctx, "schedule:", row_id)
+	pipe.HDel(ctx, "retry:", row_id)
+	pipe.Del(ctx, "inv:"+row_id)
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("cache.evict_row", err)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// KEYS: schedule: delay:  ARGV: 当前时间 租期（秒）
+// 取出一个到期的数据行 并把它的调度时间推迟一个租期 这样其他进程不会同时处理它 处理者崩溃后租期结束会重新处理
+// 返回 {row_id, 缓存间隔} 没有到期的行时返回nil
+var claimRowScript = redis.NewScript(`
+local due = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "limit", 0, 1)
+if #due == 0 then
+	return false
+end
+redis.call("zadd", KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[2]), due[1])
+return {due[1], redis.call("zscore", KEYS[2], due[1]) or "0"}`)
+
+// KEYS: schedule: delay: inv:row_id retry:  ARGV: row_id 内容 当前时间
+// 缓存间隔仍然大于0时写入缓存并安排下一次 否则清除数据行（在加载期间被驱逐）
+var finishRowScript = redis.NewScript(`
+local delay = tonumber(redis.call("zscore", KEYS[2], ARGV[1]))
+redis.call("hdel", KEYS[4], ARGV[1])
+if not delay or delay <= 0 then
+	redis.call("zrem", KEYS[1], ARGV[1])
+	redis.call("zrem", KEYS[2], ARGV[1])
+	redis.call("del", KEYS[3])
+	return 0
+end
+redis.call("set", KEYS[3], ARGV[2])
+redis.call("zadd", KEYS[1], tonumber(ARGV[3]) + delay, ARGV[1])
+return 1`)
+
+// KEYS: schedule: retry:  ARGV: row_id 当前时间 初始退避 最大退避（秒）
+// 记录一次失败 按指数退避重新安排 返回失败次数
+// 数据行在加载期间已经被驱逐时不安排 也不留下失败次数 返回0
+var failRowScript = redis.NewScript(`
+if not redis.call("zscore", KEYS[1], ARGV[1]) then
+	redis.call("hdel", KEYS[2], ARGV[1])
+	return 0
+end
+local n = redis.call("hincrby", KEYS[2], ARGV[1], 1)
+local backoff = math.min(tonumber(ARGV[3]) * 2 ^ (n - 1), tonumber(ARGV[4]))
+redis.call("zadd", KEYS[1], tonumber(ARGV[2]) + backoff, ARGV[1])
+return n`)
+
+// RowCacher 缓存数据行的工作池 多个进程可以同时运行 每个到期的数据行只会被一个工作者取出
+// 加载失败的数据行按 Backoff、2*Backoff…（最多MaxBackoff）重试 失败次数记录在retry:散列中
+type RowCacher struct {
+	Loader     RowLoader
+	Workers    int
+	Lease      time.Duration // 取出的数据行在这段时间内不会被其他工作者取出
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Idle       time.Duration // 没有到期的数据行时的休眠时间
+
+	conn redis.Cmdable
+}
+
+func NewRowCacher(conn redis.Cmdable, loader RowLoader) *RowCacher {
+	return &RowCacher{
+		Loader:     loader,
+		Workers:    4,
+		Lease:      30 * time.Second,
+		Backoff:    time.Second,
+		MaxBackoff: 5 * time.Minute,
+		Idle:       50 * time.Millisecond,
+		conn:       conn,
+	}
+}
+
+// Run 守护任务 启动Workers个工作者 直到ctx结束或者redis出错
+func (c *RowCacher) Run(ctx context.Context) error {
+	ctx = core.WithOp(ctx, "cache.rows")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.work(ctx); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (c *RowCacher) work(ctx context.Context) error {
+	for ctx.Err() == nil {
+		ok, err := c.CacheOne(ctx)
+		if err != nil {
+			return core.StopErr(ctx, "cache.rows", err)
+		}
+		if !ok {
+			core.Sleep(Clock, ctx.Done(), c.Idle)
+		}
+	}
+	return nil
+}
+
+// CacheOne 取出并处理一个到期的数据行 没有到期的数据行时返回false
+// 数据源的错误不会返回 而是安排重试 只有redis出错时返回错误
+func (c *RowCacher) CacheOne(ctx context.Context) (bool, error) {
+	ctx = core.WithOp(ctx, "cache.rows")
+	now := unixSeconds(Clock.Now())
+	reply, err := claimRowScript.Run(ctx, c.conn, []string{"schedule:", "delay:"}, now, c.Lease.Seconds()).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, core.Wrap("cache.rows", err)
+	}
+	res := reply.([]interface{})
+	row_id := res[0].(string)
+	delay, _ := strconv.ParseFloat(res[1].(string), 64)
+	if delay <= 0 {
+		// 不必再缓存这个行，将它从缓存中移除。
+		return true, EvictRow(ctx, c.conn, row_id)
+	}
+	row, err := c.Loader.LoadRow(ctx, row_id)
+	if errors.Is(err, core.ErrNotFound) {
+		return true, EvictRow(ctx, c.conn, row_id)
+	} else if err != nil {
+		if ctx.Err() != nil {
+			return true, nil
+		}
+		now = unixSeconds(Clock.Now())
+		err = failRowScript.Run(ctx, c.conn, []string{"schedule:", "retry:"}, row_id, now,
+			c.Backoff.Seconds(), c.MaxBackoff.Seconds()).Err()
+		return true, core.Wrap("cache.rows", err)
+	}
+	now = unixSeconds(Clock.Now())
+	keys := []string{"schedule:", "delay:", "inv:" + row_id, "retry:"}
+	err = finishRowScript.Run(ctx, c.conn, keys, row_id, row, now).Err()
+	return true, core.Wrap("cache.rows", err)
+}
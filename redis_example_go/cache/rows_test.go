@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func TestSQLLoader(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "inventory.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE inventory (row_id TEXT PRIMARY KEY, name TEXT, count INTEGER)",
+		"INSERT INTO inventory VALUES ('itemX', 'xiaoming', 3)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	c := NewRowCacher(conn, &SQLLoader{DB: db, Query: "SELECT row_id, name, count FROM inventory WHERE row_id = ?"})
+	ScheduleRowCache(ctx, conn, "itemX", 10)
+	if ok, err := c.CacheOne(ctx); !ok || err != nil {
+		t.Fatalf("CacheOne() = %v, %v", ok, err)
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(conn.Get(ctx, "inv:itemX").Val()), &row); err != nil {
+		t.Fatal(err)
+	}
+	if row["name"] != "xiaoming" || row["count"] != float64(3) {
+		t.Fatalf("cached row = %v", row)
+	}
+	if next := conn.ZScore(ctx, "schedule:", "itemX").Val(); next != 1600000010 {
+		t.Fatalf("next schedule = %v", next)
+	}
+	if ok, _ := c.CacheOne(ctx); ok {
+		t.Fatal("CacheOne() claimed a row that is not due")
+	}
+
+	// 数据行被删除之后缓存也被清除
+	db.Exec("DELETE FROM inventory")
+	clock.Advance(10 * time.Second)
+	c.CacheOne(ctx)
+	if conn.Exists(ctx, "inv:itemX", "schedule:", "delay:").Val() != 0 {
+		t.Fatal("deleted row still cached")
+	}
+}
+
+func TestRowCacherRetry(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	fail := true
+	c := NewRowCacher(conn, RowLoaderFunc(func(ctx context.Context, row_id string) (string, error) {
+		if fail {
+			return "", errors.New("database is down")
+		}
+		return "row " + row_id, nil
+	}))
+	ScheduleRowCache(ctx, conn, "itemX", 60)
+	// 失败之后按1秒、2秒、4秒退避
+	for i, backoff := range []float64{1, 2, 4} {
+		if ok, err := c.CacheOne(ctx); !ok || err != nil {
+			t.Fatalf("CacheOne() #%d = %v, %v", i, ok, err)
+		}
+		now := float64(clock.Now().Unix())
+		if next := conn.ZScore(ctx, "schedule:", "itemX").Val(); next != now+backoff {
+			t.Fatalf("retry #%d scheduled at %v, want %v", i, next-now, backoff)
+		}
+		clock.Advance(time.Duration(backoff) * time.Second)
+	}
+	fail = false
+	c.CacheOne(ctx)
+	if conn.Get(ctx, "inv:itemX").Val() != "row itemX" || conn.HExists(ctx, "retry:", "itemX").Val() {
+		t.Fatal("row not cached after the source recovered")
+	}
+
+	// 修改间隔和驱逐
+	if err := RescheduleRow(ctx, conn, "itemX", 5); err != nil {
+		t.Fatal(err)
+	}
+	if next := conn.ZScore(ctx, "schedule:", "itemX").Val(); next != float64(clock.Now().Unix()+5) {
+		t.Fatalf("rescheduled at %v", next)
+	}
+	if err := EvictRow(ctx, conn, "itemX"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Exists(ctx, "inv:itemX", "schedule:", "delay:").Val() != 0 {
+		t.Fatal("row not evicted")
+	}
+
+	// 驱逐正在重试的数据行时清除失败次数
+	fail = true
+	ScheduleRowCache(ctx, conn, "itemY", 60)
+	c.CacheOne(ctx)
+	if conn.HGet(ctx, "retry:", "itemY").Val() != "1" {
+		t.Fatal("failure not counted")
+	}
+	EvictRow(ctx, conn, "itemY")
+	if conn.Exists(ctx, "retry:").Val() != 0 {
+		t.Fatal("evicted row left its failure count")
+	}
+
+	// 加载期间被驱逐的数据行失败时 不重新安排也不记录失败次数
+	c.Loader = RowLoaderFunc(func(ctx context.Context, row_id string) (string, error) {
+		EvictRow(ctx, conn, row_id)
+		return "", errors.New("database is down")
+	})
+	ScheduleRowCache(ctx, conn, "itemZ", 60)
+	if ok, err := c.CacheOne(ctx); !ok || err != nil {
+		t.Fatalf("CacheOne() = %v, %v", ok, err)
+	}
+	if conn.Exists(ctx, "schedule:", "delay:", "retry:").Val() != 0 {
+		t.Fatal("row evicted during the load was rescheduled")
+	}
+}
+
+// 两个进程的工作池共享调度 每个数据行只加载一次
+func TestRowCacherWorkers(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	var mu sync.Mutex
+	loads := map[string]int{}
+	loader := RowLoaderFunc(func(ctx context.Context, row_id string) (string, error) {
+		mu.Lock()
+		loads[row_id]++
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		return row_id, nil
+	})
+	for i := 0; i < 50; i++ {
+		ScheduleRowCache(ctx, conn, fmt.Sprintf("row%d", i), 3600)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- NewRowCacher(conn, loader).Run(runCtx) }()
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if len(conn.Keys(ctx, "inv:*").Val()) == 50 {
+			break
+		}
+	}
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(loads) != 50 {
+		t.Fatalf("loaded %d rows, want 50", len(loads))
+	}
+	for row, n := range loads {
+		if n != 1 {
+			t.Errorf("%s loaded %d times", row, n)
+		}
+	}
+}
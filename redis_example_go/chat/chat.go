@@ -0,0 +1,221 @@
+// Package chat 第六章的多接收者消息群组
+// chat:<chat_id> 记录群组成员以及每个成员已读的最大消息id seen:<user> 记录用户加入的群组以及已读的最大消息id
+// msgs:<chat_id> 以消息id为分数保存消息 ids:<chat_id> 为群组的消息id计数器
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/locks"
+	"strconv"
+)
+
+// 群组id分配器 为nil时用INCR ids:chat: 生成群组id
+// 消息id需要在群组锁内严格递增 并且JoinChat依赖ids:<chat_id>的当前值 所以仍然使用INCR
+var IDs *core.SegmentAllocator
+
+// 消息时间戳使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 获取群组锁失败
+var ErrLockTimeout = core.Conflict("chat.send", "couldn't get the lock")
+
+type Message struct {
+	ID      int64   `json:"id"`
+	Ts      float64 `json:"ts"`
+	Sender  string  `json:"sender"`
+	Message string  `json:"message"`
+}
+
+type ChatInfo struct {
+	ChatID   string
+	Messages []Message
+}
+
+// CreateChat 创建群组并发送第一条消息 chat_id为空时分配新的群组id 返回群组id（代码清单6-24）
+// 将所有参与的人拉到一个有序集合中 并初始化已读信息的有序集合（分数代表当前已读）
+func CreateChat(ctx context.Context, conn redis.UniversalClient, sender string, recipients []string, message string, chat_id string) (string, error) {
+	ctx = core.WithOp(ctx, "chat.create")
+	// 获得新的群组ID。
+	if chat_id == "" {
+		var id int64
+		var err error
+		if IDs != nil {
+			id, err = IDs.Next(ctx, "ids:chat:")
+		} else {
+			id, err = conn.Incr(ctx, "ids:chat:").Result()
+		}
+		if err != nil {
+			return "", core.Wrap("chat.create", err)
+		}
+		chat_id = strconv.FormatInt(id, 10)
+	}
+	// 创建一个由用户和分值组成的字典，字典里面的信息将被添加到有序集合里面。
+	recipients = append(append([]string{}, recipients...), sender)
+	recipientsd := make([]*redis.Z, len(recipients))
+	for i, v := range recipients {
+		recipientsd[i] = &redis.Z{Score: 0, Member: v}
+	}
+
+	pipeline := conn.TxPipeline()
+	// 将所有参与群聊的用户添加到有序集合里面。
+	pipeline.ZAdd(ctx, "chat:"+chat_id, recipientsd...)
+	// 初始化已读有序集合。
+	for _, rec := range recipients {
+		pipeline.ZAdd(ctx, "seen:"+rec, &redis.Z{Score: 0, Member: chat_id})
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return "", core.Wrap("chat.create", err)
+	}
+
+	// 发送消息。
+	return SendMessage(ctx, conn, chat_id, sender, message)
+}
+
+// SendMessage 向群组发送消息 消息id在群组锁内分配 保证按发送顺序递增（代码清单6-25）
+func SendMessage(ctx context.Context, conn redis.UniversalClient, chat_id string, sender string, message string) (string, error) {
+	ctx = core.WithOp(ctx, "chat.send")
+	identifier, err := locks.AcquireLock(ctx, conn, "chat:"+chat_id, 0)
+	if err != nil {
+		return "", err
+	} else if identifier == "" {
+		return "", ErrLockTimeout
+	}
+	defer locks.ReleaseLock(ctx, conn, "chat:"+chat_id, identifier)
+
+	// 筹备待发送的消息。
+	mid, err := conn.Incr(ctx, "ids:"+chat_id).Result()
+	if err != nil {
+		return "", core.Wrap("chat.send", err)
+	}
+	packed, _ := json.Marshal(Message{
+		ID:      mid,
+		Ts:      float64(Clock.Now().UnixNano()) / 1e9,
+		Sender:  sender,
+		Message: message,
+	})
+
+	// 将消息发送至群组。
+	if err := conn.ZAdd(ctx, "msgs:"+chat_id, &redis.Z{Score: float64(mid), Member: packed}).Err(); err != nil {
+		return "", core.Wrap("chat.send", err)
+	}
+	return chat_id, nil
+}
+
+// FetchPendingMessages 获取用户在所有群组中的未读消息 并清理所有成员都已读的消息（代码清单6-26）
+func FetchPendingMessages(ctx context.Context, conn redis.UniversalClient, recipient string) ([]ChatInfo, error) {
+	ctx = core.WithOp(ctx, "chat.fetch")
+	// 获取最后接收到的消息的ID。
+	seen, err := conn.ZRangeWithScores(ctx, "seen:"+recipient, 0, -1).Result()
+	if err != nil {
+		return nil, core.Wrap("chat.fetch", err)
+	}
+	if len(seen) == 0 {
+		return nil, nil
+	}
+
+	pipeline := conn.TxPipeline()
+	// 获取所有未读消息。
+	cmds := make([]*redis.StringSliceCmd, len(seen))
+	for i, v := range seen {
+		chat_id, seen_id := v.Member.(string), int64(v.Score)
+		cmds[i] = pipeline.ZRangeByScore(ctx, "msgs:"+chat_id, &redis.ZRangeBy{Min: strconv.FormatInt(seen_id+1, 10), Max: "inf"})
+	}
+	if _, err := pipeline.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, core.Wrap("chat.fetch", err)
+	}
+
+	// 这些数据将被返回给函数调用者。
+	chat_info := make([]ChatInfo, 0, len(seen))
+	for i, v := range seen {
+		packed := cmds[i].Val()
+		if len(packed) == 0 {
+			continue
+		}
+		chat_id := v.Member.(string)
+		messages := make([]Message, 0, len(packed))
+		for _, p := range packed {
+			var m Message
+			if err := json.Unmarshal([]byte(p), &m); err == nil {
+				messages = append(messages, m)
+			}
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		// 使用最新收到的消息来更新群组有序集合。
+		seen_id := float64(messages[len(messages)-1].ID)
+		if err := conn.ZAdd(ctx, "chat:"+chat_id, &redis.Z{Score: seen_id, Member: recipient}).Err(); err != nil {
+			return nil, core.Wrap("chat.fetch", err)
+		}
+
+		// 找出那些所有人都已经阅读过的消息。
+		min_id, err := conn.ZRangeWithScores(ctx, "chat:"+chat_id, 0, 0).Result()
+		if err != nil {
+			return nil, core.Wrap("chat.fetch", err)
+		}
+
+		// 更新已读消息有序集合。
+		pipeline.ZAdd(ctx, "seen:"+recipient, &redis.Z{Score: seen_id, Member: chat_id})
+		if len(min_id) > 0 {
+			// 清除那些已经被所有人阅读过的消息。
+			pipeline.ZRemRangeByScore(ctx, "msgs:"+chat_id, "0", strconv.FormatInt(int64(min_id[0].Score), 10))
+		}
+		chat_info = append(chat_info, ChatInfo{ChatID: chat_id, Messages: messages})
+	}
+	if len(chat_info) > 0 {
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return nil, core.Wrap("chat.fetch", err)
+		}
+	}
+	return chat_info, nil
+}
+
+// JoinChat 加入群组 只能看到加入之后发送的消息 群组不存在时返回 core.ErrNotFound（代码清单6-27）
+func JoinChat(ctx context.Context, conn redis.Cmdable, chat_id string, user string) error {
+	ctx = core.WithOp(ctx, "chat.join")
+	// 取得最新群组消息的ID。
+	message_id, err := conn.Get(ctx, "ids:"+chat_id).Float64()
+	if err != nil {
+		return core.Wrap("chat.join", err)
+	}
+
+	pipeline := conn.TxPipeline()
+	// 将用户添加到群组成员列表里面。
+	pipeline.ZAdd(ctx, "chat:"+chat_id, &redis.Z{Score: message_id, Member: user})
+	// 将群组添加到用户的已读列表里面。
+	pipeline.ZAdd(ctx, "seen:"+user, &redis.Z{Score: message_id, Member: chat_id})
+	_, err = pipeline.Exec(ctx)
+	return core.Wrap("chat.join", err)
+}
+
+// LeaveChat 离开群组 最后一个成员离开时删除群组（代码清单6-28）
+// 有序集合chat:chat_id 分数为最小已阅读的消息No 所有从0-No的消息都是可以删除的
+func LeaveChat(ctx context.Context, conn redis.Cmdable, chat_id string, user string) error {
+	ctx = core.WithOp(ctx, "chat.leave")
+	pipeline := conn.TxPipeline()
+	// 从群组里面移除给定的用户。
+	pipeline.ZRem(ctx, "chat:"+chat_id, user)
+	pipeline.ZRem(ctx, "seen:"+user, chat_id)
+	// 查看群组剩余成员的数量。
+	zCmd := pipeline.ZCard(ctx, "chat:"+chat_id)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return core.Wrap("chat.leave", err)
+	}
+	if zCmd.Val() <= 0 {
+		// 删除群组。
+		pipeline.Del(ctx, "msgs:"+chat_id)
+		pipeline.Del(ctx, "ids:"+chat_id)
+		_, err := pipeline.Exec(ctx)
+		return core.Wrap("chat.leave", err)
+	}
+	// 查找那些已经被所有成员阅读过的消息。
+	oldest, err := conn.ZRangeWithScores(ctx, "chat:"+chat_id, 0, 0).Result()
+	if err != nil || len(oldest) == 0 {
+		return core.Wrap("chat.leave", err)
+	}
+	// 删除那些已经被所有成员阅读过的消息。
+	return core.Wrap("chat.leave", conn.ZRemRangeByScore(ctx, "msgs:"+chat_id, "0", strconv.FormatInt(int64(oldest[0].Score), 10)).Err())
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh06.test_multi_recipient_messaging
+func TestMultiRecipientMessaging(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	chat_id, err := CreateChat(ctx, conn, "joe", []string{"jeff", "jenny"}, "message 1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < 5; i++ {
+		if _, err := SendMessage(ctx, conn, chat_id, "joe", "message "+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r1, err := FetchPendingMessages(ctx, conn, "jeff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := FetchPendingMessages(ctx, conn, "jenny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r1) != 1 || len(r1[0].Messages) != 4 || r1[0].ChatID != chat_id {
+		t.Fatalf("jeff's messages = %+v", r1)
+	}
+	if len(r2) != 1 || len(r2[0].Messages) != 4 {
+		t.Fatalf("jenny's messages = %+v", r2)
+	}
+	for i := range r1[0].Messages {
+		if r1[0].Messages[i] != r2[0].Messages[i] {
+			t.Fatalf("message %d differs: %+v != %+v", i, r1[0].Messages[i], r2[0].Messages[i])
+		}
+	}
+	if again, _ := FetchPendingMessages(ctx, conn, "jeff"); len(again) != 0 {
+		t.Fatalf("read messages fetched again: %+v", again)
+	}
+
+	if err := LeaveChat(ctx, conn, chat_id, "jenny"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LeaveChat(ctx, conn, chat_id, "jeff"); err != nil {
+		t.Fatal(err)
+	}
+	// joe还没有读过消息 消息仍然保留
+	if n := conn.ZCard(ctx, "msgs:"+chat_id).Val(); n != 4 {
+		t.Fatalf("messages kept = %d, want 4", n)
+	}
+	if err := LeaveChat(ctx, conn, chat_id, "joe"); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.Exists(ctx, "msgs:"+chat_id, "ids:"+chat_id).Val(); n != 0 {
+		t.Fatal("empty chat was not deleted")
+	}
+}
+
+func TestJoinChat(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if err := JoinChat(ctx, conn, "missing", "joe"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("JoinChat() on missing chat = %v, want ErrNotFound", err)
+	}
+	chat_id, err := CreateChat(ctx, conn, "joe", []string{"jeff"}, "before", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := JoinChat(ctx, conn, chat_id, "jenny"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SendMessage(ctx, conn, chat_id, "joe", "after"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := FetchPendingMessages(ctx, conn, "jenny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || len(r[0].Messages) != 1 || r[0].Messages[0].Message != "after" {
+		t.Fatalf("jenny's messages = %+v", r)
+	}
+}
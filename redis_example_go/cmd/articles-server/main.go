@@ -0,0 +1,71 @@
+// articles-server 以HTTP/JSON接口提供第一章的文章投票网站 接口说明见 GET /openapi.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/redis_example_go/articles"
+	"redis-learn/redis_example_go/ratelimit"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "http listen address")
+	archiveDir := flag.String("archive-dir", "", "archive articles past the voting window into this directory")
+	devLogin := flag.Bool("dev-login", false, "allow POST /sessions to log in as any user without a credential (development only)")
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := core.Connect(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	articles.IDs = core.NewSegmentAllocator(conn, 100)
+	articles.VoteLimiter = &ratelimit.Limiter{
+		Name: "vote",
+		User: ratelimit.Limit{Mode: ratelimit.SlidingWindow, Rate: 60, Period: time.Minute},
+		IP:   ratelimit.Limit{Mode: ratelimit.TokenBucket, Rate: 300, Period: time.Minute, Burst: 100},
+	}
+	articles.PostLimiter = &ratelimit.Limiter{
+		Name: "post",
+		User: ratelimit.Limit{Mode: ratelimit.SlidingWindow, Rate: 10, Period: time.Hour},
+		IP:   ratelimit.Limit{Mode: ratelimit.TokenBucket, Rate: 30, Period: time.Hour, Burst: 10},
+	}
+	articles.RingDetector = &articles.VoteRingDetector{NewAccountAge: 24 * time.Hour, Window: 10 * time.Minute, Threshold: 5}
+	// 随时间衰减的排名需要定期重新计算
+	go func() {
+		if err := articles.ReRankLoop(ctx, conn, 5*time.Minute); err != nil {
+			log.Println("rerank:", err)
+		}
+	}()
+	// 投票期限结束的文章不能再审核通过 定期丢弃它们等待审核的投票
+	go func() {
+		for ctx.Err() == nil {
+			if _, err := articles.ExpireHeldVotes(ctx, conn); err != nil {
+				log.Println("expire held votes:", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+	if *archiveDir != "" {
+		go func() {
+			err := articles.ArchiveLoop(ctx, conn, articles.ArchiveOptions{Dir: *archiveDir}, time.Hour)
+			if err != nil {
+				log.Println("archive:", err)
+			}
+		}()
+	}
+
+	if *devLogin {
+		log.Println("WARNING: -dev-login lets anyone log in as any user")
+	}
+	log.Println("listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, newServer(conn, *devLogin)))
+}
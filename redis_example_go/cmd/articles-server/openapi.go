@@ -0,0 +1,225 @@
+package main
+
+// 接口说明 由 GET /openapi.yaml 返回
+const openAPIDoc = `openapi: 3.0.3
+info:
+  title: articles-server
+  description: "第一章的文章投票网站。需要登录的接口使用会话令牌（Authorization: Bearer <token>），这个服务器本身没有登录方式：只有用 -dev-login 启动时才能通过 POST /sessions 获取令牌，否则令牌只能由服务器之外的登录系统写入第二章的 login: 散列。"
+  version: 1.0.0
+paths:
+  /sessions:
+    post:
+      summary: 登录并创建会话令牌（仅开发环境）
+      description: 不验证身份，任何人都可以用任何用户名登录，所以只有服务器用 -dev-login 启动时才可用，否则返回403。
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [user]
+              properties:
+                user: {type: string}
+      responses:
+        "201":
+          description: 会话令牌
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  token: {type: string}
+                  user: {type: string}
+        "400": {$ref: "#/components/responses/Error"}
+        "403": {$ref: "#/components/responses/Error"}
+  /articles:
+    get:
+      summary: 分页列出文章
+      parameters:
+        - name: order
+          in: query
+          description: 排名算法 score（默认）、hn、hot、wilson 或者按发布时间排序的 time
+          schema: {type: string, enum: [score, hn, hot, wilson, time]}
+        - name: cursor
+          in: query
+          description: 上一页返回的next
+          schema: {type: string}
+        - name: count
+          in: query
+          schema: {type: integer, minimum: 1, maximum: 100, default: 25}
+        - name: group
+          in: query
+          description: 只列出这些分组中的文章 可以重复
+          schema: {type: array, items: {type: string}}
+          style: form
+          explode: true
+        - name: op
+          in: query
+          description: 多个分组之间的组合方式
+          schema: {type: string, enum: [and, or], default: and}
+      responses:
+        "200":
+          description: 一页文章
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/ArticlePage"}
+        "400": {$ref: "#/components/responses/Error"}
+        "404": {$ref: "#/components/responses/Error"}
+    post:
+      summary: 发布文章
+      security: [{session: []}]
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [title, link]
+              properties:
+                title: {type: string}
+                link: {type: string}
+      responses:
+        "201":
+          description: 发布的文章
+          headers:
+            Location: {schema: {type: string}}
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Article"}
+        "400": {$ref: "#/components/responses/Error"}
+        "401": {$ref: "#/components/responses/Error"}
+        "429": {$ref: "#/components/responses/RateLimited"}
+  /articles/{id}:
+    parameters:
+      - {$ref: "#/components/parameters/ArticleID"}
+    get:
+      summary: 获取文章
+      responses:
+        "200":
+          description: 文章
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Article"}
+        "404": {$ref: "#/components/responses/Error"}
+  /articles/{id}/vote:
+    parameters:
+      - {$ref: "#/components/parameters/ArticleID"}
+    get:
+      summary: 当前用户的投票
+      security: [{session: []}]
+      responses:
+        "200":
+          description: 投票状态
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Vote"}
+        "401": {$ref: "#/components/responses/Error"}
+        "404": {$ref: "#/components/responses/Error"}
+    put:
+      summary: 投票、改票或取消投票
+      security: [{session: []}]
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: "#/components/schemas/Vote"}
+      responses:
+        "200":
+          description: 投票之后的文章评分
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Vote"}
+        "202":
+          description: 投票被判定为可疑（新账号集中投票） 审核通过之后才计入评分
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Vote"}
+        "400": {$ref: "#/components/responses/Error"}
+        "401": {$ref: "#/components/responses/Error"}
+        "404": {$ref: "#/components/responses/Error"}
+        "409":
+          description: 文章发布超过一周 投票已经截止
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Error"}
+        "429": {$ref: "#/components/responses/RateLimited"}
+  /articles/{id}/groups:
+    parameters:
+      - {$ref: "#/components/parameters/ArticleID"}
+    post:
+      summary: 把文章加入或移出分组 只有发布者可以修改
+      security: [{session: []}]
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                add: {type: array, items: {type: string}}
+                remove: {type: array, items: {type: string}}
+      responses:
+        "204": {description: 修改成功}
+        "400": {$ref: "#/components/responses/Error"}
+        "401": {$ref: "#/components/responses/Error"}
+        "403": {$ref: "#/components/responses/Error"}
+        "404": {$ref: "#/components/responses/Error"}
+  /openapi.yaml:
+    get:
+      summary: 本文档
+      responses:
+        "200": {description: OpenAPI文档}
+components:
+  securitySchemes:
+    session:
+      type: http
+      scheme: bearer
+  parameters:
+    ArticleID:
+      name: id
+      in: path
+      required: true
+      schema: {type: integer, minimum: 1}
+  responses:
+    Error:
+      description: 错误
+      content:
+        application/json:
+          schema: {$ref: "#/components/schemas/Error"}
+    RateLimited:
+      description: 超过了用户或IP的频率限制
+      headers:
+        Retry-After: {description: 需要等待的秒数, schema: {type: integer}}
+      content:
+        application/json:
+          schema: {$ref: "#/components/schemas/Error"}
+  schemas:
+    Article:
+      type: object
+      properties:
+        id: {type: string}
+        title: {type: string}
+        link: {type: string}
+        poster: {type: string}
+        time: {type: integer, description: 发布时间（unix秒）}
+        votes: {type: integer, description: 净票数}
+        upvotes: {type: integer}
+        downvotes: {type: integer}
+        score: {type: number}
+    ArticlePage:
+      type: object
+      properties:
+        articles: {type: array, items: {$ref: "#/components/schemas/Article"}}
+        next: {type: string, description: 下一页的游标 没有更多文章时不返回}
+    Vote:
+      type: object
+      required: [vote]
+      properties:
+        vote: {type: string, enum: [up, down, none]}
+        score: {type: number, readOnly: true}
+        held: {type: boolean, readOnly: true, description: 投票等待审核}
+    Error:
+      type: object
+      properties:
+        error: {type: string}
+`
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/articles"
+	"redis-learn/redis_example_go/ratelimit"
+	"redis-learn/redis_example_go/sessions"
+)
+
+// 请求体的最大长度
+const maxBodySize = 1 << 20
+
+// 每页最多的文章数量
+const maxPageSize = 100
+
+// httpError 直接对应一个HTTP状态码的错误
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// 没有登录或令牌无效
+var errUnauthorized = &httpError{http.StatusUnauthorized, "missing or invalid session token"}
+
+// 只有发布者才能修改文章的分组
+var errForbidden = &httpError{http.StatusForbidden, "only the poster can change the groups of an article"}
+
+// 没有启用 -dev-login 时不能用 POST /sessions 登录
+var errDevLoginDisabled = &httpError{http.StatusForbidden, "password-less login is only available with -dev-login"}
+
+type server struct {
+	conn redis.UniversalClient
+	// 允许 POST /sessions 不验证身份直接登录 只用于本地开发和测试
+	devLogin bool
+}
+
+func newServer(conn redis.UniversalClient, devLogin bool) http.Handler {
+	return &server{conn: conn, devLogin: devLogin}
+}
+
+// 文章的JSON表示 id不带article:前缀
+type articleJSON struct {
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Link      string  `json:"link"`
+	Poster    string  `json:"poster"`
+	Time      int64   `json:"time"`
+	Votes     int64   `json:"votes"`
+	Upvotes   int64   `json:"upvotes"`
+	Downvotes int64   `json:"downvotes"`
+	Score     float64 `json:"score"`
+}
+
+func toArticleJSON(m map[string]string) articleJSON {
+	a := articleJSON{
+		ID:     strings.TrimPrefix(m["id"], "article:"),
+		Title:  m["title"],
+		Link:   m["link"],
+		Poster: m["poster"],
+	}
+	a.Time, _ = strconv.ParseInt(m["time"], 10, 64)
+	a.Votes, _ = strconv.ParseInt(m["votes"], 10, 64)
+	a.Upvotes, _ = strconv.ParseInt(m["upvotes"], 10, 64)
+	a.Downvotes, _ = strconv.ParseInt(m["downvotes"], 10, 64)
+	a.Score, _ = strconv.ParseFloat(m["score"], 64)
+	return a
+}
+
+type pageJSON struct {
+	Articles []articleJSON `json:"articles"`
+	Next     string        `json:"next,omitempty"`
+}
+
+var voteNames = map[string]articles.VoteState{
+	"up":   articles.VoteUp,
+	"down": articles.VoteDown,
+	"none": articles.VoteNone,
+}
+
+func voteName(state articles.VoteState) string {
+	for name, s := range voteNames {
+		if s == state {
+			return name
+		}
+	}
+	return ""
+}
+
+// ServeHTTP 按路径分发请求
+//
+//	GET  /openapi.yaml
+//	POST /sessions
+//	GET  /articles  POST /articles
+//	GET  /articles/{id}
+//	GET  /articles/{id}/vote  PUT /articles/{id}/vote
+//	POST /articles/{id}/groups
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 投票和发布同时按来源IP限流
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		r = r.WithContext(ratelimit.WithIP(r.Context(), ip))
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var err error
+	switch {
+	case len(parts) == 1 && parts[0] == "openapi.yaml":
+		err = s.only(w, r, "GET", s.openAPI)
+	case len(parts) == 1 && parts[0] == "sessions":
+		err = s.only(w, r, "POST", s.login)
+	case len(parts) == 1 && parts[0] == "articles":
+		switch r.Method {
+		case "GET":
+			err = s.listArticles(w, r)
+		case "POST":
+			err = s.authed(w, r, s.postArticle)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case len(parts) >= 2 && parts[0] == "articles":
+		id, perr := strconv.ParseUint(parts[1], 10, 63)
+		if perr != nil || id == 0 {
+			err = core.NotFound("articles-server", "article "+parts[1])
+			break
+		}
+		article := "article:" + parts[1]
+		switch {
+		case len(parts) == 2:
+			err = s.only(w, r, "GET", func(w http.ResponseWriter, r *http.Request) error {
+				return s.getArticle(w, r, article)
+			})
+		case len(parts) == 3 && parts[2] == "vote":
+			switch r.Method {
+			case "GET":
+				err = s.authed(w, r, func(w http.ResponseWriter, r *http.Request, user string) error {
+					return s.getVote(w, r, user, article)
+				})
+			case "PUT":
+				err = s.authed(w, r, func(w http.ResponseWriter, r *http.Request, user string) error {
+					return s.vote(w, r, user, article)
+				})
+			default:
+				methodNotAllowed(w, "GET, PUT")
+			}
+		case len(parts) == 3 && parts[2] == "groups":
+			err = s.only(w, r, "POST", func(w http.ResponseWriter, r *http.Request) error {
+				return s.authed(w, r, func(w http.ResponseWriter, r *http.Request, user string) error {
+					return s.changeGroups(w, r, user, int(id))
+				})
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *server) only(w http.ResponseWriter, r *http.Request, method string, h func(http.ResponseWriter, *http.Request) error) error {
+	if r.Method != method {
+		methodNotAllowed(w, method)
+		return nil
+	}
+	return h(w, r)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+}
+
+// 校验 Authorization: Bearer <token> 中的会话令牌 并像第二章一样刷新令牌的最近出现时间
+func (s *server) authed(w http.ResponseWriter, r *http.Request, h func(http.ResponseWriter, *http.Request, string) error) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return errUnauthorized
+	}
+	user, err := sessions.CheckToken(r.Context(), s.conn, token)
+	if errors.Is(err, core.ErrNotFound) {
+		return errUnauthorized
+	} else if err != nil {
+		return err
+	}
+	if err := sessions.UpdateToken(r.Context(), s.conn, token, user, ""); err != nil {
+		return err
+	}
+	return h(w, r, user)
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 把错误转换成HTTP状态码
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var he *httpError
+	var le *ratelimit.LimitError
+	switch {
+	case errors.As(err, &he):
+		status = he.status
+	case errors.As(err, &le):
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int((le.RetryAfter+time.Second-1)/time.Second)))
+	case errors.Is(err, articles.ErrInvalidCursor):
+		status = http.StatusBadRequest
+	case errors.Is(err, core.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, core.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, context.Canceled):
+		return
+	}
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Println("articles-server:", err)
+		msg = "internal error"
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (s *server) openAPI(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, err := w.Write([]byte(openAPIDoc))
+	return err
+}
+
+// POST /sessions 登录 返回会话令牌 这个示例网站没有密码 任何人都可以用任何用户名登录
+// 所以只有用 -dev-login 启动时才可用 这个服务器没有其他登录方式
+// 不用 -dev-login 时会话只能由服务器之外的登录系统用 sessions.UpdateToken 写入第二章的 login: 散列
+func (s *server) login(w http.ResponseWriter, r *http.Request) error {
+	if !s.devLogin {
+		return errDevLoginDisabled
+	}
+	var req struct {
+		User string `json:"user"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		return err
+	}
+	if req.User == "" {
+		return badRequest("user is required")
+	}
+	// 记录用户第一次登录的时间 投票团伙检测据此识别新账号
+	if err := articles.RecordUser(r.Context(), s.conn, req.User); err != nil {
+		return err
+	}
+	token := core.NewToken()
+	if err := sessions.UpdateToken(r.Context(), s.conn, token, req.User, ""); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"token": token, "user": req.User})
+	return nil
+}
+
+// GET /articles?order=&cursor=&count=&group=&op=
+// 指定了group时只返回这些分组中的文章 op为and（默认）或or
+func (s *server) listArticles(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	count := articles.ARTICLES_PER_PAGE
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return badRequest("count must be between 1 and %d", maxPageSize)
+		}
+		count = n
+	}
+	var page *articles.ArticlePage
+	var err error
+	if groups := query["group"]; len(groups) > 0 {
+		op := articles.GroupOp(query.Get("op"))
+		if op != "" && op != articles.GroupAnd && op != articles.GroupOr {
+			return badRequest("op must be and or or")
+		}
+		q := articles.GroupQuery{Groups: groups, Op: op, Order: query.Get("order")}
+		page, err = articles.QueryGroupArticles(r.Context(), s.conn, q, query.Get("cursor"), count)
+	} else {
+		page, err = articles.ListArticles(r.Context(), s.conn, query.Get("order"), query.Get("cursor"), count)
+	}
+	if err != nil {
+		return err
+	}
+	resp := pageJSON{Articles: make([]articleJSON, 0, len(page.Articles)), Next: page.Next}
+	for _, a := range page.Articles {
+		resp.Articles = append(resp.Articles, toArticleJSON(a))
+	}
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+// POST /articles 发布文章
+func (s *server) postArticle(w http.ResponseWriter, r *http.Request, user string) error {
+	var req struct {
+		Title string `json:"title"`
+		Link  string `json:"link"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		return err
+	}
+	if req.Title == "" || req.Link == "" {
+		return badRequest("title and link are required")
+	}
+	id, err := articles.PostArticle(r.Context(), s.conn, user, req.Title, req.Link)
+	if err != nil {
+		return err
+	}
+	article, err := articles.GetArticle(r.Context(), s.conn, "article:"+id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/articles/"+id)
+	writeJSON(w, http.StatusCreated, toArticleJSON(article))
+	return nil
+}
+
+// GET /articles/{id}
+func (s *server) getArticle(w http.ResponseWriter, r *http.Request, article string) error {
+	a, err := articles.GetArticle(r.Context(), s.conn, article)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, toArticleJSON(a))
+	return nil
+}
+
+type voteJSON struct {
+	Vote  string  `json:"vote"`
+	Score float64 `json:"score,omitempty"`
+	Held  bool    `json:"held,omitempty"`
+}
+
+// GET /articles/{id}/vote 当前用户的投票
+func (s *server) getVote(w http.ResponseWriter, r *http.Request, user string, article string) error {
+	if _, err := articles.GetArticle(r.Context(), s.conn, article); err != nil {
+		return err
+	}
+	state, err := articles.GetVote(r.Context(), s.conn, user, article)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, voteJSON{Vote: voteName(state)})
+	return nil
+}
+
+// PUT /articles/{id}/vote 投票 改票或者取消投票 超过投票期限时返回409
+// 投票被判定为可疑时返回202 等待审核之后才计入评分
+// 先确认文章存在 不存在的文章的投票不会占用限流的配额 也不会计入团伙检测
+func (s *server) vote(w http.ResponseWriter, r *http.Request, user string, article string) error {
+	var req voteJSON
+	if err := decodeBody(r, &req); err != nil {
+		return err
+	}
+	state, ok := voteNames[req.Vote]
+	if !ok {
+		return badRequest("vote must be up, down or none")
+	}
+	if _, err := articles.GetArticle(r.Context(), s.conn, article); err != nil {
+		return err
+	}
+	score, err := articles.Vote(r.Context(), s.conn, user, article, state)
+	if errors.Is(err, articles.ErrVoteHeld) {
+		writeJSON(w, http.StatusAccepted, voteJSON{Vote: req.Vote, Held: true})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, voteJSON{Vote: req.Vote, Score: score})
+	return nil
+}
+
+// POST /articles/{id}/groups 添加或移除分组 只有发布者可以修改
+func (s *server) changeGroups(w http.ResponseWriter, r *http.Request, user string, id int) error {
+	var req struct {
+		Add    []string `json:"add"`
+		Remove []string `json:"remove"`
+	}
+	if err := decodeBody(r, &req); err != nil {
+		return err
+	}
+	for _, g := range append(append([]string{}, req.Add...), req.Remove...) {
+		if g == "" || strings.Contains(g, ",") {
+			return badRequest("invalid group name %q", g)
+		}
+	}
+	article, err := articles.GetArticle(r.Context(), s.conn, "article:"+strconv.Itoa(id))
+	if err != nil {
+		return err
+	}
+	if article["poster"] != user {
+		return errForbidden
+	}
+	if err := articles.AddRemoveGroups(r.Context(), s.conn, id, req.Add, req.Remove); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+	"redis-learn/redis_example_go/articles"
+	"redis-learn/redis_example_go/ratelimit"
+)
+
+type client struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+func newTestServer(t *testing.T) *client {
+	srv := httptest.NewServer(newServer(testutil.NewServer(t).Client, true))
+	t.Cleanup(srv.Close)
+	return &client{t: t, base: srv.URL}
+}
+
+// 发送请求 检查状态码并把响应解码到out（out为nil时忽略响应体）
+func (c *client) do(method string, path string, body interface{}, status int, out interface{}) {
+	c.t.Helper()
+	var reader *bytes.Reader
+	if s, ok := body.(string); ok {
+		reader = bytes.NewReader([]byte(s))
+	} else {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, c.base+path, reader)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s = %d %s, want %d", method, path, resp.StatusCode, data, status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			c.t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+}
+
+func (c *client) login(user string) *client {
+	c.t.Helper()
+	var resp struct{ Token string }
+	c.do("POST", "/sessions", map[string]string{"user": user}, http.StatusCreated, &resp)
+	return &client{t: c.t, base: c.base, token: resp.Token}
+}
+
+func (c *client) post(title string) articleJSON {
+	c.t.Helper()
+	var a articleJSON
+	c.do("POST", "/articles", map[string]string{"title": title, "link": "http://www.google.com"}, http.StatusCreated, &a)
+	return a
+}
+
+// 没有 -dev-login 时不能不验证身份登录
+func TestDevLoginDisabled(t *testing.T) {
+	srv := httptest.NewServer(newServer(testutil.NewServer(t).Client, false))
+	defer srv.Close()
+	c := &client{t: t, base: srv.URL}
+	c.do("POST", "/sessions", map[string]string{"user": "alice"}, http.StatusForbidden, nil)
+}
+
+func TestPostAndVote(t *testing.T) {
+	anon := newTestServer(t)
+	alice := anon.login("alice")
+	bob := anon.login("bob")
+
+	// 需要登录的接口
+	anon.do("POST", "/articles", map[string]string{"title": "t", "link": "l"}, http.StatusUnauthorized, nil)
+	(&client{t: t, base: anon.base, token: "nope"}).do("PUT", "/articles/1/vote", map[string]string{"vote": "up"}, http.StatusUnauthorized, nil)
+
+	a := alice.post("A title")
+	if a.Poster != "alice" || a.Votes != 1 || a.Upvotes != 1 {
+		t.Fatalf("posted article = %+v", a)
+	}
+	var got articleJSON
+	anon.do("GET", "/articles/"+a.ID, nil, http.StatusOK, &got)
+	if got != a {
+		t.Fatalf("GET article = %+v, want %+v", got, a)
+	}
+	anon.do("GET", "/articles/404", nil, http.StatusNotFound, nil)
+	anon.do("GET", "/articles/abc", nil, http.StatusNotFound, nil)
+
+	var vote voteJSON
+	bob.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "up"}, http.StatusOK, &vote)
+	if vote.Score != a.Score+articles.VOTE_SCORE {
+		t.Fatalf("score after upvote = %v, want %v", vote.Score, a.Score+articles.VOTE_SCORE)
+	}
+	bob.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "down"}, http.StatusOK, &vote)
+	if vote.Score != a.Score-articles.VOTE_SCORE {
+		t.Fatalf("score after downvote = %v, want %v", vote.Score, a.Score-articles.VOTE_SCORE)
+	}
+	bob.do("GET", "/articles/"+a.ID+"/vote", nil, http.StatusOK, &vote)
+	if vote.Vote != "down" {
+		t.Fatalf("vote = %q, want down", vote.Vote)
+	}
+	bob.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "sideways"}, http.StatusBadRequest, nil)
+	bob.do("PUT", "/articles/"+a.ID+"/vote", `{"vote": "up", "extra": 1}`, http.StatusBadRequest, nil)
+	bob.do("PUT", "/articles/404/vote", map[string]string{"vote": "up"}, http.StatusNotFound, nil)
+	bob.do("DELETE", "/articles/"+a.ID+"/vote", nil, http.StatusMethodNotAllowed, nil)
+
+	anon.do("GET", "/articles/"+a.ID, nil, http.StatusOK, &got)
+	if got.Votes != 0 || got.Upvotes != 1 || got.Downvotes != 1 {
+		t.Fatalf("article after votes = %+v", got)
+	}
+}
+
+func TestVotingClosed(t *testing.T) {
+	clock := core.NewFakeClock(time.Time{})
+	articles.Clock = clock
+	defer func() { articles.Clock = core.RealClock{} }()
+
+	alice := newTestServer(t).login("alice")
+	a := alice.post("A title")
+	clock.Advance(8 * 24 * time.Hour)
+	alice.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "down"}, http.StatusConflict, nil)
+}
+
+func TestListArticles(t *testing.T) {
+	anon := newTestServer(t)
+	alice := anon.login("alice")
+	bob := anon.login("bob")
+
+	ids := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		a := alice.post("A title")
+		ids[a.ID] = true
+		group := "even"
+		if i%2 == 1 {
+			group = "odd"
+		}
+		alice.do("POST", "/articles/"+a.ID+"/groups", map[string][]string{"add": {group, "all"}}, http.StatusNoContent, nil)
+		// 只有发布者可以修改分组
+		bob.do("POST", "/articles/"+a.ID+"/groups", map[string][]string{"remove": {"all"}}, http.StatusForbidden, nil)
+	}
+
+	for _, order := range []string{"", "score", "hn", "hot", "wilson", "time"} {
+		seen := make(map[string]bool)
+		path := "/articles?count=2&order=" + order
+		for pages := 0; ; pages++ {
+			var page pageJSON
+			anon.do("GET", path, nil, http.StatusOK, &page)
+			for _, a := range page.Articles {
+				seen[a.ID] = true
+			}
+			if page.Next == "" {
+				break
+			}
+			if pages > 3 {
+				t.Fatalf("order %q: too many pages", order)
+			}
+			path = "/articles?count=2&order=" + order + "&cursor=" + page.Next
+		}
+		if len(seen) != len(ids) {
+			t.Fatalf("order %q: listed %d articles, want %d", order, len(seen), len(ids))
+		}
+	}
+
+	for query, want := range map[string]int{
+		"group=even":                  3,
+		"group=odd&group=all":         2,
+		"group=odd&group=even&op=and": 0,
+		"group=odd&group=even&op=or":  5,
+	} {
+		var page pageJSON
+		anon.do("GET", "/articles?"+query, nil, http.StatusOK, &page)
+		if len(page.Articles) != want {
+			t.Errorf("%s: %d articles, want %d", query, len(page.Articles), want)
+		}
+	}
+
+	anon.do("GET", "/articles?order=nope", nil, http.StatusNotFound, nil)
+	anon.do("GET", "/articles?count=0", nil, http.StatusBadRequest, nil)
+	anon.do("GET", "/articles?cursor=!!", nil, http.StatusBadRequest, nil)
+	anon.do("GET", "/articles?group=a&op=xor", nil, http.StatusBadRequest, nil)
+}
+
+// 文档是合法的yaml 并且包含所有的接口
+func TestOpenAPI(t *testing.T) {
+	c := newTestServer(t)
+	resp, err := http.Get(c.base + "/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	var doc struct {
+		OpenAPI string                            `yaml:"openapi"`
+		Paths   map[string]map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+	for path, methods := range map[string][]string{
+		"/sessions":             {"post"},
+		"/articles":             {"get", "post"},
+		"/articles/{id}":        {"get"},
+		"/articles/{id}/vote":   {"get", "put"},
+		"/articles/{id}/groups": {"post"},
+		"/openapi.yaml":         {"get"},
+	} {
+		for _, m := range methods {
+			if _, ok := doc.Paths[path][m]; !ok {
+				t.Errorf("%s %s not documented", m, path)
+			}
+		}
+	}
+}
+
+func TestRateLimitAndHeldVotes(t *testing.T) {
+	// 测试服务器的请求都来自127.0.0.1
+	articles.PostLimiter = &ratelimit.Limiter{Name: "post", IP: ratelimit.Limit{Rate: 1, Period: time.Hour}}
+	articles.VoteLimiter = &ratelimit.Limiter{Name: "vote", User: ratelimit.Limit{Rate: 1, Period: time.Hour}}
+	articles.RingDetector = &articles.VoteRingDetector{Threshold: 1}
+	defer func() { articles.PostLimiter, articles.VoteLimiter, articles.RingDetector = nil, nil, nil }()
+
+	anon := newTestServer(t)
+	a := anon.login("alice").post("A title")
+	anon.login("bob").do("POST", "/articles", map[string]string{"title": "t", "link": "l"}, http.StatusTooManyRequests, nil)
+
+	// 刚登录的账号是新账号 投票等待审核
+	var vote voteJSON
+	carol := anon.login("carol")
+	carol.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "up"}, http.StatusAccepted, &vote)
+	if !vote.Held {
+		t.Fatalf("vote = %+v, want held", vote)
+	}
+	carol.do("GET", "/articles/"+a.ID+"/vote", nil, http.StatusOK, &vote)
+	if vote.Vote != "none" {
+		t.Fatalf("held vote counted: %+v", vote)
+	}
+
+	// 不存在的文章的投票直接返回404 不占用限流的配额
+	dave := anon.login("dave")
+	dave.do("PUT", "/articles/999/vote", map[string]string{"vote": "up"}, http.StatusNotFound, nil)
+	dave.do("PUT", "/articles/"+a.ID+"/vote", map[string]string{"vote": "up"}, http.StatusAccepted, nil)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/articles"
+	"strconv"
+)
+
+var redisCli redis.UniversalClient
+
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	articles.IDs = core.NewSegmentAllocator(redisCli, 100)
+}
+
+//测试redis的string类型
+func Ex1_1_string() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.Set(ctx, "hello", "world", 0).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.Get(ctx, "hello").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.Del(ctx, "hello").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.Get(ctx, "hello").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//测试redis的list类型
+func Ex1_2_list() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.RPush(ctx, "list-key", "item").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.RPush(ctx, "list-key", "item2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.RPush(ctx, "list-key", "item").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.LRange(ctx, "list-key", 0, 1).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.LIndex(ctx, "list-key", 1).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.LPop(ctx, "list-key").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.LRange(ctx, "list-key", 0, -1).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//测试hash的 getall
+func test() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.HSet(ctx, "hsh-key", "key1", "val1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HSet(ctx, "hsh-key", "key2", "val2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HSet(ctx, "hsh-key", "key3", "val3").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HGetAll(ctx, "hsh-key").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//测试redis的set类型
+func Ex1_3_set() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.SAdd(ctx, "set-key", "item").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SAdd(ctx, "set-key", "item2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SAdd(ctx, "set-key", "item3").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SAdd(ctx, "set-key", "item").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SMembers(ctx, "set-key").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SIsMember(ctx, "set-key", "item4").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SIsMember(ctx, "set-key", "item").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SRem(ctx, "set-key", "item2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SRem(ctx, "set-key", "item2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.SMembers(ctx, "set-key").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//测试redis的hash类型
+func Ex1_4_hssh() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.HSet(ctx, "hash-ey", "sub-key1", "value1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HSet(ctx, "hash-ey", "sub-key2", "value2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HSet(ctx, "hash-ey", "sub-key1", "value1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HGetAll(ctx, "hash-ey").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HDel(ctx, "hash-ey", "sub-key2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HDel(ctx, "hash-ey", "sub-key2").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HGet(ctx, "hash-ey", "sub-key1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.HGetAll(ctx, "hash-ey").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//测试redis的zset类型
+func Ex1_5_zset() {
+	ctx := context.Background()
+	{
+		ret, err := redisCli.ZAdd(ctx, "zset-ey", &redis.Z{Score: 728, Member: "member1"}).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZAdd(ctx, "zset-ey", &redis.Z{Score: 982, Member: "member0"}).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZAdd(ctx, "zset-ey", &redis.Z{Score: 982, Member: "member0"}).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZRangeWithScores(ctx, "zset-ey", 0, -1).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		//type ZRangeBy struct {
+		//	Min, Max      string
+		//	Offset, Count int64			//limit
+		//}
+		ret, err := redisCli.ZRangeByScore(ctx, "zset-ey", &redis.ZRangeBy{Min: "0", Max: "800"}).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZRem(ctx, "zset-ey", "member1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZRem(ctx, "zset-ey", "member1").Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+	{
+		ret, err := redisCli.ZRangeWithScores(ctx, "zset-ey", 0, -1).Result()
+		fmt.Println("ret:", ret, " err:", err)
+	}
+}
+
+//总测试
+func TestCh01() {
+	ctx := context.Background()
+
+	conn := redisCli
+	article_id, err := articles.PostArticle(ctx, conn, "username", "A title", "http://www.google.com")
+	if err != nil {
+		fmt.Println("post article err:", err)
+		return
+	}
+	fmt.Println("We posted a new article with id:", article_id)
+
+	fmt.Println("Its HASH looks like:")
+	r := conn.HGetAll(ctx, "article:"+article_id).Val()
+	fmt.Println(r)
+
+	if _, err := articles.ArticleVote(ctx, conn, "other_user", "article:"+article_id); err != nil {
+		fmt.Println("vote err:", err)
+	}
+	fmt.Println("We voted for the article, it now has votes:")
+	v := conn.HGet(ctx, "article:"+article_id, "votes").Val()
+	fmt.Println(v)
+
+	if _, err := articles.ArticleOpposeVote(ctx, conn, "other_user", "article:"+article_id); err != nil {
+		fmt.Println("oppose vote err:", err)
+	}
+	fmt.Println("We changed our vote to oppose the article, it now has votes:")
+	v2 := conn.HGet(ctx, "article:"+article_id, "votes").Val()
+	fmt.Println(v2)
+
+	fmt.Println("The currently highest-scoring articles are:")
+	list, err := articles.GetArticles(ctx, conn, 1, "")
+	fmt.Println(list, err)
+	fmt.Println("article count:", len(list))
+
+	aid, _ := strconv.ParseInt(article_id, 10, 64)
+	if err := articles.AddRemoveGroups(ctx, conn, int(aid), []string{"new-group"}, nil); err != nil {
+		fmt.Println("add groups err:", err)
+	}
+	fmt.Println("We added the article to a new group, other articles include:")
+	list, err = articles.GetGroupArticles(ctx, conn, "new-group", 1, "")
+	fmt.Println(list, err)
+	fmt.Println("article count:", len(list))
+
+	fmt.Println("Articles in new-group or other-group, ordered by wilson score:")
+	page, err := articles.QueryGroupArticles(ctx, conn, articles.GroupQuery{
+		Groups: []string{"new-group", "other-group"}, Op: articles.GroupOr, Order: "wilson"}, "", 10)
+	if err != nil {
+		fmt.Println("query groups err:", err)
+		return
+	}
+	fmt.Println(page.Articles, "next cursor:", page.Next)
+}
+
+func main() {
+	//test()
+	//Ex1_1_string()
+	//Ex1_2_list()
+	//Ex1_3_set()
+	//Ex1_4_hssh()
+	//Ex1_5_zset()
+	TestCh01()
+	ctx := context.Background()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/cache"
+	"redis-learn/redis_example_go/recommend"
+	"redis-learn/redis_example_go/sessions"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCh02_test_login_cookies() {
+	ctx := context.Background()
+	conn := redisCli
+	token := core.NewToken()
+
+	if err := sessions.UpdateToken(ctx, conn, token, "username", "itemX"); err != nil {
+		fmt.Println("update token err:", err)
+		return
+	}
+	fmt.Println("We just logged-in/updated token:", token)
+	fmt.Println("For user:", "username")
+
+	fmt.Println("What username do we Get when we look-up that token?")
+	r, err := sessions.CheckToken(ctx, conn, token)
+	fmt.Println(r, err)
+
+	fmt.Println("Let s drop the maximum number of cookies to 0 to clean them out")
+	fmt.Println("We will start a thread to do the cleaning, while we stop it later")
+
+	cleanCtx, cancel := context.WithCancel(ctx)
+	go sessions.CleanSessions(cleanCtx, conn, 0)
+	time.Sleep(time.Second)
+	cancel()
+	time.Sleep(time.Second * 2)
+
+	s := conn.HLen(ctx, "login:").Val()
+	fmt.Println("The current number of sessions still available is:", s)
+}
+
+func TestCh02_test_shoping_cart_cookies() {
+	ctx := context.Background()
+	conn := redisCli
+	token := core.NewToken()
+
+	fmt.Println("We'll refresh our session...")
+	sessions.UpdateToken(ctx, conn, token, "username", "itemX")
+	fmt.Println("And add an item to the shopping cart")
+	sessions.AddToCart(ctx, conn, token, "itemY", 3)
+	r := conn.HGetAll(ctx, "cart:"+token).Val()
+	fmt.Println("Our shopping cart currently has:", r)
+
+	fmt.Println("Let's clean out our sessions and carts")
+	cleanCtx, cancel := context.WithCancel(ctx)
+	go sessions.CleanFullSessions(cleanCtx, conn, 0)
+	time.Sleep(time.Second)
+	cancel()
+	time.Sleep(2 * time.Second)
+
+	r2 := conn.HGetAll(ctx, "cart:"+token).Val()
+	fmt.Println("Our shopping cart now contains:", r2)
+}
+
+func TestCh02_test_cache_request() {
+	ctx := context.Background()
+	conn := redisCli
+	token := core.NewToken()
+
+	callback := func(request string) string {
+		return "content for " + request
+	}
+
+	sessions.UpdateToken(ctx, conn, token, "username", "itemX")
+	url := "http://test.com/?item=itemX"
+	fmt.Println("We are going to cache a simple request against ", url)
+	result, err := cache.CacheRequest(ctx, conn, url, callback)
+	fmt.Println("We got initial content:", result, err)
+
+	fmt.Println("To test that we've cached the request, we'll pass a bad callback")
+	result2, err := cache.CacheRequest(ctx, conn, url, nil)
+	fmt.Println("We ended up Getting the same response! ", result2, err)
+
+	fmt.Println(cache.CanCache(ctx, conn, "http://test.com/"))
+	fmt.Println(cache.CanCache(ctx, conn, "http://test.com/?item=itemX&_=1234536"))
+}
+
+func TestCh02_test_cache_rows() {
+	ctx := context.Background()
+
+	conn := redisCli
+
+	fmt.Println("First, let's schedule caching of itemX every 5 seconds")
+	cache.ScheduleRowCache(ctx, conn, "itemX", 5)
+	fmt.Println("Our schedule looks like:")
+	s := conn.ZRangeWithScores(ctx, "schedule:", 0, -1).Val()
+	fmt.Println(s)
+
+	fmt.Println("We.ll start a caching thread that will cache the data...")
+	cacheCtx, cancel := context.WithCancel(ctx)
+	go cache.CacheRows(cacheCtx, conn)
+
+	time.Sleep(time.Second)
+	fmt.Println("Our cached data looks like:")
+	r := conn.Get(ctx, "inv:itemX").Val()
+	fmt.Println(r)
+	fmt.Println("We'll check again in 5 seconds...")
+	time.Sleep(5 * time.Second)
+	fmt.Println("Notice that the data has changed...")
+	r2 := conn.Get(ctx, "inv:itemX").Val()
+	fmt.Println(r2)
+
+	fmt.Println("Let's force un-caching")
+	cache.ScheduleRowCache(ctx, conn, "itemX", -1)
+	time.Sleep(time.Second)
+	r3 := conn.Get(ctx, "inv:itemX").Val()
+	fmt.Println("The cache was cleared?", r3 == "")
+
+	cancel()
+	time.Sleep(2 * time.Second)
+}
+
+func TestCh02_test_recommendations() {
+	ctx := context.Background()
+	conn := redisCli
+	sessions.OnView = recommend.RecordView
+	defer func() { sessions.OnView = nil }()
+
+	fmt.Println("Two visitors browse some items...")
+	first, second := core.NewToken(), core.NewToken()
+	for _, item := range []string{"itemX", "itemY", "itemZ"} {
+		sessions.UpdateToken(ctx, conn, first, "username", item)
+	}
+	for _, item := range []string{"itemX", "itemY"} {
+		sessions.UpdateToken(ctx, conn, second, "username2", item)
+	}
+	fmt.Println("People who viewed itemX also viewed:")
+	recs, err := recommend.AlsoViewed(ctx, conn, "itemX", "", 5)
+	fmt.Println(recs, err)
+
+	fmt.Println("After putting itemY in the cart, it is no longer recommended:")
+	sessions.AddToCart(ctx, conn, second, "itemY", 1)
+	recs, err = recommend.AlsoViewed(ctx, conn, "itemX", second, 5)
+	fmt.Println(recs, err)
+}
+
+func main() {
+	ctx := context.Background()
+	//TestCh02_test_login_cookies()
+	//TestCh02_test_cache_rows()
+	//TestCh02_test_cache_request()
+	//TestCh02_test_recommendations()
+	TestCh02_test_shoping_cart_cookies()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
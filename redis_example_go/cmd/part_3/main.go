@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/commands"
+	"sync"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Ex3_1() {
+	ctx := context.Background()
+	{
+		ret := redisCli.Get(ctx, "key").Val() //尝试获取一个不存在的键将得到一个None值，终端不会显示这个值。
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.Incr(ctx, "key").Val() //我们既可以对不存在的键执行自增操作	也可以通过可选的参数来指定自增操作的增量。
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.IncrBy(ctx, "key", 15).Val()
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.Decr(ctx, "key").Val() //和自增操作一样，	执行自减操作的函数也可以通过可选的参数来指定减量。
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.Get(ctx, "key").Val() //在尝试获取一个键的时候，命令以字符串格式返回被存储的整数。
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.Set(ctx, "key", "13", 0).Val() //即使在设置键时输入的值为字符串，	但只要这个值可以被解释为整数，
+		fmt.Println("ret:", ret)
+	}
+	{
+		ret := redisCli.Incr(ctx, "key").Val() //我们就可以把它当作整数来处理。
+		fmt.Println("ret:", ret)
+	}
+}
+
+func Ex3_2() {
+	ctx := context.Background()
+	{
+		ret := redisCli.Append(ctx, "new-string-key", "hello ").Val()
+		fmt.Println("ret:", ret)
+	}
+	//...
+}
+
+//...
+
+func Publisher(data string) {
+	ctx := context.Background()
+	time.Sleep(time.Second)
+	for {
+		err := redisCli.Publish(ctx, "message", data).Err()
+		if err != nil {
+			fmt.Println("发布失败")
+			return
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
+
+func Subscribe() {
+	ctx := context.Background()
+	go Publisher("test")
+	//参数1 频道名 字符串类型
+	pubsub := redisCli.Subscribe(ctx, "message")
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		return
+	}
+	ch := pubsub.Channel()
+	count := 0
+	for {
+		msg, ok := <-ch
+		fmt.Println(msg.Channel, msg.Payload)
+		if !ok {
+			break
+		}
+		count++
+		if count == 4 {
+			pubsub.Unsubscribe(ctx)
+		}
+		if count == 5 {
+			break
+		}
+	}
+	fmt.Println("end")
+}
+
+func TestVotes() {
+	ctx := context.Background()
+	conn := redisCli
+	conn.ZAdd(ctx, "time:", &redis.Z{Score: float64(time.Now().Unix()), Member: "article:1"})
+	// 同一个用户投两次票只算一次
+	for i := 0; i < 2; i++ {
+		if err := commands.ArticleVote(ctx, conn, "user", "article:1"); err != nil {
+			fmt.Println("vote err:", err)
+		}
+	}
+	if err := commands.ArticleVoteWatch(ctx, conn, "other", "article:1"); err != nil {
+		fmt.Println("vote err:", err)
+	}
+	fmt.Println("score:", conn.ZScore(ctx, "score:", "article:1").Val(), "votes:", conn.HGet(ctx, "article:1", "votes").Val())
+}
+
+// 100个goroutine同时用乐观锁给同一个键加一
+func TestIncrement() {
+	ctx := context.Background()
+	const routineCount = 100
+	var wg sync.WaitGroup
+	wg.Add(routineCount)
+	for i := 0; i < routineCount; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := commands.Increment(ctx, redisCli, "counter3", routineCount); err != nil {
+				fmt.Println("increment error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := redisCli.Get(ctx, "counter3").Int()
+	fmt.Println("ended with", n, err)
+	// Output: ended with 100 <nil>
+}
+
+//列表和散列都无法在操作的同时设置过期时间 所有需要在操作完之后单独调用过期设置函数
+
+func main() {
+	ctx := context.Background()
+	//Subscribe()
+	TestVotes()
+	TestIncrement()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
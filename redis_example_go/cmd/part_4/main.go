@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/market"
+	"redis-learn/redis_example_go/sessions"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+// 市场的订单号由 core.GenID 生成 机器号从redis租用 避免多个进程生成相同的订单号 没有租用机器号时无法结账
+var idLease *core.WorkerLease
+
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	idLease, err = core.InitIDGenerator(ctx, redisCli, time.Minute)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//性能测试
+func benchmark_update_token(conn redis.UniversalClient, duration time.Duration) {
+	ctx := context.Background()
+	// 测试会分别执行update_token()函数和update_token_pipeline()函数。
+	funcs := map[string]func(ctx context.Context, conn redis.Cmdable, token string, user string, item string) error{
+		"UpdateToken":         sessions.UpdateToken,
+		"UpdateTokenPipeline": sessions.UpdateTokenPipeline,
+	}
+	for name, function := range funcs {
+		// 设置计数器以及测试结束的条件。
+		count := 0
+		start := time.Now()
+		end := start.Add(duration)
+		for time.Now().Before(end) {
+			count += 1
+			// 调用两个函数的其中一个。
+			function(ctx, conn, "token", "user", "item")
+		}
+		// 计算函数的执行时长。
+		delta := time.Since(start).Seconds()
+		// 打印测试结果。
+		fmt.Println(name, count, delta, float64(count)/delta)
+	}
+}
+
+func TestCh04_test_list_item() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("We need to set up just enough state so that a user can list an item")
+	seller := "userX"
+	item := "itemX"
+	conn.SAdd(ctx, "inventory:"+seller, item)
+	fmt.Println("The user's inventory has:", conn.SMembers(ctx, "inventory:"+seller).Val())
+
+	fmt.Println("Listing the item...")
+	err := market.ListItem(ctx, conn, item, seller, 10)
+	fmt.Println("Listing the item succeeded?", err == nil, err)
+	fmt.Println("The market contains:", conn.ZRangeWithScores(ctx, "market:", 0, -1).Val())
+}
+
+func TestCh04_test_purchase_item() {
+	ctx := context.Background()
+	conn := redisCli
+	TestCh04_test_list_item()
+
+	fmt.Println("We need to set up just enough state so a user can buy an item")
+	conn.HSet(ctx, "users:userY", "funds", 125)
+	fmt.Println("The user has some money:", conn.HGetAll(ctx, "users:userY").Val())
+
+	fmt.Println("Let's purchase an item")
+	err := market.PurchaseItem(ctx, conn, "userY", "itemX", "userX", 10)
+	fmt.Println("Purchasing an item succeeded?", err == nil, err)
+	fmt.Println("Their money is now:", conn.HGetAll(ctx, "users:userY").Val())
+	fmt.Println("Their inventory is now:", conn.SMembers(ctx, "inventory:userY").Val())
+}
+
+func main() {
+	ctx := context.Background()
+	TestCh04_test_purchase_item()
+	//benchmark_update_token(redisCli, 5*time.Second)
+	if err := idLease.Release(ctx); err != nil {
+		fmt.Println("release worker id err:", err)
+	}
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
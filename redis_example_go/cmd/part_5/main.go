@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"math/rand"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/logs"
+	"redis-learn/redis_example_go/stats"
+	"strconv"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCh05_test_log_recent() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's write a few logs to the recent log")
+	for msg := 0; msg < 5; msg++ {
+		logs.LogRecent(ctx, conn, "test", "this is message "+strconv.Itoa(msg), "")
+	}
+	recent := conn.LRange(ctx, "recent:test:info", 0, -1).Val()
+	fmt.Println("The current recent message log has this many messages:", len(recent))
+	fmt.Println("Those messages include:", recent)
+}
+
+func TestCh05_test_log_common() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's write some items to the common log")
+	for count := 1; count < 6; count++ {
+		for i := 0; i < count; i++ {
+			logs.LogCommon(ctx, conn, "test", "message-"+strconv.Itoa(count), "", 0)
+		}
+	}
+	common := conn.ZRevRangeWithScores(ctx, "common:test:info", 0, -1).Val()
+	fmt.Println("The current number of common messages is:", len(common))
+	fmt.Println("Those common messages are:", common)
+}
+
+func TestCh05_test_counters() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's update some counters for now and a little in the future")
+	now := time.Now()
+	for delta := 0; delta < 10; delta++ {
+		stats.UpdateCounter(ctx, conn, "test", int64(rand.Intn(4)+1), now.Add(time.Duration(delta)*time.Second))
+	}
+	counter, _ := stats.GetCounter(ctx, conn, "test", 1)
+	fmt.Println("We have some per-second counters:", len(counter))
+	counter, _ = stats.GetCounter(ctx, conn, "test", 5)
+	fmt.Println("We have some per-5-second counters:", len(counter))
+	fmt.Println("These counters include:", counter)
+
+	fmt.Println("Let's clean out some counters by setting our sample count to 0")
+	stats.SAMPLE_COUNT = 0
+	cleanCtx, cancel := context.WithCancel(ctx)
+	go stats.CleanCounters(cleanCtx, conn)
+	time.Sleep(time.Second)
+	cancel()
+	stats.SAMPLE_COUNT = 100
+	counter, err := stats.GetCounter(ctx, conn, "test", 86400)
+	fmt.Println("Did we clean out all of the counters?", len(counter) == 0, err)
+}
+
+func TestCh05_test_stats() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's add some data for our statistics!")
+	var r []float64
+	var err error
+	for i := 0; i < 5; i++ {
+		r, err = stats.UpdateStats(ctx, conn, "temp", "example", float64(rand.Intn(10)+5), 0)
+	}
+	fmt.Println("We have some aggregate statistics:", r, err)
+	data, err := stats.GetStats(ctx, conn, "temp", "example")
+	fmt.Println("Which we can also fetch manually:", data, err)
+}
+
+func TestCh05_test_access_time() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's calculate some access times...")
+	for i := 0; i < 10; i++ {
+		stats.AccessTime(ctx, conn, "req-"+strconv.Itoa(i), func() {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		})
+	}
+	fmt.Println("The slowest access times are:", conn.ZRevRangeWithScores(ctx, "slowest:AccessTime", 0, 9).Val())
+}
+
+func TestCh05_test_is_under_maintenance() {
+	ctx := context.Background()
+	conn := redisCli
+
+	m, err := stats.IsUnderMaintenance(ctx, conn)
+	fmt.Println("Are we under maintenance (we shouldn't be)?", m, err)
+	conn.Set(ctx, "is-under-maintenance", "yes", 0)
+	m, err = stats.IsUnderMaintenance(ctx, conn)
+	fmt.Println("We cached this, so it should be the same:", m, err)
+	time.Sleep(1100 * time.Millisecond)
+	m, err = stats.IsUnderMaintenance(ctx, conn)
+	fmt.Println("But after a sleep, it should change:", m, err)
+	fmt.Println("Cleaning up...")
+	conn.Del(ctx, "is-under-maintenance")
+	time.Sleep(1100 * time.Millisecond)
+	m, err = stats.IsUnderMaintenance(ctx, conn)
+	fmt.Println("Should be False again:", m, err)
+}
+
+func TestCh05_test_config() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's set a config and then get it back...")
+	if err := stats.SetConfig(ctx, conn, "redis", "test", map[string]interface{}{"db": 15}); err != nil {
+		fmt.Println("set config err:", err)
+	}
+	config, err := stats.GetConfig(ctx, conn, "redis", "test", 0)
+	fmt.Println("The config is:", config, err)
+}
+
+// 根据配置动态创建连接的装饰器（代码清单5-16、5-17）在go中对应为
+// 用core.LoadConfig读取的配置（或者stats.GetConfig读取的组件配置）创建core.Config 再调用core.Connect
+
+func main() {
+	ctx := context.Background()
+	TestCh05_test_log_recent()
+	TestCh05_test_log_common()
+	TestCh05_test_counters()
+	TestCh05_test_stats()
+	TestCh05_test_access_time()
+	//TestCh05_test_is_under_maintenance()
+	TestCh05_test_config()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/autocomplete"
+	"redis-learn/redis_example_go/chat"
+	"redis-learn/redis_example_go/locks"
+	"redis-learn/redis_example_go/queues"
+	"strconv"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	chat.IDs = core.NewSegmentAllocator(redisCli, 100)
+}
+
+func TestCh06_test_add_update_contact() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's add a few contacts...")
+	for i := 0; i < 10; i++ {
+		autocomplete.AddUpdateContact(ctx, conn, "user", fmt.Sprintf("contact-%d-%d", i/3, i))
+	}
+	fmt.Println("Current recently contacted contacts:", conn.LRange(ctx, "recent:user", 0, -1).Val())
+
+	fmt.Println("Let's pull one of the older ones up to the front")
+	autocomplete.AddUpdateContact(ctx, conn, "user", "contact-1-4")
+	fmt.Println("New top-3 contacts:", conn.LRange(ctx, "recent:user", 0, 2).Val())
+
+	fmt.Println("Let's remove a contact...")
+	autocomplete.RemoveContact(ctx, conn, "user", "contact-2-6")
+	fmt.Println("New contacts:", conn.LRange(ctx, "recent:user", 0, -1).Val())
+
+	matches, err := autocomplete.FetchAutocompleteList(ctx, conn, "user", "contact-2-")
+	fmt.Println("And let's finally autocomplete on contact-2-:", matches, err)
+}
+
+func TestCh06_test_address_book_autocomplete() {
+	ctx := context.Background()
+	conn := redisCli
+
+	start, end := autocomplete.FindPrefixRange("abc")
+	fmt.Println("the start/end range of 'abc' is:", start, end)
+
+	fmt.Println("Let's add a few people to the guild")
+	for _, name := range []string{"jeff", "jenny", "jack", "jennifer"} {
+		autocomplete.JoinGuild(ctx, conn, "test", name)
+	}
+	r, err := autocomplete.AutocompleteOnPrefix(ctx, conn, "test", "je")
+	fmt.Println("now let's try to find users with names starting with 'je':", r, err)
+	fmt.Println("jeff just left to join a different guild...")
+	autocomplete.LeaveGuild(ctx, conn, "test", "jeff")
+	fmt.Println(autocomplete.AutocompleteOnPrefix(ctx, conn, "test", "je"))
+}
+
+func TestCh06_test_distributed_locking() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Getting an initial lock...")
+	r, err := locks.AcquireLockWithTimeout(ctx, conn, "testlock", time.Second, time.Second)
+	fmt.Println("Got it?", r != "", err)
+	fmt.Println("Trying to get it again without releasing the first one...")
+	r, err = locks.AcquireLockWithTimeout(ctx, conn, "testlock", 10*time.Millisecond, time.Second)
+	fmt.Println("Got it?", r != "", err)
+	fmt.Println("Waiting for the lock to timeout...")
+	time.Sleep(2 * time.Second)
+	r, err = locks.AcquireLockWithTimeout(ctx, conn, "testlock", time.Second, time.Second)
+	fmt.Println("Getting the lock again, got it?", r != "", err)
+	fmt.Println(locks.ReleaseLock(ctx, conn, "testlock", r))
+}
+
+func TestCh06_test_counting_semaphore() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Getting 3 initial semaphores with a limit of 3...")
+	for i := 0; i < 3; i++ {
+		r, err := locks.AcquireFairSemaphore(ctx, conn, "testsem", 3, time.Second)
+		fmt.Println("Got it?", r != "", err)
+	}
+	r, err := locks.AcquireFairSemaphore(ctx, conn, "testsem", 3, time.Second)
+	fmt.Println("Getting one more that should fail, got it?", r != "", err)
+	fmt.Println("Lets's wait for some of them to time out")
+	time.Sleep(2 * time.Second)
+	r, err = locks.AcquireFairSemaphore(ctx, conn, "testsem", 3, time.Second)
+	fmt.Println("Can we get one?", r != "", err)
+	fmt.Println(locks.ReleaseFairSemaphore(ctx, conn, "testsem", r))
+}
+
+func TestCh06_test_delayed_tasks() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's start some regular and delayed tasks...")
+	for _, delay := range []time.Duration{0, 500 * time.Millisecond, 0, 1500 * time.Millisecond} {
+		queues.ExecuteLater(ctx, conn, "tqueue", "testfn", nil, delay)
+	}
+	fmt.Println("How many non-delayed tasks are there (should be 2)?", conn.LLen(ctx, "queue:tqueue").Val())
+	fmt.Println("Let's start up a thread to bring those delayed tasks back...")
+	pollCtx, cancel := context.WithCancel(ctx)
+	go queues.PollQueue(pollCtx, conn)
+	time.Sleep(2 * time.Second)
+	cancel()
+	fmt.Println("Waiting is over, how many tasks do we have (should be 4)?", conn.LLen(ctx, "queue:tqueue").Val())
+}
+
+func TestCh06_test_multi_recipient_messaging() {
+	ctx := context.Background()
+	conn := redisCli
+
+	fmt.Println("Let's create a new chat session with some recipients...")
+	chat_id, err := chat.CreateChat(ctx, conn, "joe", []string{"jeff", "jenny"}, "message 1", "")
+	if err != nil {
+		fmt.Println("create chat err:", err)
+		return
+	}
+	fmt.Println("Now let's send a few messages...")
+	for i := 2; i < 5; i++ {
+		chat.SendMessage(ctx, conn, chat_id, "joe", "message "+strconv.Itoa(i))
+	}
+	fmt.Println("And let's get the messages that are waiting for jeff and jenny...")
+	r1, _ := chat.FetchPendingMessages(ctx, conn, "jeff")
+	r2, _ := chat.FetchPendingMessages(ctx, conn, "jenny")
+	fmt.Println("Those messages are:", r1, r2)
+}
+
+func main() {
+	ctx := context.Background()
+	TestCh06_test_add_update_contact()
+	TestCh06_test_address_book_autocomplete()
+	//TestCh06_test_distributed_locking()
+	//TestCh06_test_counting_semaphore()
+	TestCh06_test_delayed_tasks()
+	TestCh06_test_multi_recipient_messaging()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
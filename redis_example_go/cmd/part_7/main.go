@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/search"
+	"strconv"
+	"time"
+)
+
+var redisCli redis.UniversalClient
+
+func main() {
+	Test(redisCli)
+	TestSearch(redisCli)
+	TestAds(redisCli)
+	TestJobs(redisCli)
+	ctx := context.Background()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//交 并 差  运算  使用redis的sort功能
+//搜索原理 反向查找：使用网页内容关键词建立网页索引 通过关键词查找获取到相关网页id集合
+//然后对多个集合进行并集 交集 差集的计算最终得出想要的结果
+
+//可以使用有序集合记录字符串的分值来对字符串进行排序
+func Test(conn redis.UniversalClient) {
+	ctx := context.Background()
+	items1 := []string{"item1", "item2", "item3"}
+	items2 := []string{"item1", "item2", "item4"}
+	items3 := []string{"item2", "item3"}
+	conn.SAdd(ctx, "set1", items1)
+	conn.SAdd(ctx, "set2", items2)
+	conn.SAdd(ctx, "set3", items3)
+
+	fmt.Println("conn.SInter set1,set2 :", conn.SInter(ctx, "set1", "set2").Val())
+	fmt.Println("conn.SInter set1,set3 :", conn.SInter(ctx, "set1", "set3").Val())
+	fmt.Println("conn.SInter set2,set3 :", conn.SInter(ctx, "set2", "set3").Val())
+	conn.SInterStore(ctx, "destination", "set1", "set2")
+	fmt.Println("conn.SUnion set1,set2 :", conn.SUnion(ctx, "set1", "set2").Val())
+	fmt.Println("conn.SUnion set1,set3 :", conn.SUnion(ctx, "set1", "set3").Val())
+	fmt.Println("conn.SUnion set2,set3 :", conn.SUnion(ctx, "set2", "set3").Val())
+	conn.SUnionStore(ctx, "destination", "set1", "set2")
+	fmt.Println("conn.SDiff set1,set2 :", conn.SDiff(ctx, "set1", "set2").Val())
+	fmt.Println("conn.SDiff set2,set1 :", conn.SDiff(ctx, "set2", "set1").Val())
+	fmt.Println("conn.SDiff set1,set3 :", conn.SDiff(ctx, "set1", "set3").Val())
+	fmt.Println("conn.SDiff set3,set1 :", conn.SDiff(ctx, "set3", "set1").Val())
+	fmt.Println("conn.SDiff set2,set3 :", conn.SDiff(ctx, "set2", "set3").Val())
+	fmt.Println("conn.SDiff set3,set2 :", conn.SDiff(ctx, "set3", "set2").Val())
+	conn.SDiffStore(ctx, "destination", "set1", "set2")
+
+	now := time.Now().Unix()
+
+	datas := [][]struct {
+		score  float64
+		member string
+	}{
+		{ //点击数量的有序集合
+			{555, "item1"},
+			{333, "item2"},
+			{444, "item3"},
+			{4552, "item4"},
+		},
+		{ //排名的有序集合
+			{99, "item1"},
+			{88, "item2"},
+			{55, "item4"},
+		},
+		{ //时间戳的有序集合
+			{float64(now + 10), "item1"},
+			{float64(now + 30), "item3"},
+			{float64(now + 50), "item4"},
+		},
+		{ //需求的技能熟练度
+			{-10, "item1"},
+			{-20, "item2"},
+			{-100, "item3"},
+		},
+		{ //掌握的技能熟练度
+			{20, "item1"},
+			{40, "item2"},
+			{200, "item3"},
+			{60, "item4"},
+		},
+	}
+
+	for no, arr := range datas {
+		for _, v := range arr {
+			keyName := "zset" + strconv.Itoa(no)
+			conn.ZAdd(ctx, keyName, &redis.Z{Score: v.score, Member: v.member})
+		}
+	}
+	base := []string{"item1", "item2", "item3", "item4"}
+	conn.SAdd(ctx, "base", base)
+
+	zustores := []*redis.ZStore{
+		{ //按点击数量排序
+			Keys:    []string{"base", "zset0"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //按排名排序
+			Keys:    []string{"base", "zset1"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //按时间戳排序
+			Keys:    []string{"base", "zset2"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //技能熟练度的合并（分析契合度）
+			Keys:    []string{"zset3", "zset4"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+	}
+	aggregates := []string{"SUM", "MIN", "MAX"}
+	for _, v := range zustores {
+		for _, agg := range aggregates {
+			v.Aggregate = agg
+			conn.ZUnionStore(ctx, "destination", v)
+			fmt.Println("conn.ZUnionStore keys:", v.Keys, " aggregates:", agg, " ret:", conn.ZRangeWithScores(ctx, "destination", 0, -1).Val())
+		}
+	}
+
+	zistores := []*redis.ZStore{
+		{ //按点击数量排序
+			Keys:    []string{"base", "zset0"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //按排名排序
+			Keys:    []string{"base", "zset1"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //按时间戳排序
+			Keys:    []string{"base", "zset2"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+		{ //技能熟练度的合并（分析契合度）
+			Keys:    []string{"zset3", "zset4"},
+			Weights: []float64{},
+			//Aggregate: "",
+		},
+	}
+
+	for _, v := range zistores {
+		for _, agg := range aggregates {
+			v.Aggregate = agg
+			conn.ZInterStore(ctx, "destination", v)
+			fmt.Println("conn.ZInterStore keys:", v.Keys, " aggregates:", agg, " ret:", conn.ZRangeWithScores(ctx, "destination", 0, -1).Val())
+		}
+	}
+
+	//通过将一个不存在的键作为参数传给 BY 选项， 可以让 SORT 跳过排序操作， 直接返回结果
+	//这种用法和 GET 选项配合， 就可以在不排序的情况下， 获取多个外部键， 相当于执行一个整合的获取操作（类似于 SQL 数据库的 join 关键字）。
+	//将具备某个前缀的一堆键名的后半部分存储在一个集合中，通过sort的这个方法可以一次性拿取到所有键值
+	conn.Sort(ctx, "key", &redis.Sort{
+		//By            string	//通过使用 BY 选项，可以让按其他键的元素来排序 例如user_level_{uid} SORT uid BY user_level_*
+		//甚至可以将哈希作为外部键来使用SORT uid BY user_info_*->level
+		//Offset, Count int64	//LIMIT 修饰符限制返回结果
+		//Get           []string	//1使用 GET 选项， 可以根据排序的结果来取出相应的键值。2可以同时使用多个 GET 选项，
+		// 获取多个外部键的值。3GET 有一个额外的参数规则，那就是 —— 可以用 # 获取被排序键的值
+		//Order         string	//SORT key DESC ：
+		//Alpha         bool	//使用 ALPHA 修饰符对字符串进行排序
+	})
+	temps := []string{"sfsfys", "yys", "zdfdswe", "zdfdsweersfs"}
+	for i, v := range base {
+		conn.Set(ctx, v, temps[i], 0)
+	}
+	//TODO 不是string类型不行!
+	nums := []string{"4", "2", "3", "1"}
+	conn.SAdd(ctx, "temp", nums)
+	cint := conn.SortStore(ctx, "temp", "store", &redis.Sort{
+		Get:   []string{"#", "item*"},
+		By:    "item*",
+		Alpha: true,
+	}).Val()
+	fmt.Println("cint:", cint)
+	//fmt.Println("store type:",conn.Type("store"))
+	//sort temp by item* get item* get # alpha
+	fmt.Println("store:", conn.LRange(ctx, "store", 0, -1).Val())
+}
+
+//建立反向索引 然后用查询语句搜索并排序 实现见 search 包
+func TestSearch(conn redis.UniversalClient) {
+	ctx := context.Background()
+	docs := map[string]string{
+		"1": "this is some random content, look at how it is indexed.",
+		"2": "this is some additional content, look at how it is indexed.",
+	}
+	for id, content := range docs {
+		n, err := search.IndexDocument(ctx, conn, id, content)
+		fmt.Println("IndexDocument:", id, n, err)
+		conn.ZAdd(ctx, "idx:sort:update", &redis.Z{Score: float64(time.Now().Unix()), Member: id})
+		conn.HSet(ctx, "kb:doc:"+id, "id", id, "updated", time.Now().Unix())
+	}
+	fmt.Println("Tokenize:", search.Tokenize(docs["1"]))
+	fmt.Println(search.Parse("content +indexed random -additional"))
+
+	id, err := search.ParseAndSearch(ctx, conn, "content +indexed random -additional", search.DefaultTTL)
+	fmt.Println("ParseAndSearch:", conn.SMembers(ctx, "idx:"+id).Val(), err)
+	res, err := search.SearchAndSort(ctx, conn, "content indexed", search.SortOptions{Sort: "-id"})
+	fmt.Printf("SearchAndSort: %+v %v\n", res, err)
+	res, err = search.SearchAndZSort(ctx, conn, "content indexed", search.ZSortOptions{Update: 1})
+	fmt.Printf("SearchAndZSort: %+v %v\n", res, err)
+	fmt.Println("StringToScore:", search.StringToScore("robber", false), search.StringToScore("robbers", false))
+}
+
+//按地区和页面内容定向广告 并根据点击更新eCPM
+func TestAds(conn redis.UniversalClient) {
+	ctx := context.Background()
+	content := "this is some random content, look at how it is indexed."
+	fmt.Println("IndexAd:", search.IndexAd(ctx, conn, "1", []string{"USA", "CA"}, content, "cpc", .25))
+	fmt.Println("IndexAd:", search.IndexAd(ctx, conn, "2", []string{"USA", "VA"}, content+" wooooo", "cpc", .125))
+	target_id, ad_id, err := search.TargetAds(ctx, conn, []string{"USA"}, content)
+	fmt.Println("TargetAds:", target_id, ad_id, err)
+	fmt.Println("RecordClick:", search.RecordClick(ctx, conn, target_id, ad_id, false))
+	fmt.Println("idx:ad:value:", conn.ZRangeWithScores(ctx, "idx:ad:value:", 0, -1).Val())
+}
+
+//根据求职者的技能找出能够胜任的职位
+func TestJobs(conn redis.UniversalClient) {
+	ctx := context.Background()
+	search.AddJob(ctx, conn, "test", []string{"q1", "q2", "q3"})
+	ok, err := search.IsQualified(ctx, conn, "test", []string{"q1", "q2"})
+	fmt.Println("IsQualified:", ok, err)
+	search.IndexJob(ctx, conn, "test1", []string{"q1", "q2", "q3"})
+	search.IndexJob(ctx, conn, "test2", []string{"q1", "q3", "q4"})
+	jobs, err := search.FindJobs(ctx, conn, []string{"q1", "q3", "q4"})
+	fmt.Println("FindJobs:", jobs, err)
+	search.IndexJobYears(ctx, conn, "job1", map[string]int{"go": 3})
+	jobs, err = search.SearchJobYears(ctx, conn, map[string]int{"go": 5})
+	fmt.Println("SearchJobYears:", jobs, err)
+}
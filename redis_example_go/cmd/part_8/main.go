@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"github.com/go-redis/redis/v8"
+	"os"
 	"redis-learn/core"
 )
 
-var redisCli *redis.Client
+var redisCli redis.UniversalClient
 
 //初始化连接
 func init() {
 	ctx := context.Background()
-	redisCli = core.InitRedis(ctx, "127.0.0.1:6379", "", 0)
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
 }
 
 //采用分块http可以实现生成并发送增量式数据 websocket可以实现服务器推送（更佳）
@@ -20,5 +30,7 @@ func init() {
 
 func main() {
 	ctx := context.Background()
-	core.ClearAllKeys(ctx, redisCli)
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
 }
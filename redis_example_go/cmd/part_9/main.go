@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/shard"
+)
+
+var redisCli redis.UniversalClient
+
+//初始化连接
+func init() {
+	ctx := context.Background()
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCh09_test_long_ziplist_performance() {
+	ctx := context.Background()
+	for _, length := range []int64{1, 100, 1000, 5000} {
+		ops, err := shard.LongZiplistPerformance(ctx, redisCli, "list", length, 100, 100)
+		fmt.Println("list length", length, "rpoplpush/s:", ops, err)
+	}
+}
+
+func TestCh09_test_shard_key() {
+	base := "test"
+	fmt.Println(shard.ShardKey(base, "1", 2, 2), shard.ShardKey(base, "125", 1000, 100))
+	for i := 0; i < 50; i++ {
+		fmt.Print(shard.ShardKey(base, fmt.Sprintf("hello:%d", i), 1000, 100), " ")
+	}
+	fmt.Println()
+}
+
+func TestCh09_test_sharded_hash() {
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		shard.ShardHSet(ctx, redisCli, "test", fmt.Sprintf("keyname:%d", i), i, 1000, 100)
+	}
+	value, err := shard.ShardHGet(ctx, redisCli, "test", "keyname:33", 1000, 100)
+	fmt.Println("keyname:33 =", value, err)
+}
+
+func TestCh09_test_unique_visitors() {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		shard.CountVisit(ctx, redisCli, core.NewToken())
+	}
+	fmt.Println("unique visitors today:", redisCli.Keys(ctx, "unique:*").Val())
+}
+
+func TestCh09_test_user_location() {
+	ctx := context.Background()
+	i := int64(0)
+	for _, country := range shard.COUNTRIES[:10] {
+		shard.SetLocation(ctx, redisCli, i, country, "")
+		i++
+	}
+	for _, state := range shard.STATES["USA"][:5] {
+		shard.SetLocation(ctx, redisCli, i, "USA", state)
+		i++
+	}
+	countries, states, err := shard.AggregateLocation(ctx, redisCli)
+	fmt.Println(countries, states, err)
+	countries, states, err = shard.AggregateLocationList(ctx, redisCli, []int64{0, 1, 2, 10, 11})
+	fmt.Println(countries, states, err)
+}
+
+func main() {
+	ctx := context.Background()
+	TestCh09_test_long_ziplist_performance()
+	TestCh09_test_shard_key()
+	TestCh09_test_sharded_hash()
+	TestCh09_test_unique_visitors()
+	TestCh09_test_user_location()
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
+}
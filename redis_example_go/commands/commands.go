@@ -0,0 +1,146 @@
+// Package commands 第三章的命令示例：不使用事务的流水线投票、用WATCH实现的投票、用列表记录浏览历史和乐观锁计数器
+package commands
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"strings"
+	"time"
+)
+
+const ONE_WEEK_IN_SECONDS = 7 * 86400
+const VOTE_SCORE = 432
+
+// 投票截止时间和会话时间戳使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 投票期限已经结束时返回的错误
+var ErrVotingClosed = core.Conflict("commands.vote", "voting window closed")
+
+// ArticleVote 为文章投赞成票 voted:id 集合在投票期限结束时过期
+// 两次往返之间文章可能被其他客户端修改 这正是第三章用它说明需要事务的原因 完整的实现见 articles.Vote
+func ArticleVote(ctx context.Context, conn redis.Cmdable, user string, article string) error {
+	ctx = core.WithOp(ctx, "commands.vote")
+	// 在进行投票之前，先检查这篇文章是否仍然处于可投票的时间之内
+	cutoff := float64(Clock.Now().Unix() - ONE_WEEK_IN_SECONDS)
+	posted, err := conn.ZScore(ctx, "time:", article).Result()
+	if err != nil {
+		return core.Wrap("commands.vote", err)
+	}
+	if posted < cutoff {
+		return ErrVotingClosed
+	}
+
+	// 从article:id标识符（identifier）里面取出文章的ID。
+	article_id := strings.TrimPrefix(article, "article:")
+	voted := "voted:" + article_id
+	pipeline := conn.Pipeline()
+	added := pipeline.SAdd(ctx, voted, user) //执行前可能被其他客户端修改
+	pipeline.Expire(ctx, voted, time.Duration(posted-cutoff)*time.Second)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return core.Wrap("commands.vote", err)
+	}
+	// 如果用户是第一次为这篇文章投票，那么增加这篇文章的投票数量和评分。
+	if added.Val() == 0 {
+		return nil
+	}
+	pipeline.ZIncrBy(ctx, "score:", VOTE_SCORE, article)
+	pipeline.HIncrBy(ctx, article, "votes", 1)
+	_, err = pipeline.Exec(ctx)
+	return core.Wrap("commands.vote", err)
+}
+
+// ArticleVoteWatch 和 ArticleVote 相同 但是用WATCH监视 voted:id 在一个事务中完成投票
+// 用户已经投过票时不做任何修改 重试多次仍然冲突时返回 core.ErrConflict
+func ArticleVoteWatch(ctx context.Context, conn redis.UniversalClient, user string, article string) error {
+	ctx = core.WithOp(ctx, "commands.vote")
+	article_id := strings.TrimPrefix(article, "article:")
+	voted := "voted:" + article_id
+	for i := 0; i < 5; i++ {
+		txf := func(tx *redis.Tx) error {
+			// 在进行投票之前，先检查这篇文章是否仍然处于可投票的时间之内
+			cutoff := float64(Clock.Now().Unix() - ONE_WEEK_IN_SECONDS)
+			posted, err := tx.ZScore(ctx, "time:", article).Result()
+			if err != nil {
+				return err
+			}
+			if posted < cutoff {
+				return ErrVotingClosed
+			}
+			if voter, err := tx.SIsMember(ctx, voted, user).Result(); err != nil || voter {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.SAdd(ctx, voted, user)
+				pipe.Expire(ctx, voted, time.Duration(posted-cutoff)*time.Second)
+				pipe.ZIncrBy(ctx, "score:", VOTE_SCORE, article)
+				pipe.HIncrBy(ctx, article, "votes", 1)
+				return nil
+			})
+			return err
+		}
+		err := conn.Watch(ctx, txf, voted)
+		// 其他客户端同时修改了投票集合，重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return core.Wrap("commands.vote", err)
+	}
+	return core.Conflict("commands.vote", "voters kept changing")
+}
+
+// UpdateTokenList 和 sessions.UpdateToken 相同 但是用列表记录最近浏览的25个商品（第三章的练习）
+func UpdateTokenList(ctx context.Context, conn redis.Cmdable, token string, user string, item string) error {
+	ctx = core.WithOp(ctx, "commands.update_token")
+	timestamp := float64(Clock.Now().Unix())
+	pipeline := conn.TxPipeline()
+	pipeline.HSet(ctx, "login:", token, user)
+	pipeline.ZAdd(ctx, "recent:", &redis.Z{Score: timestamp, Member: token})
+	if item != "" {
+		key := "viewed:" + token
+		// 如果指定的元素存在于列表当中，那么移除它
+		pipeline.LRem(ctx, key, 1, item)
+		// 将元素推入到列表的右端，使得 ZRANGE 和 LRANGE 可以取得相同的结果
+		pipeline.RPush(ctx, key, item)
+		// 对列表进行修剪，让它最多只能保存 25 个元素
+		pipeline.LTrim(ctx, key, -25, -1)
+		pipeline.ZIncrBy(ctx, "viewed:", -1, item)
+	}
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("commands.update_token", err)
+}
+
+// Increment 用GET和SET在WATCH事务中把key加一 返回加一之后的值 重试retries次仍然冲突时返回 core.ErrConflict
+func Increment(ctx context.Context, conn redis.UniversalClient, key string, retries int) (int64, error) {
+	ctx = core.WithOp(ctx, "commands.increment")
+	var n int64
+	txf := func(tx *redis.Tx) error {
+		// 读取当前的值 不存在时为0
+		var err error
+		n, err = tx.Get(ctx, key).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		// 在本地加一 只有被监视的键没有变化时才会写入
+		n++
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, n, 0)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < retries; i++ {
+		err := conn.Watch(ctx, txf, key)
+		// 乐观锁失败，重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return 0, core.Wrap("commands.increment", err)
+		}
+		return n, nil
+	}
+	return 0, core.Conflict("commands.increment", "key kept changing")
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func postArticle(ctx context.Context, conn redis.Cmdable, article string) {
+	conn.ZAdd(ctx, "time:", &redis.Z{Score: float64(Clock.Now().Unix()), Member: article})
+	conn.ZAdd(ctx, "score:", &redis.Z{Score: float64(Clock.Now().Unix()), Member: article})
+}
+
+func TestArticleVote(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	postArticle(ctx, conn, "article:1")
+	before := conn.ZScore(ctx, "score:", "article:1").Val()
+	for _, vote := range []func(context.Context, *redis.Client, string, string) error{
+		func(ctx context.Context, conn *redis.Client, user, article string) error {
+			return ArticleVote(ctx, conn, user, article)
+		},
+		func(ctx context.Context, conn *redis.Client, user, article string) error {
+			return ArticleVoteWatch(ctx, conn, user, article)
+		},
+	} {
+		// 同一个用户投两次票只算一次
+		for i := 0; i < 2; i++ {
+			if err := vote(ctx, conn, "user", "article:1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if score := conn.ZScore(ctx, "score:", "article:1").Val(); score != before+VOTE_SCORE {
+		t.Fatalf("score = %v, want %v", score, before+VOTE_SCORE)
+	}
+	if ttl := conn.TTL(ctx, "voted:1").Val(); ttl <= 0 || ttl > ONE_WEEK_IN_SECONDS*time.Second {
+		t.Fatalf("TTL(voted:1) = %v", ttl)
+	}
+
+	clock.Advance(8 * 24 * time.Hour)
+	if err := ArticleVote(ctx, conn, "other", "article:1"); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("ArticleVote() after a week = %v", err)
+	}
+	if err := ArticleVoteWatch(ctx, conn, "other", "article:1"); !errors.Is(err, ErrVotingClosed) {
+		t.Fatalf("ArticleVoteWatch() after a week = %v", err)
+	}
+	if err := ArticleVoteWatch(ctx, conn, "other", "article:2"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("ArticleVoteWatch(missing) = %v", err)
+	}
+}
+
+func TestUpdateTokenList(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	for _, item := range []string{"a", "b", "a"} {
+		if err := UpdateTokenList(ctx, conn, "token", "user", item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if viewed := conn.LRange(ctx, "viewed:token", 0, -1).Val(); len(viewed) != 2 || viewed[0] != "b" || viewed[1] != "a" {
+		t.Fatalf("viewed:token = %v", viewed)
+	}
+	if user := conn.HGet(ctx, "login:", "token").Val(); user != "user" {
+		t.Fatalf("login: token = %q", user)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Increment(ctx, conn, "counter", 100); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := conn.Get(ctx, "counter").Int(); n != 20 {
+		t.Fatalf("counter = %d, want 20", n)
+	}
+}
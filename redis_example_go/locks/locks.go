@@ -0,0 +1,256 @@
+// Package locks 第六章的分布式锁和计数信号量
+package locks
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"strconv"
+	"time"
+)
+
+// 信号量的获取时间、获取锁的超时判断和重试等待使用的时钟
+// 使用 core.FakeClock 时获取锁的重试会一直等待 直到时钟被 Advance
+var Clock core.Clock = core.RealClock{}
+
+// 锁已经被其他客户端持有（过期后被重新获取）
+var errLockLost = errors.New("locks: lock lost")
+
+// AcquireLock 获取分布式锁 在acquire_timeout内拿不到锁时返回空字符串 只有redis出错时才返回error
+// 成功时返回锁的标识符 释放锁时需要用到（代码清单6-8）
+func AcquireLock(ctx context.Context, conn redis.Cmdable, lockname string, acquire_timeout time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "locks.acquire")
+	if acquire_timeout <= 0 {
+		acquire_timeout = 10 * time.Second
+	}
+	// 128位随机标识符。
+	identifier := core.NewToken()
+	end := Clock.Now().Add(acquire_timeout)
+	for Clock.Now().Before(end) {
+		// 尝试取得锁。
+		ok, err := conn.SetNX(ctx, "lock:"+lockname, identifier, 0).Result()
+		if err != nil {
+			return "", core.Wrap("locks.acquire", err)
+		} else if ok {
+			return identifier, nil
+		}
+		core.Sleep(Clock, ctx.Done(), time.Millisecond)
+	}
+	return "", nil
+}
+
+// ReleaseLock 释放锁 锁已经不属于identifier时返回false（代码清单6-10）
+func ReleaseLock(ctx context.Context, conn redis.UniversalClient, lockname string, identifier string) (bool, error) {
+	ctx = core.WithOp(ctx, "locks.release")
+	lockname = "lock:" + lockname
+	for {
+		// 检查并确认进程还持有着锁。
+		txf := func(tx *redis.Tx) error {
+			value, err := tx.Get(ctx, lockname).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if value == identifier {
+				_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					pipe.Del(ctx, lockname)
+					return nil
+				})
+				return err
+			} else {
+				// 进程已经失去了锁。
+				return errLockLost
+			}
+		}
+		err := conn.Watch(ctx, txf, lockname)
+		//watch值改变
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+			//成功
+		} else if err == nil {
+			return true, nil
+			//失去锁
+		} else if err == errLockLost {
+			return false, nil
+		} else {
+			return false, core.Wrap("locks.release", err)
+		}
+	}
+}
+
+// AcquireLockWithTimeout 获取带过期时间的锁 持有者崩溃后锁会在lock_timeout后自动释放（代码清单6-11）
+func AcquireLockWithTimeout(ctx context.Context, conn redis.Cmdable, lockname string, acquire_timeout time.Duration, lock_timeout time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "locks.acquire")
+	if acquire_timeout <= 0 {
+		acquire_timeout = 10 * time.Second
+	}
+	if lock_timeout <= 0 {
+		lock_timeout = 10 * time.Second
+	}
+	// 128位随机标识符。
+	identifier := core.NewToken()
+	lockname = "lock:" + lockname
+
+	end := Clock.Now().Add(acquire_timeout)
+	for Clock.Now().Before(end) {
+		// 获取锁并设置过期时间。
+		ok, err := conn.SetNX(ctx, lockname, identifier, lock_timeout).Result()
+		if err != nil {
+			return "", core.Wrap("locks.acquire", err)
+		} else if ok {
+			return identifier, nil
+		}
+		// 检查过期时间，并在有需要时对其进行更新。 -1代表没有设置过期时间
+		ttl, err := conn.TTL(ctx, lockname).Result()
+		if err != nil {
+			return "", core.Wrap("locks.acquire", err)
+		} else if ttl == -1 {
+			if err := conn.Expire(ctx, lockname, lock_timeout).Err(); err != nil {
+				return "", core.Wrap("locks.acquire", err)
+			}
+		}
+		core.Sleep(Clock, ctx.Done(), time.Millisecond)
+	}
+	return "", nil
+}
+
+// 续期脚本 只有锁的持有者才能续期
+var refreshLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RefreshLock 把带过期时间的锁的过期时间重新设置为lock_timeout 锁已经不属于identifier时返回false
+func RefreshLock(ctx context.Context, conn redis.Cmdable, lockname string, identifier string, lock_timeout time.Duration) (bool, error) {
+	ctx = core.WithOp(ctx, "locks.refresh")
+	ok, err := refreshLockScript.Run(ctx, conn, []string{"lock:" + lockname}, identifier, lock_timeout.Milliseconds()).Int()
+	if err != nil {
+		return false, core.Wrap("locks.refresh", err)
+	}
+	return ok == 1, nil
+}
+
+// AcquireSemaphore 获取计数信号量 持有者超过timeout没有刷新会被清理（代码清单6-12）
+// 将时间戳作为分数的有序集合 对于时钟不一致的多个分布式机器是不公平的抢夺信号量
+func AcquireSemaphore(ctx context.Context, conn redis.Cmdable, semname string, limit int64, timeout time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "locks.acquire_semaphore")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// 128位随机标识符。
+	identifier := core.NewToken()
+	now := Clock.Now()
+
+	pipeline := conn.TxPipeline()
+	// 清理过期的信号量持有者。
+	pipeline.ZRemRangeByScore(ctx, semname, "-inf", strconv.FormatFloat(unixSeconds(now.Add(-timeout)), 'f', -1, 64))
+	// 尝试获取信号量。 分数精确到纳秒 同一秒内获取的信号量也能按先后排名
+	pipeline.ZAdd(ctx, semname, &redis.Z{Score: unixSeconds(now), Member: identifier})
+	// 检查是否成功取得了信号量。
+	rankCmd := pipeline.ZRank(ctx, semname, identifier)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return "", core.Wrap("locks.acquire_semaphore", err)
+	}
+	if rankCmd.Val() < limit {
+		return identifier, nil
+	}
+	// 获取信号量失败，删除之前添加的标识符。
+	return "", core.Wrap("locks.acquire_semaphore", conn.ZRem(ctx, semname, identifier).Err())
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// ReleaseSemaphore 释放信号量 返回false表示信号量已经因为过期而被删除了（代码清单6-13）
+func ReleaseSemaphore(ctx context.Context, conn redis.Cmdable, semname string, identifier string) (bool, error) {
+	ctx = core.WithOp(ctx, "locks.release_semaphore")
+	removed, err := conn.ZRem(ctx, semname, identifier).Result()
+	return removed > 0, core.Wrap("locks.release_semaphore", err)
+}
+
+// AcquireFairSemaphore 公平的获取信号量 使用自增计数器作为排名依据 允许各机器的时钟存在一定的偏差（代码清单6-14）
+func AcquireFairSemaphore(ctx context.Context, conn redis.Cmdable, semname string, limit int64, timeout time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "locks.acquire_fair_semaphore")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// 128位随机标识符。
+	identifier := core.NewToken()
+	czset := semname + ":owner"
+	ctr := semname + ":counter"
+
+	now := Clock.Now()
+	pipeline := conn.TxPipeline()
+	// 删除超时的信号量。
+	pipeline.ZRemRangeByScore(ctx, semname, "-inf", strconv.FormatInt(now.Add(-timeout).Unix(), 10))
+	pipeline.ZInterStore(ctx, czset, &redis.ZStore{Weights: []float64{1, 0}, Keys: []string{czset, semname}})
+
+	// 对计数器执行自增操作，并获取操作执行之后的值。
+	counterCmd := pipeline.Incr(ctx, ctr)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return "", core.Wrap("locks.acquire_fair_semaphore", err)
+	}
+	counter := counterCmd.Val()
+
+	// 尝试获取信号量。
+	pipeline.ZAdd(ctx, semname, &redis.Z{Score: float64(now.Unix()), Member: identifier})
+	pipeline.ZAdd(ctx, czset, &redis.Z{Score: float64(counter), Member: identifier})
+
+	// 通过检查排名来判断客户端是否取得了信号量。
+	rankCmd := pipeline.ZRank(ctx, czset, identifier)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return "", core.Wrap("locks.acquire_fair_semaphore", err)
+	}
+	if rankCmd.Val() < limit {
+		// 客户端成功取得了信号量。
+		return identifier, nil
+	}
+	// 客户端未能取得信号量，清理无用数据。
+	pipeline.ZRem(ctx, semname, identifier)
+	pipeline.ZRem(ctx, czset, identifier)
+	_, err := pipeline.Exec(ctx)
+	return "", core.Wrap("locks.acquire_fair_semaphore", err)
+}
+
+// ReleaseFairSemaphore 释放公平信号量 返回false表示信号量已经因为超时而被删除了（代码清单6-15）
+func ReleaseFairSemaphore(ctx context.Context, conn redis.Cmdable, semname string, identifier string) (bool, error) {
+	ctx = core.WithOp(ctx, "locks.release_fair_semaphore")
+	pipeline := conn.TxPipeline()
+	retCmd := pipeline.ZRem(ctx, semname, identifier)
+	pipeline.ZRem(ctx, semname+":owner", identifier)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return false, core.Wrap("locks.release_fair_semaphore", err)
+	}
+	return retCmd.Val() > 0, nil
+}
+
+// RefreshFairSemaphore 刷新信号量的持有时间（续命） 返回false表示已经失去了信号量（代码清单6-16）
+// ZADD操作会刷新已经存在的值 返回新增的数量大于0说明原来的记录已经被清理掉了
+func RefreshFairSemaphore(ctx context.Context, conn redis.Cmdable, semname string, identifier string) (bool, error) {
+	ctx = core.WithOp(ctx, "locks.refresh_fair_semaphore")
+	// 更新客户端持有的信号量。
+	added, err := conn.ZAdd(ctx, semname, &redis.Z{Score: float64(Clock.Now().Unix()), Member: identifier}).Result()
+	if err != nil {
+		return false, core.Wrap("locks.refresh_fair_semaphore", err)
+	}
+	if added > 0 {
+		// 告知调用者，客户端已经失去了信号量。
+		_, err := ReleaseFairSemaphore(ctx, conn, semname, identifier)
+		return false, err
+	}
+	// 客户端仍然持有信号量。
+	return true, nil
+}
+
+// AcquireSemaphoreWithLock 带锁的方式获取信号量 消除AcquireFairSemaphore内部的竞态（代码清单6-17）
+func AcquireSemaphoreWithLock(ctx context.Context, conn redis.UniversalClient, semname string, limit int64, timeout time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "locks.acquire_semaphore")
+	identifier, err := AcquireLock(ctx, conn, semname, 10*time.Millisecond)
+	if identifier == "" {
+		return "", err
+	}
+	defer ReleaseLock(ctx, conn, semname, identifier)
+	return AcquireFairSemaphore(ctx, conn, semname, limit, timeout)
+}
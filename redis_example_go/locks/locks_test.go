@@ -0,0 +1,136 @@
+package locks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh06.test_distributed_locking
+func TestDistributedLocking(t *testing.T) {
+	ctx := context.Background()
+	srv := testutil.NewServer(t)
+	conn := srv.Client
+
+	id, err := AcquireLockWithTimeout(ctx, conn, "testlock", time.Second, time.Second)
+	if err != nil || id == "" {
+		t.Fatalf("AcquireLockWithTimeout() = %q, %v", id, err)
+	}
+	if id2, _ := AcquireLockWithTimeout(ctx, conn, "testlock", 10*time.Millisecond, time.Second); id2 != "" {
+		t.Fatal("got a lock that is already held")
+	}
+	// 等待锁过期
+	srv.FastForward(2 * time.Second)
+	id2, err := AcquireLockWithTimeout(ctx, conn, "testlock", time.Second, time.Second)
+	if err != nil || id2 == "" {
+		t.Fatalf("AcquireLockWithTimeout() after expiry = %q, %v", id2, err)
+	}
+	if ok, _ := ReleaseLock(ctx, conn, "testlock", id); ok {
+		t.Fatal("released a lock that expired")
+	}
+	// 只有持有者能续期
+	if ok, _ := RefreshLock(ctx, conn, "testlock", id, time.Minute); ok {
+		t.Fatal("refreshed a lock that expired")
+	}
+	if ok, err := RefreshLock(ctx, conn, "testlock", id2, time.Minute); !ok || err != nil {
+		t.Fatalf("RefreshLock() = %v, %v", ok, err)
+	}
+	if ttl := conn.PTTL(ctx, "lock:testlock").Val(); ttl <= time.Second {
+		t.Fatalf("lock ttl after refresh = %v", ttl)
+	}
+	if ok, err := ReleaseLock(ctx, conn, "testlock", id2); !ok || err != nil {
+		t.Fatalf("ReleaseLock() = %v, %v", ok, err)
+	}
+	if conn.Exists(ctx, "lock:testlock").Val() != 0 {
+		t.Fatal("lock key still exists")
+	}
+}
+
+// 锁被占用时按 Clock 等待重试 时钟前进超过acquire_timeout之后放弃
+func TestAcquireLockClock(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	if id, err := AcquireLock(ctx, conn, "testlock", time.Second); id == "" || err != nil {
+		t.Fatalf("AcquireLock() = %q, %v", id, err)
+	}
+	done := make(chan string, 1)
+	go func() {
+		id, _ := AcquireLock(ctx, conn, "testlock", time.Second)
+		done <- id
+	}()
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(400 * time.Millisecond)
+	}
+	if id := <-done; id != "" {
+		t.Fatal("got a lock that is already held")
+	}
+}
+
+// 对应TestCh06.test_counting_semaphore
+func TestCountingSemaphore(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	ids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := AcquireSemaphore(ctx, conn, "testsem", 3, time.Second)
+		if err != nil || id == "" {
+			t.Fatalf("AcquireSemaphore() #%d = %q, %v", i, id, err)
+		}
+		ids = append(ids, id)
+	}
+	if id, _ := AcquireSemaphore(ctx, conn, "testsem", 3, time.Second); id != "" {
+		t.Fatal("got more semaphores than the limit")
+	}
+	if ok, _ := ReleaseSemaphore(ctx, conn, "testsem", ids[0]); !ok {
+		t.Fatal("ReleaseSemaphore() = false")
+	}
+	if id, _ := AcquireSemaphore(ctx, conn, "testsem", 3, time.Second); id == "" {
+		t.Fatal("couldn't get a released semaphore")
+	}
+	if n := conn.ZCard(ctx, "testsem").Val(); n != 3 {
+		t.Fatalf("holders = %d, want 3", n)
+	}
+}
+
+func TestFairSemaphore(t *testing.T) {
+	ctx := context.Background()
+	srv := testutil.NewServer(t)
+	if !srv.Real() {
+		t.Skip("miniredis clears the destination of ZINTERSTORE before reading it")
+	}
+	conn := srv.Client
+
+	ids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		id, err := AcquireFairSemaphore(ctx, conn, "testsem", 3, time.Second)
+		if err != nil || id == "" {
+			t.Fatalf("AcquireFairSemaphore() #%d = %q, %v", i, id, err)
+		}
+		ids = append(ids, id)
+	}
+	if id, _ := AcquireFairSemaphore(ctx, conn, "testsem", 3, time.Second); id != "" {
+		t.Fatal("got more semaphores than the limit")
+	}
+	if ok, _ := RefreshFairSemaphore(ctx, conn, "testsem", ids[0]); !ok {
+		t.Fatal("RefreshFairSemaphore() lost a held semaphore")
+	}
+	if ok, _ := ReleaseFairSemaphore(ctx, conn, "testsem", ids[0]); !ok {
+		t.Fatal("ReleaseFairSemaphore() = false")
+	}
+	id, err := AcquireSemaphoreWithLock(ctx, conn, "testsem", 3, time.Second)
+	if err != nil || id == "" {
+		t.Fatalf("AcquireSemaphoreWithLock() = %q, %v", id, err)
+	}
+	if n := conn.ZCard(ctx, "testsem:owner").Val(); n != 3 {
+		t.Fatalf("owners = %d, want 3", n)
+	}
+}
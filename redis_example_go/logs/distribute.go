@@ -0,0 +1,257 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/chat"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每次读写的数据块大小
+const blockSize = 1 << 17
+
+type waitingFile struct {
+	logfile string
+	fsize   int64
+}
+
+// CopyLogsToRedis 将path目录下的日志文件拷贝到redis 并通过群组channel通知count个客户端处理（代码清单6-30）
+// redis中的日志总大小超过limit时 等待客户端处理完毕之后再继续拷贝
+func CopyLogsToRedis(ctx context.Context, conn redis.UniversalClient, path string, channel string, count int, limit int64, quit_when_done bool) error {
+	ctx = core.WithOp(ctx, "logs.copy")
+	if count <= 0 {
+		count = 10
+	}
+	if limit <= 0 {
+		limit = 1 << 30
+	}
+	var bytes_in_redis int64
+	waiting := make([]waitingFile, 0)
+	// 创建用于向客户端发送消息的群组。
+	recipients := make([]string, count)
+	for i := range recipients {
+		recipients[i] = strconv.Itoa(i)
+	}
+	if _, err := chat.CreateChat(ctx, conn, "source", recipients, "", channel); err != nil {
+		return err
+	}
+	counts := strconv.Itoa(count)
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	// 遍历所有日志文件。
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		logfile := fi.Name()
+		fsize := fi.Size()
+		// 如果程序需要更多空间，那么清除已经处理完毕的文件。
+		for bytes_in_redis+fsize > limit {
+			cleaned, err := clean(ctx, conn, channel, &waiting, counts)
+			if err != nil {
+				return err
+			} else if cleaned != 0 {
+				bytes_in_redis -= cleaned
+			} else if err := sleep(ctx, 250*time.Millisecond); err != nil {
+				return err
+			}
+		}
+
+		// 将文件上传至Redis。
+		if err := appendFile(ctx, conn, filepath.Join(path, logfile), channel+logfile); err != nil {
+			return err
+		}
+		// 提醒监听者，文件已经准备就绪。
+		if _, err := chat.SendMessage(ctx, conn, channel, "source", logfile); err != nil {
+			return err
+		}
+
+		// 对本地记录的Redis内存占用量相关信息进行更新。
+		bytes_in_redis += fsize
+		waiting = append(waiting, waitingFile{logfile, fsize})
+	}
+
+	// 所有日志文件已经处理完毕，向监听者报告此事。
+	if quit_when_done {
+		if _, err := chat.SendMessage(ctx, conn, channel, "source", ":done"); err != nil {
+			return err
+		}
+	}
+	// 在工作完成之后，清理无用的日志文件。
+	for len(waiting) > 0 {
+		cleaned, err := clean(ctx, conn, channel, &waiting, counts)
+		if err != nil {
+			return err
+		} else if cleaned != 0 {
+			bytes_in_redis -= cleaned
+		} else if err := sleep(ctx, 250*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendFile(ctx context.Context, conn redis.Cmdable, full_path string, key string) error {
+	inp, err := os.Open(full_path)
+	if err != nil {
+		return err
+	}
+	defer inp.Close()
+	block := make([]byte, blockSize)
+	for {
+		n, err := inp.Read(block)
+		if n > 0 {
+			if err := conn.Append(ctx, key, string(block[:n])).Err(); err != nil {
+				return core.Wrap("logs.copy", err)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// 对Redis进行清理的详细步骤。
+// 如果:done计数和客户端数量相等则代表日志被所有客户端处理完了
+func clean(ctx context.Context, conn redis.Cmdable, channel string, waiting *[]waitingFile, count string) (int64, error) {
+	if len(*waiting) == 0 {
+		return 0, nil
+	}
+	w0 := (*waiting)[0]
+	done, err := conn.Get(ctx, channel+w0.logfile+":done").Result()
+	if err != nil && err != redis.Nil {
+		return 0, core.Wrap("logs.copy", err)
+	}
+	if done == count {
+		if err := conn.Del(ctx, channel+w0.logfile, channel+w0.logfile+":done").Err(); err != nil {
+			return 0, core.Wrap("logs.copy", err)
+		}
+		*waiting = (*waiting)[1:]
+		return w0.fsize, nil
+	}
+	return 0, nil
+}
+
+// ProcessLogsFromRedis 客户端id从redis中读取日志并逐行交给callback处理（代码清单6-31）
+// 每个日志文件处理完之后以空行调用一次callback 用于刷新本地的聚合数据 收到:done消息时返回
+func ProcessLogsFromRedis(ctx context.Context, conn redis.UniversalClient, id string, callback func(conn redis.UniversalClient, line string)) error {
+	ctx = core.WithOp(ctx, "logs.process_from_redis")
+	for {
+		// 获取文件列表。
+		fdata, err := chat.FetchPendingMessages(ctx, conn, id)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range fdata {
+			ch := info.ChatID
+			for _, message := range info.Messages {
+				logfile := message.Message
+
+				// 所有日志行已经处理完毕。
+				if logfile == ":done" {
+					return nil
+				} else if logfile == "" {
+					continue
+				}
+
+				// 选择一个块读取器（block reader）。
+				var reader io.Reader = NewBlockReader(ctx, conn, ch+logfile)
+				if strings.HasSuffix(logfile, ".gz") {
+					gz, err := gzip.NewReader(reader)
+					if err != nil {
+						return err
+					}
+					reader = gz
+				}
+				// 遍历日志行。
+				if err := readlines(reader, func(line string) { callback(conn, line) }); err != nil {
+					return err
+				}
+				// 强制地刷新聚合数据缓存。
+				callback(conn, "")
+
+				// 报告日志已经处理完毕。
+				if err := conn.Incr(ctx, ch+logfile+":done").Err(); err != nil {
+					return core.Wrap("logs.process_from_redis", err)
+				}
+			}
+		}
+		if len(fdata) == 0 {
+			if err := sleep(ctx, 100*time.Millisecond); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 逐行读取数据 每一行都带有结尾的断行符（最后一行可能没有）（代码清单6-32）
+func readlines(r io.Reader, fn func(line string)) error {
+	reader := bufio.NewReaderSize(r, blockSize)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			fn(line)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// BlockReader 用GETRANGE分块读取redis中的字符串（代码清单6-33）
+type BlockReader struct {
+	ctx  context.Context
+	conn redis.Cmdable
+	key  string
+	pos  int64
+}
+
+func NewBlockReader(ctx context.Context, conn redis.Cmdable, key string) *BlockReader {
+	return &BlockReader{ctx: ctx, conn: conn, key: key}
+}
+
+func (r *BlockReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// 获取数据块。
+	block, err := r.conn.GetRange(r.ctx, r.key, r.pos, r.pos+int64(len(p))-1).Result()
+	if err != nil {
+		return 0, core.Wrap("logs.block_reader", err)
+	}
+	// 读到空的数据块说明已经读完了
+	if block == "" {
+		return 0, io.EOF
+	}
+	n := copy(p, block)
+	r.pos += int64(n)
+	return n, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
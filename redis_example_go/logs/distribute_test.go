@@ -0,0 +1,62 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh06.test_file_distribution
+func TestFileDistribution(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn := testutil.NewServer(t).Client
+
+	dir := tempDir(t)
+	writeLogs(t, dir, "temp-1.txt", 1)
+	writeLogs(t, dir, "temp-2.txt", 10000)
+	// 压缩过的日志文件
+	f, err := os.Create(filepath.Join(dir, "temp-3.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	for i := 0; i < 100000; i++ {
+		gz.Write([]byte("line " + strconv.Itoa(i) + "\n"))
+	}
+	gz.Close()
+	f.Close()
+
+	copied := make(chan error, 1)
+	go func() { copied <- CopyLogsToRedis(ctx, conn, dir, "test:", 1, 1<<20, true) }()
+
+	var lines, flushes int64
+	err = ProcessLogsFromRedis(ctx, conn, "0", func(conn redis.UniversalClient, line string) {
+		if line == "" {
+			atomic.AddInt64(&flushes, 1)
+		} else {
+			atomic.AddInt64(&lines, 1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-copied; err != nil {
+		t.Fatal(err)
+	}
+	if lines != 110001 || flushes != 3 {
+		t.Fatalf("lines = %d, flushes = %d, want 110001 and 3", lines, flushes)
+	}
+	// 所有客户端都处理完之后 日志会被清理掉
+	if keys := conn.Keys(ctx, "test:temp-*").Val(); len(keys) != 0 {
+		t.Fatalf("leftover log keys: %v", keys)
+	}
+}
@@ -0,0 +1,207 @@
+// Package logs 第四、五章的日志处理：最新日志、常见日志以及带进度记录的日志文件处理
+package logs
+
+import (
+	"bufio"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"redis-learn/core"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 日志的安全级别
+const (
+	DEBUG    = "debug"
+	INFO     = "info"
+	WARNING  = "warning"
+	ERROR    = "error"
+	CRITICAL = "critical"
+)
+
+// 日志时间、每小时轮换和重试等待使用的时钟
+// 使用 core.FakeClock 时 LogCommon 冲突之后的重试会一直等待 直到时钟被 Advance
+var Clock core.Clock = core.RealClock{}
+
+// LogRecent 记录最新日志 每个名字和级别只保留最新的100条（代码清单5-1）
+func LogRecent(ctx context.Context, conn redis.Cmdable, name string, message string, severity string) error {
+	ctx = core.WithOp(ctx, "logs.recent")
+	// 使用流水线来将通信往返次数降低为一次。
+	pipe := conn.Pipeline()
+	logRecent(ctx, pipe, name, message, severity)
+	// 执行两个命令。
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("logs.recent", err)
+}
+
+func logRecent(ctx context.Context, pipe redis.Pipeliner, name string, message string, severity string) {
+	if severity == "" {
+		severity = INFO
+	}
+	// 创建负责存储消息的键。
+	destination := "recent:" + name + ":" + severity
+	// 将当前时间添加到消息里面，用于记录消息的发送时间。
+	message = Clock.Now().Format(time.ANSIC) + " " + message
+	// 将消息添加到日志列表的最前面。
+	pipe.LPush(ctx, destination, message)
+	// 对日志列表进行修剪，让它只包含最新的100条消息。
+	pipe.LTrim(ctx, destination, 0, 99)
+}
+
+// LogCommon 记录常见日志 同时记录到最新日志中（代码清单5-2）
+// 一个小时内的日志使用一个有序集合进行记录 日志行为有序集合的元素 出现的次数为分数
+// 每小时进行一次归档（将有序集合、记录所处小时的键重命名为:last和:pstart） timeout内没有完成时返回 core.ErrConflict
+func LogCommon(ctx context.Context, conn redis.UniversalClient, name string, message string, severity string, timeout time.Duration) error {
+	ctx = core.WithOp(ctx, "logs.common")
+	if severity == "" {
+		severity = INFO
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	// 负责存储最新日志的键。
+	destination := "common:" + name + ":" + severity
+	// 因为程序每小时需要轮换一次日志，所以它使用一个键来记录当前所处的小时数。
+	start_key := destination + ":start"
+	end := Clock.Now().Add(timeout)
+	for Clock.Now().Before(end) {
+		txf := func(tx *redis.Tx) error {
+			// 取得当前所处的小时数。
+			hour_start := HourStart(Clock.Now())
+			existing, err := tx.Get(ctx, start_key).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			// 创建一个事务。
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				// 如果目前的常见日志列表是上一个小时的……
+				if existing != "" && existing < hour_start {
+					// ……那么将旧的常见日志信息进行归档。
+					pipe.Rename(ctx, destination, destination+":last")
+					pipe.Rename(ctx, start_key, destination+":pstart")
+					// 更新当前所处的小时数。
+					pipe.Set(ctx, start_key, hour_start, 0)
+				} else if existing == "" {
+					pipe.Set(ctx, start_key, hour_start, 0)
+				}
+				// 对记录日志出现次数的计数器执行自增操作。
+				pipe.ZIncrBy(ctx, destination, 1, message)
+				// 同时记录到最新日志里面。
+				logRecent(ctx, pipe, name, message, severity)
+				return nil
+			})
+			return err
+		}
+		// 对记录当前小时数的键进行监视，确保轮换操作可以正确地执行。
+		err := conn.Watch(ctx, txf, start_key)
+		// 如果程序因为其他客户端在执行归档操作而出现监视错误，那么稍等一下再重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			core.Sleep(Clock, ctx.Done(), time.Millisecond)
+			continue
+		}
+		return core.Wrap("logs.common", err)
+	}
+	return core.Conflict("logs.common", "log rotation kept changing")
+}
+
+// HourStart 返回t所处的小时（UTC）的ISO格式字符串 可以直接按字符串比较先后
+func HourStart(t time.Time) string {
+	return t.UTC().Truncate(time.Hour).Format("2006-01-02T15:04:05")
+}
+
+// ProcessLogs 有序地处理path目录下的日志文件 并把处理进度记录到redis
+// 程序崩溃之后重新调用可以从上次记录的进度继续处理（代码清单4-2）
+// callback通过流水线执行redis命令 流水线会和进度一起执行
+func ProcessLogs(ctx context.Context, conn redis.Cmdable, path string, callback func(pipe redis.Pipeliner, line string)) error {
+	ctx = core.WithOp(ctx, "logs.process")
+	// 获取文件当前的处理进度。
+	ret, err := conn.MGet(ctx, "progress:file", "progress:position").Result()
+	if err != nil {
+		return core.Wrap("logs.process", err)
+	}
+	current_file, _ := ret[0].(string)
+	position, _ := ret[1].(string)
+	offset, _ := strconv.ParseInt(position, 10, 64)
+
+	pipe := conn.Pipeline()
+	// 更新正在处理的日志文件的名字和偏移量。
+	update_progress := func(fname string, offset int64) error {
+		pipe.MSet(ctx, "progress:file", fname, "progress:position", offset)
+		// 这个语句负责执行实际的日志更新操作，
+		// 并将日志文件的名字和目前的处理进度记录到Redis里面。
+		_, err := pipe.Exec(ctx)
+		return core.Wrap("logs.process", err)
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	// 有序地遍历各个日志文件。
+	sort.Strings(names)
+	for _, fname := range names {
+		// 略过所有已处理的日志文件。
+		if fname < current_file {
+			continue
+		}
+		inp, err := os.Open(filepath.Join(path, fname))
+		if err != nil {
+			return err
+		}
+		// 在接着处理一个因为系统崩溃而未能完成处理的日志文件时，略过已处理的内容。
+		if fname == current_file {
+			if _, err := inp.Seek(offset, io.SeekStart); err != nil {
+				inp.Close()
+				return err
+			}
+		} else {
+			offset = 0
+		}
+		current_file = ""
+
+		reader := bufio.NewReader(inp)
+		for lno := 0; ; lno++ {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				// 处理日志行。
+				callback(pipe, line)
+				// 更新已处理内容的偏移量。
+				offset += int64(len(line))
+				// 每当处理完1000个日志行的时候，都更新一次文件的处理进度。
+				if (lno+1)%1000 == 0 {
+					if err := update_progress(fname, offset); err != nil {
+						inp.Close()
+						return err
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				inp.Close()
+				return err
+			}
+		}
+		inp.Close()
+		// 处理完整个日志文件的时候也更新一次处理进度。
+		if err := update_progress(fname, offset); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh05.test_log_recent
+func TestLogRecent(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for i := 0; i < 105; i++ {
+		if err := LogRecent(ctx, conn, "test", "this is message "+strconv.Itoa(i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent := conn.LRange(ctx, "recent:test:info", 0, -1).Val()
+	if len(recent) != 100 {
+		t.Fatalf("recent logs = %d, want 100", len(recent))
+	}
+	if !strings.HasSuffix(recent[0], "this is message 104") {
+		t.Fatalf("newest log = %q", recent[0])
+	}
+}
+
+// 对应TestCh05.test_log_common
+func TestLogCommon(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for count := 1; count < 6; count++ {
+		for i := 0; i < count; i++ {
+			if err := LogCommon(ctx, conn, "test", "message-"+strconv.Itoa(count), WARNING, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	common := conn.ZRevRangeWithScores(ctx, "common:test:warning", 0, -1).Val()
+	if len(common) != 5 {
+		t.Fatalf("common logs = %v, want 5", common)
+	}
+	if common[0].Member != "message-5" || common[0].Score != 5 {
+		t.Fatalf("most common log = %v", common[0])
+	}
+	if conn.Get(ctx, "common:test:warning:start").Val() == "" {
+		t.Fatal("hour start was not recorded")
+	}
+	if n := conn.LLen(ctx, "recent:test:warning").Val(); n != 15 {
+		t.Fatalf("recent logs = %d, want 15", n)
+	}
+
+	// 模拟进入下一个小时 旧的日志被归档
+	conn.Set(ctx, "common:test:warning:start", "2000-01-01T00:00:00", 0)
+	if err := LogCommon(ctx, conn, "test", "message-new", WARNING, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.ZCard(ctx, "common:test:warning:last").Val(); n != 5 {
+		t.Fatalf("archived logs = %d, want 5", n)
+	}
+	if n := conn.ZCard(ctx, "common:test:warning").Val(); n != 1 {
+		t.Fatalf("current logs = %d, want 1", n)
+	}
+}
+
+// 每个事务执行之前用另一个连接改写当前小时数
+type rotateHook struct {
+	other *redis.Client
+	key   string
+}
+
+func (rotateHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (rotateHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (h rotateHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.other.Set(ctx, h.key, "2000-01-01T00:00:00", 0)
+	return ctx, nil
+}
+func (rotateHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// 轮换一直冲突时按 Clock 等待重试 时钟前进超过timeout之后返回 core.ErrConflict
+func TestLogCommonConflict(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	other := redis.NewClient(conn.Options())
+	defer other.Close()
+	conn.AddHook(rotateHook{other: other, key: "common:test:info:start"})
+
+	done := make(chan error, 1)
+	go func() { done <- LogCommon(ctx, conn, "test", "message", INFO, time.Second) }()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if err := <-done; !errors.Is(err, core.ErrConflict) {
+		t.Fatalf("LogCommon() = %v, want ErrConflict", err)
+	}
+}
+
+func writeLogs(t *testing.T, dir string, name string, lines int) {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		b.WriteString("line " + strconv.Itoa(i) + "\n")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestProcessLogs(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	dir := tempDir(t)
+	writeLogs(t, dir, "a.log", 1500)
+	writeLogs(t, dir, "b.log", 10)
+
+	count := func(pipe redis.Pipeliner, line string) {
+		pipe.Incr(ctx, "lines")
+	}
+	if err := ProcessLogs(ctx, conn, dir, count); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := conn.Get(ctx, "lines").Int(); n != 1510 {
+		t.Fatalf("processed lines = %d, want 1510", n)
+	}
+	if f := conn.Get(ctx, "progress:file").Val(); f != "b.log" {
+		t.Fatalf("progress file = %q, want b.log", f)
+	}
+
+	// 从记录的进度继续处理 只会处理新追加的内容
+	f, err := os.OpenFile(filepath.Join(dir, "b.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new 1\nnew 2\n")
+	f.Close()
+	if err := ProcessLogs(ctx, conn, dir, count); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := conn.Get(ctx, "lines").Int(); n != 1512 {
+		t.Fatalf("processed lines after resume = %d, want 1512", n)
+	}
+}
@@ -0,0 +1,257 @@
+package market
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// 结账时预留商品的时间 超过之后其他买家可以购买
+var HoldTTL = 30 * time.Second
+
+// 结账结束时释放预留的超时时间
+const releaseTimeout = time.Second
+
+var (
+	// 商品正在被其他买家结账
+	ErrHeld = core.Conflict("market.checkout", "item held by another checkout")
+	// 市场上的每件商品只有一件 购物车里的数量不是1
+	ErrQuantity = core.Conflict("market.checkout", "only one of each listing is available")
+	// 预留已经过期 商品可能被其他买家买走
+	ErrHoldExpired = core.Conflict("market.checkout", "hold expired")
+)
+
+// LineResult 购物车中一行的结账结果 Err为nil时已经购买
+type LineResult struct {
+	Item  string // 市场中的商品成员 商品id.卖家id
+	Price float64
+	Err   error
+}
+
+// Order 一次结账的结果 保存在 order:<id> 散列中
+type Order struct {
+	ID    string
+	Buyer string
+	Total float64
+	Lines []LineResult
+}
+
+// Purchased 返回已经购买的行
+func (o *Order) Purchased() []LineResult {
+	var lines []LineResult
+	for _, l := range o.Lines {
+		if l.Err == nil {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// KEYS: market: 之后是每件商品的 hold:<商品>  ARGV: 订单id 预留毫秒数 之后是商品成员
+// 为每件商品设置 hold:<商品> 返回每件商品的价格 不在市场上时为-1 被其他订单预留时为-2
+var holdScript = redis.NewScript(`
+local res = {}
+for i = 3, #ARGV do
+	local hold = KEYS[i - 1]
+	local price = redis.call("zscore", KEYS[1], ARGV[i])
+	if not price then
+		res[#res + 1] = "-1"
+	elseif redis.call("set", hold, ARGV[1], "nx", "px", ARGV[2]) then
+		res[#res + 1] = price
+	elseif redis.call("get", hold) == ARGV[1] then
+		res[#res + 1] = price
+	else
+		res[#res + 1] = "-2"
+	end
+end
+return res`)
+
+// KEYS: market: users:买家 inventory:买家 order:id cart:会话 之后是每件商品的 hold:<商品> users:<卖家>
+var checkoutScript = redis.NewScript(`
+local total = 0
+local lines = {}
+local ok = {}
+for i = 4, #ARGV, 2 do
+	local item = ARGV[i]
+	local status = 0
+	if redis.call("get", KEYS[i + 2]) ~= ARGV[1] then
+		status = 1
+	elseif redis.call("zscore", KEYS[1], item) ~= ARGV[i + 1] then
+		status = 2
+	else
+		total = total + tonumber(ARGV[i + 1])
+		ok[#ok + 1] = i
+	end
+	lines[#lines + 1] = status
+end
+local funds = tonumber(redis.call("hget", KEYS[2], "funds")) or 0
+if total > funds then
+	return {1, tostring(total), unpack(lines)}
+end
+for _, i in ipairs(ok) do
+	local item = ARGV[i]
+	local itemid = string.sub(item, 1, string.find(item, ".", 1, true) - 1)
+	redis.call("hincrbyfloat", KEYS[i + 3], "funds", ARGV[i + 1])
+	redis.call("sadd", KEYS[3], itemid)
+	redis.call("zrem", KEYS[1], item)
+	redis.call("del", KEYS[i + 2])
+	redis.call("hdel", KEYS[5], item)
+	redis.call("hset", KEYS[4], "item:" .. item, ARGV[i + 1])
+end
+if total > 0 then
+	redis.call("hincrbyfloat", KEYS[2], "funds", -total)
+end
+redis.call("hset", KEYS[4], "buyer", ARGV[2], "time", ARGV[3], "total", tostring(total))
+return {0, tostring(total), unpack(lines)}`)
+
+// KEYS: hold:商品  ARGV: 订单id 只删除自己的预留
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// Checkout 购买会话购物车（cart:<session>）中的商品 购物车的字段是市场中的商品成员（商品id.卖家id） 数量只能为1
+// 先用 hold:<商品> 预留商品（HoldTTL） 再用一个Lua脚本原子地检查预留和价格、扣款并把商品转移到买家的包裹
+// 每一行的结果记录在 Order.Lines 中：不在市场上为 core.ErrNotFound 其余为 ErrHeld、ErrQuantity、ErrHoldExpired、ErrPriceChanged
+// 余额不足时不购买任何商品 所有可以购买的行的错误和返回的错误都是 ErrInsufficientFunds
+// 购物车为空时返回 core.ErrNotFound 订单（包括失败的行）保存在 order:<id> 散列中
+// 释放预留失败不影响结账的结果 只记录日志 预留会在HoldTTL之后过期
+// 订单号由 core.GenID 生成 需要先调用 core.InitIDGenerator 租用机器号 否则返回 core.ErrNoWorkerID
+func Checkout(ctx context.Context, conn redis.UniversalClient, session string, buyerid string) (*Order, error) {
+	ctx = core.WithOp(ctx, "market.checkout")
+	cart, err := conn.HGetAll(ctx, "cart:"+session).Result()
+	if err != nil {
+		return nil, core.Wrap("market.checkout", err)
+	}
+	if len(cart) == 0 {
+		return nil, core.NotFound("market.checkout", "empty cart")
+	}
+	id, err := core.GenID()
+	if err != nil {
+		return nil, core.Wrap("market.checkout", err)
+	}
+	order := &Order{ID: id, Buyer: buyerid}
+	items := make([]string, 0, len(cart))
+	for item := range cart {
+		items = append(items, item)
+	}
+	sort.Strings(items)
+
+	// 预留商品
+	var held []string
+	keys := []string{"market:"}
+	args := []interface{}{order.ID, HoldTTL.Milliseconds()}
+	for _, item := range items {
+		line := LineResult{Item: item}
+		if cart[item] != "1" {
+			line.Err = ErrQuantity
+		} else {
+			keys = append(keys, "hold:"+item)
+			args = append(args, item)
+			held = append(held, item)
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	prices := map[string]string{}
+	if len(held) > 0 {
+		reply, err := holdScript.Run(ctx, conn, keys, args...).Result()
+		if err != nil {
+			return nil, core.Wrap("market.checkout", err)
+		}
+		for i, p := range reply.([]interface{}) {
+			prices[held[i]] = p.(string)
+		}
+	}
+	defer releaseHolds(ctx, conn, order.ID, held)
+
+	keys = []string{"market:", "users:" + buyerid, "inventory:" + buyerid, "order:" + order.ID, "cart:" + session}
+	args = []interface{}{order.ID, buyerid, Clock.Now().Unix()}
+	var charged []int
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if line.Err != nil {
+			continue
+		}
+		switch prices[line.Item] {
+		case "-1":
+			line.Err = core.NotFound("market.checkout", "item "+line.Item)
+		case "-2":
+			line.Err = ErrHeld
+		default:
+			line.Price, _ = strconv.ParseFloat(prices[line.Item], 64)
+			keys = append(keys, "hold:"+line.Item, "users:"+seller(line.Item))
+			args = append(args, line.Item, prices[line.Item])
+			charged = append(charged, i)
+		}
+	}
+
+	if len(charged) == 0 {
+		return order, saveFailures(ctx, conn, keys[3], order)
+	}
+	reply, err := checkoutScript.Run(ctx, conn, keys, args...).Result()
+	if err != nil {
+		return nil, core.Wrap("market.checkout", err)
+	}
+	res := reply.([]interface{})
+	for j, i := range charged {
+		line := &order.Lines[i]
+		switch {
+		case res[2+j].(int64) == 1:
+			line.Err = ErrHoldExpired
+		case res[2+j].(int64) == 2:
+			line.Err = ErrPriceChanged
+		case res[0].(int64) == 1:
+			line.Err = ErrInsufficientFunds
+		}
+	}
+	if res[0].(int64) == 1 {
+		if err := saveFailures(ctx, conn, keys[3], order); err != nil {
+			return order, err
+		}
+		return order, ErrInsufficientFunds
+	}
+	order.Total, _ = strconv.ParseFloat(res[1].(string), 64)
+	return order, saveFailures(ctx, conn, keys[3], order)
+}
+
+// 释放没有买到的商品的预留 最多等待releaseTimeout 失败时预留会在HoldTTL之后过期
+func releaseHolds(ctx context.Context, conn redis.Cmdable, order_id string, items []string) {
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+	for _, item := range items {
+		if err := releaseScript.Run(ctx, conn, []string{"hold:" + item}, order_id).Err(); err != nil {
+			log.Printf("market.checkout: release hold:%s: %v", item, err)
+		}
+	}
+}
+
+// 市场中的商品成员 商品id.卖家id 中的卖家id
+func seller(item string) string {
+	return item[strings.Index(item, ".")+1:]
+}
+
+// 把失败的行记录到订单散列中 failed:<商品> -> 原因
+func saveFailures(ctx context.Context, conn redis.Cmdable, key string, order *Order) error {
+	fields := []interface{}{"buyer", order.Buyer}
+	for _, line := range order.Lines {
+		if line.Err != nil {
+			fields = append(fields, "failed:"+line.Item, failureReason(line.Err))
+		}
+	}
+	return core.Wrap("market.checkout", conn.HSet(ctx, key, fields...).Err())
+}
+
+func failureReason(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
@@ -0,0 +1,137 @@
+package market
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 结账的订单号需要租用的机器号
+func leaseWorkerID(t *testing.T, conn redis.Cmdable) {
+	lease, err := core.InitIDGenerator(context.Background(), conn, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lease.Release(context.Background()) })
+}
+
+func TestCheckout(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	leaseWorkerID(t, conn)
+	Clock = core.NewFakeClock(time.Unix(1600000000, 0))
+	defer func() { Clock = core.RealClock{} }()
+
+	for _, item := range []string{"itemA", "itemB", "itemC"} {
+		conn.SAdd(ctx, "inventory:userX", item)
+		if err := ListItem(ctx, conn, item, "userX", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.HSet(ctx, "users:userY", "funds", 25)
+	conn.HSet(ctx, "cart:sess", "itemA.userX", 1, "itemB.userX", 1, "itemC.userX", 2, "itemD.userX", 1)
+
+	// itemB正在被其他买家结账
+	conn.Set(ctx, "hold:itemB.userX", "other", time.Minute)
+	if err := PurchaseItem(ctx, conn, "userZ", "itemB", "userX", 10); !errors.Is(err, ErrHeld) {
+		t.Fatalf("PurchaseItem(held) = %v, want ErrHeld", err)
+	}
+
+	order, err := Checkout(ctx, conn, "sess", "userY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]error{"itemA.userX": nil, "itemB.userX": ErrHeld, "itemC.userX": ErrQuantity, "itemD.userX": core.ErrNotFound}
+	for _, line := range order.Lines {
+		if w := want[line.Item]; w == nil && line.Err != nil || w != nil && !errors.Is(line.Err, w) {
+			t.Errorf("line %s: %v, want %v", line.Item, line.Err, w)
+		}
+	}
+	if order.Total != 10 || len(order.Purchased()) != 1 {
+		t.Fatalf("order = %+v", order)
+	}
+	if funds, _ := conn.HGet(ctx, "users:userY", "funds").Float64(); funds != 15 {
+		t.Fatalf("buyer funds = %v, want 15", funds)
+	}
+	if funds, _ := conn.HGet(ctx, "users:userX", "funds").Float64(); funds != 10 {
+		t.Fatalf("seller funds = %v, want 10", funds)
+	}
+	if !conn.SIsMember(ctx, "inventory:userY", "itemA").Val() || conn.ZScore(ctx, "market:", "itemA.userX").Err() == nil {
+		t.Fatal("itemA not moved to the buyer")
+	}
+	if conn.HExists(ctx, "cart:sess", "itemA.userX").Val() || !conn.HExists(ctx, "cart:sess", "itemB.userX").Val() {
+		t.Fatalf("cart = %v", conn.HGetAll(ctx, "cart:sess").Val())
+	}
+	saved := conn.HGetAll(ctx, "order:"+order.ID).Val()
+	if saved["buyer"] != "userY" || saved["total"] != "10" || saved["time"] != "1600000000" || saved["item:itemA.userX"] != "10" || saved["failed:itemC.userX"] == "" {
+		t.Fatalf("order hash = %v", saved)
+	}
+	if conn.Get(ctx, "hold:itemB.userX").Val() != "other" {
+		t.Fatal("other checkout's hold was released")
+	}
+
+	// 余额不足时什么也不买 预留全部释放
+	conn.Del(ctx, "hold:itemB.userX", "cart:sess")
+	conn.HSet(ctx, "cart:sess", "itemB.userX", 1, "itemC.userX", 1)
+	order, err = Checkout(ctx, conn, "sess", "userY")
+	if !errors.Is(err, ErrInsufficientFunds) || len(order.Purchased()) != 0 {
+		t.Fatalf("Checkout() without funds = %+v, %v", order, err)
+	}
+	if conn.Exists(ctx, "hold:itemB.userX").Val() != 0 || conn.ZCard(ctx, "market:").Val() != 2 {
+		t.Fatal("failed checkout changed the market")
+	}
+	if _, err := Checkout(ctx, conn, "empty", "userY"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("Checkout(empty cart) = %v", err)
+	}
+}
+
+// 让释放预留的脚本失败
+type failReleaseHook struct{}
+
+func (failReleaseHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if args := cmd.Args(); cmd.Name() == "evalsha" && len(args) > 1 && args[1] == releaseScript.Hash() {
+		return ctx, errors.New("release failed")
+	}
+	return ctx, nil
+}
+func (failReleaseHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (failReleaseHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (failReleaseHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestCheckoutReleaseFails(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	leaseWorkerID(t, conn)
+	for _, item := range []string{"itemA", "itemB"} {
+		conn.SAdd(ctx, "inventory:userX", item)
+		if err := ListItem(ctx, conn, item, "userX", 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.HSet(ctx, "users:userY", "funds", 25)
+	conn.HSet(ctx, "cart:sess", "itemA.userX", 1)
+	conn.AddHook(failReleaseHook{})
+
+	// 商品已经买到 释放预留失败不是结账的错误
+	order, err := Checkout(ctx, conn, "sess", "userY")
+	if err != nil || order.Total != 10 || len(order.Purchased()) != 1 {
+		t.Fatalf("Checkout() = %+v, %v", order, err)
+	}
+
+	// 没有买到的商品的预留留到HoldTTL之后过期
+	conn.HSet(ctx, "users:userY", "funds", 5)
+	conn.HSet(ctx, "cart:sess", "itemB.userX", 1)
+	if _, err := Checkout(ctx, conn, "sess", "userY"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Checkout() without funds = %v", err)
+	}
+	if ttl := conn.PTTL(ctx, "hold:itemB.userX").Val(); ttl <= 0 || ttl > HoldTTL {
+		t.Fatalf("hold TTL = %v, want it to expire within HoldTTL", ttl)
+	}
+}
@@ -0,0 +1,177 @@
+// Package market 第四章的商品买卖市场：卖家把包裹里的商品放到market:有序集合 买家用钱购买
+// 用户信息保存在users:<id>散列 包裹保存在inventory:<id>集合 市场中的商品成员为 商品id.卖家id
+package market
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/locks"
+	"time"
+)
+
+type Users_Id struct {
+	Name  string
+	Funds int
+}
+
+type Inventory_Id struct {
+	Items []string
+}
+
+// 结账时间、重试期限和重试等待使用的时钟
+// 使用 core.FakeClock 时冲突之后的重试会一直等待 直到时钟被 Advance
+var Clock core.Clock = core.RealClock{}
+
+var (
+	// 商品的价格和买家看到的不一致
+	ErrPriceChanged = core.Conflict("market.purchase", "price changed")
+	// 买家的余额不足以购买商品
+	ErrInsufficientFunds = errors.New("market: insufficient funds")
+)
+
+// ListItem 将卖家包裹中的商品以price的价格放到市场上（代码清单4-5）
+// 商品不在包裹中时返回 core.ErrNotFound 5秒内没有完成时返回 core.ErrConflict
+func ListItem(ctx context.Context, conn redis.UniversalClient, itemid string, sellerid string, price float64) error {
+	ctx = core.WithOp(ctx, "market.list")
+	inventory := "inventory:" + sellerid
+	item := itemid + "." + sellerid
+	end := Clock.Now().Add(5 * time.Second)
+
+	for Clock.Now().Before(end) {
+		// 监视用户包裹发生的变化。
+		txf := func(tx *redis.Tx) error {
+			owned, err := tx.SIsMember(ctx, inventory, itemid).Result()
+			if err != nil {
+				return err
+			} else if !owned {
+				// 如果指定的物品不在用户的包裹里面，
+				// 那么停止对包裹键的监视并返回不存在错误。
+				return core.NotFound("market.list", "item "+itemid)
+			}
+			// 把被销售的物品添加到物品买卖市场里面。
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZAdd(ctx, "market:", &redis.Z{Score: price, Member: item})
+				pipe.SRem(ctx, inventory, itemid)
+				return nil
+			})
+			return err
+		}
+		err := conn.Watch(ctx, txf, inventory)
+		// 用户的包裹已经发生了变化，稍等一下再重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			core.Sleep(Clock, ctx.Done(), time.Millisecond)
+			continue
+		}
+		return core.Wrap("market.list", err)
+	}
+	return core.Conflict("market.list", "inventory kept changing")
+}
+
+// PurchaseItem 买家以lprice的价格购买卖家的商品（代码清单4-6）
+// 商品不在市场上时返回 core.ErrNotFound 价格变化时返回 ErrPriceChanged 余额不足时返回 ErrInsufficientFunds
+// 商品正在被 Checkout 预留时返回 ErrHeld 10秒内没有完成时返回 core.ErrConflict
+func PurchaseItem(ctx context.Context, conn redis.UniversalClient, buyerid string, itemid string, sellerid string, lprice float64) error {
+	ctx = core.WithOp(ctx, "market.purchase")
+	buyer := "users:" + buyerid
+	seller := "users:" + sellerid
+	item := itemid + "." + sellerid
+	inventory := "inventory:" + buyerid
+	end := Clock.Now().Add(10 * time.Second)
+
+	for Clock.Now().Before(end) {
+		txf := func(tx *redis.Tx) error {
+			// 检查指定物品的价格是否出现了变化，
+			// 以及买家是否有足够的钱来购买指定的物品。
+			price, err := tx.ZScore(ctx, "market:", item).Result()
+			if err != nil {
+				return err
+			}
+			funds, err := tx.HGet(ctx, buyer, "funds").Float64()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			// 正在被其他买家结账的商品不能购买。
+			if held, err := tx.Exists(ctx, "hold:"+item).Result(); err != nil {
+				return err
+			} else if held == 1 {
+				return ErrHeld
+			}
+			if price != lprice {
+				return ErrPriceChanged
+			} else if price > funds {
+				return ErrInsufficientFunds
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				// 将买家支付的货款转移给卖家，并将卖家出售的物品移交给买家。
+				pipe.HIncrByFloat(ctx, seller, "funds", price)
+				pipe.HIncrByFloat(ctx, buyer, "funds", -price)
+				pipe.SAdd(ctx, inventory, itemid)
+				pipe.ZRem(ctx, "market:", item)
+				return nil
+			})
+			return err
+		}
+		// 对物品买卖市场以及买家账号信息的变化进行监视。
+		err := conn.Watch(ctx, txf, "market:", buyer, "hold:"+item)
+		// 如果买家的账号或者物品买卖市场出现了变化，那么进行重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			core.Sleep(Clock, ctx.Done(), time.Millisecond)
+			continue
+		} else if err == ErrInsufficientFunds {
+			return err
+		}
+		return core.Wrap("market.purchase", err)
+	}
+	return core.Conflict("market.purchase", "market kept changing")
+}
+
+// PurchaseItemWithLock 用锁代替WATCH来购买商品 只锁住market:（代码清单6-9）
+// 拿不到锁时返回 core.ErrConflict 其余错误与PurchaseItem相同
+func PurchaseItemWithLock(ctx context.Context, conn redis.UniversalClient, buyerid string, itemid string, sellerid string) error {
+	ctx = core.WithOp(ctx, "market.purchase")
+	buyer := "users:" + buyerid
+	seller := "users:" + sellerid
+	item := itemid + "." + sellerid
+	inventory := "inventory:" + buyerid
+
+	// 尝试获取锁。
+	locked, err := locks.AcquireLock(ctx, conn, "market:", 10*time.Second)
+	if err != nil {
+		return err
+	} else if locked == "" {
+		return core.Conflict("market.purchase", "couldn't get the lock")
+	}
+	defer locks.ReleaseLock(ctx, conn, "market:", locked)
+
+	// 检查物品是否已经售出，以及买家是否有足够的金钱来购买物品。
+	pipe := conn.Pipeline()
+	priceCmd := pipe.ZScore(ctx, "market:", item)
+	fundsCmd := pipe.HGet(ctx, buyer, "funds")
+	heldCmd := pipe.Exists(ctx, "hold:"+item)
+	_, err = pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return core.Wrap("market.purchase", err)
+	}
+	price, err := priceCmd.Result()
+	if err != nil {
+		return core.Wrap("market.purchase", err)
+	}
+	funds, _ := fundsCmd.Float64()
+	if heldCmd.Val() == 1 {
+		return ErrHeld
+	}
+	if price > funds {
+		return ErrInsufficientFunds
+	}
+
+	// 将买家支付的货款转移给卖家，并将售出的物品转移给买家。
+	tx := conn.TxPipeline()
+	tx.HIncrByFloat(ctx, seller, "funds", price)
+	tx.HIncrByFloat(ctx, buyer, "funds", -price)
+	tx.SAdd(ctx, inventory, itemid)
+	tx.ZRem(ctx, "market:", item)
+	_, err = tx.Exec(ctx)
+	return core.Wrap("market.purchase", err)
+}
@@ -0,0 +1,138 @@
+package market
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh04.test_list_item
+func TestListItem(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	seller, item := "userX", "itemX"
+	conn.SAdd(ctx, "inventory:"+seller, item)
+	if err := ListItem(ctx, conn, item, seller, 10); err != nil {
+		t.Fatal(err)
+	}
+	if conn.SIsMember(ctx, "inventory:"+seller, item).Val() {
+		t.Fatal("listed item still in the inventory")
+	}
+	if price := conn.ZScore(ctx, "market:", item+"."+seller).Val(); price != 10 {
+		t.Fatalf("market price = %v, want 10", price)
+	}
+
+	err := ListItem(ctx, conn, item, seller, 10)
+	if !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("ListItem() on missing item = %v, want ErrNotFound", err)
+	}
+}
+
+// 对应TestCh04.test_purchase_item
+func TestPurchaseItem(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	conn.SAdd(ctx, "inventory:userX", "itemX")
+	if err := ListItem(ctx, conn, "itemX", "userX", 10); err != nil {
+		t.Fatal(err)
+	}
+	conn.HSet(ctx, "users:userY", "funds", 125)
+
+	if err := PurchaseItem(ctx, conn, "userY", "itemX", "userX", 9); !errors.Is(err, ErrPriceChanged) {
+		t.Fatalf("PurchaseItem() with stale price = %v, want ErrPriceChanged", err)
+	}
+	if err := PurchaseItem(ctx, conn, "userY", "itemX", "userX", 10); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.SIsMember(ctx, "inventory:userY", "itemX").Val() {
+		t.Fatal("bought item not in the buyer's inventory")
+	}
+	if funds, _ := conn.HGet(ctx, "users:userY", "funds").Float64(); funds != 115 {
+		t.Fatalf("buyer funds = %v, want 115", funds)
+	}
+	if funds, _ := conn.HGet(ctx, "users:userX", "funds").Float64(); funds != 10 {
+		t.Fatalf("seller funds = %v, want 10", funds)
+	}
+	if err := PurchaseItem(ctx, conn, "userY", "itemX", "userX", 10); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("PurchaseItem() on sold item = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPurchaseItemInsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	conn.SAdd(ctx, "inventory:userX", "itemX")
+	if err := ListItem(ctx, conn, "itemX", "userX", 10); err != nil {
+		t.Fatal(err)
+	}
+	conn.HSet(ctx, "users:userY", "funds", 5)
+
+	if err := PurchaseItem(ctx, conn, "userY", "itemX", "userX", 10); err != ErrInsufficientFunds {
+		t.Fatalf("PurchaseItem() = %v, want ErrInsufficientFunds", err)
+	}
+	if err := PurchaseItemWithLock(ctx, conn, "userY", "itemX", "userX"); err != ErrInsufficientFunds {
+		t.Fatalf("PurchaseItemWithLock() = %v, want ErrInsufficientFunds", err)
+	}
+	conn.HSet(ctx, "users:userY", "funds", 10)
+	if err := PurchaseItemWithLock(ctx, conn, "userY", "itemX", "userX"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.Exists(ctx, "lock:market:").Val() != 0 {
+		t.Fatal("market lock was not released")
+	}
+}
+
+// 每个事务执行之前用另一个连接修改被监视的键
+type contendHook struct {
+	touch func(ctx context.Context)
+}
+
+func (contendHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+func (contendHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (h contendHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.touch(ctx)
+	return ctx, nil
+}
+func (contendHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// 一直冲突时按 Clock 等待重试 时钟前进超过期限之后返回 core.ErrConflict
+func TestListItemConflict(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	conn.SAdd(ctx, "inventory:userX", "itemX")
+	other := redis.NewClient(conn.Options())
+	defer other.Close()
+	conn.AddHook(contendHook{touch: func(ctx context.Context) {
+		other.SAdd(ctx, "inventory:userX", core.NewToken())
+	}})
+
+	done := make(chan error, 1)
+	go func() { done <- ListItem(ctx, conn, "itemX", "userX", 10) }()
+	clock.BlockUntil(1)
+	select {
+	case err := <-done:
+		t.Fatalf("ListItem() returned before the clock advanced: %v", err)
+	default:
+	}
+	clock.Advance(5 * time.Second)
+	if err := <-done; !errors.Is(err, core.ErrConflict) {
+		t.Fatalf("ListItem() = %v, want ErrConflict", err)
+	}
+	if conn.ZCard(ctx, "market:").Val() != 0 {
+		t.Fatal("item listed despite the conflict")
+	}
+}
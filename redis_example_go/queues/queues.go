@@ -0,0 +1,205 @@
+// Package queues 第六章的任务队列：先进先出队列、优先级队列和延迟任务
+package queues
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"redis-learn/redis_example_go/locks"
+	"time"
+)
+
+// 延迟任务的执行时间使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 已售出商品的邮件
+type SoldEmail struct {
+	SellerID string  `json:"seller_id"`
+	ItemID   string  `json:"item_id"`
+	Price    float64 `json:"price"`
+	BuyerID  string  `json:"buyer_id"`
+	Time     float64 `json:"time"`
+}
+
+// 任务回调 参数为入队时的args
+type Callback func(args ...interface{})
+
+// SendSoldEmailViaQueue 将邮件序列化之后推入queue:email队列（代码清单6-18）
+func SendSoldEmailViaQueue(ctx context.Context, conn redis.Cmdable, seller string, item string, price float64, buyer string) error {
+	ctx = core.WithOp(ctx, "queues.send_sold_email")
+	// 准备好待发送邮件。
+	data := SoldEmail{
+		SellerID: seller,
+		ItemID:   item,
+		Price:    price,
+		BuyerID:  buyer,
+		Time:     float64(Clock.Now().UnixNano()) / 1e9,
+	}
+	// 将待发送邮件推入到队列里面。
+	bytes, _ := json.Marshal(data)
+	return core.Wrap("queues.send_sold_email", conn.RPush(ctx, "queue:email", string(bytes)).Err())
+}
+
+// ProcessSoldEmailQueue 守护任务 从queue:email中取出邮件并调用send发送 直到ctx结束或出错（代码清单6-19）
+func ProcessSoldEmailQueue(ctx context.Context, conn redis.Cmdable, send func(SoldEmail) error) error {
+	ctx = core.WithOp(ctx, "queues.process_sold_email")
+	for ctx.Err() == nil {
+		// 尝试获取一封待发送邮件。
+		packed, err := conn.BLPop(ctx, 30*time.Second, "queue:email").Result()
+		// 队列里面暂时还没有待发送邮件，重试。
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return core.StopErr(ctx, "queues.process_sold_email", err)
+		}
+		// 从JSON对象中解码出邮件信息。
+		var to_send SoldEmail
+		if err := json.Unmarshal([]byte(packed[1]), &to_send); err != nil {
+			fmt.Println("Bad sold email", err, packed[1])
+			continue
+		}
+		// 使用预先编写好的邮件发送函数来发送邮件。
+		if err := send(to_send); err != nil {
+			fmt.Println("Failed to send sold email", err, to_send)
+		} else {
+			fmt.Println("Sent sold email", to_send)
+		}
+	}
+	return nil
+}
+
+// WorkerWatchQueue 守护任务 从queue中取出[name, args]形式的任务并调用对应的回调 直到ctx结束或出错（代码清单6-20）
+func WorkerWatchQueue(ctx context.Context, conn redis.Cmdable, queue string, callbacks map[string]Callback) error {
+	return WorkerWatchQueues(ctx, conn, []string{queue}, callbacks)
+}
+
+// WorkerWatchQueues 与WorkerWatchQueue相同 但同时监视多个队列 排在前面的队列优先级更高（代码清单6-21）
+// go-redis的客户端API中BLPop支持对多个列表进行取值操作
+func WorkerWatchQueues(ctx context.Context, conn redis.Cmdable, queues []string, callbacks map[string]Callback) error {
+	ctx = core.WithOp(ctx, "queues.worker")
+	for ctx.Err() == nil {
+		// 尝试从队列里面取出一项待执行任务。
+		packed, err := conn.BLPop(ctx, 30*time.Second, queues...).Result()
+		// 队列为空，没有任务需要执行；重试。
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return core.StopErr(ctx, "queues.worker", err)
+		}
+		// 解码任务信息。
+		name, args, err := decodeTask(packed[1])
+		if err != nil {
+			fmt.Println("Bad task", err, packed[1])
+			continue
+		}
+		// 没有找到任务指定的回调函数，用日志记录错误并重试。
+		callback, ok := callbacks[name]
+		if !ok {
+			fmt.Println("Unknown callback", name)
+			continue
+		}
+		// 执行任务。
+		callback(args...)
+	}
+	return nil
+}
+
+// 任务可能是[name, args]（直接入队） 也可能是[identifier, queue, name, args]（ExecuteLater入队）
+func decodeTask(packed string) (string, []interface{}, error) {
+	var task []json.RawMessage
+	if err := json.Unmarshal([]byte(packed), &task); err != nil {
+		return "", nil, err
+	}
+	if len(task) < 2 {
+		return "", nil, fmt.Errorf("bad task length %d", len(task))
+	}
+	task = task[len(task)-2:]
+	var name string
+	var args []interface{}
+	if err := json.Unmarshal(task[0], &name); err != nil {
+		return "", nil, err
+	}
+	if err := json.Unmarshal(task[1], &args); err != nil {
+		return "", nil, err
+	}
+	return name, args, nil
+}
+
+// ExecuteLater 在delay之后将任务推入queue:<queue> delay<=0时立即入队 返回任务标识符（代码清单6-22）
+// 带有延迟的任务会添加到延迟队列delayed:中（一个以执行时间戳为分数的有序集合）
+func ExecuteLater(ctx context.Context, conn redis.Cmdable, queue string, name string, args []interface{}, delay time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "queues.execute_later")
+	// 创建唯一标识符。
+	identifier := core.NewToken()
+	if args == nil {
+		args = []interface{}{}
+	}
+	// 准备好需要入队的任务。
+	bytes, _ := json.Marshal([]interface{}{identifier, queue, name, args})
+	item := string(bytes)
+	var err error
+	if delay > 0 {
+		// 延迟执行这个任务。
+		when := float64(Clock.Now().Add(delay).UnixNano()) / 1e9
+		err = conn.ZAdd(ctx, "delayed:", &redis.Z{Score: when, Member: item}).Err()
+	} else {
+		// 立即执行这个任务。
+		err = conn.RPush(ctx, "queue:"+queue, item).Err()
+	}
+	if err != nil {
+		return "", core.Wrap("queues.execute_later", err)
+	}
+	// 返回标识符。
+	return identifier, nil
+}
+
+// PollQueue 守护任务 把delayed:中执行时间已到的任务移动到对应的任务队列 直到ctx结束或出错（代码清单6-23）
+func PollQueue(ctx context.Context, conn redis.UniversalClient) error {
+	ctx = core.WithOp(ctx, "queues.poll")
+	for ctx.Err() == nil {
+		// 获取队列中的第一个任务。
+		item, err := conn.ZRangeWithScores(ctx, "delayed:", 0, 0).Result()
+		if err != nil {
+			return core.StopErr(ctx, "queues.poll", err)
+		}
+		// 队列没有包含任何任务，或者任务的执行时间未到。
+		if len(item) == 0 || item[0].Score > float64(Clock.Now().UnixNano())/1e9 {
+			core.Sleep(Clock, ctx.Done(), 10*time.Millisecond)
+			continue
+		}
+
+		// 解码要被执行的任务，弄清楚它应该被推入到哪个任务队列里面。
+		packed := item[0].Member.(string)
+		var task []interface{}
+		if err := json.Unmarshal([]byte(packed), &task); err != nil || len(task) != 4 {
+			conn.ZRem(ctx, "delayed:", packed)
+			continue
+		}
+		identifier, _ := task[0].(string)
+		queue, _ := task[1].(string)
+
+		// 为了对任务进行移动，尝试获取锁。
+		locked, err := locks.AcquireLock(ctx, conn, identifier, 0)
+		if err != nil {
+			return core.StopErr(ctx, "queues.poll", err)
+		}
+		// 获取锁失败，跳过后续步骤并重试。
+		if locked == "" {
+			continue
+		}
+
+		// 将任务推入到适当的任务队列里面。
+		removed, err := conn.ZRem(ctx, "delayed:", packed).Result()
+		if err == nil && removed > 0 {
+			err = conn.RPush(ctx, "queue:"+queue, packed).Err()
+		}
+		// 释放锁。
+		locks.ReleaseLock(ctx, conn, identifier, locked)
+		if err != nil {
+			return core.StopErr(ctx, "queues.poll", err)
+		}
+	}
+	return nil
+}
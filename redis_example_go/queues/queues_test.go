@@ -0,0 +1,84 @@
+package queues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh06.test_delayed_tasks 延迟一小时的任务通过快进时钟到期
+func TestDelayedTasks(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	for _, delay := range []time.Duration{0, time.Hour, 0, time.Hour} {
+		if _, err := ExecuteLater(ctx, conn, "tqueue", "testfn", nil, delay); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.LLen(ctx, "queue:tqueue").Val(); n != 2 {
+		t.Fatalf("queued before polling = %d, want 2", n)
+	}
+
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- PollQueue(pctx, conn) }()
+	// 执行时间未到 任务留在delayed:中
+	clock.BlockUntil(1)
+	if n := conn.LLen(ctx, "queue:tqueue").Val(); n != 2 {
+		t.Fatalf("queued before the delay = %d, want 2", n)
+	}
+	clock.Advance(time.Hour)
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.LLen(ctx, "queue:tqueue").Val(); n != 4 {
+		t.Fatalf("queued after polling = %d, want 4", n)
+	}
+	if n := conn.ZCard(ctx, "delayed:").Val(); n != 0 {
+		t.Fatalf("delayed tasks left = %d", n)
+	}
+}
+
+func TestWorkerWatchQueues(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if _, err := ExecuteLater(ctx, conn, "low", "record", []interface{}{"low"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	conn.RPush(ctx, "queue:high", `["record", ["high"]]`)
+
+	got := make(chan interface{}, 2)
+	callbacks := map[string]Callback{
+		"record": func(args ...interface{}) { got <- args[0] },
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- WorkerWatchQueues(wctx, conn, []string{"queue:high", "queue:low"}, callbacks) }()
+
+	for _, want := range []string{"high", "low"} {
+		select {
+		case v := <-got:
+			if v != want {
+				t.Fatalf("task = %v, want %v", v, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("task was not run")
+		}
+	}
+	cancel()
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,216 @@
+// Package ratelimit 基于redis的限流器：滑动窗口和令牌桶 按用户和IP分别计数
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+)
+
+// 计算窗口和令牌数量使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 超过限制时返回的错误都满足 errors.Is(err, ErrRateLimited)
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// LimitError 超过限制 RetryAfter之后可以重试
+type LimitError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return "rate limit exceeded for " + e.Key + ", retry after " + e.RetryAfter.String()
+}
+
+func (e *LimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// Mode 限流算法
+type Mode int
+
+const (
+	// 滑动窗口 任意Period时间内最多Rate次 每次请求在有序集合中记录一个成员
+	SlidingWindow Mode = iota
+	// 令牌桶 每Period补充Rate个令牌 最多积攒Burst个 允许短时间的突发
+	TokenBucket
+)
+
+// Limit 一种限制 Rate<=0时不限制
+type Limit struct {
+	Mode   Mode
+	Rate   int64
+	Period time.Duration
+	// 令牌桶的容量 为0时等于Rate
+	Burst int64
+}
+
+// Result 一次检查的结果
+type Result struct {
+	Allowed    bool
+	Remaining  int64
+	RetryAfter time.Duration
+}
+
+// KEYS: 每个限制的键  ARGV: 当前毫秒数 之后每个限制依次是 {算法 周期毫秒数 次数或令牌数 令牌桶容量 滑动窗口成员}
+// 先检查所有限制 全部允许时才一起消耗 所以被某个限制拒绝时不会占用其他限制的次数
+// 返回 {是否允许, 剩余次数的最小值, 需要等待的毫秒数, 拒绝的限制的序号（从1开始）}
+// 滑动窗口的键是有序集合 令牌桶的键是散列{tokens, ts}
+var limitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+
+local function sliding(key, period, limit, member, consume)
+	redis.call("zremrangebyscore", key, "-inf", now - period)
+	local count = redis.call("zcard", key)
+	if count >= limit then
+		local oldest = redis.call("zrange", key, 0, 0, "withscores")
+		return {0, 0, tonumber(oldest[2]) + period - now}
+	end
+	if consume then
+		redis.call("zadd", key, now, member)
+		redis.call("pexpire", key, period)
+	end
+	return {1, limit - count - 1, 0}
+end
+
+local function bucket(key, period, rate, burst, consume)
+	local state = redis.call("hmget", key, "tokens", "ts")
+	local tokens = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / period)
+	if tokens < 1 then
+		return {0, math.floor(tokens), math.ceil((1 - tokens) * period / rate)}
+	end
+	tokens = tokens - 1
+	if consume then
+		redis.call("hset", key, "tokens", tostring(tokens), "ts", now)
+		-- 令牌补满之后桶的状态就没有意义了
+		redis.call("pexpire", key, math.ceil(period * burst / rate) + 1000)
+	end
+	return {1, math.floor(tokens), 0}
+end
+
+local function check(i, consume)
+	local a = 1 + (i - 1) * 5
+	local period, rate = tonumber(ARGV[a + 2]), tonumber(ARGV[a + 3])
+	if ARGV[a + 1] == "bucket" then
+		return bucket(KEYS[i], period, rate, tonumber(ARGV[a + 4]), consume)
+	end
+	return sliding(KEYS[i], period, rate, ARGV[a + 5], consume)
+end
+
+for i = 1, #KEYS do
+	local res = check(i, false)
+	if res[1] == 0 then
+		return {0, 0, res[3], i}
+	end
+end
+local remaining = nil
+for i = 1, #KEYS do
+	local res = check(i, true)
+	if remaining == nil or res[2] < remaining then
+		remaining = res[2]
+	end
+end
+return {1, remaining or 0, 0, 0}`)
+
+// 传给limitScript的参数
+func (l Limit) args() []interface{} {
+	period := l.Period
+	if period <= 0 {
+		period = time.Second
+	}
+	if l.Mode == TokenBucket {
+		burst := l.Burst
+		if burst <= 0 {
+			burst = l.Rate
+		}
+		return []interface{}{"bucket", period.Milliseconds(), l.Rate, burst, ""}
+	}
+	return []interface{}{"window", period.Milliseconds(), l.Rate, 0, core.NewToken()}
+}
+
+// 在keys上同时消耗一次 任何一个限制拒绝时都不消耗 返回结果和拒绝的键的序号（允许时为-1）
+func allow(ctx context.Context, conn redis.Cmdable, keys []string, limits []Limit) (Result, int, error) {
+	if len(keys) == 0 {
+		return Result{Allowed: true}, -1, nil
+	}
+	args := []interface{}{Clock.Now().UnixNano() / 1e6}
+	for _, limit := range limits {
+		args = append(args, limit.args()...)
+	}
+	reply, err := limitScript.Run(ctx, conn, keys, args...).Result()
+	if err != nil {
+		return Result{}, -1, err
+	}
+	res := reply.([]interface{})
+	return Result{
+		Allowed:    res[0].(int64) == 1,
+		Remaining:  res[1].(int64),
+		RetryAfter: time.Duration(res[2].(int64)) * time.Millisecond,
+	}, int(res[3].(int64)) - 1, nil
+}
+
+// Allow 在key上消耗一次 返回是否允许 key为完整的redis键
+func (l Limit) Allow(ctx context.Context, conn redis.Cmdable, key string) (Result, error) {
+	if l.Rate <= 0 {
+		return Result{Allowed: true}, nil
+	}
+	res, _, err := allow(ctx, conn, []string{key}, []Limit{l})
+	if err != nil {
+		return Result{}, core.Wrap("ratelimit.allow", err)
+	}
+	return res, nil
+}
+
+// Limiter 对一种操作按用户和按IP分别限流 计数保存在 ratelimit:<Name>:user:<用户> 和 ratelimit:<Name>:ip:<IP> 中
+type Limiter struct {
+	Name string
+	User Limit
+	IP   Limit
+}
+
+type ipKey struct{}
+
+// WithIP 把请求来源的IP放入ctx 之后的 Limiter.Check 会同时按IP限流
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ipKey{}, ip)
+}
+
+// IPFromContext 取出WithIP设置的IP 没有时返回空字符串
+func IPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ipKey{}).(string)
+	return ip
+}
+
+// Check 检查user（以及ctx中的IP）是否还可以执行一次操作 超过限制时返回 *LimitError
+// l为nil时不做任何限制
+func (l *Limiter) Check(ctx context.Context, conn redis.Cmdable, user string) error {
+	if l == nil {
+		return nil
+	}
+	ctx = core.WithOp(ctx, "ratelimit."+l.Name)
+	var keys []string
+	var limits []Limit
+	add := func(key string, limit Limit) {
+		if limit.Rate > 0 {
+			keys = append(keys, "ratelimit:"+l.Name+":"+key)
+			limits = append(limits, limit)
+		}
+	}
+	add("user:"+user, l.User)
+	if ip := IPFromContext(ctx); ip != "" {
+		add("ip:"+ip, l.IP)
+	}
+	// 用户和IP的限制在同一个脚本中检查 被IP限制拒绝时不会消耗用户的次数
+	res, denied, err := allow(ctx, conn, keys, limits)
+	if err != nil {
+		return core.Wrap("ratelimit.allow", err)
+	}
+	if !res.Allowed {
+		return &LimitError{Key: keys[denied], RetryAfter: res.RetryAfter}
+	}
+	return nil
+}
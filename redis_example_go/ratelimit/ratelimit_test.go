@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+func useFakeClock(t *testing.T) *core.FakeClock {
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	t.Cleanup(func() { Clock = core.RealClock{} })
+	return clock
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := useFakeClock(t)
+
+	limit := Limit{Mode: SlidingWindow, Rate: 3, Period: time.Minute}
+	for i := 0; i < 3; i++ {
+		res, err := limit.Allow(ctx, conn, "ratelimit:test")
+		if err != nil || !res.Allowed || res.Remaining != int64(2-i) {
+			t.Fatalf("Allow() #%d = %+v, %v", i, res, err)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	res, _ := limit.Allow(ctx, conn, "ratelimit:test")
+	if res.Allowed || res.RetryAfter != 30*time.Second {
+		t.Fatalf("Allow() over the limit = %+v", res)
+	}
+	// 第一次请求滑出窗口之后只能再请求一次
+	clock.Advance(30 * time.Second)
+	if res, _ := limit.Allow(ctx, conn, "ratelimit:test"); !res.Allowed {
+		t.Fatalf("Allow() after the window slid = %+v", res)
+	}
+	if res, _ := limit.Allow(ctx, conn, "ratelimit:test"); res.Allowed {
+		t.Fatalf("Allow() = %+v, want limited", res)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := useFakeClock(t)
+
+	// 每秒补充2个令牌 最多积攒4个
+	limit := Limit{Mode: TokenBucket, Rate: 2, Period: time.Second, Burst: 4}
+	for i := 0; i < 4; i++ {
+		if res, err := limit.Allow(ctx, conn, "ratelimit:test"); err != nil || !res.Allowed {
+			t.Fatalf("Allow() #%d = %+v, %v", i, res, err)
+		}
+	}
+	res, _ := limit.Allow(ctx, conn, "ratelimit:test")
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Allow() with an empty bucket = %+v", res)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if res, _ := limit.Allow(ctx, conn, "ratelimit:test"); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Allow() after refill = %+v", res)
+	}
+	// 长时间空闲之后最多只有Burst个令牌
+	clock.Advance(time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if res, _ := limit.Allow(ctx, conn, "ratelimit:test"); res.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 4 {
+		t.Fatalf("allowed %d requests after idling, want 4", allowed)
+	}
+}
+
+func TestLimiterCheck(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	useFakeClock(t)
+
+	l := &Limiter{
+		Name: "vote",
+		User: Limit{Mode: SlidingWindow, Rate: 2, Period: time.Minute},
+		IP:   Limit{Mode: TokenBucket, Rate: 3, Period: time.Minute},
+	}
+	ipCtx := WithIP(ctx, "10.0.0.1")
+	for _, user := range []string{"a", "a", "b"} {
+		if err := l.Check(ipCtx, conn, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 用户a超过限制
+	err := l.Check(ipCtx, conn, "a")
+	var le *LimitError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &le) || le.Key != "ratelimit:vote:user:a" {
+		t.Fatalf("Check(a) = %v", err)
+	}
+	// 同一个IP上的其他用户也超过了IP的限制
+	if err := l.Check(ipCtx, conn, "c"); !errors.As(err, &le) || le.Key != "ratelimit:vote:ip:10.0.0.1" {
+		t.Fatalf("Check(c) = %v", err)
+	}
+	// 没有IP时只按用户限流
+	if err := l.Check(ctx, conn, "c"); err != nil {
+		t.Fatal(err)
+	}
+	var nilLimiter *Limiter
+	if err := nilLimiter.Check(ctx, conn, "a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 被IP限制拒绝的请求不消耗用户的次数
+func TestLimiterCheckIPDenied(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	useFakeClock(t)
+
+	l := &Limiter{
+		Name: "vote",
+		User: Limit{Mode: SlidingWindow, Rate: 2, Period: time.Minute},
+		IP:   Limit{Mode: TokenBucket, Rate: 1, Period: time.Minute},
+	}
+	ipCtx := WithIP(ctx, "10.0.0.1")
+	if err := l.Check(ipCtx, conn, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		var le *LimitError
+		if err := l.Check(ipCtx, conn, "b"); !errors.As(err, &le) || le.Key != "ratelimit:vote:ip:10.0.0.1" {
+			t.Fatalf("Check(b) #%d = %v", i, err)
+		}
+	}
+	if n := conn.ZCard(ctx, "ratelimit:vote:user:b").Val(); n != 0 {
+		t.Fatalf("denied requests used %d of b's quota", n)
+	}
+	// b在其他IP上仍然有完整的次数
+	otherCtx := WithIP(ctx, "10.0.0.2")
+	if err := l.Check(otherCtx, conn, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := l.User.Allow(ctx, conn, "ratelimit:vote:user:b"); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Allow(b) = %+v", res)
+	}
+}
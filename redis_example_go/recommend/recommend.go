@@ -0,0 +1,156 @@
+// Package recommend 根据第二章记录的浏览历史推荐商品：同一个会话里先后浏览的两个商品互相加分
+// 商品X的“看过X的人也看过”保存在 coviewed:<X> 有序集合中 分值越大越相关
+//
+// 把 RecordView 设为 sessions.OnView 之后 sessions.UpdateToken 等函数每记录一次浏览就更新一次分值
+package recommend
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// 计算衰减使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+var (
+	// 分值的半衰期 已经保存的分值按修改前的半衰期计算 修改后需要清除 coviewed: 重新统计
+	HalfLife = 7 * 24 * time.Hour
+	// 只有在这段时间内浏览的商品才算一起浏览
+	Window = time.Hour
+	// 每个商品最多保留的相关商品数量 分值最低的先被删除
+	MaxNeighbors = 100
+)
+
+// 没有记录基准时间的 coviewed:<商品> 使用的基准时间（旧版本的数据）
+const legacyBase = 1577836800
+
+// Recommendation 推荐的商品和它按当前时间衰减之后的分值
+type Recommendation struct {
+	Item  string
+	Score float64
+}
+
+// KEYS: viewed:<令牌> coviewed:base coviewed:<商品> coviewed:<其他商品>...
+// ARGV: 商品 时间 窗口（秒） 半衰期（秒） 最多保留的相关商品 旧数据的基准时间
+//
+// 每个 coviewed:<商品> 的分值相对于 coviewed:base 中记录的基准时间 时间t的一次浏览加 2^((t-基准时间)/半衰期) 分
+// 相当于已有的分值每过一个半衰期减半 加的分超过2^32时把整个有序集合换算到当前时间 所以分值不会溢出
+// 这个会话已经浏览过商品时不再加分 返回加分的商品数量 会话在Window内浏览的商品和KEYS不一致时返回-1
+var recordViewScript = redis.NewScript(`
+if redis.call("zscore", KEYS[1], ARGV[1]) then
+	return 0
+end
+local now, halflife = tonumber(ARGV[2]), tonumber(ARGV[4])
+local others = redis.call("zrangebyscore", KEYS[1], now - tonumber(ARGV[3]), "+inf")
+if #others ~= #KEYS - 3 then
+	return -1
+end
+for i, other in ipairs(others) do
+	if KEYS[i + 3] ~= "coviewed:" .. other then
+		return -1
+	end
+end
+local keep = -tonumber(ARGV[5]) - 1
+local function add(key, item, member)
+	local base = tonumber(redis.call("hget", KEYS[2], item))
+	if not base and redis.call("exists", key) == 1 then
+		base = tonumber(ARGV[6])
+	end
+	if not base or (now - base) / halflife > 32 then
+		if base then
+			local factor = 2 ^ ((base - now) / halflife)
+			local entries = redis.call("zrange", key, 0, -1, "withscores")
+			for j = 1, #entries, 2 do
+				local score = tonumber(entries[j + 1]) * factor
+				if score < 1e-9 then
+					redis.call("zrem", key, entries[j])
+				else
+					redis.call("zadd", key, score, entries[j])
+				end
+			end
+		end
+		base = now
+		redis.call("hset", KEYS[2], item, now)
+	end
+	redis.call("zincrby", key, 2 ^ ((now - base) / halflife), member)
+	redis.call("zremrangebyrank", key, 0, keep)
+end
+for i, other in ipairs(others) do
+	add(KEYS[3], ARGV[1], other)
+	add(KEYS[i + 3], other, ARGV[1])
+end
+return #others`)
+
+// RecordView 会话在timestamp浏览了商品 给它和会话在Window内浏览过的其他商品互相加分
+// 必须在商品加入 viewed:<令牌> 之前调用 可以直接设为 sessions.OnView
+// 会话一直在浏览其他商品导致重试多次仍然失败时返回 core.ErrConflict
+func RecordView(ctx context.Context, conn redis.Cmdable, token string, item string, timestamp float64) error {
+	ctx = core.WithOp(ctx, "recommend.record")
+	viewed := "viewed:" + token
+	for attempt := 0; attempt < 5; attempt++ {
+		// 先读出一起浏览的商品 脚本用到的键都通过KEYS传入 脚本中会检查它们是否仍然一致
+		min := strconv.FormatFloat(timestamp-Window.Seconds(), 'f', -1, 64)
+		others, err := conn.ZRangeByScore(ctx, viewed, &redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+		if err != nil {
+			return core.Wrap("recommend.record", err)
+		}
+		keys := []string{viewed, "coviewed:base", "coviewed:" + item}
+		for _, other := range others {
+			keys = append(keys, "coviewed:"+other)
+		}
+		n, err := recordViewScript.Run(ctx, conn, keys, item, timestamp, Window.Seconds(),
+			HalfLife.Seconds(), MaxNeighbors, legacyBase).Int64()
+		if err != nil {
+			return core.Wrap("recommend.record", err)
+		}
+		if n >= 0 {
+			return nil
+		}
+	}
+	return core.Conflict("recommend.record", "session kept changing")
+}
+
+// AlsoViewed 看过商品的人也看过的商品 按分值从高到低最多返回n个
+// session不为空时排除已经在这个会话的购物车 cart:<session> 中的商品
+func AlsoViewed(ctx context.Context, conn redis.Cmdable, item string, session string, n int) ([]Recommendation, error) {
+	ctx = core.WithOp(ctx, "recommend.also_viewed")
+	pipe := conn.Pipeline()
+	neighbors := pipe.ZRevRangeWithScores(ctx, "coviewed:"+item, 0, -1)
+	baseCmd := pipe.HGet(ctx, "coviewed:base", item)
+	var cart *redis.StringSliceCmd
+	if session != "" {
+		cart = pipe.HKeys(ctx, "cart:"+session)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, core.Wrap("recommend.also_viewed", err)
+	}
+	in_cart := map[string]bool{item: true}
+	if cart != nil {
+		for _, c := range cart.Val() {
+			in_cart[c] = true
+		}
+	}
+	base, err := baseCmd.Float64()
+	if err != nil {
+		base = legacyBase
+	}
+	// 把分值从基准时间衰减到当前时间 很久没有更新的分值可能下溢成0
+	scale := math.Exp2((base - float64(Clock.Now().Unix())) / HalfLife.Seconds())
+	var result []Recommendation
+	for _, z := range neighbors.Val() {
+		other := z.Member.(string)
+		if in_cart[other] {
+			continue
+		}
+		if len(result) == n {
+			break
+		}
+		result = append(result, Recommendation{Item: other, Score: z.Score * scale})
+	}
+	return result, nil
+}
@@ -0,0 +1,163 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+	"redis-learn/redis_example_go/sessions"
+)
+
+func setup(t *testing.T) (*core.FakeClock, func()) {
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock, sessions.Clock = clock, clock
+	sessions.OnView = RecordView
+	return clock, func() {
+		Clock, sessions.Clock = core.RealClock{}, core.RealClock{}
+		sessions.OnView = nil
+	}
+}
+
+func items(recs []Recommendation) string {
+	var names []string
+	for _, r := range recs {
+		names = append(names, r.Item)
+	}
+	return fmt.Sprint(names)
+}
+
+func TestAlsoViewed(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock, done := setup(t)
+	defer done()
+
+	// 三个会话：都看过itemX 两个看过itemY 一个看过itemZ
+	views := map[string][]string{
+		"token1": {"itemX", "itemY", "itemZ"},
+		"token2": {"itemY", "itemX", "itemX", "itemY"},
+		"token3": {"itemX", "itemW"},
+	}
+	for _, token := range []string{"token1", "token2", "token3"} {
+		for _, item := range views[token] {
+			// 两种记录方式的效果相同
+			if token == "token2" {
+				sessions.UpdateTokenPipeline(ctx, conn, token, "user", item)
+			} else {
+				sessions.UpdateToken(ctx, conn, token, "user", item)
+			}
+			clock.Advance(time.Minute)
+		}
+	}
+	recs, err := AlsoViewed(ctx, conn, "itemX", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items(recs) != "[itemY itemW itemZ]" && items(recs) != "[itemY itemZ itemW]" {
+		t.Fatalf("AlsoViewed(itemX) = %v", recs)
+	}
+	// 重复浏览不重复加分 几分钟的衰减可以忽略
+	if math.Abs(recs[0].Score-2) > 0.01 {
+		t.Fatalf("itemY score = %v, want 2", recs[0].Score)
+	}
+	if recs, _ := AlsoViewed(ctx, conn, "itemZ", "", 10); items(recs) != "[itemX itemY]" && items(recs) != "[itemY itemX]" {
+		t.Fatalf("AlsoViewed(itemZ) = %v", recs)
+	}
+
+	// 购物车中的商品不推荐
+	sessions.AddToCart(ctx, conn, "token4", "itemY", 1)
+	if recs, _ := AlsoViewed(ctx, conn, "itemX", "token4", 1); len(recs) != 1 || recs[0].Item == "itemY" {
+		t.Fatalf("AlsoViewed() with itemY in cart = %v", recs)
+	}
+}
+
+func TestAlsoViewedDecay(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock, done := setup(t)
+	defer done()
+
+	// 三周前两个会话看过itemX和itemOld 现在一个会话看过itemX和itemNew
+	for _, token := range []string{"old1", "old2"} {
+		sessions.UpdateToken(ctx, conn, token, "user", "itemX")
+		sessions.UpdateToken(ctx, conn, token, "user", "itemOld")
+	}
+	clock.Advance(3 * HalfLife)
+	sessions.UpdateToken(ctx, conn, "new", "user", "itemX")
+	sessions.UpdateToken(ctx, conn, "new", "user", "itemNew")
+
+	recs, _ := AlsoViewed(ctx, conn, "itemX", "", 10)
+	if items(recs) != "[itemNew itemOld]" {
+		t.Fatalf("AlsoViewed() = %v", recs)
+	}
+	if math.Abs(recs[0].Score-1) > 1e-9 || math.Abs(recs[1].Score-0.25) > 1e-9 {
+		t.Fatalf("scores = %v, want 1 and 2/8", recs)
+	}
+	// 超过Window的浏览不算一起浏览
+	clock.Advance(2 * Window)
+	sessions.UpdateToken(ctx, conn, "new", "user", "itemLater")
+	if n := conn.ZCard(ctx, "coviewed:itemLater").Val(); n != 0 {
+		t.Fatalf("itemLater has %d neighbors", n)
+	}
+}
+
+func TestMaxNeighbors(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	_, done := setup(t)
+	defer done()
+	defer func(n int) { MaxNeighbors = n }(MaxNeighbors)
+	MaxNeighbors = 3
+
+	// itemA和itemB一起被看过两次 和其他商品各一次
+	for i := 0; i < 2; i++ {
+		token := fmt.Sprint("pair", i)
+		sessions.UpdateToken(ctx, conn, token, "user", "itemA")
+		sessions.UpdateToken(ctx, conn, token, "user", "itemB")
+	}
+	for i := 0; i < 5; i++ {
+		token := fmt.Sprint("single", i)
+		sessions.UpdateToken(ctx, conn, token, "user", "itemA")
+		sessions.UpdateToken(ctx, conn, token, "user", fmt.Sprint("item", i))
+	}
+	recs, _ := AlsoViewed(ctx, conn, "itemA", "", 10)
+	if len(recs) != 3 || recs[0].Item != "itemB" {
+		t.Fatalf("AlsoViewed() = %v", recs)
+	}
+}
+
+// 半衰期很短时 经过上千个半衰期之后分值仍然是有限的 并且新的浏览排在前面
+func TestAlsoViewedRebase(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock, done := setup(t)
+	defer done()
+	defer func(h time.Duration) { HalfLife = h }(HalfLife)
+	HalfLife = time.Second
+
+	for i, item := range []string{"itemOld", "itemMid", "itemNew"} {
+		if i > 0 {
+			clock.Advance(time.Hour)
+		}
+		token := fmt.Sprint("token", i)
+		sessions.UpdateToken(ctx, conn, token, "user", "itemX")
+		sessions.UpdateToken(ctx, conn, token, "user", item)
+	}
+	for _, z := range conn.ZRangeWithScores(ctx, "coviewed:itemX", 0, -1).Val() {
+		if math.IsInf(z.Score, 0) || math.IsNaN(z.Score) {
+			t.Fatalf("coviewed:itemX %v = %v", z.Member, z.Score)
+		}
+	}
+	recs, err := AlsoViewed(ctx, conn, "itemX", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一小时前的浏览已经衰减到0 被删除
+	if items(recs) != "[itemNew]" || recs[0].Score != 1 {
+		t.Fatalf("AlsoViewed() = %v", recs)
+	}
+}
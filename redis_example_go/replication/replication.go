@@ -0,0 +1,91 @@
+// Package replication 第四章的复制：等待从服务器同步主服务器的写入
+package replication
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 同步令牌时间戳、等待期限和轮询间隔使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// WaitForSync 等待从服务器sconn接收到主服务器mconn的数据更新 并且数据已经同步到了磁盘（代码清单4-3）
+func WaitForSync(ctx context.Context, mconn redis.Cmdable, sconn redis.Cmdable) error {
+	ctx = core.WithOp(ctx, "replication.wait_for_sync")
+	identifier := core.NewToken()
+	// 将令牌添加至主服务器。
+	if err := mconn.ZAdd(ctx, "sync:wait", &redis.Z{Member: identifier, Score: float64(Clock.Now().Unix())}).Err(); err != nil {
+		return core.Wrap("replication.wait_for_sync", err)
+	}
+
+	// 如果有必要的话，等待从服务器完成同步。
+	for {
+		status, err := infoField(ctx, sconn, "replication", "master_link_status")
+		if err != nil {
+			return err
+		} else if status == "up" {
+			break
+		}
+		if err := sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+	}
+	// 等待从服务器接收数据更新。
+	for {
+		err := sconn.ZScore(ctx, "sync:wait", identifier).Err()
+		if err == nil {
+			break
+		} else if err != redis.Nil {
+			return core.Wrap("replication.wait_for_sync", err)
+		}
+		if err := sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+	}
+	// 最多只等待一秒钟。
+	deadline := Clock.Now().Add(1010 * time.Millisecond)
+	for Clock.Now().Before(deadline) {
+		// 检查数据更新是否已经被同步到了磁盘。
+		pending, err := infoField(ctx, sconn, "persistence", "aof_pending_bio_fsync")
+		if err != nil {
+			return err
+		} else if pending == "0" {
+			break
+		}
+		if err := sleep(ctx, time.Millisecond); err != nil {
+			return err
+		}
+	}
+
+	// 清理刚刚创建的新令牌以及之前可能留下的旧令牌。
+	pipe := mconn.Pipeline()
+	pipe.ZRem(ctx, "sync:wait", identifier)
+	pipe.ZRemRangeByScore(ctx, "sync:wait", "0", strconv.FormatInt(Clock.Now().Unix()-900, 10))
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("replication.wait_for_sync", err)
+}
+
+// 从INFO section的输出中取出name字段的值
+func infoField(ctx context.Context, conn redis.Cmdable, section string, name string) (string, error) {
+	info, err := conn.Info(ctx, section).Result()
+	if err != nil {
+		return "", core.Wrap("replication.info", err)
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimSpace(line[len(name)+1:]), nil
+		}
+	}
+	return "", nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if !core.Sleep(Clock, ctx.Done(), d) {
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package search
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"redis-learn/core"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CPCToECPM 按点击计费的广告的eCPM（代码清单7-9）
+func CPCToECPM(views float64, clicks float64, cpc float64) float64 {
+	return 1000 * cpc * clicks / views
+}
+
+// CPAToECPM 按动作计费的广告的eCPM（代码清单7-9）
+func CPAToECPM(views float64, actions float64, cpa float64) float64 {
+	// 因为点击通过率是由点击次数除以展示次数计算出的，
+	// 而动作的执行概率则是由动作执行次数除以点击次数计算出的，
+	// 所以这两个概率相乘的结果等于动作执行次数除以展示次数。
+	return 1000 * cpa * actions / views
+}
+
+// 每种广告类型计算eCPM的函数（代码清单7-10）
+var TO_ECPM = map[string]func(views float64, actions float64, value float64) float64{
+	"cpc": CPCToECPM,
+	"cpa": CPAToECPM,
+	"cpm": func(views float64, actions float64, value float64) float64 { return value },
+}
+
+// 广告类型不是cpc、cpa或者cpm时返回的错误
+var ErrUnknownAdType = errors.New("search: unknown ad type")
+
+// 每种广告每千次展示的平均点击次数或平均动作执行次数 update_cpms 会更新它
+var averagePer1K = struct {
+	sync.Mutex
+	m map[string]float64
+}{m: map[string]float64{}}
+
+func averageFor(typ string) float64 {
+	averagePer1K.Lock()
+	defer averagePer1K.Unlock()
+	if v, ok := averagePer1K.m[typ]; ok {
+		return v
+	}
+	return 1
+}
+
+// IndexAd 索引一个广告：定向的地区、内容中的单词、类型和价格（代码清单7-10）
+// typ为cpc、cpa或cpm value为每次点击、每次动作或者每千次展示的价格
+func IndexAd(ctx context.Context, conn redis.Cmdable, id string, locations []string, content string, typ string, value float64) error {
+	ctx = core.WithOp(ctx, "search.index_ad")
+	to_ecpm, ok := TO_ECPM[typ]
+	if !ok {
+		return core.Wrap("search.index_ad", errors.Wrap(ErrUnknownAdType, typ))
+	}
+	// 设置流水线，使得程序可以在一次通信往返里面完成整个索引操作。
+	pipeline := conn.TxPipeline()
+	for _, location := range locations {
+		// 为了进行定向操作，把广告 ID 添加到所有相关的位置集合里面。
+		pipeline.SAdd(ctx, "idx:req:"+location, id)
+	}
+	words := Tokenize(content)
+	// 对广告包含的单词进行索引。
+	for _, word := range words {
+		pipeline.ZAdd(ctx, "idx:"+word, &redis.Z{Score: 0, Member: id})
+	}
+	// 为了评估新广告的效果，
+	// 程序会使用字典来储存广告每千次展示的平均点击次数或平均动作执行次数。
+	rvalue := to_ecpm(1000, averageFor(typ), value)
+	// 记录这个广告的类型。
+	pipeline.HSet(ctx, "type:", id, typ)
+	// 将广告的 eCPM 添加到一个记录了所有广告的 eCPM 的有序集合里面。
+	pipeline.ZAdd(ctx, "idx:ad:value:", &redis.Z{Score: rvalue, Member: id})
+	// 将广告的基本价格（base value）添加到一个记录了所有广告的基本价格的有序集合里面。
+	pipeline.ZAdd(ctx, "ad:base_value:", &redis.Z{Score: value, Member: id})
+	// 把能够对广告进行定向的单词全部记录起来。
+	if len(words) > 0 {
+		pipeline.SAdd(ctx, "terms:"+id, toMembers(words)...)
+	}
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("search.index_ad", err)
+}
+
+// TargetAds 为位于locations、内容为content的页面选出eCPM最高的广告（代码清单7-11）
+// 返回记录本次定向的id和广告id 没有广告与目标位置相匹配时都为空字符串
+func TargetAds(ctx context.Context, conn redis.Cmdable, locations []string, content string) (string, string, error) {
+	ctx = core.WithOp(ctx, "search.target_ads")
+	pipeline := conn.TxPipeline()
+	// 根据用户传入的位置定向参数，找到所有位于该位置的广告，以及这些广告的 eCPM 。
+	matched_ads, base_ecpm := matchLocation(ctx, pipeline, locations)
+	// 基于匹配的内容计算附加值。
+	words, targeted_ads := finishScoring(ctx, pipeline, matched_ads, base_ecpm, content)
+	// 获取一个 ID ，它可以用于汇报并记录这个被定向的广告。
+	served := pipeline.Incr(ctx, "ads:served:")
+	// 找到 eCPM 最高的广告，并获取这个广告的 ID 。
+	targeted := pipeline.ZRevRange(ctx, "idx:"+targeted_ads, 0, 0)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return "", "", core.Wrap("search.target_ads", err)
+	}
+	// 如果没有任何广告与目标位置相匹配，那么返回空值。
+	if len(targeted.Val()) == 0 {
+		return "", "", nil
+	}
+	target_id := strconv.FormatInt(served.Val(), 10)
+	ad_id := targeted.Val()[0]
+	// 记录一系列定向操作的执行结果，作为学习用户行为的其中一个步骤。
+	if err := recordTargetingResult(ctx, conn, target_id, ad_id, words); err != nil {
+		return "", "", err
+	}
+	// 向调用者返回记录本次定向操作相关信息的 ID ，以及被选中的广告的 ID 。
+	return target_id, ad_id, nil
+}
+
+// 找出位于locations的广告和它们的基本eCPM 只把命令加入流水线（代码清单7-12）
+func matchLocation(ctx context.Context, pipe redis.Pipeliner, locations []string) (string, string) {
+	// 根据给定的位置，找出所有需要执行并集操作的集合键。
+	required := make([]string, len(locations))
+	for i, loc := range locations {
+		required[i] = "req:" + loc
+	}
+	// 找出与指定地区相匹配的广告，并将它们储存到集合里面。
+	matched_ads, _ := Union(ctx, pipe, required, 300*time.Second)
+	// 找到储存着所有被匹配广告的集合，
+	// 以及储存着所有被匹配广告的基本 eCPM 的有序集合，
+	// 然后返回它们的 ID 。
+	base_ecpm, _ := ZIntersect(ctx, pipe, map[string]float64{matched_ads: 0, "ad:value:": 1}, DefaultTTL, "")
+	return matched_ads, base_ecpm
+}
+
+// 加上内容中的单词带来的eCPM附加值 返回内容中的单词和最终eCPM的有序集合 只把命令加入流水线（代码清单7-13）
+func finishScoring(ctx context.Context, pipe redis.Pipeliner, matched string, base string, content string) ([]string, string) {
+	bonus_ecpm := map[string]float64{}
+	// 对内容进行标记化处理，以便与广告进行匹配。
+	words := Tokenize(content)
+	for _, word := range words {
+		// 找出那些既位于定向位置之内，又拥有页面内容其中一个单词的广告。
+		word_bonus, _ := ZIntersect(ctx, pipe, map[string]float64{matched: 0, word: 1}, DefaultTTL, "")
+		bonus_ecpm[word_bonus] = 1
+	}
+	// 如果页面内容中没有出现任何可匹配的单词，那么返回广告的基本 eCPM 。
+	if len(bonus_ecpm) == 0 {
+		return words, base
+	}
+	// 计算每个广告的最小 eCPM 附加值和最大 eCPM 附加值。
+	minimum, _ := ZUnion(ctx, pipe, bonus_ecpm, DefaultTTL, "MIN")
+	maximum, _ := ZUnion(ctx, pipe, bonus_ecpm, DefaultTTL, "MAX")
+	// 将广告的基本价格、最小 eCPM 附加值的一半以及最大 eCPM 附加值的一半这三者相加起来。
+	final, _ := ZUnion(ctx, pipe, map[string]float64{base: 1, minimum: .5, maximum: .5}, DefaultTTL, "")
+	return words, final
+}
+
+// 记录定向的结果：内容与广告之间相匹配的单词和展示次数 每展示100次更新一次广告的eCPM（代码清单7-14）
+func recordTargetingResult(ctx context.Context, conn redis.Cmdable, target_id string, ad_id string, words []string) error {
+	// 找出内容与广告之间相匹配的那些单词。
+	terms, err := conn.SMembers(ctx, "terms:"+ad_id).Result()
+	if err != nil {
+		return core.Wrap("search.record_targeting", err)
+	}
+	content := toSet(words)
+	matched := []interface{}{}
+	for _, term := range terms {
+		if content[term] {
+			matched = append(matched, term)
+		}
+	}
+	typ, err := conn.HGet(ctx, "type:", ad_id).Result()
+	if err != nil {
+		return core.Wrap("search.record_targeting", err)
+	}
+	pipeline := conn.TxPipeline()
+	if len(matched) > 0 {
+		// 如果有相匹配的单词出现，那么把它们记录起来，并设置 15 分钟的生存时间。
+		matched_key := "terms:matched:" + target_id
+		pipeline.SAdd(ctx, matched_key, matched...)
+		pipeline.Expire(ctx, matched_key, 900*time.Second)
+	}
+	// 为每种类型的广告分别记录它们的展示次数。
+	pipeline.Incr(ctx, "type:"+typ+":views:")
+	// 对广告以及广告包含的单词的展示信息进行记录。
+	for _, word := range matched {
+		pipeline.ZIncrBy(ctx, "views:"+ad_id, 1, word.(string))
+	}
+	views := pipeline.ZIncrBy(ctx, "views:"+ad_id, 1, "")
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return core.Wrap("search.record_targeting", err)
+	}
+	// 广告每展示 100 次，就更新一次它的 eCPM 。
+	if int64(views.Val())%100 == 0 {
+		return updateCPMs(ctx, conn, ad_id)
+	}
+	return nil
+}
+
+// RecordClick 记录用户点击了定向得到的广告 action为true时记录按动作计费的广告的动作（代码清单7-15）
+func RecordClick(ctx context.Context, conn redis.Cmdable, target_id string, ad_id string, action bool) error {
+	ctx = core.WithOp(ctx, "search.record_click")
+	click_key := "clicks:" + ad_id
+	match_key := "terms:matched:" + target_id
+	typ, err := conn.HGet(ctx, "type:", ad_id).Result()
+	if err != nil {
+		return core.Wrap("search.record_click", err)
+	}
+	pipeline := conn.TxPipeline()
+	// 如果这是一个按动作计费的广告，
+	// 并且被匹配的单词仍然存在，
+	// 那么刷新这些单词的过期时间。
+	if typ == "cpa" {
+		pipeline.Expire(ctx, match_key, 900*time.Second)
+		if action {
+			// 记录动作信息，而不是点击信息。
+			click_key = "actions:" + ad_id
+		}
+	}
+	// 根据广告的类型，维持一个全局的点击/动作计数器。
+	if action && typ == "cpa" {
+		pipeline.Incr(ctx, "type:"+typ+":actions:")
+	} else {
+		pipeline.Incr(ctx, "type:"+typ+":clicks:")
+	}
+	// 为广告以及所有被定向至该广告的单词记录下本次点击（或动作）。
+	matched, err := conn.SMembers(ctx, match_key).Result()
+	if err != nil {
+		return core.Wrap("search.record_click", err)
+	}
+	for _, word := range append(matched, "") {
+		pipeline.ZIncrBy(ctx, click_key, 1, word)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return core.Wrap("search.record_click", err)
+	}
+	// 对广告中出现的所有单词的 eCPM 进行更新。
+	return updateCPMs(ctx, conn, ad_id)
+}
+
+// 根据展示和点击（动作）次数更新广告和广告中每个单词的eCPM（代码清单7-16）
+func updateCPMs(ctx context.Context, conn redis.Cmdable, ad_id string) error {
+	// 获取广告的类型和价格，以及广告包含的所有单词。
+	pipeline := conn.TxPipeline()
+	typCmd := pipeline.HGet(ctx, "type:", ad_id)
+	baseCmd := pipeline.ZScore(ctx, "ad:base_value:", ad_id)
+	wordsCmd := pipeline.SMembers(ctx, "terms:"+ad_id)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return core.Wrap("search.update_cpms", err)
+	}
+	typ, base_value, words := typCmd.Val(), baseCmd.Val(), wordsCmd.Val()
+
+	// 判断广告的 eCPM 应该基于点击次数进行计算还是基于动作执行次数进行计算。
+	which := "clicks"
+	if typ == "cpa" {
+		which = "actions"
+	}
+	// 根据广告的类型，
+	// 获取这类广告的展示次数和点击次数（或者动作执行次数）。
+	typeViews := pipeline.Get(ctx, "type:"+typ+":views:")
+	typeClicks := pipeline.Get(ctx, "type:"+typ+":"+which+":")
+	if _, err := pipeline.Exec(ctx); err != nil && err != redis.Nil {
+		return core.Wrap("search.update_cpms", err)
+	}
+	// 将广告的点击率或动作执行率重新写入到全局字典里面。
+	views, clicks := orOne(typeViews), orOne(typeClicks)
+	averagePer1K.Lock()
+	averagePer1K.m[typ] = 1000 * clicks / views
+	averagePer1K.Unlock()
+
+	// 如果正在处理的是一个 CPM 广告，
+	// 那么它的 eCPM 已经更新完毕，
+	// 无需再做其他处理。
+	if typ == "cpm" {
+		return nil
+	}
+	view_key := "views:" + ad_id
+	click_key := which + ":" + ad_id
+	to_ecpm := TO_ECPM[typ]
+
+	// 获取广告的展示次数，以及广告的点击次数（或者动作执行次数）。
+	adViews := pipeline.ZScore(ctx, view_key, "")
+	adClicks := pipeline.ZScore(ctx, click_key, "")
+	if _, err := pipeline.Exec(ctx); err != nil && err != redis.Nil {
+		return core.Wrap("search.update_cpms", err)
+	}
+	var ad_ecpm float64
+	if adClicks.Val() < 1 {
+		// 如果广告还没有被点击过，那么使用已有的 eCPM 。
+		score, err := conn.ZScore(ctx, "idx:ad:value:", ad_id).Result()
+		if err != nil && err != redis.Nil {
+			return core.Wrap("search.update_cpms", err)
+		}
+		ad_ecpm = score
+	} else {
+		// 计算广告的 eCPM 并更新它的价格。
+		ad_ecpm = to_ecpm(scoreOrOne(adViews), adClicks.Val(), base_value)
+		pipeline.ZAdd(ctx, "idx:ad:value:", &redis.Z{Score: ad_ecpm, Member: ad_id})
+	}
+
+	// 获取每个单词的展示次数和点击次数（或者动作执行次数）。
+	wordViews := make([]*redis.FloatCmd, len(words))
+	wordClicks := make([]*redis.FloatCmd, len(words))
+	for i, word := range words {
+		wordViews[i] = pipeline.ZScore(ctx, view_key, word)
+		wordClicks[i] = pipeline.ZScore(ctx, click_key, word)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil && err != redis.Nil {
+		return core.Wrap("search.update_cpms", err)
+	}
+	for i, word := range words {
+		// 如果广告还未被点击过，那么不对 eCPM 进行更新。
+		if wordClicks[i].Val() < 1 {
+			continue
+		}
+		// 计算单词的 eCPM 。
+		word_ecpm := to_ecpm(scoreOrOne(wordViews[i]), wordClicks[i].Val(), base_value)
+		// 计算单词的附加值。
+		bonus := word_ecpm - ad_ecpm
+		// 将单词的附加值重新写入到为广告包含的每个单词分别记录附加值的有序集合里面。
+		pipeline.ZAdd(ctx, "idx:"+word, &redis.Z{Score: bonus, Member: ad_id})
+	}
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("search.update_cpms", err)
+}
+
+// 计数器的值 不存在时为1
+func orOne(cmd *redis.StringCmd) float64 {
+	if n, err := cmd.Float64(); err == nil && n != 0 {
+		return n
+	}
+	return 1
+}
+
+// 有序集合中的计数 不存在时为1
+func scoreOrOne(cmd *redis.FloatCmd) float64 {
+	if n := cmd.Val(); n != 0 {
+		return n
+	}
+	return 1
+}
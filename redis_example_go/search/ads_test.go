@@ -0,0 +1,61 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core/testutil"
+)
+
+func TestIndexAndTargetAds(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	averagePer1K.m = map[string]float64{}
+
+	if err := IndexAd(ctx, conn, "1", []string{"USA", "CA"}, content, "cpc", .25); err != nil {
+		t.Fatal(err)
+	}
+	if err := IndexAd(ctx, conn, "2", []string{"USA", "VA"}, content+" wooooo", "cpc", .125); err != nil {
+		t.Fatal(err)
+	}
+	if err := IndexAd(ctx, conn, "3", []string{"USA"}, content, "cpx", 1); !errors.Is(err, ErrUnknownAdType) {
+		t.Fatalf("IndexAd(cpx) = %v", err)
+	}
+
+	var target_id, ad_id string
+	for i := 0; i < 100; i++ {
+		var err error
+		if target_id, ad_id, err = TargetAds(ctx, conn, []string{"USA"}, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ad_id != "1" {
+		t.Fatalf("TargetAds(USA) = %q, want 1", ad_id)
+	}
+	if _, ad, _ := TargetAds(ctx, conn, []string{"VA"}, "wooooo"); ad != "2" {
+		t.Fatalf("TargetAds(VA) = %q, want 2", ad)
+	}
+	if _, ad, _ := TargetAds(ctx, conn, []string{"UK"}, content); ad != "" {
+		t.Fatalf("TargetAds(UK) = %q, want none", ad)
+	}
+
+	want := []redis.Z{{Score: .125, Member: "2"}, {Score: .25, Member: "1"}}
+	if values := conn.ZRangeWithScores(ctx, "idx:ad:value:", 0, -1).Val(); !reflect.DeepEqual(values, want) {
+		t.Fatalf("idx:ad:value: = %v", values)
+	}
+	if values := conn.ZRangeWithScores(ctx, "ad:base_value:", 0, -1).Val(); !reflect.DeepEqual(values, want) {
+		t.Fatalf("ad:base_value: = %v", values)
+	}
+
+	// 一次点击和一百次展示 eCPM = 1000 * 0.25 * 1 / 100
+	if err := RecordClick(ctx, conn, target_id, ad_id, false); err != nil {
+		t.Fatal(err)
+	}
+	want[1].Score = 2.5
+	if values := conn.ZRangeWithScores(ctx, "idx:ad:value:", 0, -1).Val(); !reflect.DeepEqual(values, want) {
+		t.Fatalf("idx:ad:value: after click = %v", values)
+	}
+}
@@ -0,0 +1,175 @@
+package search
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"strconv"
+	"time"
+)
+
+// AddJob 把职位所需的技能全部添加到职位对应的集合 job:<id> 里面（代码清单7-17）
+func AddJob(ctx context.Context, conn redis.Cmdable, job_id string, required_skills []string) error {
+	ctx = core.WithOp(ctx, "search.add_job")
+	if len(required_skills) == 0 {
+		return nil
+	}
+	return core.Wrap("search.add_job", conn.SAdd(ctx, "job:"+job_id, toMembers(required_skills)...).Err())
+}
+
+// IsQualified 求职者是否具备职位所需的全部技能（代码清单7-17）
+func IsQualified(ctx context.Context, conn redis.Cmdable, job_id string, candidate_skills []string) (bool, error) {
+	ctx = core.WithOp(ctx, "search.is_qualified")
+	if len(candidate_skills) == 0 {
+		// 没有任何技能的求职者只能胜任不需要技能的职位
+		n, err := conn.SCard(ctx, "job:"+job_id).Result()
+		return n == 0, core.Wrap("search.is_qualified", err)
+	}
+	temp := core.NewToken()
+	pipeline := conn.TxPipeline()
+	// 把求职者拥有的技能全部添加到一个临时集合里面，并设置过期时间。
+	pipeline.SAdd(ctx, temp, toMembers(candidate_skills)...)
+	pipeline.Expire(ctx, temp, 5*time.Second)
+	// 找出职位所需技能当中，求职者不具备的那些技能。
+	missing := pipeline.SDiff(ctx, "job:"+job_id, temp)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return false, core.Wrap("search.is_qualified", err)
+	}
+	// 如果求职者具备职位所需的全部技能，那么返回 true 。
+	return len(missing.Val()) == 0, nil
+}
+
+// IndexJob 把职位添加到所需技能的索引 idx:skill:<技能> 中 并记录所需技能的数量（代码清单7-18）
+func IndexJob(ctx context.Context, conn redis.Cmdable, job_id string, skills []string) error {
+	ctx = core.WithOp(ctx, "search.index_job")
+	unique := toSet(skills)
+	pipeline := conn.TxPipeline()
+	for skill := range unique {
+		// 将职位 ID 添加到相应的技能集合里面。
+		pipeline.SAdd(ctx, "idx:skill:"+skill, job_id)
+	}
+	// 将职位所需技能的数量添加到记录了所有职位所需技能数量的有序集合里面。
+	pipeline.ZAdd(ctx, "idx:jobs:req", &redis.Z{Score: float64(len(unique)), Member: job_id})
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("search.index_job", err)
+}
+
+// FindJobs 找出求职者能够胜任的职位（代码清单7-19）
+func FindJobs(ctx context.Context, conn redis.Cmdable, candidate_skills []string) ([]string, error) {
+	ctx = core.WithOp(ctx, "search.find_jobs")
+	// 设置好用于计算职位得分的字典。
+	skills := map[string]float64{}
+	for _, skill := range candidate_skills {
+		skills["skill:"+skill] = 1
+	}
+	return findQualified(ctx, conn, skills)
+}
+
+// 计算求职者对于每个职位的得分（具备的所需技能数量） 返回得分等于所需技能数量的职位
+func findQualified(ctx context.Context, conn redis.Cmdable, skills map[string]float64) ([]string, error) {
+	if len(skills) == 0 {
+		return nil, nil
+	}
+	// 计算求职者对于每个职位的得分。
+	job_scores, err := ZUnion(ctx, conn, skills, DefaultTTL, "")
+	if err != nil {
+		return nil, err
+	}
+	// 计算出求职者能够胜任以及不能够胜任的职位。
+	final_result, err := ZIntersect(ctx, conn, map[string]float64{job_scores: -1, "jobs:req": 1}, DefaultTTL, "")
+	if err != nil {
+		return nil, err
+	}
+	// 返回求职者能够胜任的那些职位。
+	jobs, err := conn.ZRangeByScore(ctx, "idx:"+final_result, &redis.ZRangeBy{Min: "0", Max: "0"}).Result()
+	return jobs, core.Wrap("search.find_jobs", err)
+}
+
+// 技能熟练度的上限 0为初学者 1为中级 2为专家
+const SKILL_LEVEL_LIMIT = 2
+
+// IndexJobLevels 索引职位对每种技能要求的熟练度 skill_levels为技能到熟练度的映射
+// 职位被添加到要求的熟练度以及更高熟练度的索引 idx:skill:<技能>:<熟练度> 中
+func IndexJobLevels(ctx context.Context, conn redis.Cmdable, job_id string, skill_levels map[string]int) error {
+	ctx = core.WithOp(ctx, "search.index_job")
+	pipeline := conn.TxPipeline()
+	for skill, level := range skill_levels {
+		for wlevel := minInt(level, SKILL_LEVEL_LIMIT); wlevel <= SKILL_LEVEL_LIMIT; wlevel++ {
+			pipeline.SAdd(ctx, "idx:skill:"+skill+":"+strconv.Itoa(wlevel), job_id)
+		}
+	}
+	pipeline.ZAdd(ctx, "idx:jobs:req", &redis.Z{Score: float64(len(skill_levels)), Member: job_id})
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("search.index_job", err)
+}
+
+// SearchJobLevels 找出求职者以skill_levels中的熟练度能够胜任的职位
+func SearchJobLevels(ctx context.Context, conn redis.Cmdable, skill_levels map[string]int) ([]string, error) {
+	ctx = core.WithOp(ctx, "search.find_jobs")
+	skills := map[string]float64{}
+	for skill, level := range skill_levels {
+		// 熟练度为level的求职者可以胜任要求level及以下熟练度的职位 它们都在 idx:skill:<技能>:<level> 中
+		skills["skill:"+skill+":"+strconv.Itoa(minInt(level, SKILL_LEVEL_LIMIT))] = 1
+	}
+	return findQualified(ctx, conn, skills)
+}
+
+// IndexJobYears 索引职位对每种技能要求的工作年限 skill_years为技能到年数的映射
+func IndexJobYears(ctx context.Context, conn redis.Cmdable, job_id string, skill_years map[string]int) error {
+	ctx = core.WithOp(ctx, "search.index_job")
+	pipeline := conn.TxPipeline()
+	for skill, years := range skill_years {
+		pipeline.ZAdd(ctx, "idx:skill:"+skill+":years", &redis.Z{Score: float64(maxInt(years, 0)), Member: job_id})
+	}
+	pipeline.SAdd(ctx, "idx:jobs:all", job_id)
+	pipeline.ZAdd(ctx, "idx:jobs:req", &redis.Z{Score: float64(len(skill_years)), Member: job_id})
+	_, err := pipeline.Exec(ctx)
+	return core.Wrap("search.index_job", err)
+}
+
+// SearchJobYears 找出求职者以skill_years中的工作年限能够胜任的职位
+func SearchJobYears(ctx context.Context, conn redis.Cmdable, skill_years map[string]int) ([]string, error) {
+	ctx = core.WithOp(ctx, "search.find_jobs")
+	if len(skill_years) == 0 {
+		return nil, nil
+	}
+	pipeline := conn.TxPipeline()
+	union := map[string]float64{}
+	for skill, years := range skill_years {
+		// 职位要求的年限减去求职者的年限 大于0的职位求职者不能胜任
+		sub_result, _ := ZIntersect(ctx, pipeline, map[string]float64{"jobs:all": -float64(years), "skill:" + skill + ":years": 1}, DefaultTTL, "")
+		pipeline.ZRemRangeByScore(ctx, "idx:"+sub_result, "(0", "inf")
+		// 剩下的职位在这种技能上得1分
+		qualified, _ := ZIntersect(ctx, pipeline, map[string]float64{"jobs:all": 1, sub_result: 0}, DefaultTTL, "")
+		union[qualified] = 1
+	}
+	job_scores, _ := ZUnion(ctx, pipeline, union, DefaultTTL, "")
+	final_result, _ := ZIntersect(ctx, pipeline, map[string]float64{job_scores: -1, "jobs:req": 1}, DefaultTTL, "")
+	jobs := pipeline.ZRangeByScore(ctx, "idx:"+final_result, &redis.ZRangeBy{Min: "0", Max: "0"})
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, core.Wrap("search.find_jobs", err)
+	}
+	return jobs.Val(), nil
+}
+
+func toMembers(values []string) []interface{} {
+	members := make([]interface{}, len(values))
+	for i, v := range values {
+		members[i] = v
+	}
+	return members
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
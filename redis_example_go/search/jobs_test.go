@@ -0,0 +1,108 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"redis-learn/core/testutil"
+)
+
+func TestIsQualified(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	if err := AddJob(ctx, conn, "test", []string{"q1", "q2", "q3"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := IsQualified(ctx, conn, "test", []string{"q1", "q3", "q2"}); !ok || err != nil {
+		t.Fatalf("IsQualified(all) = %v, %v", ok, err)
+	}
+	if ok, _ := IsQualified(ctx, conn, "test", []string{"q1", "q2"}); ok {
+		t.Fatal("IsQualified(missing q3) = true")
+	}
+	if ok, _ := IsQualified(ctx, conn, "test", nil); ok {
+		t.Fatal("IsQualified(no skills) = true")
+	}
+}
+
+func TestFindJobs(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	for job, skills := range map[string][]string{
+		"test1": {"q1", "q2", "q3"},
+		"test2": {"q1", "q3", "q4"},
+		"test3": {"q1", "q3", "q5"},
+	} {
+		if err := IndexJob(ctx, conn, job, skills); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		skills []string
+		want   []string
+	}{
+		{[]string{"q1"}, nil},
+		{[]string{"q1", "q3", "q4"}, []string{"test2"}},
+		{[]string{"q1", "q3", "q5"}, []string{"test3"}},
+		{[]string{"q1", "q2", "q3", "q4", "q5"}, []string{"test1", "test2", "test3"}},
+	} {
+		jobs, err := FindJobs(ctx, conn, c.skills)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(jobs)
+		if len(jobs) != len(c.want) || len(jobs) > 0 && !reflect.DeepEqual(jobs, c.want) {
+			t.Errorf("FindJobs(%v) = %v, want %v", c.skills, jobs, c.want)
+		}
+	}
+}
+
+func TestSearchJobLevels(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	IndexJobLevels(ctx, conn, "job1", map[string]int{"go": 2})
+	IndexJobLevels(ctx, conn, "job2", map[string]int{"go": 1, "sql": 0})
+	for _, c := range []struct {
+		levels map[string]int
+		want   []string
+	}{
+		{map[string]int{"go": 1}, nil},
+		{map[string]int{"go": 1, "sql": 0}, []string{"job2"}},
+		{map[string]int{"go": 5, "sql": 2}, []string{"job1", "job2"}},
+	} {
+		jobs, err := SearchJobLevels(ctx, conn, c.levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(jobs)
+		if len(jobs) != len(c.want) || len(jobs) > 0 && !reflect.DeepEqual(jobs, c.want) {
+			t.Errorf("SearchJobLevels(%v) = %v, want %v", c.levels, jobs, c.want)
+		}
+	}
+}
+
+func TestSearchJobYears(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	IndexJobYears(ctx, conn, "job1", map[string]int{"go": 3})
+	IndexJobYears(ctx, conn, "job2", map[string]int{"go": 1, "sql": 2})
+	for _, c := range []struct {
+		years map[string]int
+		want  []string
+	}{
+		{map[string]int{"go": 2, "sql": 5}, []string{"job2"}},
+		{map[string]int{"go": 5}, []string{"job1"}},
+		{map[string]int{"go": 5, "sql": 2}, []string{"job1", "job2"}},
+		{map[string]int{"sql": 1}, nil},
+	} {
+		jobs, err := SearchJobYears(ctx, conn, c.years)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(jobs)
+		if len(jobs) != len(c.want) || len(jobs) > 0 && !reflect.DeepEqual(jobs, c.want) {
+			t.Errorf("SearchJobYears(%v) = %v, want %v", c.years, jobs, c.want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package search 第七章的搜索：反向索引、集合运算组成的查询、按散列字段或有序集合排序的搜索结果、广告定向和职位搜索
+// 索引和临时结果都保存在 idx: 前缀的键中 函数返回的结果id对应 idx:<id>
+package search
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 集合运算的临时结果默认的生存时间
+const DefaultTTL = 30 * time.Second
+
+// 预先定义好从网上获取的停止词。
+var STOP_WORDS = toSet(strings.Fields(`able about across after all almost also am among
+an and any are as at be because been but by can cannot could dear did
+do does either else ever every for from get got had has have he her
+hers him his how however if in into is it its just least let like
+likely may me might most must my neither no nor not of off often on
+only or other our own rather said say says she should since so some
+than that the their them then there these they this tis to too twas us
+wants was we were what when where which while who whom why will with
+would yet you your`))
+
+// 根据定义提取单词的正则表达式。
+var WORDS_RE = regexp.MustCompile("[a-z']{2,}")
+
+func toSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// 集合中的元素 按字母排序
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Tokenize 提取内容中至少两个字符长并且不是停止词的单词 按字母排序（代码清单7-1）
+func Tokenize(content string) []string {
+	words := map[string]bool{}
+	// 遍历文章包含的所有单词。
+	for _, match := range WORDS_RE.FindAllString(strings.ToLower(content), -1) {
+		// 剔除所有位于单词前面或后面的单引号。
+		word := strings.Trim(match, "'")
+		// 保留那些至少有两个字符长并且不是停止词的单词。
+		if len(word) >= 2 && !STOP_WORDS[word] {
+			words[word] = true
+		}
+	}
+	return sortedKeys(words)
+}
+
+// IndexDocument 把文章添加到它包含的每个单词的反向索引集合 idx:<单词> 中 返回索引的单词数量（代码清单7-1）
+func IndexDocument(ctx context.Context, conn redis.Cmdable, docid string, content string) (int, error) {
+	ctx = core.WithOp(ctx, "search.index_document")
+	words := Tokenize(content)
+	pipeline := conn.TxPipeline()
+	// 将文章添加到正确的反向索引集合里面。
+	for _, word := range words {
+		pipeline.SAdd(ctx, "idx:"+word, docid)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return 0, core.Wrap("search.index_document", err)
+	}
+	return len(words), nil
+}
+
+// 对 idx:<name> 执行集合运算 结果保存在 idx:<id> 中并在ttl之后过期 返回id（代码清单7-2）
+// conn为redis.Pipeliner时只把命令加入流水线 由调用者执行
+func setCommon(ctx context.Context, conn redis.Cmdable, op string, names []string, ttl time.Duration) (string, error) {
+	// 创建一个新的临时标识符。
+	id := core.NewToken()
+	pipeline, queued := conn.(redis.Pipeliner)
+	if !queued {
+		// 设置事务流水线，确保每个调用都能获得一致的执行结果。
+		pipeline = conn.TxPipeline()
+	}
+	// 给每个单词加上 "idx:" 前缀。
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = "idx:" + name
+	}
+	// 为将要执行的集合操作设置相应的参数。
+	switch op {
+	case "sinterstore":
+		pipeline.SInterStore(ctx, "idx:"+id, keys...)
+	case "sunionstore":
+		pipeline.SUnionStore(ctx, "idx:"+id, keys...)
+	case "sdiffstore":
+		pipeline.SDiffStore(ctx, "idx:"+id, keys...)
+	}
+	// 吩咐 Redis 在将来自动删除这个集合。
+	pipeline.Expire(ctx, "idx:"+id, ttl)
+	if !queued {
+		// 实际地执行操作。
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return "", core.Wrap("search."+op, err)
+		}
+	}
+	// 将结果集合的 ID 返回给调用者，以便做进一步的处理。
+	return id, nil
+}
+
+// Intersect 计算 idx:<item> 的交集 返回结果的id conn为redis.Pipeliner时只加入流水线（代码清单7-2）
+func Intersect(ctx context.Context, conn redis.Cmdable, items []string, ttl time.Duration) (string, error) {
+	return setCommon(ctx, conn, "sinterstore", items, ttl)
+}
+
+// Union 计算 idx:<item> 的并集 返回结果的id conn为redis.Pipeliner时只加入流水线（代码清单7-2）
+func Union(ctx context.Context, conn redis.Cmdable, items []string, ttl time.Duration) (string, error) {
+	return setCommon(ctx, conn, "sunionstore", items, ttl)
+}
+
+// Difference 从 idx:<items[0]> 中去掉其他集合的元素 返回结果的id conn为redis.Pipeliner时只加入流水线（代码清单7-2）
+func Difference(ctx context.Context, conn redis.Cmdable, items []string, ttl time.Duration) (string, error) {
+	return setCommon(ctx, conn, "sdiffstore", items, ttl)
+}
+
+// 查找需要的单词、不需要的单词以及同义词的正则表达式。
+var QUERY_RE = regexp.MustCompile("[+-]?[a-z']{2,}")
+
+// Parse 分析查询语句（代码清单7-3）
+// 返回需要执行交集计算的同义词组（组内的单词执行并集计算）和不需要的单词
+// 带+前缀的单词是前一个单词的同义词 带-前缀的单词是不需要的单词
+func Parse(query string) ([][]string, []string) {
+	// 这个集合将用于储存不需要的单词。
+	unwanted := map[string]bool{}
+	// 这个列表将用于储存需要执行交集计算的单词。
+	all := [][]string{}
+	// 这个集合将用于储存目前已发现的同义词。
+	current := map[string]bool{}
+	// 遍历搜索查询语句中的所有单词。
+	for _, word := range QUERY_RE.FindAllString(strings.ToLower(query), -1) {
+		// 检查单词是否带有 + 号前缀或 - 号前缀。
+		prefix := word[:1]
+		if prefix == "+" || prefix == "-" {
+			word = word[1:]
+		} else {
+			prefix = ""
+		}
+		// 剔除所有位于单词前面或者后面的单引号，并略过所有停止词。
+		word = strings.Trim(word, "'")
+		if len(word) < 2 || STOP_WORDS[word] {
+			continue
+		}
+		// 如果这是一个不需要的单词，
+		// 那么将它添加到储存不需要单词的集合里面。
+		if prefix == "-" {
+			unwanted[word] = true
+			continue
+		}
+		// 如果在同义词集合非空的情况下，
+		// 遇到了一个不带 + 号前缀的单词，
+		// 那么创建一个新的同义词集合。
+		if len(current) > 0 && prefix == "" {
+			all = append(all, sortedKeys(current))
+			current = map[string]bool{}
+		}
+		// 将正在处理的单词添加到同义词集合里面。
+		current[word] = true
+	}
+	// 把所有剩余的单词都放到最后的交集计算里面进行处理。
+	if len(current) > 0 {
+		all = append(all, sortedKeys(current))
+	}
+	return all, sortedKeys(unwanted)
+}
+
+// ParseAndSearch 执行查询语句 返回结果集合的id 查询语句只包含停止词时返回空字符串（代码清单7-4）
+func ParseAndSearch(ctx context.Context, conn redis.Cmdable, query string, ttl time.Duration) (string, error) {
+	ctx = core.WithOp(ctx, "search.query")
+	// 对查询语句进行分析。
+	all, unwanted := Parse(query)
+	// 如果查询语句只包含停止词，那么这次搜索没有任何结果。
+	if len(all) == 0 {
+		return "", nil
+	}
+	to_intersect := []string{}
+	// 遍历各个同义词列表。
+	for _, syn := range all {
+		// 如果同义词列表包含的单词不止一个，那么执行并集计算。
+		if len(syn) > 1 {
+			id, err := Union(ctx, conn, syn, ttl)
+			if err != nil {
+				return "", err
+			}
+			to_intersect = append(to_intersect, id)
+		} else {
+			// 如果同义词列表只包含一个单词，那么直接使用这个单词。
+			to_intersect = append(to_intersect, syn[0])
+		}
+	}
+	// 如果单词（或者并集计算的结果）有不止一个，那么执行交集计算。
+	// 只有一个单词并且没有不需要的单词时也复制一份 返回的总是临时集合 调用者延长结果的生存时间时不会影响索引
+	intersect_result := to_intersect[0]
+	if len(to_intersect) > 1 || (len(all[0]) == 1 && len(unwanted) == 0) {
+		id, err := Intersect(ctx, conn, to_intersect, ttl)
+		if err != nil {
+			return "", err
+		}
+		intersect_result = id
+	}
+	// 如果用户给定了不需要的单词，
+	// 那么从交集计算结果里面移除包含这些单词的文章，然后返回搜索结果。
+	if len(unwanted) > 0 {
+		return Difference(ctx, conn, append([]string{intersect_result}, unwanted...), ttl)
+	}
+	// 如果用户没有给定不需要的单词，那么直接返回交集计算的结果作为搜索的结果。
+	return intersect_result, nil
+}
+
+// SortOptions SearchAndSort 的参数
+type SortOptions struct {
+	// 已有的搜索结果 仍然存在时直接对它分页
+	ID string
+	// 搜索结果的生存时间 默认300秒
+	TTL time.Duration
+	// 排序使用的 kb:doc:<id> 散列字段 带-前缀时降序 默认 -updated
+	Sort  string
+	Start int64
+	// 每页数量 默认20
+	Num int64
+}
+
+// SearchResult 一页搜索结果
+type SearchResult struct {
+	// 结果的总数
+	Total int64
+	// 这一页的文档id
+	IDs []string
+	// 搜索结果的id 之后可以用它翻页
+	ID string
+}
+
+// 如果用户给定了已有的搜索结果，并且这个结果仍然存在的话，那么延长它的生存时间。
+// 否则执行一次新的搜索操作
+func searchOrRefresh(ctx context.Context, conn redis.Cmdable, query string, id string, ttl time.Duration) (string, error) {
+	if id != "" {
+		ok, err := conn.Expire(ctx, "idx:"+id, ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return id, nil
+		}
+	}
+	return ParseAndSearch(ctx, conn, query, ttl)
+}
+
+// SearchAndSort 搜索并按文档散列 kb:doc:<id> 中的字段排序 返回一页结果（代码清单7-5）
+// updated、id、created字段按数值排序 其他字段按字母排序
+func SearchAndSort(ctx context.Context, conn redis.Cmdable, query string, opts SortOptions) (*SearchResult, error) {
+	ctx = core.WithOp(ctx, "search.sort")
+	ttl, sortBy, num := opts.TTL, opts.Sort, opts.Num
+	if ttl <= 0 {
+		ttl = 300 * time.Second
+	}
+	if sortBy == "" {
+		sortBy = "-updated"
+	}
+	if num <= 0 {
+		num = 20
+	}
+	// 决定基于文章的哪个属性进行排序，以及是进行升序排序还是降序排序。
+	order := "ASC"
+	if strings.HasPrefix(sortBy, "-") {
+		order = "DESC"
+	}
+	sortBy = strings.TrimLeft(sortBy, "-")
+	// 告知 Redis ，排序是以数值方式进行还是字母方式进行。
+	alpha := sortBy != "updated" && sortBy != "id" && sortBy != "created"
+
+	id, err := searchOrRefresh(ctx, conn, query, opts.ID, ttl)
+	if err != nil {
+		return nil, core.Wrap("search.sort", err)
+	} else if id == "" {
+		return &SearchResult{}, nil
+	}
+	pipeline := conn.TxPipeline()
+	// 获取结果集合的元素数量。
+	total := pipeline.SCard(ctx, "idx:"+id)
+	// 根据指定属性对结果进行排序，并且只获取用户指定的那一部分结果。
+	ids := pipeline.Sort(ctx, "idx:"+id, &redis.Sort{
+		By: "kb:doc:*->" + sortBy, Alpha: alpha, Order: order, Offset: opts.Start, Count: num,
+	})
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, core.Wrap("search.sort", err)
+	}
+	// 返回搜索结果包含的元素数量、搜索结果本身以及搜索结果的 ID ，
+	// 其中搜索结果的 ID 可以用于在之后再次获取本次搜索的结果。
+	return &SearchResult{Total: total.Val(), IDs: ids.Val(), ID: id}, nil
+}
+
+// ZSortOptions SearchAndZSort 的参数
+type ZSortOptions struct {
+	// 已有的搜索结果 仍然存在时直接对它分页
+	ID string
+	// 搜索结果的生存时间 默认300秒
+	TTL time.Duration
+	// sort:update 和 sort:votes 的权重 根据待排序数据的需要 投票数量可以被调整为1、10、100甚至更高
+	Update, Vote float64
+	Start        int64
+	// 每页数量 默认20
+	Num int64
+	// 按评分升序 默认降序
+	Asc bool
+}
+
+// SearchAndZSort 搜索并按 sort:update 和 sort:votes 有序集合的加权评分排序 返回一页结果（代码清单7-6）
+func SearchAndZSort(ctx context.Context, conn redis.Cmdable, query string, opts ZSortOptions) (*SearchResult, error) {
+	ctx = core.WithOp(ctx, "search.zsort")
+	ttl, num := opts.TTL, opts.Num
+	if ttl <= 0 {
+		ttl = 300 * time.Second
+	}
+	if num <= 0 {
+		num = 20
+	}
+	id, err := searchOrRefresh(ctx, conn, query, opts.ID, ttl)
+	if err != nil {
+		return nil, core.Wrap("search.zsort", err)
+	} else if id == "" {
+		return &SearchResult{}, nil
+	}
+	// 函数在计算交集的时候也会用到传入的 ID 键，但这个键不会被用作排序权重（weight）。
+	id, err = ZIntersect(ctx, conn, map[string]float64{id: 0, "sort:update": opts.Update, "sort:votes": opts.Vote}, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+	pipeline := conn.TxPipeline()
+	// 获取结果有序集合的大小。
+	total := pipeline.ZCard(ctx, "idx:"+id)
+	// 从搜索结果里面取出一页（page）。
+	var ids *redis.StringSliceCmd
+	if opts.Asc {
+		ids = pipeline.ZRange(ctx, "idx:"+id, opts.Start, opts.Start+num-1)
+	} else {
+		ids = pipeline.ZRevRange(ctx, "idx:"+id, opts.Start, opts.Start+num-1)
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return nil, core.Wrap("search.zsort", err)
+	}
+	// 返回搜索结果，以及分页用的 ID 值。
+	return &SearchResult{Total: total.Val(), IDs: ids.Val(), ID: id}, nil
+}
+
+// 对 idx:<key> 执行有序集合运算 items为键和权重 结果保存在 idx:<id> 中并在ttl之后过期（代码清单7-7）
+// aggregate为SUM（默认）、MIN或MAX conn为redis.Pipeliner时只把命令加入流水线 由调用者执行
+func zsetCommon(ctx context.Context, conn redis.Cmdable, op string, items map[string]float64, ttl time.Duration, aggregate string) (string, error) {
+	// 创建一个新的临时标识符。
+	id := core.NewToken()
+	pipeline, queued := conn.(redis.Pipeliner)
+	if !queued {
+		// 设置事务流水线，保证每个单独的调用都有一致的结果。
+		pipeline = conn.TxPipeline()
+	}
+	// 为输入的键添加 ‘idx:’ 前缀。
+	store := &redis.ZStore{Aggregate: aggregate}
+	for key, weight := range items {
+		store.Keys = append(store.Keys, "idx:"+key)
+		store.Weights = append(store.Weights, weight)
+	}
+	// 为将要被执行的操作设置好相应的参数。
+	if op == "zinterstore" {
+		pipeline.ZInterStore(ctx, "idx:"+id, store)
+	} else {
+		pipeline.ZUnionStore(ctx, "idx:"+id, store)
+	}
+	// 为计算结果有序集合设置过期时间。
+	pipeline.Expire(ctx, "idx:"+id, ttl)
+	// 除非调用者明确指示要延迟执行操作，否则实际地执行计算操作。
+	if !queued {
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return "", core.Wrap("search."+op, err)
+		}
+	}
+	// 将计算结果的 ID 返回给调用者，以便做进一步的处理。
+	return id, nil
+}
+
+// ZIntersect 对 idx:<key> 按权重计算有序集合的交集 返回结果的id（代码清单7-7）
+func ZIntersect(ctx context.Context, conn redis.Cmdable, items map[string]float64, ttl time.Duration, aggregate string) (string, error) {
+	return zsetCommon(ctx, conn, "zinterstore", items, ttl, aggregate)
+}
+
+// ZUnion 对 idx:<key> 按权重计算有序集合的并集 返回结果的id（代码清单7-7）
+func ZUnion(ctx context.Context, conn redis.Cmdable, items map[string]float64, ttl time.Duration, aggregate string) (string, error) {
+	return zsetCommon(ctx, conn, "zunionstore", items, ttl, aggregate)
+}
+
+// StringToScore 把字符串的前6个字符转换为分值 分值的顺序和字符串的顺序相同（代码清单7-8）
+// ignoreCase为true时以大小写无关的方式计算
+func StringToScore(s string, ignoreCase bool) float64 {
+	// 用户可以通过参数来决定是否以大小写无关的方式建立前缀索引。
+	if ignoreCase {
+		s = strings.ToLower(s)
+	}
+	// 将字符串的前 6 个字符转换为相应的数字值，
+	// 为长度不足 6 个字符的字符串添加占位符-1，以此来表示这是一个短字符。
+	var score int64
+	for i := 0; i < 6; i++ {
+		piece := int64(-1)
+		if i < len(s) {
+			piece = int64(s[i])
+		}
+		// 程序处理空字符的方式和处理占位符的方式并不相同。
+		score = score*257 + piece + 1
+	}
+	// 通过多使用一个二进制位，
+	// 程序可以表明字符串是否正好为 6 个字符长，
+	// 这样它就可以正确地区分出 “robber” 和 “robbers” ，
+	// 尽管这对于区分 “robbers” 和 “robbery” 并无帮助。
+	score *= 2
+	if len(s) > 6 {
+		score++
+	}
+	return float64(score)
+}
+
+// ZAddString 以 StringToScore 计算的分值把成员添加到有序集合 members为成员到字符串的映射
+func ZAddString(ctx context.Context, conn redis.Cmdable, key string, members map[string]string) (int64, error) {
+	ctx = core.WithOp(ctx, "search.zadd_string")
+	zs := make([]*redis.Z, 0, len(members))
+	for member, s := range members {
+		zs = append(zs, &redis.Z{Score: StringToScore(s, false), Member: member})
+	}
+	n, err := conn.ZAdd(ctx, key, zs...).Result()
+	return n, core.Wrap("search.zadd_string", err)
+}
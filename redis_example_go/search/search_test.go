@@ -0,0 +1,198 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core/testutil"
+)
+
+const content = "this is some random content, look at how it is indexed."
+
+func indexTestDocs(t *testing.T, ctx context.Context, conn redis.Cmdable) {
+	t.Helper()
+	for id, c := range map[string]string{"test": content, "test2": "this is some additional content, look at how it is indexed."} {
+		if _, err := IndexDocument(ctx, conn, id, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 搜索结果集合中的文档 按字母排序
+func members(t *testing.T, ctx context.Context, conn redis.Cmdable, id string) []string {
+	t.Helper()
+	docs, err := conn.SMembers(ctx, "idx:"+id).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(docs)
+	return docs
+}
+
+func TestIndexDocument(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	if words := Tokenize(content); !reflect.DeepEqual(words, []string{"content", "indexed", "look", "random"}) {
+		t.Fatalf("Tokenize() = %v", words)
+	}
+	if n, err := IndexDocument(ctx, conn, "test", content); n != 4 || err != nil {
+		t.Fatalf("IndexDocument() = %d, %v", n, err)
+	}
+	for _, word := range Tokenize(content) {
+		if docs := members(t, ctx, conn, word); !reflect.DeepEqual(docs, []string{"test"}) {
+			t.Fatalf("idx:%s = %v", word, docs)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	indexTestDocs(t, ctx, conn)
+
+	id, _ := Intersect(ctx, conn, []string{"content", "indexed"}, DefaultTTL)
+	if docs := members(t, ctx, conn, id); !reflect.DeepEqual(docs, []string{"test", "test2"}) {
+		t.Fatalf("Intersect() = %v", docs)
+	}
+	id, _ = Intersect(ctx, conn, []string{"content", "random"}, DefaultTTL)
+	if docs := members(t, ctx, conn, id); !reflect.DeepEqual(docs, []string{"test"}) {
+		t.Fatalf("Intersect() = %v", docs)
+	}
+	id, _ = Union(ctx, conn, []string{"random", "additional"}, DefaultTTL)
+	if docs := members(t, ctx, conn, id); !reflect.DeepEqual(docs, []string{"test", "test2"}) {
+		t.Fatalf("Union() = %v", docs)
+	}
+	id, _ = Difference(ctx, conn, []string{"content", "random"}, DefaultTTL)
+	if docs := members(t, ctx, conn, id); !reflect.DeepEqual(docs, []string{"test2"}) {
+		t.Fatalf("Difference() = %v", docs)
+	}
+	if ttl := conn.TTL(ctx, "idx:"+id).Val(); ttl <= 0 || ttl > DefaultTTL {
+		t.Fatalf("TTL() = %v", ttl)
+	}
+
+	// 传入流水线时只加入命令 由调用者执行
+	pipe := conn.TxPipeline()
+	id, _ = Intersect(ctx, pipe, []string{"content", "indexed"}, DefaultTTL)
+	if n := conn.Exists(ctx, "idx:"+id).Val(); n != 0 {
+		t.Fatal("queued intersection was executed")
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if docs := members(t, ctx, conn, id); len(docs) != 2 {
+		t.Fatalf("queued Intersect() = %v", docs)
+	}
+}
+
+func TestParse(t *testing.T) {
+	all, unwanted := Parse("test query without stopwords")
+	if want := [][]string{{"test"}, {"query"}, {"without"}, {"stopwords"}}; !reflect.DeepEqual(all, want) || len(unwanted) != 0 {
+		t.Fatalf("Parse() = %v, %v", all, unwanted)
+	}
+	all, unwanted = Parse("test +query without -stopwords")
+	if want := [][]string{{"query", "test"}, {"without"}}; !reflect.DeepEqual(all, want) || !reflect.DeepEqual(unwanted, []string{"stopwords"}) {
+		t.Fatalf("Parse() = %v, %v", all, unwanted)
+	}
+}
+
+func TestParseAndSearch(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	indexTestDocs(t, ctx, conn)
+
+	for query, want := range map[string][]string{
+		"content":                 {"test", "test2"},
+		"content indexed random":  {"test"},
+		"content +indexed random": {"test"},
+		"content indexed +random": {"test", "test2"},
+		"content indexed -random": {"test2"},
+	} {
+		id, err := ParseAndSearch(ctx, conn, query, DefaultTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if docs := members(t, ctx, conn, id); !reflect.DeepEqual(docs, want) {
+			t.Errorf("ParseAndSearch(%q) = %v, want %v", query, docs, want)
+		}
+	}
+	if id, err := ParseAndSearch(ctx, conn, "the and", DefaultTTL); id != "" || err != nil {
+		t.Fatalf("ParseAndSearch(stop words) = %q, %v", id, err)
+	}
+}
+
+func TestSearchAndSort(t *testing.T) {
+	s := testutil.NewServer(t)
+	if !s.Real() {
+		t.Skip("miniredis does not support SORT")
+	}
+	ctx := context.Background()
+	conn := s.Client
+	indexTestDocs(t, ctx, conn)
+	conn.HSet(ctx, "kb:doc:test", "updated", 12345, "id", 10)
+	conn.HSet(ctx, "kb:doc:test2", "updated", 54321, "id", 1)
+
+	res, err := SearchAndSort(ctx, conn, "content", SortOptions{})
+	if err != nil || res.Total != 2 || !reflect.DeepEqual(res.IDs, []string{"test2", "test"}) {
+		t.Fatalf("SearchAndSort() = %+v, %v", res, err)
+	}
+	res, _ = SearchAndSort(ctx, conn, "content", SortOptions{Sort: "-id"})
+	if !reflect.DeepEqual(res.IDs, []string{"test", "test2"}) {
+		t.Fatalf("SearchAndSort(-id) = %+v", res)
+	}
+	// 用已有的结果翻页
+	page, _ := SearchAndSort(ctx, conn, "", SortOptions{ID: res.ID, Sort: "-id", Start: 1, Num: 1})
+	if page.ID != res.ID || !reflect.DeepEqual(page.IDs, []string{"test2"}) {
+		t.Fatalf("second page = %+v", page)
+	}
+	// 延长结果的生存时间不会让索引过期
+	if ttl := conn.TTL(ctx, "idx:content").Val(); ttl != -1 {
+		t.Fatalf("TTL(idx:content) = %v", ttl)
+	}
+}
+
+func TestSearchAndZSort(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	indexTestDocs(t, ctx, conn)
+	conn.ZAdd(ctx, "idx:sort:update", &redis.Z{Score: 12345, Member: "test"}, &redis.Z{Score: 54321, Member: "test2"})
+	conn.ZAdd(ctx, "idx:sort:votes", &redis.Z{Score: 10, Member: "test"}, &redis.Z{Score: 1, Member: "test2"})
+
+	res, err := SearchAndZSort(ctx, conn, "content", ZSortOptions{Update: 1})
+	if err != nil || res.Total != 2 || !reflect.DeepEqual(res.IDs, []string{"test2", "test"}) {
+		t.Fatalf("SearchAndZSort(update) = %+v, %v", res, err)
+	}
+	res, _ = SearchAndZSort(ctx, conn, "content", ZSortOptions{Vote: 1})
+	if !reflect.DeepEqual(res.IDs, []string{"test", "test2"}) {
+		t.Fatalf("SearchAndZSort(votes) = %+v", res)
+	}
+	res, _ = SearchAndZSort(ctx, conn, "content", ZSortOptions{Vote: 1, Asc: true})
+	if !reflect.DeepEqual(res.IDs, []string{"test2", "test"}) {
+		t.Fatalf("SearchAndZSort(votes, asc) = %+v", res)
+	}
+}
+
+func TestStringToScore(t *testing.T) {
+	words := []string{"these", "are", "some", "words", "that", "will", "be", "sorted", "robber", "robbers", "robbery"}
+	sorted := append([]string(nil), words...)
+	sort.Strings(sorted)
+	byScore := append([]string(nil), words...)
+	sort.SliceStable(byScore, func(i, j int) bool { return StringToScore(byScore[i], false) < StringToScore(byScore[j], false) })
+	if !reflect.DeepEqual(byScore, sorted) {
+		t.Fatalf("sorted by score = %v, want %v", byScore, sorted)
+	}
+	if StringToScore("Robber", true) != StringToScore("robber", false) {
+		t.Fatal("ignoreCase did not lower the string")
+	}
+
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	if _, err := ZAddString(ctx, conn, "names", map[string]string{"a": "zed", "b": "adam"}); err != nil {
+		t.Fatal(err)
+	}
+	if names := conn.ZRange(ctx, "names", 0, -1).Val(); !reflect.DeepEqual(names, []string{"b", "a"}) {
+		t.Fatalf("names = %v", names)
+	}
+}
@@ -0,0 +1,222 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// Options 会话中间件的配置
+type Options struct {
+	// cookie的名字 默认为"session"
+	CookieName string
+	// cookie的路径 默认为"/"
+	Path string
+	// 只通过HTTPS发送cookie
+	Secure bool
+	// 滑动过期：令牌超过这么长时间没有出现（recent:中的时间）就失效 为0时不限制
+	IdleTimeout time.Duration
+	// 绝对过期：会话创建之后超过这么长时间就失效 不论是否活跃 为0时不限制
+	MaxAge time.Duration
+	// 返回请求浏览的商品 返回空字符串表示没有浏览商品 为nil时不记录浏览
+	Item func(r *http.Request) string
+}
+
+// Session 一个请求对应的会话 未登录的访客也有会话（User为空） 可以浏览商品和使用购物车
+type Session struct {
+	Token   string
+	User    string
+	Created time.Time
+
+	conn redis.Cmdable
+	opts *Options
+	w    http.ResponseWriter
+}
+
+type sessionKey struct{}
+
+// FromContext 取出中间件放入请求ctx的会话 没有经过中间件时返回nil
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Middleware 返回net/http中间件：从cookie中取出令牌 通过login:散列找到用户 刷新recent:中的时间并记录浏览的商品
+// 没有cookie或者会话已经过期时签发新的随机令牌 会话通过 FromContext(r.Context()) 获取
+// 会话的创建时间记录在created:有序集合中 用于绝对过期
+func Middleware(conn redis.Cmdable, opts Options) func(http.Handler) http.Handler {
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s, err := loadSession(r.Context(), conn, &opts, r)
+			if err != nil {
+				http.Error(w, "session unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			s.w = w
+			item := ""
+			if opts.Item != nil {
+				item = opts.Item(r)
+			}
+			if err := s.touch(r.Context(), item); err != nil {
+				http.Error(w, "session unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+		})
+	}
+}
+
+// 按cookie加载会话 没有有效的会话时创建一个新的匿名会话（还没有写入redis）
+func loadSession(ctx context.Context, conn redis.Cmdable, opts *Options, r *http.Request) (*Session, error) {
+	ctx = core.WithOp(ctx, "sessions.load")
+	now := Clock.Now()
+	if c, err := r.Cookie(opts.CookieName); err == nil && c.Value != "" {
+		pipe := conn.Pipeline()
+		user := pipe.HGet(ctx, "login:", c.Value)
+		seen := pipe.ZScore(ctx, "recent:", c.Value)
+		created := pipe.ZScore(ctx, "created:", c.Value)
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, core.Wrap("sessions.load", err)
+		}
+		if user.Err() == nil {
+			s := &Session{Token: c.Value, User: user.Val(), Created: now, conn: conn, opts: opts}
+			if created.Err() == nil {
+				s.Created = time.Unix(int64(created.Val()), 0)
+			}
+			idle := opts.IdleTimeout > 0 && seen.Err() == nil && now.Sub(time.Unix(int64(seen.Val()), 0)) >= opts.IdleTimeout
+			expired := opts.MaxAge > 0 && now.Sub(s.Created) >= opts.MaxAge
+			if !idle && !expired {
+				return s, nil
+			}
+			if err := DeleteSession(ctx, conn, c.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Session{Token: core.NewToken(), Created: now, conn: conn, opts: opts}, nil
+}
+
+// 刷新令牌的最近出现时间 并把cookie写入响应
+func (s *Session) touch(ctx context.Context, item string) error {
+	ctx = core.WithOp(ctx, "sessions.update_token")
+	timestamp := float64(Clock.Now().Unix())
+	if err := onView(ctx, s.conn, s.Token, item, timestamp); err != nil {
+		return err
+	}
+	pipe := s.conn.Pipeline()
+	updateToken(ctx, pipe, s.Token, s.User, item, timestamp)
+	pipe.ZAddNX(ctx, "created:", &redis.Z{Score: float64(s.Created.Unix()), Member: s.Token})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return core.Wrap("sessions.update_token", err)
+	}
+	s.setCookie(s.Token, s.cookieMaxAge())
+	return nil
+}
+
+// cookie的有效期 取滑动过期和绝对过期中较早的一个 都没有时为浏览器会话cookie
+func (s *Session) cookieMaxAge() int {
+	var age time.Duration
+	if s.opts.IdleTimeout > 0 {
+		age = s.opts.IdleTimeout
+	}
+	if s.opts.MaxAge > 0 {
+		left := s.opts.MaxAge - Clock.Now().Sub(s.Created)
+		if age == 0 || left < age {
+			age = left
+		}
+	}
+	if age > 0 && age < time.Second {
+		age = time.Second
+	}
+	return int(age / time.Second)
+}
+
+// 写入cookie 替换同一个响应里之前写入的会话cookie maxAge<0表示删除cookie
+func (s *Session) setCookie(value string, maxAge int) {
+	header := s.w.Header()
+	cookies := header["Set-Cookie"][:0]
+	for _, c := range header["Set-Cookie"] {
+		if !strings.HasPrefix(c, s.opts.CookieName+"=") {
+			cookies = append(cookies, c)
+		}
+	}
+	header["Set-Cookie"] = cookies
+	http.SetCookie(s.w, &http.Cookie{
+		Name:     s.opts.CookieName,
+		Value:    value,
+		Path:     s.opts.Path,
+		MaxAge:   maxAge,
+		Secure:   s.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Login 把会话绑定到user 同时换一个新的令牌（防止会话固定攻击） 购物车和浏览记录转移到新令牌
+// 需要在写入响应体之前调用
+func (s *Session) Login(ctx context.Context, user string) error {
+	ctx = core.WithOp(ctx, "sessions.login")
+	old := s.Token
+	token := core.NewToken()
+	check := s.conn.Pipeline()
+	cart := check.Exists(ctx, "cart:"+old)
+	viewed := check.Exists(ctx, "viewed:"+old)
+	if _, err := check.Exec(ctx); err != nil {
+		return core.Wrap("sessions.login", err)
+	}
+	now := Clock.Now()
+	pipe := s.conn.TxPipeline()
+	if cart.Val() == 1 {
+		pipe.Rename(ctx, "cart:"+old, "cart:"+token)
+	}
+	if viewed.Val() == 1 {
+		pipe.Rename(ctx, "viewed:"+old, "viewed:"+token)
+	}
+	pipe.HDel(ctx, "login:", old)
+	pipe.ZRem(ctx, "recent:", old)
+	pipe.ZRem(ctx, "created:", old)
+	updateToken(ctx, pipe, token, user, "", float64(now.Unix()))
+	pipe.ZAdd(ctx, "created:", &redis.Z{Score: float64(now.Unix()), Member: token})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return core.Wrap("sessions.login", err)
+	}
+	s.Token, s.User, s.Created = token, user, now
+	s.setCookie(token, s.cookieMaxAge())
+	return nil
+}
+
+// Logout 删除会话和cookie 之后的请求会得到新的匿名会话
+func (s *Session) Logout(ctx context.Context) error {
+	if err := DeleteSession(ctx, s.conn, s.Token); err != nil {
+		return err
+	}
+	s.setCookie("", -1)
+	return nil
+}
+
+// AddToCart 将商品添加到会话的购物车 count<=0时移除
+func (s *Session) AddToCart(ctx context.Context, item string, count int) error {
+	return AddToCart(ctx, s.conn, s.Token, item, count)
+}
+
+// DeleteSession 删除令牌对应的会话 包括浏览记录和购物车
+func DeleteSession(ctx context.Context, conn redis.Cmdable, token string) error {
+	ctx = core.WithOp(ctx, "sessions.delete")
+	pipe := conn.TxPipeline()
+	pipe.Del(ctx, "viewed:"+token, "cart:"+token)
+	pipe.HDel(ctx, "login:", token)
+	pipe.ZRem(ctx, "recent:", token)
+	pipe.ZRem(ctx, "created:", token)
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("sessions.delete", err)
+}
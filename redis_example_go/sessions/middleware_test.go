@@ -0,0 +1,129 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 返回 用户 令牌
+func get(t *testing.T, c *http.Client, url string) (string, string) {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	parts := strings.SplitN(string(body), " ", 2)
+	return parts[0], parts[1]
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	srv := httptest.NewServer(Middleware(conn, Options{
+		Item: func(r *http.Request) string {
+			if strings.HasPrefix(r.URL.Path, "/item/") {
+				return strings.TrimPrefix(r.URL.Path, "/item/")
+			}
+			return ""
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		if r.URL.Path == "/login" {
+			s.AddToCart(r.Context(), "itemY", 1)
+			if err := s.Login(r.Context(), "username"); err != nil {
+				t.Error(err)
+			}
+		}
+		if r.URL.Path == "/logout" {
+			s.Logout(r.Context())
+		}
+		fmt.Fprintf(w, "%s %s", s.User, s.Token)
+	})))
+	defer srv.Close()
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{Jar: jar}
+
+	// 匿名访客得到令牌 浏览记录在令牌下
+	user, anon := get(t, c, srv.URL+"/item/itemX")
+	if user != "" || len(anon) != 32 {
+		t.Fatalf("anonymous session = %q %q", user, anon)
+	}
+	if _, again := get(t, c, srv.URL+"/"); again != anon {
+		t.Fatalf("token changed to %s", again)
+	}
+	if items := conn.ZRange(ctx, "viewed:"+anon, 0, -1).Val(); len(items) != 1 || items[0] != "itemX" {
+		t.Fatalf("viewed = %v", items)
+	}
+
+	// 登录换了新令牌 购物车跟着转移
+	user, token := get(t, c, srv.URL+"/login")
+	if user != "username" || token == anon {
+		t.Fatalf("after login = %q %q", user, token)
+	}
+	if r, _ := CheckToken(ctx, conn, token); r != "username" {
+		t.Fatalf("CheckToken() = %q", r)
+	}
+	if conn.HGet(ctx, "cart:"+token, "itemY").Val() != "1" || conn.HExists(ctx, "login:", anon).Val() {
+		t.Fatal("cart not moved to the new token")
+	}
+	if user, again := get(t, c, srv.URL+"/"); user != "username" || again != token {
+		t.Fatalf("next request = %q %q", user, again)
+	}
+
+	get(t, c, srv.URL+"/logout")
+	if conn.HExists(ctx, "login:", token).Val() || conn.Exists(ctx, "cart:"+token).Val() != 0 {
+		t.Fatal("session not deleted on logout")
+	}
+	if user, _ := get(t, c, srv.URL+"/"); user != "" {
+		t.Fatalf("user after logout = %q", user)
+	}
+}
+
+func TestMiddlewareExpiry(t *testing.T) {
+	clock := core.NewFakeClock(time.Now())
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	conn := testutil.NewServer(t).Client
+	srv := httptest.NewServer(Middleware(conn, Options{IdleTimeout: time.Hour, MaxAge: 3 * time.Hour})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "x ", FromContext(r.Context()).Token)
+		})))
+	defer srv.Close()
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{Jar: jar}
+
+	_, first := get(t, c, srv.URL)
+	// 活跃的会话每次请求都会续期
+	for i := 0; i < 2; i++ {
+		clock.Advance(50 * time.Minute)
+		if _, token := get(t, c, srv.URL); token != first {
+			t.Fatalf("session expired while active")
+		}
+	}
+	// 超过3小时 即使一直活跃也会过期
+	clock.Advance(50 * time.Minute)
+	get(t, c, srv.URL)
+	clock.Advance(50 * time.Minute)
+	_, second := get(t, c, srv.URL)
+	if second == first || conn.HExists(context.Background(), "login:", first).Val() {
+		t.Fatal("session not expired after MaxAge")
+	}
+	// 空闲超过1小时过期（cookie还在时服务端也会拒绝）
+	clock.Advance(2 * time.Hour)
+	if _, third := get(t, c, srv.URL); third == second {
+		t.Fatal("session not expired after IdleTimeout")
+	}
+}
@@ -0,0 +1,160 @@
+package sessions
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+// ReaperStats 会话清理器的统计信息
+type ReaperStats struct {
+	Batches int64         // 执行过的批次数量
+	Reaped  int64         // 删除的会话数量
+	Skipped int64         // 选出之后又被刷新 因此没有删除的会话数量
+	Backlog int64         // 最近一批之后仍然需要清除的会话数量
+	Lag     time.Duration // 最近一批删除的会话中最旧的一个距离最后出现的时间
+	LastRun time.Time     // 最近一批的时间
+}
+
+// Reaper 会话清理器 每批从recent:中选出最旧的会话 用Lua脚本原子地确认它们没有被刷新之后再删除
+// 会话数量超过Limit或者超过MaxIdle没有出现的会话会被清除
+type Reaper struct {
+	Limit    int64         // 最多保留的会话数量
+	MaxIdle  time.Duration // 为0时只按Limit清除
+	Batch    int64         // 每批最多删除的会话数量
+	Interval time.Duration // 没有需要清除的会话时的休眠时间
+	WithCart bool          // 同时删除购物车
+
+	conn  redis.Cmdable
+	mu    sync.Mutex
+	stats ReaperStats
+}
+
+func NewReaper(conn redis.Cmdable, limit int64) *Reaper {
+	return &Reaper{
+		Limit:    limit,
+		Batch:    100,
+		Interval: time.Second,
+		conn:     conn,
+	}
+}
+
+// KEYS: recent: login: created: 之后是每个令牌的 viewed:<令牌> cart:<令牌>
+// ARGV: 是否删除购物车 之后是成对的 令牌 选出时的最近出现时间
+// 只删除最近出现时间没有变化的令牌 返回 {删除的数量, 删除的令牌中最早的出现时间}
+var reapScript = redis.NewScript(`
+local reaped = 0
+local oldest = 0
+for i = 2, #ARGV, 2 do
+	local token = ARGV[i]
+	local seen = redis.call("zscore", KEYS[1], token)
+	if seen and tonumber(seen) == tonumber(ARGV[i + 1]) then
+		redis.call("del", KEYS[i + 2])
+		if ARGV[1] == "1" then
+			redis.call("del", KEYS[i + 3])
+		end
+		redis.call("hdel", KEYS[2], token)
+		redis.call("zrem", KEYS[1], token)
+		redis.call("zrem", KEYS[3], token)
+		reaped = reaped + 1
+		if reaped == 1 or tonumber(seen) < oldest then
+			oldest = tonumber(seen)
+		end
+	end
+end
+return {reaped, oldest}`)
+
+// Stats 返回统计信息
+func (r *Reaper) Stats() ReaperStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// ReapOnce 清除一批会话 返回删除的数量和之后仍然需要清除的数量
+func (r *Reaper) ReapOnce(ctx context.Context) (int64, int64, error) {
+	ctx = core.WithOp(ctx, "sessions.clean")
+	now := Clock.Now()
+	pipe := r.conn.Pipeline()
+	size := pipe.ZCard(ctx, "recent:")
+	var idle *redis.IntCmd
+	if r.MaxIdle > 0 {
+		max := strconv.FormatInt(now.Add(-r.MaxIdle).Unix(), 10)
+		idle = pipe.ZCount(ctx, "recent:", "-inf", "("+max)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, core.Wrap("sessions.clean", err)
+	}
+	// 超过限制的会话和空闲太久的会话都是最旧的那些
+	due := size.Val() - r.Limit
+	if idle != nil && idle.Val() > due {
+		due = idle.Val()
+	}
+	if due <= 0 {
+		r.record(now, 0, 0, 0, 0)
+		return 0, 0, nil
+	}
+	n := due
+	if r.Batch > 0 && n > r.Batch {
+		n = r.Batch
+	}
+	tokens, err := r.conn.ZRangeWithScores(ctx, "recent:", 0, n-1).Result()
+	if err != nil {
+		return 0, 0, core.Wrap("sessions.clean", err)
+	}
+	withCart := "0"
+	if r.WithCart {
+		withCart = "1"
+	}
+	keys := []string{"recent:", "login:", "created:"}
+	args := []interface{}{withCart}
+	for _, z := range tokens {
+		token := z.Member.(string)
+		keys = append(keys, "viewed:"+token, "cart:"+token)
+		args = append(args, token, strconv.FormatFloat(z.Score, 'f', -1, 64))
+	}
+	reply, err := reapScript.Run(ctx, r.conn, keys, args...).Result()
+	if err != nil {
+		return 0, 0, core.Wrap("sessions.clean", err)
+	}
+	res := reply.([]interface{})
+	reaped, oldest := res[0].(int64), res[1].(int64)
+	backlog := due - reaped
+	r.record(now, reaped, int64(len(tokens))-reaped, backlog, oldest)
+	return reaped, backlog, nil
+}
+
+func (r *Reaper) record(now time.Time, reaped int64, skipped int64, backlog int64, oldest int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Batches++
+	r.stats.Reaped += reaped
+	r.stats.Skipped += skipped
+	r.stats.Backlog = backlog
+	r.stats.LastRun = now
+	if reaped > 0 {
+		r.stats.Lag = now.Sub(time.Unix(oldest, 0))
+	}
+}
+
+// Run 守护任务 一直清除会话直到ctx结束或出错 还有积压时不休眠
+func (r *Reaper) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for ctx.Err() == nil {
+		reaped, backlog, err := r.ReapOnce(ctx)
+		if err != nil {
+			return core.StopErr(ctx, "sessions.clean", err)
+		}
+		if reaped == 0 || backlog <= 0 {
+			core.Sleep(Clock, ctx.Done(), interval)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package sessions 第二章的登录令牌和购物车：用散列保存令牌对应的用户 用有序集合记录令牌最近出现的时间
+package sessions
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+)
+
+type Login struct {
+	Tokens map[string]string
+}
+
+type Recent struct {
+	TokenTimestamp map[string]float64 `sorted:"1"`
+}
+
+type Viewed_Token struct {
+	ItemTimestamp map[string]bool `sorted:"0"`
+}
+
+type Cart_Session struct {
+	Items map[string]int
+}
+
+// 令牌最近出现时间使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// OnView 在记录浏览的商品之前调用 这时viewed:<令牌>中还没有这次浏览 为nil时不调用
+// UpdateTokenPipeline 和中间件在执行流水线之前调用它 所以设置之后会多出OnView自己的通信往返
+var OnView func(ctx context.Context, conn redis.Cmdable, token string, item string, timestamp float64) error
+
+// 默认最多保留的会话数量
+const LIMIT int64 = 10000000
+
+// CheckToken 获取令牌对应的用户 令牌不存在时返回 core.ErrNotFound（代码清单2-1）
+func CheckToken(ctx context.Context, conn redis.Cmdable, token string) (string, error) {
+	ctx = core.WithOp(ctx, "sessions.check_token")
+	user, err := conn.HGet(ctx, "login:", token).Result() // 尝试获取并返回令牌对应的用户。
+	return user, core.Wrap("sessions.check_token", err)
+}
+
+// UpdateToken 更新令牌的最近出现时间 并记录用户浏览过的商品（代码清单2-2、2-9）
+// 商品的浏览次数记录在viewed:有序集合中 分值越小浏览次数越多
+func UpdateToken(ctx context.Context, conn redis.Cmdable, token string, user string, item string) error {
+	ctx = core.WithOp(ctx, "sessions.update_token")
+	// 获取当前时间戳。
+	timestamp := float64(Clock.Now().Unix())
+	// 维持令牌与已登录用户之间的映射。
+	if err := conn.HSet(ctx, "login:", token, user).Err(); err != nil {
+		return core.Wrap("sessions.update_token", err)
+	}
+	// 记录令牌最后一次出现的时间。
+	if err := conn.ZAdd(ctx, "recent:", &redis.Z{Score: timestamp, Member: token}).Err(); err != nil {
+		return core.Wrap("sessions.update_token", err)
+	}
+	if item != "" {
+		if err := onView(ctx, conn, token, item, timestamp); err != nil {
+			return err
+		}
+		// 记录用户浏览过的商品。
+		if err := conn.ZAdd(ctx, "viewed:"+token, &redis.Z{Score: timestamp, Member: item}).Err(); err != nil {
+			return core.Wrap("sessions.update_token", err)
+		}
+		// 移除旧的记录，只保留用户最近浏览过的25个商品。
+		//移除从开始到倒数26的元素
+		if err := conn.ZRemRangeByRank(ctx, "viewed:"+token, 0, -26).Err(); err != nil {
+			return core.Wrap("sessions.update_token", err)
+		}
+		if err := conn.ZIncrBy(ctx, "viewed:", -1, item).Err(); err != nil {
+			return core.Wrap("sessions.update_token", err)
+		}
+	}
+	return nil
+}
+
+// UpdateTokenPipeline 使用流水线更新令牌 效果与UpdateToken相同 但只需要一次通信往返（代码清单4-7）
+func UpdateTokenPipeline(ctx context.Context, conn redis.Cmdable, token string, user string, item string) error {
+	ctx = core.WithOp(ctx, "sessions.update_token")
+	timestamp := float64(Clock.Now().Unix())
+	if err := onView(ctx, conn, token, item, timestamp); err != nil {
+		return err
+	}
+	// 设置流水线。
+	pipe := conn.Pipeline() //A
+	updateToken(ctx, pipe, token, user, item, timestamp)
+	// 执行那些被流水线包裹的命令。
+	_, err := pipe.Exec(ctx) //B
+	return core.Wrap("sessions.update_token", err)
+}
+
+// 浏览了商品时调用OnView
+func onView(ctx context.Context, conn redis.Cmdable, token string, item string, timestamp float64) error {
+	if OnView == nil || item == "" {
+		return nil
+	}
+	return OnView(ctx, conn, token, item, timestamp)
+}
+
+// 把更新令牌的命令加入流水线
+func updateToken(ctx context.Context, pipe redis.Pipeliner, token string, user string, item string, timestamp float64) {
+	pipe.HSet(ctx, "login:", token, user)
+	pipe.ZAdd(ctx, "recent:", &redis.Z{Score: timestamp, Member: token})
+	if item != "" {
+		pipe.ZAdd(ctx, "viewed:"+token, &redis.Z{Score: timestamp, Member: item})
+		pipe.ZRemRangeByRank(ctx, "viewed:"+token, 0, -26)
+		pipe.ZIncrBy(ctx, "viewed:", -1, item)
+	}
+}
+
+// CleanSessions 守护任务 会话数量超过limit时清除最旧的会话 直到ctx结束或出错（代码清单2-3）
+func CleanSessions(ctx context.Context, conn redis.Cmdable, limit int64) error {
+	return NewReaper(conn, limit).Run(ctx)
+}
+
+// CleanFullSessions 与CleanSessions相同 但同时删除会话对应的购物车（代码清单2-5）
+func CleanFullSessions(ctx context.Context, conn redis.Cmdable, limit int64) error {
+	r := NewReaper(conn, limit)
+	r.WithCart = true
+	return r.Run(ctx)
+}
+
+// AddToCart 将商品添加到购物车 count<=0时从购物车中移除（代码清单2-4）
+func AddToCart(ctx context.Context, conn redis.Cmdable, session string, item string, count int) error {
+	ctx = core.WithOp(ctx, "sessions.add_to_cart")
+	var err error
+	if count <= 0 {
+		// 从购物车里面移除指定的商品。
+		err = conn.HDel(ctx, "cart:"+session, item).Err()
+	} else {
+		// 将指定的商品添加到购物车。
+		err = conn.HSet(ctx, "cart:"+session, item, count).Err()
+	}
+	return core.Wrap("sessions.add_to_cart", err)
+}
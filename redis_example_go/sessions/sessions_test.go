@@ -0,0 +1,128 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 在后台运行清理会话的守护任务 一秒之后结束
+func runCleaner(t *testing.T, clean func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := clean(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 对应TestCh02.test_login_cookies
+func TestLoginCookies(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	token := core.NewToken()
+
+	if err := UpdateToken(ctx, conn, token, "username", "itemX"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := CheckToken(ctx, conn, token); err != nil || r != "username" {
+		t.Fatalf("CheckToken() = %q, %v", r, err)
+	}
+
+	runCleaner(t, func(ctx context.Context) error { return CleanSessions(ctx, conn, 0) })
+	if s := conn.HLen(ctx, "login:").Val(); s != 0 {
+		t.Fatalf("%d sessions left", s)
+	}
+	if _, err := CheckToken(ctx, conn, token); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("CheckToken() after clean = %v, want ErrNotFound", err)
+	}
+}
+
+// 对应TestCh02.test_shoppping_cart_cookies
+func TestShoppingCartCookies(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	token := core.NewToken()
+
+	UpdateToken(ctx, conn, token, "username", "itemX")
+	if err := AddToCart(ctx, conn, token, "itemY", 3); err != nil {
+		t.Fatal(err)
+	}
+	if r := conn.HGetAll(ctx, "cart:"+token).Val(); r["itemY"] != "3" {
+		t.Fatalf("cart = %v", r)
+	}
+
+	runCleaner(t, func(ctx context.Context) error { return CleanFullSessions(ctx, conn, 0) })
+	if r := conn.HGetAll(ctx, "cart:"+token).Val(); len(r) != 0 {
+		t.Fatalf("cart after clean = %v", r)
+	}
+}
+
+func TestUpdateTokenPipeline(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	token := core.NewToken()
+
+	for i := 0; i < 30; i++ {
+		if err := UpdateTokenPipeline(ctx, conn, token, "username", "item"+string(rune('A'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.ZCard(ctx, "viewed:"+token).Val(); n != 25 {
+		t.Fatalf("viewed items = %d, want 25", n)
+	}
+}
+
+func TestReaper(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Unix(1600000000, 0))
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	tokens := make([]string, 5)
+	for i := range tokens {
+		tokens[i] = core.NewToken()
+		UpdateToken(ctx, conn, tokens[i], "username", "itemX")
+		AddToCart(ctx, conn, tokens[i], "itemY", 1)
+		clock.Advance(time.Minute)
+	}
+
+	r := NewReaper(conn, 2)
+	r.Batch = 2
+	r.WithCart = true
+	if reaped, backlog, err := r.ReapOnce(ctx); reaped != 2 || backlog != 1 || err != nil {
+		t.Fatalf("ReapOnce() = %d, %d, %v", reaped, backlog, err)
+	}
+	if conn.HExists(ctx, "login:", tokens[0]).Val() || conn.Exists(ctx, "viewed:"+tokens[1], "cart:"+tokens[1]).Val() != 0 {
+		t.Fatal("oldest sessions not reaped")
+	}
+	if s := r.Stats(); s.Reaped != 2 || s.Backlog != 1 || s.Lag != 5*time.Minute {
+		t.Fatalf("Stats() = %+v", s)
+	}
+
+	// 选出之后又被刷新的会话不会被删除
+	old := conn.ZScore(ctx, "recent:", tokens[2]).Val()
+	UpdateToken(ctx, conn, tokens[2], "username", "")
+	reply, err := reapScript.Run(ctx, conn, []string{"recent:", "login:", "created:"}, "1", tokens[2], old).Result()
+	if err != nil || reply.([]interface{})[0].(int64) != 0 {
+		t.Fatalf("reapScript(refreshed) = %v, %v", reply, err)
+	}
+	if r, _ := CheckToken(ctx, conn, tokens[2]); r != "username" {
+		t.Fatal("refreshed session was reaped")
+	}
+
+	// 空闲超过MaxIdle的会话即使没有超过数量限制也会被清除
+	r = NewReaper(conn, 10)
+	r.MaxIdle = 3 * time.Minute
+	clock.Advance(2 * time.Minute)
+	if reaped, _, err := r.ReapOnce(ctx); reaped != 1 || err != nil {
+		t.Fatalf("ReapOnce(idle) = %d, %v", reaped, err)
+	}
+	if conn.HExists(ctx, "login:", tokens[3]).Val() || conn.HLen(ctx, "login:").Val() != 2 {
+		t.Fatalf("sessions left = %v", conn.HKeys(ctx, "login:").Val())
+	}
+}
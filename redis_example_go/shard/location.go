@@ -0,0 +1,225 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"sort"
+	"strings"
+)
+
+// 一个由 ISO3 国家编码组成的字符串表格，
+// 根据空白对这个字符串进行分割，将它转换为一个由国家编码组成的列表。（代码清单9-13）
+var COUNTRIES = strings.Fields(`
+ABW AFG AGO AIA ALA ALB AND ARE ARG ARM ASM ATA ATF ATG AUS AUT AZE BDI
+BEL BEN BES BFA BGD BGR BHR BHS BIH BLM BLR BLZ BMU BOL BRA BRB BRN BTN
+BVT BWA CAF CAN CCK CHE CHL CHN CIV CMR COD COG COK COL COM CPV CRI CUB
+CUW CXR CYM CYP CZE DEU DJI DMA DNK DOM DZA ECU EGY ERI ESH ESP EST ETH
+FIN FJI FLK FRA FRO FSM GAB GBR GEO GGY GHA GIB GIN GLP GMB GNB GNQ GRC
+GRD GRL GTM GUF GUM GUY HKG HMD HND HRV HTI HUN IDN IMN IND IOT IRL IRN
+IRQ ISL ISR ITA JAM JEY JOR JPN KAZ KEN KGZ KHM KIR KNA KOR KWT LAO LBN
+LBR LBY LCA LIE LKA LSO LTU LUX LVA MAC MAF MAR MCO MDA MDG MDV MEX MHL
+MKD MLI MLT MMR MNE MNG MNP MOZ MRT MSR MTQ MUS MWI MYS MYT NAM NCL NER
+NFK NGA NIC NIU NLD NOR NPL NRU NZL OMN PAK PAN PCN PER PHL PLW PNG POL
+PRI PRK PRT PRY PSE PYF QAT REU ROU RUS RWA SAU SDN SEN SGP SGS SHN SJM
+SLB SLE SLV SMR SOM SPM SRB SSD STP SUR SVK SVN SWE SWZ SXM SYC SYR TCA
+TCD TGO THA TJK TKL TKM TLS TON TTO TUN TUR TUV TWN TZA UGA UKR UMI URY
+USA UZB VAT VCT VEN VGB VIR VNM VUT WLF WSM YEM ZAF ZMB ZWE`)
+
+var STATES = map[string][]string{
+	// 加拿大的省信息和属地信息。
+	"CAN": strings.Fields(`AB BC MB NB NL NS NT NU ON PE QC SK YT`),
+	// 美国各个州的信息。
+	"USA": strings.Fields(`AA AE AK AL AP AR AS AZ CA CO CT DC DE FL FM GA GU HI IA ID
+IL IN KS KY LA MA MD ME MH MI MN MO MP MS MT NC ND NE NH NJ NM NV NY OH
+OK OR PA PR PW RI SC SD TN TX UT VA VI VT WA WI WV WY`),
+}
+
+// GetCode 将国家和州转换为两个字节的位置编码（代码清单9-14）
+// 因为 Redis 里面的未初始化数据为0 所以未找到时编码为0 找到时编码为索引加1
+func GetCode(country string, state string) string {
+	// 寻找国家对应的偏移量。
+	cindex := sort.SearchStrings(COUNTRIES, country)
+	// 没有找到指定的国家时，将索引设置为 -1 。
+	if cindex >= len(COUNTRIES) || COUNTRIES[cindex] != country {
+		cindex = -1
+	}
+	cindex += 1
+
+	sindex := -1
+	if states, ok := STATES[country]; ok && state != "" {
+		// 寻找州对应的偏移量。
+		sindex = sort.SearchStrings(states, state)
+		// 像处理“未找到指定国家”时的情况一样，处理“未找到指定州”的情况。
+		if sindex >= len(states) || states[sindex] != state {
+			sindex = -1
+		}
+	}
+	// 如果没有找到指定的州，那么索引为 0 ；
+	// 如果找到了指定的州，那么索引大于 0 。
+	sindex += 1
+
+	return string([]byte{byte(cindex), byte(sindex)})
+}
+
+// 设置每个分片的大小。
+const USERS_PER_SHARD = 1 << 20
+
+// SetLocation 将用户的位置编码打包保存到location:<分片id>字符串中（代码清单9-15）
+func SetLocation(ctx context.Context, conn redis.Cmdable, user_id int64, country string, state string) error {
+	ctx = core.WithOp(ctx, "shard.set_location")
+	// 取得用户所在位置的编码。
+	code := GetCode(country, state)
+
+	// 查找分片 ID 以及用户在指定分片中的位置（position）。
+	shard_id, position := user_id/USERS_PER_SHARD, user_id%USERS_PER_SHARD
+	// 计算用户数据的偏移量。
+	offset := position * 2
+
+	pipe := conn.Pipeline()
+	// 将用户的位置信息储存到分片后的位置表格里面。
+	pipe.SetRange(ctx, fmt.Sprintf("location:%d", shard_id), offset, code)
+
+	// 对记录目前已知最大用户 ID 的有序集合进行更新。
+	tkey := core.NewToken()
+	pipe.ZAdd(ctx, tkey, &redis.Z{Score: float64(user_id), Member: "max"})
+	pipe.ZUnionStore(ctx, "location:max", &redis.ZStore{Aggregate: "MAX", Keys: []string{tkey, "location:max"}})
+	pipe.Del(ctx, tkey)
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("shard.set_location", err)
+}
+
+// AggregateLocation 统计所有用户所在的国家和州（代码清单9-16）
+func AggregateLocation(ctx context.Context, conn redis.Cmdable) (map[string]int64, map[string]map[string]int64, error) {
+	ctx = core.WithOp(ctx, "shard.aggregate_location")
+	countries := make(map[string]int64)
+	states := make(map[string]map[string]int64)
+
+	// 获取目前已知的最大用户 ID ，
+	// 并使用它来计算出程序需要访问的最大分片 ID 。
+	max_score, err := conn.ZScore(ctx, "location:max", "max").Result()
+	if err == redis.Nil {
+		return countries, states, nil
+	} else if err != nil {
+		return nil, nil, core.Wrap("shard.aggregate_location", err)
+	}
+	max_id := int64(max_score)
+	max_block := max_id / USERS_PER_SHARD
+
+	// 按顺序地处理每个分片……
+	for shard_id := int64(0); shard_id <= max_block; shard_id++ {
+		key := fmt.Sprintf("location:%d", shard_id)
+		// 读取每个块……
+		const blocksize = 1 << 17
+		for pos := int64(0); ; pos += blocksize {
+			block, err := conn.GetRange(ctx, key, pos, pos+blocksize-1).Result()
+			if err != nil {
+				return nil, nil, core.Wrap("shard.aggregate_location", err)
+			}
+			// 从块里面提取出每个编码，
+			// 并根据编码查找原始的位置信息，
+			// 然后对这些位置信息进行聚合计算。
+			codes := make([]string, 0, len(block)/2)
+			for offset := 0; offset+1 < len(block); offset += 2 {
+				codes = append(codes, block[offset:offset+2])
+			}
+			UpdateAggregates(countries, states, codes)
+			if len(block) < blocksize {
+				break
+			}
+		}
+	}
+	return countries, states, nil
+}
+
+// UpdateAggregates 将位置编码解码为国家和州 并对计数器执行加一操作（代码清单9-17）
+func UpdateAggregates(countries map[string]int64, states map[string]map[string]int64, codes []string) {
+	for _, code := range codes {
+		// 只对合法的编码进行查找。
+		if len(code) != 2 {
+			continue
+		}
+
+		// 计算出国家和州在查找表格中的实际偏移量。
+		cindex := int(code[0]) - 1
+		sindex := int(code[1]) - 1
+
+		// 如果国家所处的偏移量不在合法范围之内，那么跳过这个编码。
+		if cindex < 0 || cindex >= len(COUNTRIES) {
+			continue
+		}
+
+		// 获取 ISO3 国家编码。
+		country := COUNTRIES[cindex]
+		// 在对国家信息进行解码之后，
+		// 把用户计入到这个国家对应的计数器里面。
+		countries[country] += 1
+
+		// 如果程序没有找到指定的州信息，
+		// 或者查找州信息时的偏移量不在合法的范围之内，
+		// 那么跳过这个编码。
+		country_states, ok := STATES[country]
+		if !ok || sindex < 0 || sindex >= len(country_states) {
+			continue
+		}
+
+		// 根据编码获取州名。
+		state := country_states[sindex]
+		// 对州计数器执行加一操作。
+		if states[country] == nil {
+			states[country] = make(map[string]int64)
+		}
+		states[country][state] += 1
+	}
+}
+
+// AggregateLocationList 统计指定用户所在的国家和州（代码清单9-18）
+func AggregateLocationList(ctx context.Context, conn redis.Cmdable, user_ids []int64) (map[string]int64, map[string]map[string]int64, error) {
+	ctx = core.WithOp(ctx, "shard.aggregate_location_list")
+	// 设置流水线，减少操作执行过程中与 Redis 的通信往返次数。
+	pipe := conn.Pipeline()
+	// 和之前一样，设置好基本的聚合数据。
+	countries := make(map[string]int64)
+	states := make(map[string]map[string]int64)
+
+	cmds := make([]*redis.StringCmd, 0, 1000)
+	flush := func() error {
+		if len(cmds) == 0 {
+			return nil
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return core.Wrap("shard.aggregate_location_list", err)
+		}
+		codes := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			codes[i] = cmd.Val()
+		}
+		UpdateAggregates(countries, states, codes)
+		cmds = cmds[:0]
+		return nil
+	}
+	for i, user_id := range user_ids {
+		// 查找用户位置信息所在分片的 ID ，以及信息在分片中的偏移量。
+		shard_id, position := user_id/USERS_PER_SHARD, user_id%USERS_PER_SHARD
+		offset := position * 2
+
+		// 发送另一个被流水线包裹的命令，获取用户的位置信息。
+		cmds = append(cmds, pipe.GetRange(ctx, fmt.Sprintf("location:%d", shard_id), offset, offset+1))
+
+		// 每处理 1000 个请求，
+		// 程序就会调用之前定义的辅助函数对聚合数据进行一次更新。
+		if (i+1)%1000 == 0 {
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	// 对遍历余下的最后一批用户进行处理。
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	// 返回聚合数据。
+	return countries, states, nil
+}
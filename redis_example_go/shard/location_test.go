@@ -0,0 +1,54 @@
+package shard
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh09.test_user_location
+func TestUserLocation(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	r := rand.New(rand.NewSource(1))
+	want_countries := make(map[string]int64)
+	want_states := make(map[string]map[string]int64)
+	user_ids := make([]int64, 0, 1000)
+	for i := int64(0); i < 1000; i++ {
+		country := COUNTRIES[r.Intn(len(COUNTRIES))]
+		state := ""
+		if states, ok := STATES[country]; ok {
+			state = states[r.Intn(len(states))]
+			if want_states[country] == nil {
+				want_states[country] = make(map[string]int64)
+			}
+			want_states[country][state] += 1
+		}
+		want_countries[country] += 1
+		// 跳过一些用户id 未设置位置的用户不会被统计
+		user_id := i * 3
+		if err := SetLocation(ctx, conn, user_id, country, state); err != nil {
+			t.Fatal(err)
+		}
+		user_ids = append(user_ids, user_id)
+	}
+
+	countries, states, err := AggregateLocation(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(countries, want_countries) || !reflect.DeepEqual(states, want_states) {
+		t.Fatalf("AggregateLocation() = %v, %v", countries, states)
+	}
+	countries, states, err = AggregateLocationList(ctx, conn, user_ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(countries, want_countries) || !reflect.DeepEqual(states, want_states) {
+		t.Fatalf("AggregateLocationList() = %v, %v", countries, states)
+	}
+}
@@ -0,0 +1,172 @@
+// Package shard 第九章的降低内存占用：短结构性能测试、分片式散列与集合以及打包存储的用户位置信息
+//
+// 几种降低redis内存占用的方法 1使用短结构（压缩列表、集合、有序集合、散列 集合的整数集合编码） 这些短结构在键长度（64）
+// 比较短以及元素数量比较少（512）时保持良好的存取性能和较少的内存占用
+// 2使用分片结构（分片式散列、分片式集合） 将一些散键（具备共同前缀，即业务类型一致的一组数量庞大的数据） 可以简化共有键名
+// 然后通过原键名转换为分片id进行简化内存存储
+// 3将数据打包到二进制位或者字符串中（对于一些范围比较小，密集型的数据适用），可以减少单独存储这些数据的键所占用的内存
+package shard
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"math"
+	"redis-learn/core"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShardKey 计算分片键（代码清单9-7）
+// 用户需要给定基础散列的名字、将要被储存到分片散列里面的键、预计的元素总数量以及请求的分片大小
+func ShardKey(base string, key string, total_elements int64, shard_size int64) string {
+	var shard_id int64
+	// 如果值是一个看上去像是整数的字符串，那么它将被直接用于计算分片 ID 。
+	// 整数键将被程序假定为连续指派的 ID ，并基于这个整数 ID 的二进制位的高位来选择分片 ID 。
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil && id >= 0 && !strings.HasPrefix(key, "+") {
+		shard_id = id / shard_size
+	} else {
+		// 对于不是整数的键，
+		// 程序将基于预计的元素总数量以及请求的分片数量，
+		// 计算出实际所需的分片总数量。
+		shards := 2 * total_elements / shard_size
+		if shards <= 0 {
+			shards = 1
+		}
+		// 在得知了分片的数量之后，
+		// 程序就可以通过计算键的散列值与分片数量之间的模数来得到分片 ID 。
+		shard_id = int64(crc32.ChecksumIEEE([]byte(key))) % shards
+	}
+	// 最后，程序会把基础键和分片 ID 组合在一起，得出分片键。
+	return fmt.Sprintf("%s:%d", base, shard_id)
+}
+
+// ShardHSet 设置分片式散列的值（代码清单9-8）
+func ShardHSet(ctx context.Context, conn redis.Cmdable, base string, key string, value interface{}, total_elements int64, shard_size int64) (bool, error) {
+	ctx = core.WithOp(ctx, "shard.hset")
+	// 计算出应该由哪个分片来储存值。
+	shard := ShardKey(base, key, total_elements, shard_size)
+	// 将值储存到分片里面。
+	added, err := conn.HSet(ctx, shard, key, value).Result()
+	return added > 0, core.Wrap("shard.hset", err)
+}
+
+// ShardHGet 获取分片式散列的值 不存在时返回 core.ErrNotFound（代码清单9-8）
+func ShardHGet(ctx context.Context, conn redis.Cmdable, base string, key string, total_elements int64, shard_size int64) (string, error) {
+	ctx = core.WithOp(ctx, "shard.hget")
+	// 计算出值可能被储存到了哪个分片里面。
+	shard := ShardKey(base, key, total_elements, shard_size)
+	// 取得储存在分片里面的值。
+	value, err := conn.HGet(ctx, shard, key).Result()
+	return value, core.Wrap("shard.hget", err)
+}
+
+// ShardSAdd 将成员添加到分片式集合 成员原来不存在时返回true（代码清单9-10）
+func ShardSAdd(ctx context.Context, conn redis.Cmdable, base string, member string, total_elements int64, shard_size int64) (bool, error) {
+	ctx = core.WithOp(ctx, "shard.sadd")
+	// 计算成员应该被储存到哪个分片集合里面；
+	// 因为成员并非连续 ID ，所以程序在计算成员所属的分片之前，会先在成员前面加上x。
+	shard := ShardKey(base, "x"+member, total_elements, shard_size)
+	// 将成员储存到分片里面。
+	added, err := conn.SAdd(ctx, shard, member).Result()
+	return added > 0, core.Wrap("shard.sadd", err)
+}
+
+// 统计唯一访客时判断日期以及性能测试计时使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 为整数集合编码的集合预设一个典型的分片大小。
+const SHARD_SIZE = 512
+
+// 这个初始的预计每日访客人数会设置得稍微比较高一些。
+const DAILY_EXPECTED = 1000000
+
+// 在本地储存一份计算得出的预计访客人数副本。
+var expected = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: make(map[string]int64)}
+
+// CountVisit 统计每天的唯一访客数量 session_id为uuid或者128位十六进制的令牌（代码清单9-11）
+func CountVisit(ctx context.Context, conn redis.Cmdable, session_id string) error {
+	ctx = core.WithOp(ctx, "shard.count_visit")
+	// 取得当天的日期，并生成唯一访客计数器的键。
+	today := Clock.Now()
+	key := "unique:" + today.Format("2006-01-02")
+	// 计算或者获取当天的预计唯一访客人数。
+	exp, err := GetExpected(ctx, conn, key, today)
+	if err != nil {
+		return err
+	}
+
+	// 根据 128 位的 UUID ，计算出一个 56 位的 ID 。
+	hex := strings.Replace(session_id, "-", "", -1)
+	if len(hex) > 15 {
+		hex = hex[:15]
+	}
+	id, err := strconv.ParseInt(hex, 16, 64)
+	if err != nil {
+		return errors.Wrapf(err, "shard.count_visit: bad session id %s", session_id)
+	}
+	// 将 ID 添加到分片集合里面。
+	added, err := ShardSAdd(ctx, conn, key, strconv.FormatInt(id, 10), exp, SHARD_SIZE)
+	if err != nil || !added {
+		return err
+	}
+	// 如果 ID 在分片集合里面并不存在，那么对唯一访客计数器执行加一操作。
+	return core.Wrap("shard.count_visit", conn.Incr(ctx, key).Err())
+}
+
+// GetExpected 获取当日的预计访客人数（代码清单9-12）
+func GetExpected(ctx context.Context, conn redis.Cmdable, key string, today time.Time) (int64, error) {
+	ctx = core.WithOp(ctx, "shard.get_expected")
+	expected.Lock()
+	defer expected.Unlock()
+	// 如果程序已经计算出或者获取到了当日的预计访客人数，
+	// 那么直接使用已计算出的数字。
+	if v, ok := expected.m[key]; ok {
+		return v, nil
+	}
+
+	exkey := key + ":expected"
+	// 如果其他客户端已经计算出了当日的预计访客人数，
+	// 那么直接使用已计算出的数字。
+	exp, err := conn.Get(ctx, exkey).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, core.Wrap("shard.get_expected", err)
+	}
+	if err == redis.Nil {
+		// 获取昨天的唯一访客人数，如果该数值不存在就使用默认值一百万。
+		yesterday := today.AddDate(0, 0, -1).Format("2006-01-02")
+		exp, err = conn.Get(ctx, "unique:"+yesterday).Int64()
+		if err != nil && err != redis.Nil {
+			return 0, core.Wrap("shard.get_expected", err)
+		}
+		if exp <= 0 {
+			exp = DAILY_EXPECTED
+		}
+		// 基于“明天的访客人数至少会比今天的访客人数多 50%”这一假设，
+		// 给昨天的访客人数加上 50% ，然后向上舍入至下一个底数为 2 的幂。
+		exp = int64(math.Pow(2, math.Ceil(math.Log2(float64(exp)*1.5))))
+		// 将计算出的预计访客人数写入到 Redis 里面，以便其他程序在有需要时使用。
+		ok, err := conn.SetNX(ctx, exkey, exp, 0).Result()
+		if err != nil {
+			return 0, core.Wrap("shard.get_expected", err)
+		}
+		if !ok {
+			// 如果在我们之前，
+			// 已经有其他客户端储存了当日的预计访客人数，
+			// 那么直接使用已储存的数字。
+			if exp, err = conn.Get(ctx, exkey).Int64(); err != nil {
+				return 0, core.Wrap("shard.get_expected", err)
+			}
+		}
+	}
+	// 将当日的预计访客人数记录到本地副本里面，并将它返回给调用者。
+	expected.m[key] = exp
+	return exp, nil
+}
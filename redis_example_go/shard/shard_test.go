@@ -0,0 +1,117 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh09.test_shard_key
+func TestShardKey(t *testing.T) {
+	base := "test"
+	for _, c := range []struct {
+		key         string
+		total, size int64
+		want        string
+	}{
+		{"1", 2, 2, "test:0"},
+		{"125", 1000, 100, "test:1"},
+	} {
+		if got := ShardKey(base, c.key, c.total, c.size); got != c.want {
+			t.Errorf("ShardKey(%q, %d, %d) = %q, want %q", c.key, c.total, c.size, got, c.want)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"hello:" + strconv.Itoa(i), "world:" + strconv.Itoa(i)} {
+			shard := ShardKey(base, key, 1000, 100)
+			id, err := strconv.Atoi(strings.TrimPrefix(shard, base+":"))
+			if err != nil || id < 0 || id >= 20 {
+				t.Errorf("ShardKey(%q) = %q, want a shard in [0, 20)", key, shard)
+			}
+		}
+	}
+}
+
+// 对应TestCh09.test_sharded_hash
+func TestShardedHash(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for i := 0; i < 50; i++ {
+		for base, key := range map[string]string{"test": "keyname:" + strconv.Itoa(i), "test2": strconv.Itoa(i)} {
+			if _, err := ShardHSet(ctx, conn, base, key, i, 1000, 100); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := ShardHGet(ctx, conn, base, key, 1000, 100); v != strconv.Itoa(i) || err != nil {
+				t.Fatalf("ShardHGet(%s, %s) = %q, %v, want %d", base, key, v, err, i)
+			}
+		}
+	}
+	if _, err := ShardHGet(ctx, conn, "test", "missing", 1000, 100); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("ShardHGet() on missing key = %v, want ErrNotFound", err)
+	}
+}
+
+// 对应TestCh09.test_sharded_sadd
+func TestShardedSAdd(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for i := 0; i < 50; i++ {
+		if added, err := ShardSAdd(ctx, conn, "testx", strconv.Itoa(i), 50, 50); !added || err != nil {
+			t.Fatalf("ShardSAdd(%d) = %v, %v", i, added, err)
+		}
+	}
+	if added, _ := ShardSAdd(ctx, conn, "testx", "0", 50, 50); added {
+		t.Fatal("ShardSAdd() added an existing member")
+	}
+	if n := conn.SCard(ctx, "testx:0").Val() + conn.SCard(ctx, "testx:1").Val(); n != 50 {
+		t.Fatalf("members = %d, want 50", n)
+	}
+}
+
+// 对应TestCh09.test_unique_visitors
+func TestUniqueVisitors(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	today := time.Now()
+	key := "unique:" + today.Format("2006-01-02")
+	reset := func() {
+		expected.Lock()
+		expected.m = make(map[string]int64)
+		expected.Unlock()
+	}
+	reset()
+	defer reset()
+
+	for i := 0; i < 179; i++ {
+		if err := CountVisit(ctx, conn, core.NewToken()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := conn.Get(ctx, key).Int(); n != 179 {
+		t.Fatalf("unique visitors = %d, want 179", n)
+	}
+
+	// 昨天有1000个访客时 预计访客人数为1500向上取整到2048
+	conn.FlushDB(ctx)
+	reset()
+	conn.Set(ctx, "unique:"+today.AddDate(0, 0, -1).Format("2006-01-02"), 1000, 0)
+	for i := 0; i < 183; i++ {
+		if err := CountVisit(ctx, conn, core.NewToken()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := conn.Get(ctx, key).Int(); n != 183 {
+		t.Fatalf("unique visitors = %d, want 183", n)
+	}
+	if exp, _ := conn.Get(ctx, key+":expected").Int(); exp != 2048 {
+		t.Fatalf("expected visitors = %d, want 2048", exp)
+	}
+}
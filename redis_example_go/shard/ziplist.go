@@ -0,0 +1,118 @@
+package shard
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"redis-learn/core"
+	"time"
+)
+
+// 压缩列表相关的配置选项（redis.conf）：
+//
+//	list-max-ziplist-entries 512    // 列表结构使用压缩列表表示的限制条件。
+//	list-max-ziplist-value 64
+//	hash-max-ziplist-entries 512    // 散列结构使用压缩列表表示的限制条件
+//	hash-max-ziplist-value 64
+//	zset-max-ziplist-entries 128    // 有序集合使用压缩列表表示的限制条件。
+//	zset-max-ziplist-value 64
+//	set-max-intset-entries 512      // 集合使用整数集合表示的限制条件。
+//
+// 可以用 OBJECT ENCODING key 查看结构当前使用的编码 超出限制之后结构会被转换为普通编码
+// 并且即使之后重新满足限制条件 结构也不会转换回压缩列表
+
+func rangeValues(start int64, length int64) []interface{} {
+	values := make([]interface{}, length)
+	for i := range values {
+		values[i] = start + int64(i)
+	}
+	return values
+}
+
+// 计算每秒钟执行的命令数量
+func opsPerSecond(passes int, psize int, start time.Time) float64 {
+	elapsed := core.Since(Clock, start).Seconds()
+	if elapsed == 0 {
+		elapsed = .001
+	}
+	return float64(passes*psize) / elapsed
+}
+
+// LongZiplistPerformance 测试列表长度对RPOPLPUSH性能的影响 返回每秒钟执行的命令数量（代码清单9-6）
+func LongZiplistPerformance(ctx context.Context, conn redis.Cmdable, key string, length int64, passes int, psize int) (float64, error) {
+	ctx = core.WithOp(ctx, "shard.long_ziplist")
+	// 删除指定的键，确保被测试数据的准确性。
+	if err := conn.Del(ctx, key).Err(); err != nil {
+		return 0, core.Wrap("shard.long_ziplist", err)
+	}
+	// 通过从右端推入指定数量的元素来对列表进行初始化。
+	if err := conn.RPush(ctx, key, rangeValues(0, length)...).Err(); err != nil {
+		return 0, core.Wrap("shard.long_ziplist", err)
+	}
+	// 通过流水线来降低网络通信给测试带来的影响。
+	pipeline := conn.Pipeline()
+
+	// 启动计时器。
+	t := Clock.Now()
+	// 根据 passes 参数来决定流水线操作的执行次数。
+	for p := 0; p < passes; p++ {
+		// 每个流水线操作都包含了 psize 次 RPOPLPUSH 命令调用。
+		for pi := 0; pi < psize; pi++ {
+			// 每个 rpoplpush() 函数调用都会将列表最右端的元素弹出，
+			// 并将它推入到同一个列表的左端。
+			pipeline.RPopLPush(ctx, key, key)
+		}
+		// 执行 psize 次 RPOPLPUSH 命令。
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return 0, core.Wrap("shard.long_ziplist", err)
+		}
+	}
+	return opsPerSecond(passes, psize, t), nil
+}
+
+// LongZiplistIndex 测试列表长度对LINDEX性能的影响
+func LongZiplistIndex(ctx context.Context, conn redis.Cmdable, key string, length int64, passes int, psize int) (float64, error) {
+	ctx = core.WithOp(ctx, "shard.long_ziplist_index")
+	if err := conn.Del(ctx, key).Err(); err != nil {
+		return 0, core.Wrap("shard.long_ziplist_index", err)
+	}
+	if err := conn.RPush(ctx, key, rangeValues(0, length)...).Err(); err != nil {
+		return 0, core.Wrap("shard.long_ziplist_index", err)
+	}
+	length >>= 1
+	pipeline := conn.Pipeline()
+	t := Clock.Now()
+	for p := 0; p < passes; p++ {
+		for pi := 0; pi < psize; pi++ {
+			pipeline.LIndex(ctx, key, length)
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return 0, core.Wrap("shard.long_ziplist_index", err)
+		}
+	}
+	return opsPerSecond(passes, psize, t), nil
+}
+
+// LongIntsetPerformance 测试整数集合大小对SPOP、SADD性能的影响
+func LongIntsetPerformance(ctx context.Context, conn redis.Cmdable, key string, length int64, passes int, psize int) (float64, error) {
+	ctx = core.WithOp(ctx, "shard.long_intset")
+	if err := conn.Del(ctx, key).Err(); err != nil {
+		return 0, core.Wrap("shard.long_intset", err)
+	}
+	if err := conn.SAdd(ctx, key, rangeValues(1000000, length)...).Err(); err != nil {
+		return 0, core.Wrap("shard.long_intset", err)
+	}
+	cur := int64(1000000 - 1)
+	pipeline := conn.Pipeline()
+	t := Clock.Now()
+	for p := 0; p < passes; p++ {
+		for pi := 0; pi < psize; pi++ {
+			pipeline.SPop(ctx, key)
+			pipeline.SAdd(ctx, key, cur)
+			cur -= 1
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return 0, core.Wrap("shard.long_intset", err)
+		}
+	}
+	return opsPerSecond(passes, psize, t), nil
+}
@@ -0,0 +1,36 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh09.test_long_ziplist_performance
+func TestLongZiplistPerformance(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if _, err := LongZiplistPerformance(ctx, conn, "test", 5, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.LLen(ctx, "test").Val(); n != 5 {
+		t.Fatalf("list length = %d, want 5", n)
+	}
+	if _, err := LongIntsetPerformance(ctx, conn, "testset", 5, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.SCard(ctx, "testset").Val(); n != 5 {
+		t.Fatalf("set size = %d, want 5", n)
+	}
+
+	// 计时使用 Clock 时钟不前进时按1毫秒计算
+	Clock = core.NewFakeClock(time.Unix(1600000000, 0))
+	defer func() { Clock = core.RealClock{} }()
+	if ops, err := LongZiplistIndex(ctx, conn, "test", 5, 10, 10); ops != 100/.001 || err != nil {
+		t.Fatalf("LongZiplistIndex() = %v, %v", ops, err)
+	}
+}
@@ -0,0 +1,438 @@
+// Package stats 第五章的计数器、统计数据、IP所属地查找以及配置信息
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"math"
+	"redis-learn/core"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 以秒为单位的计数器精度，分别为1秒钟、5秒钟、1分钟、5分钟、1小时、5小时、1天——用户可以按需调整这些精度。
+// 每个精度都对应一个散列count:<精度>:<名字> 键为时间片 值为计数器数值
+var PRECISION = []int64{1, 5, 60, 300, 3600, 18000, 86400}
+
+// 计数器时间片、统计数据轮换、访问时长以及维护状态和配置的检查间隔使用的时钟
+var Clock core.Clock = core.RealClock{}
+
+// 每个计数器保留的样本数量
+var SAMPLE_COUNT int64 = 100
+
+type Sample struct {
+	Time  int64
+	Count int64
+}
+
+// UpdateCounter 按照不同的时间精度更新计数器 now为零值时使用当前时间（代码清单5-3）
+func UpdateCounter(ctx context.Context, conn redis.Cmdable, name string, count int64, now time.Time) error {
+	ctx = core.WithOp(ctx, "stats.update_counter")
+	if now.IsZero() {
+		// 通过取得当前时间来判断应该对哪个时间片执行自增操作。
+		now = Clock.Now()
+	}
+	// 为了保证之后的清理工作可以正确地执行，这里需要创建一个事务型流水线。
+	pipe := conn.TxPipeline()
+	// 为我们记录的每种精度都创建一个计数器。
+	for _, prec := range PRECISION {
+		// 取得当前时间片的开始时间。
+		pnow := (now.Unix() / prec) * prec
+		// 创建负责存储计数信息的散列。
+		hash := fmt.Sprintf("%v:%v", prec, name)
+		// 将计数器的引用信息添加到有序集合里面，
+		// 并将其分值设置为0，以便在之后执行清理操作。
+		pipe.ZAdd(ctx, "known:", &redis.Z{Score: 0, Member: hash})
+		// 对给定名字和精度的计数器进行更新。
+		pipe.HIncrBy(ctx, "count:"+hash, strconv.FormatInt(pnow, 10), count)
+	}
+	_, err := pipe.Exec(ctx)
+	return core.Wrap("stats.update_counter", err)
+}
+
+// GetCounter 获取指定精度的计数器数据 旧的样本排在前面（代码清单5-4）
+func GetCounter(ctx context.Context, conn redis.Cmdable, name string, precision int64) ([]Sample, error) {
+	ctx = core.WithOp(ctx, "stats.get_counter")
+	// 取得存储着计数器数据的键的名字。
+	hash := fmt.Sprintf("%v:%v", precision, name)
+	// 从Redis里面取出计数器数据。
+	data, err := conn.HGetAll(ctx, "count:"+hash).Result()
+	if err != nil {
+		return nil, core.Wrap("stats.get_counter", err)
+	}
+	// 将计数器数据转换成指定的格式。
+	to_return := make([]Sample, 0, len(data))
+	for key, value := range data {
+		t, _ := strconv.ParseInt(key, 10, 64)
+		c, _ := strconv.ParseInt(value, 10, 64)
+		to_return = append(to_return, Sample{Time: t, Count: c})
+	}
+	// 对数据进行排序，把旧的数据样本排在前面。
+	sort.Slice(to_return, func(i, j int) bool { return to_return[i].Time < to_return[j].Time })
+	return to_return, nil
+}
+
+// CleanCounters 守护任务 每分钟清理一次计数器中超出SAMPLE_COUNT的旧样本 直到ctx结束或出错（代码清单5-5）
+func CleanCounters(ctx context.Context, conn redis.UniversalClient) error {
+	ctx = core.WithOp(ctx, "stats.clean_counters")
+	// 为了平等地处理更新频率各不相同的多个计数器，程序需要记录清理操作执行的次数。
+	passes := int64(0)
+	// 持续地对计数器进行清理，直到退出为止。
+	for ctx.Err() == nil {
+		// 记录清理操作开始执行的时间，用于计算清理操作执行的时长。
+		start := Clock.Now()
+		// 渐进地遍历所有已知的计数器。
+		var index int64 = 0
+		for ctx.Err() == nil {
+			known, err := conn.ZCard(ctx, "known:").Result()
+			if err != nil {
+				return core.StopErr(ctx, "stats.clean_counters", err)
+			} else if index >= known {
+				break
+			}
+			// 取得被检查计数器的数据。
+			hashes, err := conn.ZRange(ctx, "known:", index, index).Result()
+			if err != nil {
+				return core.StopErr(ctx, "stats.clean_counters", err)
+			}
+			index += 1
+			if len(hashes) == 0 {
+				break
+			}
+			hash := hashes[0]
+			// 取得计数器的精度。
+			prec, _ := strconv.ParseInt(strings.SplitN(hash, ":", 2)[0], 10, 64)
+			// 因为清理程序每60秒钟就会循环一次，
+			// 所以这里需要根据计数器的更新频率来判断是否真的有必要对计数器进行清理。
+			bprec := prec / 60
+			if bprec == 0 {
+				bprec = 1
+			}
+			// 如果这个计数器在这次循环里不需要进行清理，
+			// 那么检查下一个计数器。
+			// （举个例子，如果清理程序只循环了三次，而计数器的更新频率为每5分钟一次，
+			// 那么程序暂时还不需要对这个计数器进行清理。）
+			if passes%bprec != 0 {
+				continue
+			}
+
+			hkey := "count:" + hash
+			// 根据给定的精度以及需要保留的样本数量，
+			// 计算出我们需要保留什么时间之前的样本。
+			cutoff := Clock.Now().Unix() - SAMPLE_COUNT*prec
+			// 获取样本的开始时间，并将其从字符串转换为整数。
+			keys, err := conn.HKeys(ctx, hkey).Result()
+			if err != nil {
+				return core.StopErr(ctx, "stats.clean_counters", err)
+			}
+			samples := make([]int64, 0, len(keys))
+			for _, k := range keys {
+				v, _ := strconv.ParseInt(k, 10, 64)
+				samples = append(samples, v)
+			}
+			// 计算出需要移除的样本数量。
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			remove := sort.Search(len(samples), func(i int) bool { return samples[i] > cutoff })
+
+			// 按需移除计数样本。
+			if remove > 0 {
+				fields := make([]string, remove)
+				for i := 0; i < remove; i++ {
+					fields[i] = strconv.FormatInt(samples[i], 10)
+				}
+				if err := conn.HDel(ctx, hkey, fields...).Err(); err != nil {
+					return core.StopErr(ctx, "stats.clean_counters", err)
+				}
+				// 这个散列可能已经被清空。
+				if remove == len(samples) {
+					// 在尝试修改计数器散列之前，对其进行监视。
+					txf := func(tx *redis.Tx) error {
+						// 验证计数器散列是否为空，如果是的话，
+						// 那么从记录已知计数器的有序集合里面移除它。
+						size, err := tx.HLen(ctx, hkey).Result()
+						if err != nil {
+							return err
+						} else if size != 0 {
+							// 计数器散列并不为空，
+							// 继续让它留在记录已有计数器的有序集合里面。
+							return nil
+						}
+						_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+							pipe.ZRem(ctx, "known:", hash)
+							return nil
+						})
+						if err == nil {
+							// 在删除了一个计数器的情况下，
+							// 下次循环可以使用与本次循环相同的索引。
+							index -= 1
+						}
+						return err
+					}
+					// 有其他程序向这个计算器散列添加了新的数据，
+					// 它已经不再是空的了，继续让它留在记录已知计数器的有序集合里面。
+					err := conn.Watch(ctx, txf, hkey)
+					if err != nil && !errors.Is(err, redis.TxFailedErr) {
+						return core.StopErr(ctx, "stats.clean_counters", err)
+					}
+				}
+			}
+		}
+		// 为了让清理操作的执行频率与计数器更新的频率保持一致，
+		// 对记录循环次数的变量以及记录执行时长的变量进行更新。
+		passes += 1
+		duration := math.Min(core.Since(Clock, start).Seconds()+1, 60)
+		// 如果这次循环未耗尽60秒钟，那么在余下的时间内进行休眠；
+		// 如果60秒钟已经耗尽，那么休眠一秒钟以便稍作休息。
+		core.Sleep(Clock, ctx.Done(), time.Duration(math.Max(60-duration, 1))*time.Second)
+	}
+	return nil
+}
+
+// UpdateStats 更新统计数据 返回更新后的count、sum、sumsq（代码清单5-6）
+// 像LogCommon一样 统计数据只保留当前这一个小时和上一个小时的 timeout内没有完成时返回 core.ErrConflict
+func UpdateStats(ctx context.Context, conn redis.UniversalClient, context string, type_ string, value float64, timeout time.Duration) ([]float64, error) {
+	ctx = core.WithOp(ctx, "stats.update")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	// 设置用于存储统计数据的键。
+	destination := fmt.Sprintf("stats:%v:%v", context, type_)
+	start_key := destination + ":start"
+	end := Clock.Now().Add(timeout)
+	for Clock.Now().Before(end) {
+		var cmds [3]*redis.FloatCmd
+		txf := func(tx *redis.Tx) error {
+			hour_start := Clock.Now().UTC().Truncate(time.Hour).Format("2006-01-02T15:04:05")
+			existing, err := tx.Get(ctx, start_key).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if existing != "" && existing < hour_start {
+					pipe.Rename(ctx, destination, destination+":last")
+					pipe.Rename(ctx, start_key, destination+":pstart")
+					pipe.Set(ctx, start_key, hour_start, 0)
+				} else if existing == "" {
+					pipe.Set(ctx, start_key, hour_start, 0)
+				}
+				tkey1 := core.NewToken()
+				tkey2 := core.NewToken()
+				// 将值添加到临时键里面。
+				pipe.ZAdd(ctx, tkey1, &redis.Z{Score: value, Member: "min"})
+				pipe.ZAdd(ctx, tkey2, &redis.Z{Score: value, Member: "max"})
+				// 使用合适聚合函数MIN和MAX，
+				// 对存储统计数据的键和两个临时键进行并集计算。
+				pipe.ZUnionStore(ctx, destination, &redis.ZStore{Aggregate: "MIN", Keys: []string{destination, tkey1}})
+				pipe.ZUnionStore(ctx, destination, &redis.ZStore{Aggregate: "MAX", Keys: []string{destination, tkey2}})
+
+				// 删除临时键。
+				pipe.Del(ctx, tkey1, tkey2)
+				// 对有序集合中的样本数量、值的和、值的平方之和三个成员进行更新。
+				cmds[0] = pipe.ZIncrBy(ctx, destination, 1, "count")
+				cmds[1] = pipe.ZIncrBy(ctx, destination, value, "sum")
+				cmds[2] = pipe.ZIncrBy(ctx, destination, value*value, "sumsq")
+				return nil
+			})
+			return err
+		}
+		err := conn.Watch(ctx, txf, start_key)
+		// 如果新的一个小时已经开始，并且旧的数据已经被归档，那么进行重试。
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		} else if err != nil {
+			return nil, core.Wrap("stats.update", err)
+		}
+		// 返回基本的计数信息，以便函数调用者在有需要时做进一步的处理。
+		return []float64{cmds[0].Val(), cmds[1].Val(), cmds[2].Val()}, nil
+	}
+	return nil, core.Conflict("stats.update", "stats rotation kept changing")
+}
+
+// GetStats 获取统计数据 外加平均值average和标准差stddev（代码清单5-7）
+func GetStats(ctx context.Context, conn redis.Cmdable, context string, type_ string) (map[string]float64, error) {
+	ctx = core.WithOp(ctx, "stats.get")
+	// 程序将从这个键里面取出统计数据。
+	key := fmt.Sprintf("stats:%v:%v", context, type_)
+	// 获取基本的统计数据，并将它们都放到一个字典里面。
+	zs, err := conn.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, core.Wrap("stats.get", err)
+	}
+	data := make(map[string]float64)
+	for _, z := range zs {
+		data[z.Member.(string)] = z.Score
+	}
+	if data["count"] == 0 {
+		return data, nil
+	}
+	// 计算平均值。
+	data["average"] = data["sum"] / data["count"]
+	// 计算标准差的第一个步骤。
+	numerator := data["sumsq"] - data["sum"]*data["sum"]/data["count"]
+	// 完成标准差的计算工作。
+	denominator := data["count"] - 1
+	if denominator == 0 {
+		denominator = 1
+	}
+	data["stddev"] = math.Sqrt(numerator / denominator)
+	return data, nil
+}
+
+// AccessTime 记录fn的执行时长 并维护最慢的100个访问（代码清单5-8）
+// 记录失败时fn已经执行过了 返回的错误只与记录有关
+func AccessTime(ctx context.Context, conn redis.UniversalClient, context string, fn func()) error {
+	ctx = core.WithOp(ctx, "stats.access_time")
+	// 记录代码块执行前的时间。
+	start := Clock.Now()
+	// 运行被包裹的代码块。
+	fn()
+	// 计算代码块的执行时长。
+	delta := core.Since(Clock, start).Seconds()
+	// 更新这一上下文的统计数据。
+	stats, err := UpdateStats(ctx, conn, context, "AccessTime", delta, 0)
+	if err != nil || stats[0] == 0 {
+		return err
+	}
+	// 计算页面的平均访问时长。
+	average := stats[1] / stats[0]
+
+	pipe := conn.TxPipeline()
+	// 将页面的平均访问时长添加到记录最慢访问时间的有序集合里面。
+	pipe.ZAdd(ctx, "slowest:AccessTime", &redis.Z{Score: average, Member: context})
+	// AccessTime有序集合只会保留最慢的100条记录。
+	pipe.ZRemRangeByRank(ctx, "slowest:AccessTime", 0, -101)
+	_, err = pipe.Exec(ctx)
+	return core.Wrap("stats.access_time", err)
+}
+
+// IPToScore 将ip转换为分数值（代码清单5-9）
+func IPToScore(ip_address string) int64 {
+	var score int64 = 0
+	for _, v := range strings.Split(ip_address, ".") {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		score = score*256 + n
+	}
+	return score
+}
+
+// AddIPRange 记录以start_ip开头的ip段属于city_id（代码清单5-10）
+// 同一个城市可能有多个ip段 所以在城市id后面加上序号count保证成员唯一
+func AddIPRange(ctx context.Context, conn redis.Cmdable, start_ip string, city_id string, count int) error {
+	ctx = core.WithOp(ctx, "stats.add_ip_range")
+	err := conn.ZAdd(ctx, "ip2cityid:", &redis.Z{Score: float64(IPToScore(start_ip)), Member: city_id + "_" + strconv.Itoa(count)}).Err()
+	return core.Wrap("stats.add_ip_range", err)
+}
+
+// AddCity 记录城市信息（代码清单5-11）
+func AddCity(ctx context.Context, conn redis.Cmdable, city_id string, city string, region string, country string) error {
+	ctx = core.WithOp(ctx, "stats.add_city")
+	bytes, _ := json.Marshal([]string{city, region, country})
+	return core.Wrap("stats.add_city", conn.HSet(ctx, "cityid2city:", city_id, string(bytes)).Err())
+}
+
+// FindCityByIP 通过ip查找城市 返回[城市, 地区, 国家] 找不到时返回 core.ErrNotFound（代码清单5-12）
+func FindCityByIP(ctx context.Context, conn redis.Cmdable, ip_address string) ([]string, error) {
+	ctx = core.WithOp(ctx, "stats.find_city")
+	// 查找唯一城市ID。
+	city_ids, err := conn.ZRevRangeByScore(ctx, "ip2cityid:", &redis.ZRangeBy{
+		Max: strconv.FormatInt(IPToScore(ip_address), 10), Min: "0", Offset: 0, Count: 1,
+	}).Result()
+	if err != nil {
+		return nil, core.Wrap("stats.find_city", err)
+	} else if len(city_ids) == 0 {
+		return nil, core.NotFound("stats.find_city", "city of "+ip_address)
+	}
+	// 将唯一城市ID转换为普通城市ID。
+	city_id := strings.SplitN(city_ids[0], "_", 2)[0]
+	// 从散列里面取出城市信息。
+	data, err := conn.HGet(ctx, "cityid2city:", city_id).Result()
+	if err != nil {
+		return nil, core.Wrap("stats.find_city", err)
+	}
+	var info []string
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return nil, errors.Wrapf(err, "stats.find_city: bad city %s", city_id)
+	}
+	return info, nil
+}
+
+var maintenance struct {
+	sync.Mutex
+	lastChecked      time.Time
+	underMaintenance bool
+}
+
+// IsUnderMaintenance 判断是否在维护状态 每秒最多检查一次redis（代码清单5-13）
+// 检查出错时返回上一次的结果和错误
+func IsUnderMaintenance(ctx context.Context, conn redis.Cmdable) (bool, error) {
+	ctx = core.WithOp(ctx, "stats.is_under_maintenance")
+	maintenance.Lock()
+	defer maintenance.Unlock()
+	// 距离上次检查是否已经超过1秒钟？
+	if core.Since(Clock, maintenance.lastChecked) > time.Second {
+		// 更新最后检查时间。
+		maintenance.lastChecked = Clock.Now()
+		// 检查系统是否正在进行维护。
+		flag, err := conn.Get(ctx, "is-under-maintenance").Result()
+		if err != nil && err != redis.Nil {
+			return maintenance.underMaintenance, core.Wrap("stats.is_under_maintenance", err)
+		}
+		maintenance.underMaintenance = flag != ""
+	}
+	// 返回一个布尔值，用于表示系统是否正在进行维护。
+	return maintenance.underMaintenance, nil
+}
+
+// SetConfig 设置组件的配置 配置以JSON的形式保存在config:<type>:<component>（代码清单5-14）
+func SetConfig(ctx context.Context, conn redis.Cmdable, type_ string, component string, config interface{}) error {
+	ctx = core.WithOp(ctx, "stats.set_config")
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return core.Wrap("stats.set_config", conn.Set(ctx, fmt.Sprintf("config:%v:%v", type_, component), string(bytes), 0).Err())
+}
+
+var configs struct {
+	sync.Mutex
+	checked map[string]time.Time
+	values  map[string]map[string]interface{}
+}
+
+// GetConfig 获取组件的配置 距离上次检查超过wait时才会重新从redis读取（代码清单5-15）
+// 配置不存在时返回空的配置 读取出错时返回上一次的配置和错误
+func GetConfig(ctx context.Context, conn redis.Cmdable, type_ string, component string, wait time.Duration) (map[string]interface{}, error) {
+	ctx = core.WithOp(ctx, "stats.get_config")
+	if wait <= 0 {
+		wait = time.Second
+	}
+	key := fmt.Sprintf("config:%v:%v", type_, component)
+	configs.Lock()
+	defer configs.Unlock()
+	if configs.checked == nil {
+		configs.checked = make(map[string]time.Time)
+		configs.values = make(map[string]map[string]interface{})
+	}
+	// 检查是否需要对这个组件的配置信息进行更新。
+	if core.Since(Clock, configs.checked[key]) > wait {
+		// 取得Redis存储的组件配置。
+		data, err := conn.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return configs.values[key], core.Wrap("stats.get_config", err)
+		}
+		// 有需要对配置进行更新，记录最后一次检查这个连接的时间。
+		configs.checked[key] = Clock.Now()
+		config := make(map[string]interface{})
+		if data != "" {
+			_ = json.Unmarshal([]byte(data), &config)
+		}
+		configs.values[key] = config
+	}
+	return configs.values[key], nil
+}
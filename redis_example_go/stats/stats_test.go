@@ -0,0 +1,210 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"redis-learn/core"
+	"redis-learn/core/testutil"
+)
+
+// 对应TestCh05.test_counters
+func TestCounters(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := UpdateCounter(ctx, conn, "test", int64(i%3+1), now.Add(-time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counter, err := GetCounter(ctx, conn, "test", 1)
+	if err != nil || len(counter) < 10 {
+		t.Fatalf("GetCounter(1) = %v, %v", counter, err)
+	}
+	for i := 1; i < len(counter); i++ {
+		if counter[i-1].Time >= counter[i].Time {
+			t.Fatal("samples are not sorted by time")
+		}
+	}
+	counter, _ = GetCounter(ctx, conn, "test", 5)
+	if len(counter) < 2 {
+		t.Fatalf("GetCounter(5) = %v, want at least 2 samples", counter)
+	}
+
+	// 不保留任何样本 清理一次之后计数器应该被清空
+	old := SAMPLE_COUNT
+	SAMPLE_COUNT = 0
+	defer func() { SAMPLE_COUNT = old }()
+	cctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := CleanCounters(cctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	counter, _ = GetCounter(ctx, conn, "test", 86400)
+	if len(counter) != 0 {
+		t.Fatalf("GetCounter(86400) after cleaning = %v", counter)
+	}
+	if n := conn.ZCard(ctx, "known:").Val(); n != 0 {
+		t.Fatalf("known counters after cleaning = %d", n)
+	}
+}
+
+// 计数器只保留SAMPLE_COUNT个时间片 快进之后旧的样本被清理掉
+func TestCleanCountersWithClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	if err := UpdateCounter(ctx, conn, "test", 1, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- CleanCounters(ctx, conn) }()
+
+	// 第一轮清理之后所有样本都还在
+	clock.BlockUntil(1)
+	if counter, _ := GetCounter(ctx, conn, "test", 1); len(counter) != 1 {
+		t.Fatalf("GetCounter(1) = %v, want 1 sample", counter)
+	}
+	// 一周之后的第二轮清理会删掉1秒到1分钟精度的样本
+	clock.Advance(7 * 24 * time.Hour)
+	clock.BlockUntil(1)
+	for _, prec := range []int64{1, 5, 60} {
+		if counter, _ := GetCounter(ctx, conn, "test", prec); len(counter) != 0 {
+			t.Errorf("GetCounter(%d) = %v, want no samples", prec, counter)
+		}
+	}
+	if counter, _ := GetCounter(ctx, conn, "test", 86400); len(counter) != 1 {
+		t.Errorf("GetCounter(86400) = %v, want 1 sample", counter)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 对应TestCh05.test_stats
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for _, v := range []float64{5, 6, 7, 8, 9} {
+		if _, err := UpdateStats(ctx, conn, "temp", "example", v, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := GetStats(ctx, conn, "temp", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r["count"] != 5 || r["min"] != 5 || r["max"] != 9 || r["average"] != 7 {
+		t.Fatalf("GetStats() = %v", r)
+	}
+}
+
+func TestAccessTime(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	for i := 0; i < 3; i++ {
+		called := false
+		if err := AccessTime(ctx, conn, "req-"+strconv.Itoa(i), func() { called = true }); err != nil {
+			t.Fatal(err)
+		}
+		if !called {
+			t.Fatal("AccessTime() did not call fn")
+		}
+	}
+	if n := conn.ZCard(ctx, "slowest:AccessTime").Val(); n != 3 {
+		t.Fatalf("slowest contexts = %d, want 3", n)
+	}
+}
+
+// 对应TestCh05.test_ip_lookup 用几条手工数据代替GeoLiteCity的csv文件
+func TestIPLookup(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if _, err := FindCityByIP(ctx, conn, "1.2.3.4"); !errors.Is(err, core.ErrNotFound) {
+		t.Fatalf("FindCityByIP() on empty db = %v, want ErrNotFound", err)
+	}
+	ranges := []struct{ ip, city string }{
+		{"1.0.0.0", "1"}, {"1.2.0.0", "2"}, {"1.3.0.0", "1"},
+	}
+	for i, r := range ranges {
+		if err := AddIPRange(ctx, conn, r.ip, r.city, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	AddCity(ctx, conn, "1", "Beijing", "22", "CN")
+	AddCity(ctx, conn, "2", "Shanghai", "23", "CN")
+
+	for ip, want := range map[string][]string{
+		"1.1.255.255": {"Beijing", "22", "CN"},
+		"1.2.3.4":     {"Shanghai", "23", "CN"},
+		"1.200.0.1":   {"Beijing", "22", "CN"},
+	} {
+		got, err := FindCityByIP(ctx, conn, ip)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("FindCityByIP(%s) = %v, %v, want %v", ip, got, err, want)
+		}
+	}
+}
+
+// 对应TestCh05.test_is_under_maintenance
+func TestIsUnderMaintenance(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+	clock := core.NewFakeClock(time.Time{})
+	Clock = clock
+	defer func() { Clock = core.RealClock{} }()
+
+	check := func(want bool) {
+		t.Helper()
+		// 结果最多缓存1秒钟
+		clock.Advance(1100 * time.Millisecond)
+		if got, err := IsUnderMaintenance(ctx, conn); got != want || err != nil {
+			t.Fatalf("IsUnderMaintenance() = %v, %v, want %v", got, err, want)
+		}
+	}
+	check(false)
+	conn.Set(ctx, "is-under-maintenance", "yes", 0)
+	check(true)
+	// 一秒之内不会重新检查
+	conn.Del(ctx, "is-under-maintenance")
+	if got, _ := IsUnderMaintenance(ctx, conn); !got {
+		t.Fatal("IsUnderMaintenance() checked redis again within a second")
+	}
+	check(false)
+}
+
+// 对应TestCh05.test_config
+func TestConfig(t *testing.T) {
+	ctx := context.Background()
+	conn := testutil.NewServer(t).Client
+
+	if err := SetConfig(ctx, conn, "redis", "test", map[string]interface{}{"db": 15}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := GetConfig(ctx, conn, "redis", "test", 0)
+	if err != nil || config["db"] != float64(15) {
+		t.Fatalf("GetConfig() = %v, %v", config, err)
+	}
+	// 在wait内不会重新读取
+	SetConfig(ctx, conn, "redis", "test", map[string]interface{}{"db": 14})
+	if config, _ := GetConfig(ctx, conn, "redis", "test", time.Minute); config["db"] != float64(15) {
+		t.Fatalf("GetConfig() within wait = %v, want the cached config", config)
+	}
+	if config, _ := GetConfig(ctx, conn, "redis", "missing", 0); len(config) != 0 {
+		t.Fatalf("GetConfig() on missing component = %v", config)
+	}
+}
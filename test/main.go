@@ -2,23 +2,32 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	"os"
 	"redis-learn/core"
 )
 
-var redisCli *redis.Client
+var redisCli redis.UniversalClient
 
 //初始化连接
 func init() {
 	ctx := context.Background()
-	redisCli = core.InitRedis(ctx, "127.0.0.1:6379", "", 0)
+	cfg, err := core.LoadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	redisCli, err = core.Connect(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
 }
 
 var key = "test_key"
 
 //测试事务的结果的获取
-func ExampleClient_Watch(conn *redis.Client) error {
+func ExampleClient_Watch(conn redis.UniversalClient) error {
 	ctx := context.Background()
 	conn.Set(ctx, key, 100, 0)
 	var cmd *redis.StringCmd
@@ -54,5 +63,7 @@ func ExampleClient_Watch(conn *redis.Client) error {
 func main() {
 	ctx := context.Background()
 	ExampleClient_Watch(redisCli)
-	core.ClearAllKeys(ctx, redisCli)
+	if _, err := core.ResetKeys(ctx, redisCli, core.ResetOptions{}); err != nil {
+		fmt.Println("reset keys err:", err)
+	}
 }